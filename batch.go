@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/exchangedataset/stream-snapshot/pkg/snapshot"
+	sc "github.com/exchangedataset/streamcommons"
+)
+
+// BatchConfig is the configuration of the batch in JSON, the function runs the batch at scheduled events from EventBridge
+// instead of serving requests if it is set.
+var BatchConfig = os.Getenv("BATCH_CONFIG")
+
+// handleBatchEvent returns the handler of scheduled events which runs the batch of `config` at the time of the event.
+func handleBatchEvent(config snapshot.BatchConfig) func(ctx context.Context, event events.CloudWatchEvent) ([]snapshot.BatchResult, error) {
+	return func(ctx context.Context, event events.CloudWatchEvent) (results []snapshot.BatchResult, err error) {
+		if Production {
+			sc.AWSEnableProduction()
+		}
+		log := snapshot.Logger.With("event_id", event.ID)
+		ctx = snapshot.WithLogger(ctx, log)
+		ctx, span := snapshot.StartSpan(ctx, "batch")
+		defer func() {
+			snapshot.EndSpan(span, err)
+			snapshot.FlushTraces(ctx)
+		}()
+		log.Info("batch start", "time", event.Time, "target", config.BatchTarget(event.Time))
+		// failed jobs are retried by EventBridge with the error
+		return snapshot.RunBatch(ctx, config, event.Time)
+	}
+}
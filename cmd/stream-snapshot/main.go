@@ -0,0 +1,434 @@
+// stream-snapshot reconstructs snapshots from the dataset outside of Lambda, such as from files downloaded locally.
+//
+// Usage:
+//
+//	stream-snapshot --exchange bitmex --at 2020-09-01T06:17:05Z --channels orderBookL2 [--dir DIR | --bucket BUCKET] [-o FILE]
+//
+// Other parameters of the API can be given as `--param name=value`. Snapshots are written for humans to read
+// with `--output table`, orderbooks in columns of bids and asks.
+//
+// Configuration by environment variables can also be given in the JSON file at `CONFIG_FILE`, which they override.
+//
+// It serves the API over HTTP at `GET /snapshot/{exchange}/{nanosec}` in serve mode:
+//
+//	stream-snapshot serve [--addr :8080] [--grpc :9090] [--dir DIR] [--pprof] [--grace 25s]
+//
+// On SIGTERM, it stops accepting requests and lets in-flight requests finish within the grace period,
+// requests still running after that are aborted with 503 keeping checkpoints taken so far.
+//
+// Snapshots of the newest dataset are pushed every `interval` over WebSocket at `GET /subscribe/{exchange}`,
+// from a simulator kept through the subscription.
+//
+// Simulators of `WARM_CHANNELS` are kept warm in memory and advanced as notifications of new dataset files
+// are received from the SQS queue at `WARM_QUEUE_URL`, so that requests of recent targets start from them.
+//
+// Probes are served at `/healthz` and `/readyz`.
+//
+// `SnapshotService` in proto/snapshot_service.proto is served over gRPC as well if `--grpc` is given.
+//
+// It takes snapshots of jobs configured in JSON and writes them to S3 in batch mode, as the Lambda function does with `BATCH_CONFIG`:
+//
+//	stream-snapshot batch --config FILE [--at 2020-09-01T06:00:00Z] [--dir DIR]
+//
+// It writes manifests of dataset files in the directory in index mode, which are read with `MANIFEST_DIR`
+// to skip files having nothing for requested channels and to seek multistream gzip and seekable zstd files:
+//
+//	stream-snapshot index --dir DIR [--manifest-dir DIR] [FILE...]
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"google.golang.org/grpc"
+
+	"github.com/exchangedataset/stream-snapshot/pkg/snapshot"
+)
+
+// params is the flag of parameters of the API which can be repeated.
+type params map[string][]string
+
+func (p params) String() string {
+	return fmt.Sprint(map[string][]string(p))
+}
+
+func (p params) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return errors.New("must be in the form of name=value")
+	}
+	p[kv[0]] = append(p[kv[0]], kv[1])
+	return nil
+}
+
+// options is the result of command line arguments.
+type options struct {
+	event events.APIGatewayProxyRequest
+	// dir is the local directory of dataset files, S3 is used if empty
+	dir string
+	// bucket is the S3 bucket of dataset files, the default location is used if empty
+	bucket string
+	// out is the path to the file to write snapshots to, stdout if empty
+	out string
+}
+
+// parseArgs makes the request to the API equivalent to `args`.
+func parseArgs(args []string) (opts options, err error) {
+	fs := flag.NewFlagSet("stream-snapshot", flag.ContinueOnError)
+	exchange := fs.String("exchange", "", "exchange to take snapshot of")
+	at := fs.String("at", "", "target time in nanoseconds, RFC3339 time or 'latest'")
+	channels := fs.String("channels", "", "comma separated channels to take snapshot of")
+	format := fs.String("format", "raw", "format of messages")
+	output := fs.String("output", "tsv", "layout of the output, 'table' to read it")
+	fs.StringVar(&opts.dir, "dir", "", "local directory to read dataset files from")
+	fs.StringVar(&opts.bucket, "bucket", "", "S3 bucket to read dataset files from")
+	fs.StringVar(&opts.out, "o", "", "file to write snapshot to instead of stdout")
+	extra := params{}
+	fs.Var(extra, "param", "other parameter of the API in the form of name=value, can be repeated")
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+	if *exchange == "" || *at == "" || *channels == "" {
+		err = errors.New("--exchange, --at and --channels must be specified")
+		return
+	}
+	if opts.dir != "" && opts.bucket != "" {
+		err = errors.New("--dir and --bucket can not be specified together")
+		return
+	}
+	query := map[string]string{"format": *format, "output": *output}
+	multi := map[string][]string{"channels": strings.Split(*channels, ",")}
+	for name, values := range extra {
+		query[name] = values[len(values)-1]
+		multi[name] = values
+	}
+	opts.event = events.APIGatewayProxyRequest{
+		PathParameters:                  map[string]string{"exchange": *exchange, "nanosec": *at},
+		QueryStringParameters:           query,
+		MultiValueQueryStringParameters: multi,
+	}
+	return
+}
+
+// run takes the snapshot for `opts` and writes it to `w`.
+func run(ctx context.Context, opts options, w io.Writer) (err error) {
+	if opts.dir != "" {
+		snapshot.DatasetDirectory = opts.dir
+	}
+	param, err := snapshot.ParseParameter(opts.event)
+	if err != nil {
+		err = &snapshot.SnapshotError{Kind: snapshot.ErrBadParameter, Err: err}
+		return
+	}
+	// the user of the command can read any bucket
+	param.DatasetBucket = opts.bucket
+	if param.Latest {
+		if err = snapshot.ResolveLatest(ctx, &param, time.Now()); err != nil {
+			return
+		}
+	}
+	if len(param.Formats) > 1 {
+		// parts of formats are only made by the server
+		return errors.New("multiple formats can not be written by the command, use serve instead")
+	}
+	if param.FanOut {
+		// parts of symbols are only made by the server
+		return errors.New("snapshots fanned out by symbols can not be written by the command, use serve instead")
+	}
+	if param.DryRun {
+		result, serr := snapshot.DryRun(ctx, param)
+		if serr != nil {
+			return serr
+		}
+		_, err = fmt.Fprintln(w, string(result))
+		return
+	}
+	if param.Nearest {
+		result, serr := snapshot.Nearest(ctx, param)
+		if serr != nil {
+			return serr
+		}
+		_, err = fmt.Fprintln(w, string(result))
+		return
+	}
+	source, err := snapshot.OpenSource(ctx, &param)
+	if err != nil {
+		return
+	}
+	defer func() {
+		serr := source.Close()
+		if serr != nil {
+			if err != nil {
+				err = fmt.Errorf("%v, original error was: %v", serr, err)
+			} else {
+				err = serr
+			}
+		}
+	}()
+	buffer := bufio.NewWriter(w)
+	// the file is compressed if `--param encoding=gzip` is given
+	encoded := snapshot.NewEncodingWriter(buffer, param.Encoding)
+	report, err := snapshot.SnapshotTo(ctx, param, source, encoded)
+	if err != nil {
+		return
+	}
+	snapshot.LoggerFrom(ctx).Info("snapshot end", "scanned", report.Scanned, "timestamp", report.LastTimestamp, "partial", report.Partial)
+	if err = encoded.Close(); err != nil {
+		return
+	}
+	return buffer.Flush()
+}
+
+// batch runs the batch configured in the file of arguments `args` once, and writes results of jobs to `w`.
+func batch(ctx context.Context, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("stream-snapshot batch", flag.ContinueOnError)
+	configPath := fs.String("config", "", "file of the configuration of the batch in JSON")
+	at := fs.String("at", "", "RFC3339 time of the run, now if empty")
+	dir := fs.String("dir", "", "local directory to read dataset files from")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return errors.New("--config must be specified")
+	}
+	b, err := ioutil.ReadFile(*configPath)
+	if err != nil {
+		return err
+	}
+	config, err := snapshot.ParseBatchConfig(b)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *configPath, err)
+	}
+	runAt := time.Now()
+	if *at != "" {
+		if runAt, err = time.Parse(time.RFC3339, *at); err != nil {
+			return err
+		}
+	}
+	if *dir != "" {
+		snapshot.DatasetDirectory = *dir
+	}
+	results, err := snapshot.RunBatch(ctx, config, runAt)
+	encoder := json.NewEncoder(w)
+	for _, result := range results {
+		if serr := encoder.Encode(result); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// index writes manifests of dataset files given in arguments `args`, all files in the directory if none is given.
+func index(args []string) error {
+	fs := flag.NewFlagSet("stream-snapshot index", flag.ContinueOnError)
+	dir := fs.String("dir", "", "local directory of dataset files")
+	manifestDir := fs.String("manifest-dir", "", "directory to write manifests to, the directory of dataset files if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("--dir must be specified")
+	}
+	if *manifestDir == "" {
+		*manifestDir = *dir
+	}
+	keys := fs.Args()
+	if len(keys) == 0 {
+		infos, err := ioutil.ReadDir(*dir)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if name := info.Name(); !info.IsDir() && (strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".zst")) {
+				keys = append(keys, name)
+			}
+		}
+	}
+	return snapshot.IndexDataset(*dir, keys, *manifestDir)
+}
+
+// abortTimeout is the time requests have to return after they are aborted at shutdown.
+const abortTimeout = 5 * time.Second
+
+// serve serves the API over HTTP, and over gRPC if it is asked, with arguments `args` until either of them fails
+// or it is asked to terminate.
+func serve(args []string) error {
+	fs := flag.NewFlagSet("stream-snapshot serve", flag.ContinueOnError)
+	listen := snapshot.ListenAddress
+	if listen == "" {
+		listen = ":8080"
+	}
+	addr := fs.String("addr", listen, "address to listen on")
+	grpcAddr := fs.String("grpc", snapshot.GRPCAddress, "address to serve gRPC on, not served if empty")
+	fs.BoolVar(&snapshot.Pprof, "pprof", snapshot.Pprof, "serve profiles at /debug/pprof/")
+	dir := fs.String("dir", "", "local directory to read dataset files from")
+	grace := fs.Duration("grace", 25*time.Second, "time in-flight requests have to finish at shutdown before they are aborted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir != "" {
+		snapshot.DatasetDirectory = *dir
+	}
+	if err := snapshot.Setup(context.Background()); err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// requests are aborted by cancelling this after the grace period
+	base, abort := context.WithCancelCause(context.Background())
+	defer abort(nil)
+	var inflight sync.WaitGroup
+	handler := snapshot.NewHTTPHandler()
+	server := &http.Server{
+		Addr: *addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inflight.Add(1)
+			defer inflight.Done()
+			handler.ServeHTTP(w, r)
+		}),
+		BaseContext: func(net.Listener) context.Context { return base },
+	}
+	errs := make(chan error, 2)
+	var grpcServer *grpc.Server
+	if *grpcAddr != "" {
+		listener, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return err
+		}
+		grpcServer = snapshot.NewGRPCServer(grpc.WaitForHandlers(true))
+		snapshot.Logger.Info("serving gRPC", "address", *grpcAddr)
+		go func() {
+			errs <- grpcServer.Serve(listener)
+		}()
+	}
+	// simulators kept warm are advanced as dataset files land until shutdown
+	go snapshot.FollowDataset(ctx)
+	snapshot.Logger.Info("serving", "address", *addr)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	// second signal terminates immediately
+	stop()
+	return shutdown(server, grpcServer, &inflight, func() { abort(snapshot.ErrShuttingDown) }, *grace)
+}
+
+// shutdown stops accepting requests and waits for in-flight requests to finish within `grace`.
+// Requests still running after that are aborted by `abort`, and it waits for them to close their sources.
+func shutdown(server *http.Server, grpcServer *grpc.Server, inflight *sync.WaitGroup, abort func(), grace time.Duration) error {
+	log := snapshot.Logger
+	log.Info("shutting down", "grace", grace)
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	drained := make(chan struct{})
+	go func() {
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		// hijacked or not, handlers running are waited for
+		inflight.Wait()
+		close(drained)
+	}()
+	err := server.Shutdown(ctx)
+	select {
+	case <-drained:
+		log.Info("all requests finished")
+		if err == context.DeadlineExceeded {
+			// requests have just finished
+			err = nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+	log.Warn("grace period expired, aborting in-flight requests")
+	abort()
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(abortTimeout):
+		return server.Close()
+	}
+}
+
+func main() {
+	if snapshot.ConfigFile != "" {
+		if err := snapshot.LoadConfig(snapshot.ConfigFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	// stdout is for snapshots
+	snapshot.Logger = snapshot.NewLogger(os.Stderr, snapshot.LogLevel)
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		if err := serve(os.Args[2:]); err != nil && err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "index" {
+		if err := index(os.Args[2:]); err != nil && err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "batch" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := batch(ctx, os.Args[2:], os.Stdout); err != nil && err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	opts, err := parseArgs(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var w io.Writer = os.Stdout
+	if opts.out != "" {
+		file, err := os.Create(opts.out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		w = file
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, opts, w); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, snapshot.ErrBadParameter) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exchangedataset/stream-snapshot/pkg/snapshot"
+)
+
+func TestParseArgs(t *testing.T) {
+	opts, err := parseArgs([]string{"--exchange", "bitmex", "--at", "2020-09-01T06:17:05Z", "--channels", "orderBookL2,trade", "--param", "depth=10", "--dir", "/data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.dir != "/data" || opts.event.PathParameters["nanosec"] != "2020-09-01T06:17:05Z" {
+		t.Fatalf("unexpected options: %+v", opts)
+	}
+	if channels := opts.event.MultiValueQueryStringParameters["channels"]; len(channels) != 2 || channels[1] != "trade" {
+		t.Fatalf("unexpected channels: %v", channels)
+	}
+	if opts.event.QueryStringParameters["depth"] != "10" || opts.event.QueryStringParameters["format"] != "raw" {
+		t.Fatalf("unexpected query: %v", opts.event.QueryStringParameters)
+	}
+	if _, err := parseArgs([]string{"--exchange", "bitmex"}); err == nil {
+		t.Fatal("expected missing flags to be rejected")
+	}
+	if _, err := parseArgs([]string{"--exchange", "bitmex", "--at", "1", "--channels", "trade", "--param", "depth"}); err == nil {
+		t.Fatal("expected parameter without value to be rejected")
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "bitmex_26649017.gz"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseArgs([]string{"--exchange", "bitmex", "--at", "1598941025000000000", "--channels", "orderBookL2", "--dir", dir, "--param", "dryRun=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := run(context.Background(), opts, buf); err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		FilesToOpen int `json:"filesToOpen"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.FilesToOpen != 1 {
+		t.Fatalf("expected the file in the directory to be found: %s", buf.Bytes())
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		work    time.Duration
+		aborted bool
+	}{
+		{"finished", 0, false},
+		{"aborted", time.Hour, true},
+	} {
+		base, abort := context.WithCancelCause(context.Background())
+		var inflight sync.WaitGroup
+		started := make(chan struct{})
+		var cause error
+		server := &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				inflight.Add(1)
+				defer inflight.Done()
+				close(started)
+				select {
+				case <-time.After(c.work):
+				case <-r.Context().Done():
+					cause = context.Cause(r.Context())
+				}
+			}),
+			BaseContext: func(net.Listener) context.Context { return base },
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server.Serve(listener)
+		go http.Get("http://" + listener.Addr().String())
+		<-started
+		if err := shutdown(server, nil, &inflight, func() { abort(snapshot.ErrShuttingDown) }, 50*time.Millisecond); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if (cause == snapshot.ErrShuttingDown) != c.aborted {
+			t.Errorf("%s: expected aborted %v, got cause %v", c.name, c.aborted, cause)
+		}
+	}
+}
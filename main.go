@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 
+	"github.com/exchangedataset/stream-snapshot/pkg/snapshot"
 	"github.com/exchangedataset/streamcommons"
 	sc "github.com/exchangedataset/streamcommons"
 )
@@ -18,10 +23,30 @@ import (
 // Production is `true` if and only if this instance is running on the context of production environment.
 var Production = os.Getenv("PRODUCTION") == "1"
 
-func handleRequest(event events.APIGatewayProxyRequest) (response *events.APIGatewayProxyResponse, err error) {
+// ResponseStreaming is `true` if requests come from function URL in `RESPONSE_STREAM` mode.
+// It needs the binary to be built with `-tags lambda.norpc` or to run on the `provided` runtime.
+var ResponseStreaming = os.Getenv("RESPONSE_STREAMING") == "1"
+
+func handleRequest(ctx context.Context, event events.APIGatewayProxyRequest) (response *events.APIGatewayProxyResponse, err error) {
 	if Production {
 		sc.AWSEnableProduction()
 	}
+	id := requestID(event)
+	log := snapshot.Logger.With("request_id", id)
+	ctx = snapshot.WithLogger(ctx, log)
+	record := snapshot.NewRequestRecord(id, event)
+	ctx = snapshot.WithRequestRecord(ctx, record)
+	ctx, span := snapshot.StartSpan(ctx, "request")
+	defer func() {
+		snapshot.EndSpan(span, err)
+		snapshot.FlushTraces(ctx)
+		status := 500
+		if response != nil && err == nil {
+			status = response.StatusCode
+			response.Headers = withRequestID(response.Headers, id)
+		}
+		finishRecord(record, status, err)
+	}()
 
 	db, serr := sc.ConnectDatabase()
 	if serr != nil {
@@ -39,10 +64,51 @@ func handleRequest(event events.APIGatewayProxyRequest) (response *events.APIGat
 		}
 	}()
 	st := time.Now()
+	param, bill, response, serr := prepareRequest(ctx, db, event)
+	if serr != nil || response != nil {
+		err = serr
+		return
+	}
+	log.Debug("setup end", "elapsed", time.Now().Sub(st))
+	ctx = snapshot.WithLogger(ctx, logFor(log, param))
+	return respond(ctx, st, param, bill)
+}
+
+// requestID returns the ID of `event`, given by `X-Request-Id` header or API Gateway.
+func requestID(event events.APIGatewayProxyRequest) string {
+	for name, value := range event.Headers {
+		if strings.EqualFold(name, snapshot.RequestIDHeader) {
+			return snapshot.RequestID(value)
+		}
+	}
+	return snapshot.RequestID(event.RequestContext.RequestID)
+}
+
+// withRequestID returns `headers` with the request ID `id`, made if `headers` is nil.
+func withRequestID(headers map[string]string, id string) map[string]string {
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers[snapshot.RequestIDHeader] = id
+	return headers
+}
+
+// finishRecord logs `record` of the request responded with `status`, or failed with `err` if it is not nil.
+func finishRecord(record *snapshot.RequestRecord, status int, err error) {
+	record.Status = status
+	if err != nil && record.Error == "" {
+		record.Error = err.Error()
+	}
+	record.Log()
+}
+
+// prepareRequest authorizes `event` and makes the parameter of it, `response` is set if the request can not be fulfilled.
+func prepareRequest(ctx context.Context, db *sql.DB, event events.APIGatewayProxyRequest) (param snapshot.SnapshotParameter, bill func(scanned int64) (int64, error), response *events.APIGatewayProxyResponse, err error) {
+	log := snapshot.LoggerFrom(ctx)
 	// initialize apikey
 	apikey, serr := sc.NewAPIKey(event)
 	if serr != nil {
-		fmt.Printf("%v", serr)
+		log.Info("API-key authorization failed", "error", serr)
 		response = sc.MakeResponse(401, fmt.Sprintf("API-key authorization failed"))
 		return
 	}
@@ -50,110 +116,258 @@ func handleRequest(event events.APIGatewayProxyRequest) (response *events.APIGat
 		// check API-key if valid
 		serr = apikey.CheckAvalability(db)
 		if serr != nil {
-			fmt.Printf("%v", serr)
+			log.Info("API key is invalid", "error", serr)
 			response = sc.MakeResponse(401, fmt.Sprintf("API key is invalid: %v", serr))
 			return
 		}
 	}
-	fmt.Printf("apikey checked : %d\n", time.Now().Sub(st))
 	// get parameters
-	param, serr := makeParameter(event)
+	param, serr = snapshot.ParseParameter(event)
 	if serr != nil {
 		response = sc.MakeResponse(400, serr.Error())
 		return
 	}
+	ctx = snapshot.WithLogger(ctx, logFor(log, param))
+	if param.Latest {
+		serr = snapshot.ResolveLatest(ctx, &param, time.Now())
+		if errors.Is(serr, snapshot.ErrBadParameter) {
+			response = sc.MakeResponse(400, serr.Error())
+			return
+		}
+		if errors.Is(serr, snapshot.ErrDatasetGap) {
+			response = sc.MakeResponse(404, serr.Error())
+			return
+		}
+		if serr != nil {
+			err = fmt.Errorf("latest: %v", serr)
+			return
+		}
+	}
 	if apikey.Demo && Production {
 		// if this apikey is demo key, then check if nanosec is in allowed range
-		if param.nanosec < int64(streamcommons.DemoAPIKeyAllowedStart) || param.nanosec >= int64(streamcommons.DemoAPIKeyAllowedEnd) {
-			response = sc.MakeResponse(400, "'nanosec' is out of range: You are using demo API-key")
-			return
+		for _, nanosec := range param.Nanosecs {
+			if nanosec < int64(streamcommons.DemoAPIKeyAllowedStart) || nanosec >= int64(streamcommons.DemoAPIKeyAllowedEnd) {
+				response = sc.MakeResponse(400, "'nanosec' is out of range: You are using demo API-key")
+				return
+			}
 		}
 	}
-	fmt.Printf("setup end : %d\n", time.Now().Sub(st))
-	// list dataset to read to reconstruct snapshot
-	// and make response string
-	ctx := context.Background()
-	tenMinute := (param.minute / 10) * 10
-	keys := make([]string, param.minute-tenMinute+1)
-	for i := int64(0); i <= param.minute-tenMinute; i++ {
-		keys[i] = fmt.Sprintf("%s_%d.gz", param.exchange, tenMinute+i)
+	bill = func(scanned int64) (int64, error) {
+		if apikey.Demo {
+			return streamcommons.CalcQuotaUsed(scanned), nil
+		}
+		return apikey.IncrementUsed(db, scanned)
 	}
-	fmt.Printf("keys: %v\n", keys)
-	bodies := sc.S3GetAll(ctx, keys)
-	defer func() {
-		serr := bodies.Close()
+	return
+}
+
+// logFor returns `log` with the exchange of `param`, exchanges of multi-exchange request are attached to logs of each of them.
+func logFor(log *slog.Logger, param snapshot.SnapshotParameter) *slog.Logger {
+	if len(param.Exchanges) > 0 {
+		return log
+	}
+	return log.With("exchange", param.Exchange)
+}
+
+// respond takes the snapshot for `param` at once and makes the response of it.
+func respond(ctx context.Context, st time.Time, param snapshot.SnapshotParameter, bill func(scanned int64) (int64, error)) (response *events.APIGatewayProxyResponse, err error) {
+	if param.DryRun {
+		// dataset is not scanned, so nothing is billed
+		result, serr := snapshot.DryRun(ctx, param)
+		if errors.Is(serr, snapshot.ErrBadParameter) {
+			response = sc.MakeResponse(400, serr.Error())
+			return
+		}
 		if serr != nil {
-			if err != nil {
-				err = fmt.Errorf("snapshot: bodies close: %v, originally: %v", serr, err)
-			} else {
-				err = serr
-			}
+			err = fmt.Errorf("dry-run: %v", serr)
+			return
 		}
-	}()
-	fmt.Printf("snapshot start : %d\n", time.Now().Sub(st))
-	// write snapshot
-	result, scanned, eerr, serr := snapshot(param, bodies)
+		response = sc.MakeResponse(200, string(result))
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+		response.Headers["Content-Type"] = "application/json"
+		return
+	}
+	if param.Nearest {
+		// only the catalog is looked up, so nothing is billed
+		result, serr := snapshot.Nearest(ctx, param)
+		if errors.Is(serr, snapshot.ErrBadParameter) {
+			response = sc.MakeResponse(400, serr.Error())
+			return
+		}
+		if serr != nil {
+			err = fmt.Errorf("nearest: %v", serr)
+			return
+		}
+		response = sc.MakeResponse(200, string(result))
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+		response.Headers["Content-Type"] = "application/json"
+		return
+	}
+	result, report, serr := snapshot.Take(ctx, param)
+	return makeSnapshotResponse(ctx, st, result, param, report, serr, bill)
+}
+
+// makeSnapshotResponse bills for scanned bytes in `report` with `bill` and makes the response of the snapshot for `param`.
+func makeSnapshotResponse(ctx context.Context, st time.Time, result []byte, param snapshot.SnapshotParameter, report snapshot.Report, serr error, bill func(scanned int64) (int64, error)) (response *events.APIGatewayProxyResponse, err error) {
+	snapshot.RequestRecordFrom(ctx).Observe(report, serr)
+	if errors.Is(serr, snapshot.ErrBadParameter) {
+		response = sc.MakeResponse(400, serr.Error())
+		return
+	}
+	if errors.Is(serr, snapshot.ErrInsufficientHistory) || errors.Is(serr, snapshot.ErrBookReset) {
+		// the snapshot could not be made, bytes scanned are billed as in the case of the scan limit
+		if _, err = bill(report.Scanned); err != nil {
+			return
+		}
+		response = sc.MakeResponse(422, serr.Error())
+		return
+	}
+	if errors.Is(serr, snapshot.ErrScanLimit) {
+		// bytes scanned until the limit are billed
+		if _, err = bill(report.Scanned); err != nil {
+			return
+		}
+		response = sc.MakeResponse(400, serr.Error())
+		return
+	}
+	if errors.Is(serr, snapshot.ErrOverloaded) {
+		response = sc.MakeResponse(503, serr.Error())
+		return
+	}
 	if serr != nil {
 		err = fmt.Errorf("snapshot: %v", serr)
 		return
 	}
-	if eerr != nil {
-		response = sc.MakeResponse(400, eerr.Error())
+	log := snapshot.LoggerFrom(ctx)
+	log.Info("snapshot end", "scanned", report.Scanned, "size", len(result), "elapsed", time.Now().Sub(st))
+	if record := snapshot.RequestRecordFrom(ctx); record != nil {
+		record.Size = int64(len(result))
+	}
+	incremented, err := bill(report.Scanned)
+	if err != nil {
 		return
 	}
-	fmt.Printf("snapshot end : %d\n", time.Now().Sub(st))
-	var incremented int64
-	if apikey.Demo {
-		incremented = streamcommons.CalcQuotaUsed(scanned)
-	} else {
-		incremented, err = apikey.IncrementUsed(db, scanned)
-		if err != nil {
-			return
+	log.Debug("increment transfer end", "elapsed", time.Now().Sub(st))
+	if report.NotModified {
+		// the client already has the snapshot
+		response = sc.MakeResponse(304, "")
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
 		}
+		response.Headers["ETag"] = report.ETag
+		return
 	}
-	fmt.Printf("increment transfer end : %d\n", time.Now().Sub(st))
 	// return result
 	var returnCode int
-	if len(result) == 0 {
+	if len(result) == 0 && report.ResumeToken == "" {
+		// the stopped scan could have reached no target yet, which is not the end
 		returnCode = 404
 	} else {
 		returnCode = 200
 	}
-	return sc.MakeLargeResponse(returnCode, result, incremented)
-}
-
-func makeParameter(event events.APIGatewayProxyRequest) (param SnapshotParameter, err error) {
-	var ok bool
-	param.exchange, ok = event.PathParameters["exchange"]
-	if !ok {
-		err = errors.New("'exchange' must be specified")
+	body, err := snapshot.EncodeResult(result, param.Encoding)
+	if err != nil {
 		return
 	}
-	nanosecStr, ok := event.PathParameters["nanosec"]
-	if !ok {
-		err = errors.New("'nanosec' must be specified")
-		return
+	encoded := param.Encoding != "" && len(result) > 0
+	if encoded {
+		// large bodies would be encoded again by streamcommons, the body is set as it is
+		response, err = sc.MakeLargeResponse(returnCode, nil, incremented)
+	} else {
+		response, err = sc.MakeLargeResponse(returnCode, body, incremented)
 	}
-	var serr error
-	param.nanosec, serr = strconv.ParseInt(nanosecStr, 10, 64)
-	if serr != nil {
-		err = errors.New("'nanosec' must be of integer type")
+	if err != nil {
 		return
 	}
-	param.minute = param.nanosec / 60 / 1000000000
-	param.channels, ok = event.MultiValueQueryStringParameters["channels"]
-	if !ok {
-		err = errors.New("'channels' must be specified")
-		return
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	if encoded {
+		response.Body = base64.StdEncoding.EncodeToString(body)
+		response.IsBase64Encoded = true
+		response.Headers["Content-Encoding"] = param.Encoding
+		response.Headers["Vary"] = "Accept-Encoding"
+	}
+	// tell the timestamp of the last line actually applied, it could be earlier than the requested time
+	response.Headers["X-Snapshot-Timestamp"] = strconv.FormatInt(report.LastTimestamp, 10)
+	response.Headers["Content-Type"] = snapshot.ResponseContentType(param, report)
+	// clients parsing outputs by position tell whether they know the layout
+	response.Headers[snapshot.SchemaVersionHeader] = snapshot.ResponseSchemaVersion(param)
+	if report.ETag != "" {
+		response.Headers["ETag"] = report.ETag
+	}
+	if report.Token != "" {
+		// given as `since` in the next request to take only changes
+		response.Headers["X-Snapshot-Token"] = report.Token
+	}
+	if report.Channels.HasProblems() {
+		// requested channels or post filters could not make output, which is likely a typo
+		response.Headers["X-Snapshot-Channels"] = report.Channels.Header()
+	}
+	if report.NextPage != "" {
+		// given as `page` in the next request to take the rest of the result
+		response.Headers["X-Snapshot-Next-Page"] = report.NextPage
+	}
+	if report.ResumeToken != "" {
+		// given as `resume` in the next request to take snapshots at the rest of targets
+		response.Headers["X-Snapshot-Resume-Token"] = report.ResumeToken
+	}
+	if param.SinceHash != "" {
+		response.Headers["X-Snapshot-Delta"] = strconv.FormatBool(!report.FullSnapshot)
+	}
+	// tell whether lines exactly at targets were applied
+	if param.Exclusive {
+		response.Headers["X-Snapshot-Boundary"] = "exclusive"
+	} else {
+		response.Headers["X-Snapshot-Boundary"] = "inclusive"
+	}
+	if report.Partial != "" {
+		// snapshot could be stale or incomplete
+		response.Headers["X-Snapshot-Partial"] = "true"
+		response.Headers["X-Snapshot-Partial-Reason"] = report.Partial
+	}
+	if report.SkippedLines > 0 {
+		response.Headers["X-Snapshot-Skipped-Lines"] = strconv.Itoa(report.SkippedLines)
 	}
-	param.format, ok = event.QueryStringParameters["format"]
-	if !ok {
-		// default format is raw
-		param.format = "raw"
+	if len(report.TruncatedFiles) > 0 {
+		// lines after the truncation were not applied
+		response.Headers["X-Snapshot-Truncated-Files"] = strings.Join(report.TruncatedFiles, ",")
+	}
+	if param.ScanReport {
+		encoded, serr := snapshot.NewScanReport(report, time.Now().Sub(st)).HeaderValue()
+		if serr != nil {
+			err = serr
+			return
+		}
+		response.Headers["X-Snapshot-Report"] = encoded
 	}
 	return
 }
 
 func main() {
+	if snapshot.ConfigFile != "" {
+		if err := snapshot.LoadConfig(snapshot.ConfigFile); err != nil {
+			panic(err)
+		}
+	}
+	if err := snapshot.Setup(context.Background()); err != nil {
+		panic(err)
+	}
+	if BatchConfig != "" {
+		config, err := snapshot.ParseBatchConfig([]byte(BatchConfig))
+		if err != nil {
+			panic(err)
+		}
+		lambda.Start(handleBatchEvent(config))
+		return
+	}
+	if ResponseStreaming {
+		lambda.Start(handleStreamingRequest)
+		return
+	}
 	lambda.Start(handleRequest)
 }
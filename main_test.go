@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -37,36 +38,41 @@ func testCommon(t *testing.T, res *events.APIGatewayProxyResponse, err error) {
 }
 
 func TestBitmexOrderBookL2(t *testing.T) {
-	res, err := handleRequest(makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025555000000", "json"))
+	res, err := handleRequest(context.Background(), makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025555000000", "json"))
 	testCommon(t, res, err)
 }
 
 func TestBitfinexBook(t *testing.T) {
-	res, err := handleRequest(makeLambdaEvent("bitfinex", []string{"book_tBTCUSD"}, "1598941025555000000", "json"))
+	res, err := handleRequest(context.Background(), makeLambdaEvent("bitfinex", []string{"book_tBTCUSD"}, "1598941025555000000", "json"))
 	testCommon(t, res, err)
 }
 
 func TestBinanceDepth(t *testing.T) {
-	res, err := handleRequest(makeLambdaEvent("binance", []string{"btcusdt@depth@100ms"}, "1598941025555000000", "json"))
+	res, err := handleRequest(context.Background(), makeLambdaEvent("binance", []string{"btcusdt@depth@100ms"}, "1598941025555000000", "json"))
 	testCommon(t, res, err)
 }
 
 func TestBinanceDepthRest(t *testing.T) {
-	res, err := handleRequest(makeLambdaEvent("binance", []string{"btcusdt@rest_depth"}, "1598941025555000000", "json"))
+	res, err := handleRequest(context.Background(), makeLambdaEvent("binance", []string{"btcusdt@rest_depth"}, "1598941025555000000", "json"))
+	testCommon(t, res, err)
+}
+
+func TestBinanceFuturesDepth(t *testing.T) {
+	res, err := handleRequest(context.Background(), makeLambdaEvent("binance-futures", []string{"btcusdt@depth@100ms"}, "1598941025555000000", "json"))
 	testCommon(t, res, err)
 }
 
 func TestBitflyerLightningBoard(t *testing.T) {
-	res, err := handleRequest(makeLambdaEvent("bitflyer", []string{"lightning_board_BTC_JPY"}, "1598941025555000000", "json"))
+	res, err := handleRequest(context.Background(), makeLambdaEvent("bitflyer", []string{"lightning_board_BTC_JPY"}, "1598941025555000000", "json"))
 	testCommon(t, res, err)
 }
 
 func TestBitflyerLightningSnapshot(t *testing.T) {
-	res, err := handleRequest(makeLambdaEvent("bitflyer", []string{"lightning_board_snapshot_BTC_JPY"}, "1598941025555000000", "json"))
+	res, err := handleRequest(context.Background(), makeLambdaEvent("bitflyer", []string{"lightning_board_snapshot_BTC_JPY"}, "1598941025555000000", "json"))
 	testCommon(t, res, err)
 }
 
 func TestLiquidPriceLaddersCashBTCJPY(t *testing.T) {
-	res, err := handleRequest(makeLambdaEvent("liquid", []string{"price_ladders_cash_btcjpy_buy"}, "1598941025555000000", "json"))
+	res, err := handleRequest(context.Background(), makeLambdaEvent("liquid", []string{"price_ladders_cash_btcjpy_buy"}, "1598941025555000000", "json"))
 	testCommon(t, res, err)
 }
@@ -0,0 +1,203 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey is a key which can make requests in server mode, with limits of requests made by it.
+type APIKey struct {
+	Key string `json:"key"`
+	// Name identifies the consumer of the key in usage records, it is logged instead of the key
+	Name string `json:"name"`
+	// MaxConcurrent is the number of requests of the key served at the same time, not limited if 0
+	MaxConcurrent int `json:"maxConcurrent"`
+	// DailyScannedBytes is the bytes of dataset requests of the key can scan in a day in UTC, not limited if 0
+	DailyScannedBytes int64 `json:"dailyScannedBytes"`
+}
+
+// errors of requests rejected by API keys
+var (
+	errUnknownAPIKey     = errors.New("API key is missing or unknown")
+	errTooManyRequests   = errors.New("too many requests are made with the API key at the same time")
+	errScanQuotaExceeded = errors.New("scan quota of the API key for today is exceeded")
+)
+
+// ParseAPIKeys parses the list of API keys in JSON.
+func ParseAPIKeys(b []byte) (keys []APIKey, err error) {
+	if err = json.Unmarshal(b, &keys); err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	names := make(map[string]bool)
+	for i, key := range keys {
+		if key.Key == "" || key.Name == "" {
+			return nil, fmt.Errorf("key %d: 'key' and 'name' must be specified", i)
+		}
+		if seen[key.Key] || names[key.Name] {
+			return nil, fmt.Errorf("key %d: key or name '%s' is used by another key", i, key.Name)
+		}
+		if key.MaxConcurrent < 0 || key.DailyScannedBytes < 0 {
+			return nil, fmt.Errorf("key %d: limits must not be negative", i)
+		}
+		seen[key.Key] = true
+		names[key.Name] = true
+	}
+	return
+}
+
+// apiKeyState is the usage of an API key.
+type apiKeyState struct {
+	APIKey
+	running int
+	// day is the day `scanned` is of
+	day     string
+	scanned int64
+}
+
+// apiKeyLimiter authorizes requests by API keys and enforces limits of each key.
+type apiKeyLimiter struct {
+	mu   sync.Mutex
+	keys map[string]*apiKeyState
+	now  func() time.Time
+}
+
+// apiKeys is the limiter of requests in server mode, requests are not authorized if nil.
+var apiKeys *apiKeyLimiter
+
+func newAPIKeyLimiter(keys []APIKey) *apiKeyLimiter {
+	l := &apiKeyLimiter{keys: make(map[string]*apiKeyState), now: time.Now}
+	for _, key := range keys {
+		l.keys[key.Key] = &apiKeyState{APIKey: key}
+	}
+	return l
+}
+
+// loadAPIKeys returns the limiter of API keys in the file at `path`.
+func loadAPIKeys(path string) (*apiKeyLimiter, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseAPIKeys(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API keys in %s: %v", path, err)
+	}
+	return newAPIKeyLimiter(keys), nil
+}
+
+// acquire starts a request of `key` and returns the bytes it can scan for the rest of the day, unlimited if 0.
+// `release` must be called with bytes scanned at the end of the request. `state` is also returned with errors of limits
+// to tell the key rejected. Requests running at the same time can each scan up to what was left when they started.
+func (l *apiKeyLimiter) acquire(key string) (state *apiKeyState, remaining int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state, ok := l.keys[key]
+	if !ok {
+		return nil, 0, errUnknownAPIKey
+	}
+	if state.MaxConcurrent > 0 && state.running >= state.MaxConcurrent {
+		return state, 0, errTooManyRequests
+	}
+	if day := l.now().UTC().Format("2006-01-02"); state.day != day {
+		state.day = day
+		state.scanned = 0
+	}
+	if state.DailyScannedBytes > 0 {
+		remaining = state.DailyScannedBytes - state.scanned
+		if remaining <= 0 {
+			return state, 0, errScanQuotaExceeded
+		}
+	}
+	state.running++
+	return state, remaining, nil
+}
+
+// release ends the request of `state` which scanned `scanned` bytes.
+func (l *apiKeyLimiter) release(state *apiKeyState, scanned int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state.running--
+	state.scanned += scanned
+}
+
+// requestAPIKey returns the API key of `r` in either `Authorization: Bearer` or `X-Api-Key` header.
+func requestAPIKey(r *http.Request) string {
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != r.Header.Get("Authorization") {
+		return token
+	}
+	return r.Header.Get("X-Api-Key")
+}
+
+// requestUsage is the usage of a request authorized by an API key, filled by the handler.
+type requestUsage struct {
+	// quota is the bytes the request can scan, unlimited if 0
+	quota   int64
+	scanned int64
+}
+
+type usageKey struct{}
+
+// usageFrom returns the usage of the request `ctx` is of, or nil if it is not authorized by an API key.
+func usageFrom(ctx context.Context) *requestUsage {
+	usage, _ := ctx.Value(usageKey{}).(*requestUsage)
+	return usage
+}
+
+// limitScan limits bytes `param` scans to the quota of the request of `ctx`.
+func limitScan(ctx context.Context, param *SnapshotParameter) {
+	usage := usageFrom(ctx)
+	if usage == nil || usage.quota == 0 {
+		return
+	}
+	if param.MaxScanBytes == 0 || param.MaxScanBytes > usage.quota {
+		param.MaxScanBytes = usage.quota
+	}
+}
+
+// addUsage records `scanned` bytes to the usage of the request of `ctx`, if it is authorized by an API key.
+func addUsage(ctx context.Context, scanned int64) {
+	if usage := usageFrom(ctx); usage != nil {
+		usage.scanned += scanned
+	}
+}
+
+// authorize returns the handler serving requests to `next` only if they have API keys within their limits.
+// Usage of each request is logged and counted by the name of the key for billing.
+func (l *apiKeyLimiter) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := time.Now()
+		state, remaining, err := l.acquire(requestAPIKey(r))
+		if err != nil {
+			if err == errUnknownAPIKey {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			reason := "quota"
+			if err == errTooManyRequests {
+				// other requests of the key will finish soon
+				reason = "concurrency"
+				w.Header().Set("Retry-After", "1")
+			}
+			keyRejected.WithLabelValues(state.Name, reason).Inc()
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		usage := &requestUsage{quota: remaining}
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			l.release(state, usage.scanned)
+			keyRequests.WithLabelValues(state.Name).Inc()
+			keyScannedBytes.WithLabelValues(state.Name).Add(float64(usage.scanned))
+			Logger.Info("usage", "key", state.Name, "request_id", r.Header.Get("X-Request-Id"), "path", r.URL.Path, "status", recorder.status, "scanned", usage.scanned, "elapsed", time.Now().Sub(st))
+		}()
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), usageKey{}, usage)))
+	})
+}
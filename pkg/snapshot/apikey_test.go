@@ -0,0 +1,109 @@
+package snapshot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := ParseAPIKeys([]byte(`[{"key":"k1","name":"team-a","maxConcurrent":2,"dailyScannedBytes":1000},{"key":"k2","name":"team-b"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].MaxConcurrent != 2 || keys[0].DailyScannedBytes != 1000 {
+		t.Errorf("unexpected keys: %+v", keys)
+	}
+	for _, invalid := range []string{
+		`[{"key":"k1"}]`,
+		`[{"key":"k1","name":"a"},{"key":"k1","name":"b"}]`,
+		`[{"key":"k1","name":"a"},{"key":"k2","name":"a"}]`,
+		`[{"key":"k1","name":"a","maxConcurrent":-1}]`,
+		`{}`,
+	} {
+		if _, err := ParseAPIKeys([]byte(invalid)); err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+func TestAPIKeyAuthorize(t *testing.T) {
+	limiter := newAPIKeyLimiter([]APIKey{{Key: "k1", Name: "team-a", MaxConcurrent: 1, DailyScannedBytes: 100}})
+	now := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	// the handler scans 60 bytes, or waits until it is released
+	var release chan struct{}
+	var quota int64
+	handler := limiter.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		param := SnapshotParameter{}
+		limitScan(r.Context(), &param)
+		quota = param.MaxScanBytes
+		if release != nil {
+			<-release
+		}
+		addUsage(r.Context(), 60)
+		w.Write([]byte("ok"))
+	}))
+	request := func(header string, value string) int {
+		r := httptest.NewRequest("GET", "/snapshot/bitmex/1598941025000000000", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := request("", ""); code != http.StatusUnauthorized {
+		t.Errorf("without key: expected 401, got %d", code)
+	}
+	if code := request("Authorization", "Bearer unknown"); code != http.StatusUnauthorized {
+		t.Errorf("unknown key: expected 401, got %d", code)
+	}
+	if code := request("Authorization", "Bearer k1"); code != http.StatusOK || quota != 100 {
+		t.Errorf("expected 200 with the quota of 100 bytes, got %d with %d", code, quota)
+	}
+	if code := request("X-Api-Key", "k1"); code != http.StatusOK || quota != 40 {
+		t.Errorf("expected 200 with the rest of the quota, got %d with %d", code, quota)
+	}
+	if code := request("X-Api-Key", "k1"); code != http.StatusTooManyRequests {
+		t.Errorf("quota exceeded: expected 429, got %d", code)
+	}
+	// quota is of a day
+	now = now.Add(24 * time.Hour)
+	release = make(chan struct{})
+	done := make(chan int)
+	go func() { done <- request("X-Api-Key", "k1") }()
+	// wait for the first request to start
+	for {
+		limiter.mu.Lock()
+		running := limiter.keys["k1"].running
+		limiter.mu.Unlock()
+		if running == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if code := request("X-Api-Key", "k1"); code != http.StatusTooManyRequests {
+		t.Errorf("concurrent request: expected 429, got %d", code)
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("expected 200 on the next day, got %d", code)
+	}
+}
+
+func TestLimitScan(t *testing.T) {
+	ctx := context.WithValue(context.Background(), usageKey{}, &requestUsage{quota: 100})
+	param := SnapshotParameter{MaxScanBytes: 50}
+	limitScan(ctx, &param)
+	if param.MaxScanBytes != 50 {
+		t.Errorf("smaller limit of the request should be kept, got %d", param.MaxScanBytes)
+	}
+	param.MaxScanBytes = 0
+	limitScan(context.Background(), &param)
+	if param.MaxScanBytes != 0 {
+		t.Errorf("requests without keys should not be limited, got %d", param.MaxScanBytes)
+	}
+}
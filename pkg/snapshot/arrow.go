@@ -0,0 +1,94 @@
+package snapshot
+
+import (
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+// arrowSchema is the schema of record batches in arrow output, a record batch is written per target.
+var arrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "timestamp", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "channel", Type: arrow.BinaryTypes.String},
+	{Name: "side", Type: arrow.BinaryTypes.String},
+	{Name: "price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "size", Type: arrow.PrimitiveTypes.Float64},
+}, nil)
+
+// batchWriter writes record batches of `arrowSchema` into a file.
+type batchWriter interface {
+	Write(record arrow.Record) error
+	Close() error
+}
+
+// arrowWriter writes orderbook levels as record batches with `batchWriter`.
+type arrowWriter struct {
+	w         io.Writer
+	newWriter func(w io.Writer) (batchWriter, error)
+	writer    batchWriter
+	builder   *array.RecordBuilder
+}
+
+// newArrowWriter makes arrowWriter writing in Arrow IPC stream format.
+func newArrowWriter(w io.Writer) *arrowWriter {
+	return &arrowWriter{w: w, newWriter: func(w io.Writer) (batchWriter, error) {
+		return ipc.NewWriter(w, ipc.WithSchema(arrowSchema)), nil
+	}}
+}
+
+// newParquetWriter makes arrowWriter writing a Parquet file.
+func newParquetWriter(w io.Writer) *arrowWriter {
+	return &arrowWriter{w: w, newWriter: func(w io.Writer) (batchWriter, error) {
+		return pqarrow.NewFileWriter(arrowSchema, w, parquet.NewWriterProperties(), pqarrow.DefaultWriterProps())
+	}}
+}
+
+// Write writes orderbook levels in `entries` as a record batch, other entries are ignored.
+func (a *arrowWriter) Write(nanosec int64, entries []entry) error {
+	if a.writer == nil {
+		// schema is written at the first record batch so that the response would be empty without snapshots
+		writer, err := a.newWriter(a.w)
+		if err != nil {
+			return err
+		}
+		a.writer = writer
+		a.builder = array.NewRecordBuilder(memory.DefaultAllocator, arrowSchema)
+	}
+	timestamps := a.builder.Field(0).(*array.Int64Builder)
+	channels := a.builder.Field(1).(*array.StringBuilder)
+	sides := a.builder.Field(2).(*array.StringBuilder)
+	prices := a.builder.Field(3).(*array.Float64Builder)
+	sizes := a.builder.Field(4).(*array.Float64Builder)
+	for _, e := range entries {
+		side, price, size, ok := parseLevel(e.message)
+		if !ok {
+			continue
+		}
+		timestamps.Append(nanosec)
+		channels.Append(e.channel)
+		if side == sideBid {
+			sides.Append("buy")
+		} else {
+			sides.Append("sell")
+		}
+		prices.Append(price)
+		sizes.Append(size)
+	}
+	record := a.builder.NewRecord()
+	defer record.Release()
+	return a.writer.Write(record)
+}
+
+// Close writes the end of the file if anything was written.
+func (a *arrowWriter) Close() error {
+	if a.writer == nil {
+		return nil
+	}
+	a.builder.Release()
+	return a.writer.Close()
+}
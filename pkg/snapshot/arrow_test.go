@@ -0,0 +1,75 @@
+package snapshot
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/parquet/file"
+)
+
+func TestArrowWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := newArrowWriter(buf)
+	if err := w.Close(); err != nil || buf.Len() != 0 {
+		t.Fatalf("expected nothing to be written without record batches: %v", err)
+	}
+	w = newArrowWriter(buf)
+	err := w.Write(100, []entry{
+		{channel: "book", message: []byte(`{"side":"Buy","price":1.5,"size":2}`)},
+		{channel: "status", message: []byte(`{"online":true}`)},
+		{channel: "book", message: []byte(`{"side":"Sell","price":2.5,"size":3}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(200, []entry{{channel: "book", message: []byte(`{"side":"Buy","price":1,"size":1}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := ipc.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Release()
+	rows := 0
+	for reader.Next() {
+		record := reader.Record()
+		if rows == 0 {
+			sides := record.Column(2).(*array.String)
+			prices := record.Column(3).(*array.Float64)
+			if sides.Value(1) != "sell" || prices.Value(1) != 2.5 {
+				t.Fatalf("unexpected level: %s %f", sides.Value(1), prices.Value(1))
+			}
+		}
+		rows += int(record.NumRows())
+	}
+	if rows != 3 {
+		t.Fatalf("expected 3 rows, got %d", rows)
+	}
+	if err := reader.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := newParquetWriter(buf)
+	if err := w.Write(100, []entry{{channel: "book", message: []byte(`{"side":"Buy","price":1.5,"size":2}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if reader.NumRows() != 1 {
+		t.Fatalf("expected 1 row, got %d", reader.NumRows())
+	}
+}
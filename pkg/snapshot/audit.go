@@ -0,0 +1,258 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditChannel is the channel of entries reporting divergence of orderbooks from REST APIs of exchanges.
+const auditChannel = "$audit"
+
+// auditMaxAge is how recent targets must be to be audited, REST APIs only have the current orderbook.
+const auditMaxAge = time.Minute
+
+// auditTimeout is the time each REST API has to respond.
+const auditTimeout = 10 * time.Second
+
+// auditClient is the HTTP client to fetch orderbooks from REST APIs with.
+var auditClient = &http.Client{Timeout: auditTimeout}
+
+// restEndpoints are base URLs of REST APIs of exchanges, replaced in tests.
+var restEndpoints = map[string]string{
+	"bitmex":       "https://www.bitmex.com",
+	"bitfinex":     "https://api-pub.bitfinex.com",
+	binanceSpot:    "https://api.binance.com",
+	binanceFutures: "https://fapi.binance.com",
+}
+
+// restBook is how to fetch orderbooks of an exchange from its REST API.
+type restBook struct {
+	// path returns the path and query of the orderbook of `channel`, `ok` is false if it is not of orderbooks
+	path func(channel string) (path string, ok bool)
+	// parse parses the response into levels, keyed by price per side
+	parse func(body []byte) (map[int]map[float64]float64, error)
+}
+
+// restBooks has exchanges whose orderbooks can be audited.
+var restBooks = map[string]restBook{
+	"bitmex": {
+		path: func(channel string) (string, bool) {
+			if !strings.HasPrefix(channel, "orderBookL2_") {
+				return "", false
+			}
+			return "/api/v1/orderBook/L2?depth=0&symbol=" + channel[len("orderBookL2_"):], true
+		},
+		parse: func(body []byte) (map[int]map[float64]float64, error) {
+			var rows []level
+			if err := json.Unmarshal(body, &rows); err != nil {
+				return nil, err
+			}
+			levels := map[int]map[float64]float64{sideBid: {}, sideAsk: {}}
+			for _, row := range rows {
+				if row.Price == nil || row.Size == nil {
+					continue
+				}
+				side := sideAsk
+				if row.Side == "Buy" {
+					side = sideBid
+				}
+				levels[side][*row.Price] = *row.Size
+			}
+			return levels, nil
+		},
+	},
+	"bitfinex": {
+		path: func(channel string) (string, bool) {
+			if !strings.HasPrefix(channel, "book_") {
+				return "", false
+			}
+			return "/v2/book/" + channel[len("book_"):] + "/P0?len=100", true
+		},
+		parse: func(body []byte) (map[int]map[float64]float64, error) {
+			// [price, count, amount], amount is negative for asks
+			var rows [][3]float64
+			if err := json.Unmarshal(body, &rows); err != nil {
+				return nil, err
+			}
+			levels := map[int]map[float64]float64{sideBid: {}, sideAsk: {}}
+			for _, row := range rows {
+				if row[2] < 0 {
+					levels[sideAsk][row[0]] = -row[2]
+				} else {
+					levels[sideBid][row[0]] = row[2]
+				}
+			}
+			return levels, nil
+		},
+	},
+	binanceSpot:    binanceRestBook("/api/v3/depth", 5000),
+	binanceFutures: binanceRestBook("/fapi/v1/depth", 1000),
+}
+
+func binanceRestBook(path string, limit int) restBook {
+	return restBook{
+		path: func(channel string) (string, bool) {
+			at := strings.IndexByte(channel, '@')
+			if at <= 0 || !strings.HasPrefix(channel[at+1:], "depth") {
+				return "", false
+			}
+			return fmt.Sprintf("%s?symbol=%s&limit=%d", path, strings.ToUpper(channel[:at]), limit), true
+		},
+		parse: func(body []byte) (map[int]map[float64]float64, error) {
+			var depth struct {
+				Bids [][2]string `json:"bids"`
+				Asks [][2]string `json:"asks"`
+			}
+			if err := json.Unmarshal(body, &depth); err != nil {
+				return nil, err
+			}
+			levels := map[int]map[float64]float64{sideBid: {}, sideAsk: {}}
+			for side, rows := range map[int][][2]string{sideBid: depth.Bids, sideAsk: depth.Asks} {
+				for _, row := range rows {
+					price, err := strconv.ParseFloat(row[0], 64)
+					if err != nil {
+						return nil, err
+					}
+					size, err := strconv.ParseFloat(row[1], 64)
+					if err != nil {
+						return nil, err
+					}
+					levels[side][price] = size
+				}
+			}
+			return levels, nil
+		},
+	}
+}
+
+// channelAudit is the divergence of the orderbook of a channel from the one of REST API.
+// Only prices both of them cover are compared, as REST APIs return limited number of levels.
+type channelAudit struct {
+	Channel string `json:"channel"`
+	// Compared is the number of levels compared
+	Compared int `json:"compared"`
+	// Missing is the number of levels in REST API but not in the snapshot
+	Missing int `json:"missing"`
+	// Extra is the number of levels in the snapshot but not in REST API
+	Extra int `json:"extra"`
+	// SizeMismatches is the number of levels both have but in different sizes
+	SizeMismatches int `json:"sizeMismatches"`
+	// Skipped is the reason the channel was not audited, if it was not
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// fetchRestBook fetches the current orderbook of `channel` of `exchange` from its REST API.
+func fetchRestBook(ctx context.Context, exchange string, channel string) (levels map[int]map[float64]float64, err error) {
+	book := restBooks[exchange]
+	path, _ := book.path(channel)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, restEndpoints[exchange]+path, nil)
+	if err != nil {
+		return
+	}
+	response, err := auditClient.Do(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("REST API responded %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return book.parse(body)
+}
+
+// compareLevels counts levels of `snapshot` diverging from `rest` within prices covered by both.
+func compareLevels(audit *channelAudit, snapshot map[int]map[float64]float64, rest map[int]map[float64]float64) {
+	for _, side := range []int{sideBid, sideAsk} {
+		// the worst price covered by both, bids are compared as negative prices to be the same as asks
+		covered := math.Inf(1)
+		for _, levels := range []map[float64]float64{snapshot[side], rest[side]} {
+			worst := math.Inf(-1)
+			for price := range levels {
+				worst = math.Max(worst, float64(-side)*price)
+			}
+			covered = math.Min(covered, worst)
+		}
+		for price, size := range rest[side] {
+			if float64(-side)*price > covered {
+				continue
+			}
+			audit.Compared++
+			snapshotSize, ok := snapshot[side][price]
+			if !ok {
+				audit.Missing++
+			} else if snapshotSize != size {
+				audit.SizeMismatches++
+			}
+		}
+		for price := range snapshot[side] {
+			if _, ok := rest[side][price]; !ok && float64(-side)*price <= covered {
+				audit.Compared++
+				audit.Extra++
+			}
+		}
+	}
+}
+
+// auditEntries reports divergence of orderbooks in `entries` of the snapshot at `nanosec` from REST APIs of `exchange`.
+// Orderbooks whose REST API failed are reported as skipped rather than failing the snapshot.
+func auditEntries(ctx context.Context, exchange string, nanosec int64, entries []entry) ([]entry, error) {
+	book, ok := restBooks[exchange]
+	if !ok {
+		return nil, nil
+	}
+	snapshots := make(map[string]map[int]map[float64]float64)
+	for _, e := range entries {
+		if _, ok := book.path(e.channel); !ok {
+			continue
+		}
+		side, price, size, ok := parseLevel(e.message)
+		if !ok {
+			continue
+		}
+		levels, ok := snapshots[e.channel]
+		if !ok {
+			levels = map[int]map[float64]float64{sideBid: {}, sideAsk: {}}
+			snapshots[e.channel] = levels
+		}
+		levels[side][price] = size
+	}
+	channels := make([]string, 0, len(snapshots))
+	for channel := range snapshots {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	recent := time.Since(time.Unix(0, nanosec)) <= auditMaxAge
+	audits := make([]entry, len(channels))
+	for i, channel := range channels {
+		audit := channelAudit{Channel: channel}
+		if !recent {
+			audit.Skipped = "target is not recent"
+		} else if rest, err := fetchRestBook(ctx, exchange, channel); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			LoggerFrom(ctx).Warn("failed to fetch orderbook to audit", "channel", channel, "error", err)
+			audit.Skipped = err.Error()
+		} else {
+			compareLevels(&audit, snapshots[channel], rest)
+		}
+		message, err := json.Marshal(audit)
+		if err != nil {
+			return nil, err
+		}
+		audits[i] = entry{channel: auditChannel, message: message}
+	}
+	return audits, nil
+}
@@ -0,0 +1,79 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuditEntries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/depth" || r.URL.Query().Get("symbol") != "BTCUSDT" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"lastUpdateId":1,"bids":[["100.0","1.0"],["99.0","2.0"],["98.0","3.0"]],"asks":[["101.0","1.0"],["102.0","1.0"]]}`))
+	}))
+	defer server.Close()
+	endpoint := restEndpoints[binanceSpot]
+	restEndpoints[binanceSpot] = server.URL
+	defer func() { restEndpoints[binanceSpot] = endpoint }()
+
+	entries := []entry{
+		{channel: "btcusdt@depth", message: []byte(`{"side":"buy","price":100,"size":1}`)},
+		// size differs
+		{channel: "btcusdt@depth", message: []byte(`{"side":"buy","price":99,"size":1.5}`)},
+		// 101 is missing, 103 is beyond the levels REST API returned
+		{channel: "btcusdt@depth", message: []byte(`{"side":"sell","price":101.5,"size":1}`)},
+		{channel: "btcusdt@depth", message: []byte(`{"side":"sell","price":102,"size":1}`)},
+		{channel: "btcusdt@depth", message: []byte(`{"side":"sell","price":103,"size":1}`)},
+		{channel: "btcusdt@trade", message: []byte(`{"side":"buy","price":100,"size":1}`)},
+	}
+	audits, err := auditEntries(context.Background(), binanceSpot, time.Now().UnixNano(), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 1 || audits[0].channel != auditChannel {
+		t.Fatalf("expected 1 audit entry, got %v", audits)
+	}
+	var audit channelAudit
+	if err := json.Unmarshal(audits[0].message, &audit); err != nil {
+		t.Fatal(err)
+	}
+	// 98 is deeper than the snapshot
+	expected := channelAudit{Channel: "btcusdt@depth", Compared: 5, Missing: 1, Extra: 1, SizeMismatches: 1}
+	if audit != expected {
+		t.Errorf("expected %+v, got %+v", expected, audit)
+	}
+
+	audits, err = auditEntries(context.Background(), binanceSpot, time.Now().Add(-time.Hour).UnixNano(), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(audits[0].message, &audit); err != nil {
+		t.Fatal(err)
+	}
+	if audit.Skipped == "" || audit.Compared != 0 {
+		t.Errorf("expected old target to be skipped, got %+v", audit)
+	}
+}
+
+func TestAuditParameter(t *testing.T) {
+	event := makeLambdaEvent("binance", []string{"btcusdt@depth@100ms"}, "1598941025000000000", "json")
+	event.QueryStringParameters["audit"] = "true"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected audit to be unavailable unless it is enabled")
+	}
+	defer func(enabled bool) { AuditEnabled = enabled }(AuditEnabled)
+	AuditEnabled = true
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !param.Audit {
+		t.Error("expected audit")
+	}
+}
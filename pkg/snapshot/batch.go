@@ -0,0 +1,183 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// BatchJob is snapshots of an exchange the batch makes at every run.
+type BatchJob struct {
+	// Name is the name of the job in keys, `Exchange` if empty
+	Name     string   `json:"name"`
+	Exchange string   `json:"exchange"`
+	Channels []string `json:"channels"`
+	// Format is the format of messages, raw if empty
+	Format string `json:"format"`
+	// Output is the layout of snapshots, tsv if empty
+	Output string `json:"output"`
+	// Parameters are other parameters of the API, values of parameters which can be repeated are separated by comma
+	Parameters map[string]string `json:"parameters"`
+}
+
+// BatchConfig is the configuration of the batch making snapshots at a fixed cadence.
+type BatchConfig struct {
+	// Bucket is the S3 bucket snapshots are written to
+	Bucket string `json:"bucket"`
+	// Prefix is prepended to keys of snapshots
+	Prefix string `json:"prefix"`
+	// Interval is the cadence such as `1h`, snapshots are taken at times truncated to it
+	Interval string `json:"interval"`
+	// Compression is the compression of snapshots, either `gzip` or empty
+	Compression string     `json:"compression"`
+	Jobs        []BatchJob `json:"jobs"`
+	interval    time.Duration
+}
+
+// batchExtensions are extensions of keys of snapshots in each output.
+var batchExtensions = map[string]string{
+	OutputTSV:      ".tsv",
+	OutputJSON:     ".json",
+	OutputCSV:      ".csv",
+	OutputProtobuf: ".pb",
+	OutputArrow:    ".arrow",
+	OutputNDJSON:   ".ndjson",
+}
+
+// ParseBatchConfig parses the configuration of the batch in JSON.
+func ParseBatchConfig(b []byte) (config BatchConfig, err error) {
+	if err = json.Unmarshal(b, &config); err != nil {
+		return
+	}
+	if config.Bucket == "" {
+		return config, errors.New("'bucket' must be specified")
+	}
+	config.interval, err = time.ParseDuration(config.Interval)
+	if err != nil || config.interval < time.Minute {
+		return config, errors.New("'interval' must be a duration of at least a minute such as '1h'")
+	}
+	if config.Compression != "" && config.Compression != "gzip" {
+		return config, errors.New("'compression' must be 'gzip'")
+	}
+	if len(config.Jobs) == 0 {
+		return config, errors.New("'jobs' must be specified")
+	}
+	names := make(map[string]bool)
+	for i := range config.Jobs {
+		job := &config.Jobs[i]
+		if job.Exchange == "" || len(job.Channels) == 0 {
+			return config, fmt.Errorf("job %d: 'exchange' and 'channels' must be specified", i)
+		}
+		if job.Name == "" {
+			job.Name = job.Exchange
+		}
+		if names[job.Name] {
+			// keys would collide
+			return config, fmt.Errorf("job %d: name '%s' is used by another job", i, job.Name)
+		}
+		names[job.Name] = true
+		if job.Output == "" {
+			job.Output = OutputTSV
+		}
+		if _, ok := batchExtensions[job.Output]; !ok {
+			return config, fmt.Errorf("job %d: output '%s' can not be used in the batch", i, job.Output)
+		}
+	}
+	return
+}
+
+// BatchTarget returns the time of snapshots the run at `at` takes, the beginning of the interval `at` is in.
+func (c BatchConfig) BatchTarget(at time.Time) time.Time {
+	return at.UTC().Truncate(c.interval)
+}
+
+// BatchKey returns the key of the snapshot of `job` at `target`,
+// such as `prefix/bitmex/2020/09/01/20200901T0600Z.tsv.gz`.
+func (c BatchConfig) BatchKey(job BatchJob, target time.Time) string {
+	target = target.UTC()
+	key := fmt.Sprintf("%s%s/%s/%s%s", c.Prefix, job.Name, target.Format("2006/01/02"), target.Format("20060102T1504Z"), batchExtensions[job.Output])
+	if c.Compression == "gzip" {
+		key += ".gz"
+	}
+	return key
+}
+
+// BatchResult is the result of a job in a run of the batch.
+type BatchResult struct {
+	Job string `json:"job"`
+	Key string `json:"key"`
+	// Location is the location of the snapshot, nil if nothing was written
+	Location json.RawMessage `json:"location,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// batchEvent makes the request equivalent to `job` at `target`.
+func batchEvent(job BatchJob, target time.Time) events.APIGatewayProxyRequest {
+	query := map[string]string{"format": job.Format, "output": job.Output}
+	if job.Format == "" {
+		query["format"] = "raw"
+	}
+	multi := map[string][]string{"channels": job.Channels}
+	for name, value := range job.Parameters {
+		query[name] = value
+		multi[name] = strings.Split(value, ",")
+	}
+	return events.APIGatewayProxyRequest{
+		PathParameters:                  map[string]string{"exchange": job.Exchange, "nanosec": strconv.FormatInt(target.UnixNano(), 10)},
+		QueryStringParameters:           query,
+		MultiValueQueryStringParameters: multi,
+	}
+}
+
+// RunBatch takes snapshots of all jobs of `config` for the run at `at` and writes them to the bucket.
+// Jobs are run even if others failed, the error tells how many of them failed.
+func RunBatch(ctx context.Context, config BatchConfig, at time.Time) (results []BatchResult, err error) {
+	log := LoggerFrom(ctx)
+	target := config.BatchTarget(at)
+	failed := 0
+	for _, job := range config.Jobs {
+		result := BatchResult{Job: job.Name, Key: config.BatchKey(job, target)}
+		location, serr := runBatchJob(WithLogger(ctx, log.With("job", job.Name)), config, job, target, result.Key)
+		if serr != nil {
+			if ctx.Err() != nil {
+				return results, ctx.Err()
+			}
+			log.Error("batch job failed", "job", job.Name, "key", result.Key, "error", serr)
+			result.Error = serr.Error()
+			failed++
+		} else {
+			log.Info("batch job end", "job", job.Name, "key", result.Key, "empty", location == nil)
+			result.Location = location
+		}
+		results = append(results, result)
+	}
+	if failed > 0 {
+		err = fmt.Errorf("%d of %d batch jobs failed", failed, len(config.Jobs))
+	}
+	return
+}
+
+// runBatchJob takes the snapshot of `job` at `target` and writes it to `key`.
+func runBatchJob(ctx context.Context, config BatchConfig, job BatchJob, target time.Time, key string) (location []byte, err error) {
+	param, err := ParseParameter(batchEvent(job, target))
+	if err != nil {
+		return nil, &SnapshotError{Kind: ErrBadParameter, Err: err}
+	}
+	if len(param.Exchanges) > 0 || param.DryRun {
+		return nil, &SnapshotError{Kind: ErrBadParameter, Err: errors.New("'exchanges' and 'dryRun' can not be used in the batch")}
+	}
+	// the bucket is of the configuration, it does not have to be allowed for requests
+	param.Destination = destinationScheme + config.Bucket + "/" + key
+	param.DestinationCompression = config.Compression
+	location, _, err = Take(ctx, param)
+	if err == nil && location != nil {
+		recordMaterialized(ctx, catalogEntry{Exchange: param.Exchange, Nanosec: target.UnixNano(), Channels: param.Channels, Format: param.Format, Output: param.Output, Source: catalogBatch, Location: location})
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBatchConfig(t *testing.T) {
+	config, err := ParseBatchConfig([]byte(`{"bucket":"snapshots","prefix":"hourly/","interval":"1h","compression":"gzip","jobs":[
+		{"exchange":"bitmex","channels":["orderBookL2"],"format":"json"},
+		{"name":"bitmex-trades","exchange":"bitmex","channels":["trade"],"output":"ndjson"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := config.BatchTarget(time.Date(2020, 9, 1, 6, 17, 5, 0, time.UTC))
+	if !target.Equal(time.Date(2020, 9, 1, 6, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the beginning of the hour, got %v", target)
+	}
+	if key := config.BatchKey(config.Jobs[0], target); key != "hourly/bitmex/2020/09/01/20200901T0600Z.tsv.gz" {
+		t.Errorf("unexpected key: %s", key)
+	}
+	if key := config.BatchKey(config.Jobs[1], target); key != "hourly/bitmex-trades/2020/09/01/20200901T0600Z.ndjson.gz" {
+		t.Errorf("unexpected key: %s", key)
+	}
+	for _, invalid := range []string{
+		`{"interval":"1h","jobs":[{"exchange":"bitmex","channels":["trade"]}]}`,
+		`{"bucket":"snapshots","interval":"1s","jobs":[{"exchange":"bitmex","channels":["trade"]}]}`,
+		`{"bucket":"snapshots","interval":"1h","jobs":[]}`,
+		`{"bucket":"snapshots","interval":"1h","jobs":[{"exchange":"bitmex","channels":["trade"]},{"exchange":"bitmex","channels":["orderBookL2"]}]}`,
+		`{"bucket":"snapshots","interval":"1h","jobs":[{"exchange":"bitmex","channels":["trade"],"output":"parquet"}]}`,
+	} {
+		if _, err := ParseBatchConfig([]byte(invalid)); err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	defer registerFixture()()
+	defer func(dir string) { DatasetDirectory = dir }(DatasetDirectory)
+	DatasetDirectory = goldenDir
+	uploaded := make(map[string]string)
+	defer func(original func(context.Context, string, string, io.Reader, string, string) error) {
+		uploadObject = original
+	}(uploadObject)
+	uploadObject = func(ctx context.Context, bucket string, key string, body io.Reader, contentType string, contentEncoding string) error {
+		b, err := ioutil.ReadAll(body)
+		uploaded[bucket+"/"+key] = string(b)
+		return err
+	}
+	config, err := ParseBatchConfig([]byte(`{"bucket":"snapshots","interval":"1m","jobs":[
+		{"exchange":"fixture","channels":["book","ticker"]},
+		{"name":"broken","exchange":"fixture","channels":["book"],"parameters":{"depth":"1"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// runs at the beginning of the third file
+	results, err := RunBatch(context.Background(), config, time.Unix(0, fixtureAt(2*time.Minute+5*time.Second)))
+	if err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Errorf("expected a job to fail, got %v", err)
+	}
+	if len(results) != 2 || results[0].Error != "" || results[1].Error == "" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	var loc destinationLocation
+	if err := json.Unmarshal(results[0].Location, &loc); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, ok := uploaded["snapshots/"+results[0].Key]
+	if !ok || loc.Key != results[0].Key || loc.Size != int64(len(snapshot)) {
+		t.Fatalf("expected the snapshot at %s, got %v and %+v", results[0].Key, uploaded, loc)
+	}
+	// the reconnection in the second file was applied
+	if !strings.Contains(snapshot, `[[97,1]]`) {
+		t.Errorf("unexpected snapshot: %s", snapshot)
+	}
+}
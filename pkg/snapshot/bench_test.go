@@ -0,0 +1,157 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// benchFixtureMinutes is the number of minute files of fixtures of benchmarks.
+const benchFixtureMinutes = 5
+
+// benchFixture is the profile of dataset of fixtures of benchmarks, made of the fixture exchange
+// with lines per minute of each channel. `trades` lines are fed but not simulated by fixtureSimulator.
+type benchFixture struct {
+	name string
+	// levels is the number of levels of each side in the first book snapshot
+	levels  int
+	book    int
+	ticker  int
+	trades  int
+	options func(param *SnapshotParameter)
+}
+
+// benchFixtures are a large book exchange and a trade-heavy exchange.
+var benchFixtures = []benchFixture{
+	{name: "book", levels: 2000, book: 30000, ticker: 600},
+	{name: "trades", levels: 50, book: 2000, ticker: 600, trades: 40000, options: func(param *SnapshotParameter) {
+		param.Channels = append(param.Channels, "trades")
+		param.RecentTrades = 100
+	}},
+}
+
+// benchFixtureLines returns lines of the minute file `i` of `fixture`, the same every time.
+func benchFixtureLines(fixture benchFixture, i int) []string {
+	r := rand.New(rand.NewSource(int64(i)))
+	minute := int64(fixtureMinute+i) * int64(time.Minute)
+	price := func(mid float64, spread int) string {
+		return strconv.FormatFloat(mid+float64(r.Intn(spread))/2, 'f', 1, 64)
+	}
+	var lines []string
+	if i == 0 {
+		lines = append(lines, fmt.Sprintf("start\t%d\twss://fixture.example.com/ws", minute))
+		bids, asks := new(bytes.Buffer), new(bytes.Buffer)
+		for l := 0; l < fixture.levels; l++ {
+			if l > 0 {
+				bids.WriteByte(',')
+				asks.WriteByte(',')
+			}
+			fmt.Fprintf(bids, "[%d,%d]", 10000-l, l%7+1)
+			fmt.Fprintf(asks, "[%d,%d]", 10001+l, l%5+1)
+		}
+		lines = append(lines, fmt.Sprintf(`msg	%d	book	{"type":"snapshot","bids":[%s],"asks":[%s]}`, minute+1, bids, asks))
+	}
+	total := fixture.book + fixture.ticker + fixture.trades
+	step := int64(time.Minute) / int64(total+2)
+	for l := 0; l < total; l++ {
+		timestamp := minute + int64(l+2)*step
+		switch n := r.Intn(total); {
+		case n < fixture.book:
+			lines = append(lines, fmt.Sprintf(`msg	%d	book	{"type":"update","bids":[[%s,%d]],"asks":[[%s,%d]]}`,
+				timestamp, price(10000-float64(fixture.levels), fixture.levels*2), r.Intn(3), price(10001, fixture.levels*2), r.Intn(3)))
+		case n < fixture.book+fixture.ticker:
+			lines = append(lines, fmt.Sprintf(`msg	%d	ticker	{"last":%s}`, timestamp, price(10000, 4)))
+		default:
+			lines = append(lines, fmt.Sprintf(`msg	%d	trades	{"side":"buy","price":%s,"size":%d}`, timestamp, price(10000, 4), r.Intn(10)+1))
+		}
+	}
+	return lines
+}
+
+// writeBenchFixture writes minute files of `fixture` to `dir` and returns their keys and bytes of them decompressed.
+func writeBenchFixture(b *testing.B, fixture benchFixture, dir string) (keys []string, decompressed []byte) {
+	for i := 0; i < benchFixtureMinutes; i++ {
+		content := new(bytes.Buffer)
+		for _, line := range benchFixtureLines(fixture, i) {
+			content.WriteString(line)
+			content.WriteByte('\n')
+		}
+		buf := new(bytes.Buffer)
+		writer := gzip.NewWriter(buf)
+		writer.Write(content.Bytes())
+		if err := writer.Close(); err != nil {
+			b.Fatal(err)
+		}
+		key := fmt.Sprintf("%s_%d.gz", fixtureExchange, fixtureMinute+i)
+		if err := ioutil.WriteFile(filepath.Join(dir, key), buf.Bytes(), 0644); err != nil {
+			b.Fatal(err)
+		}
+		keys = append(keys, key)
+		decompressed = append(decompressed, content.Bytes()...)
+	}
+	return
+}
+
+// BenchmarkFixtureFeed measures FeedToSimulator over fixtures of each profile, the simulator is of the fixture exchange.
+func BenchmarkFixtureFeed(b *testing.B) {
+	for _, fixture := range benchFixtures {
+		b.Run(fixture.name, func(b *testing.B) {
+			_, dataset := writeBenchFixture(b, fixture, b.TempDir())
+			b.SetBytes(int64(len(dataset)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var sim simulator.Simulator = &fixtureSimulator{channels: []string{"book", "ticker"}}
+				f := &Feeder{
+					Sim:       &sim,
+					SetNewSim: func(*simulator.Simulator) error { return nil },
+					Targets:   []int64{1 << 62},
+					OnTarget:  func(int64) error { return nil },
+				}
+				if _, _, err := FeedToSimulator(context.Background(), bufio.NewReader(bytes.NewReader(dataset)), f); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFixtureSnapshot measures Snapshot from compressed fixture files of each profile to a formatted snapshot
+// at the end of them, bytes per second are of decompressed dataset.
+func BenchmarkFixtureSnapshot(b *testing.B) {
+	defer registerFixture()()
+	for _, fixture := range benchFixtures {
+		b.Run(fixture.name, func(b *testing.B) {
+			dir := b.TempDir()
+			keys, dataset := writeBenchFixture(b, fixture, dir)
+			param := SnapshotParameter{
+				Exchange: fixtureExchange,
+				Nanosecs: []int64{int64(fixtureMinute+benchFixtureMinutes)*int64(time.Minute) - 1},
+				Channels: []string{"book", "ticker"},
+				Format:   fixtureExchange,
+				Output:   OutputTSV,
+			}
+			if fixture.options != nil {
+				fixture.options(&param)
+			}
+			b.SetBytes(int64(len(dataset)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := Snapshot(context.Background(), param, NewDirSource(dir, keys)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// exchanges of Binance, channels of both are streams in the form of `symbol@stream`
+const (
+	binanceSpot = "binance"
+	// binanceFutures is USDⓈ-M futures, whose depth updates also have the final ID of the previous update
+	binanceFutures = "binance-futures"
+)
+
+// isBinance returns true if `exchange` is of Binance.
+func isBinance(exchange string) bool {
+	return exchange == binanceSpot || exchange == binanceFutures
+}
+
+// validateBinanceChannels returns the error if any of `channels` is not a stream name of Binance.
+// Streams are case-sensitive and symbols are in lowercase, `BTCUSDT@depth` would silently match nothing.
+func validateBinanceChannels(channels []string) error {
+	for _, channel := range channels {
+		if isPattern(channel) {
+			continue
+		}
+		at := strings.IndexByte(channel, '@')
+		if at <= 0 || at == len(channel)-1 {
+			return fmt.Errorf("channel '%s' must be in the form of 'symbol@stream' such as 'btcusdt@depth@100ms'", channel)
+		}
+		if symbol := channel[:at]; symbol != strings.ToLower(symbol) {
+			return fmt.Errorf("symbol of channel '%s' must be in lowercase: '%s'", channel, strings.ToLower(symbol)+channel[at:])
+		}
+	}
+	return nil
+}
+
+// binanceFuturesUpdate is the part of depth messages of Binance futures having update IDs.
+type binanceFuturesUpdate struct {
+	Data         *binanceFuturesUpdate `json:"data"`
+	FinalID      *int64                `json:"u"`
+	PreviousID   *int64                `json:"pu"`
+	LastUpdateID *int64                `json:"lastUpdateId"`
+}
+
+// binanceFuturesSequence extracts update IDs of futures, where the first ID of an update does not follow the
+// final ID of the previous one, but `pu` is the final ID of the previous update.
+func binanceFuturesSequence(channel string, message []byte) (key string, first int64, last int64, reset bool, ok bool) {
+	at := strings.IndexByte(channel, '@')
+	if at < 0 || !strings.Contains(channel[at:], "depth") {
+		return
+	}
+	key = channel[:at]
+	var update binanceFuturesUpdate
+	if err := json.Unmarshal(message, &update); err != nil {
+		return
+	}
+	if update.Data != nil {
+		update = *update.Data
+	}
+	if update.LastUpdateID != nil {
+		return key, *update.LastUpdateID, *update.LastUpdateID, true, true
+	}
+	if update.PreviousID == nil || update.FinalID == nil {
+		return
+	}
+	return key, *update.PreviousID + 1, *update.FinalID, false, true
+}
@@ -0,0 +1,50 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateBinanceChannels(t *testing.T) {
+	if err := validateBinanceChannels([]string{"btcusdt@depth@100ms", "btcusdt@rest_depth", "ethusdt@trade", "*@depth*"}); err != nil {
+		t.Errorf("expected valid channels, got %v", err)
+	}
+	for _, channel := range []string{"BTCUSDT@depth", "btcusdt", "@depth", "btcusdt@"} {
+		if err := validateBinanceChannels([]string{channel}); err == nil {
+			t.Errorf("%s: expected error", channel)
+		}
+	}
+	if _, err := ParseParameter(makeLambdaEvent("binance-futures", []string{"BTCUSDT@depth"}, "1598941025000000000", "json")); err == nil {
+		t.Error("expected channels of futures to be validated")
+	}
+}
+
+func TestBinanceFuturesSequence(t *testing.T) {
+	checker := newSequenceChecker(binanceFutures)
+	checker.check("btcusdt@rest_depth", []byte(`{"lastUpdateId":100,"bids":[],"asks":[]}`))
+	// the first update has the snapshot within it
+	checker.check("btcusdt@depth@100ms", []byte(`{"stream":"btcusdt@depth@100ms","data":{"e":"depthUpdate","U":95,"u":105,"pu":94}}`))
+	// IDs of futures are not consecutive, `pu` tells the previous one
+	checker.check("btcusdt@depth@100ms", []byte(`{"stream":"btcusdt@depth@100ms","data":{"e":"depthUpdate","U":120,"u":130,"pu":105}}`))
+	checker.check("btcusdt@depth@100ms", []byte(`{"stream":"btcusdt@depth@100ms","data":{"e":"depthUpdate","U":150,"u":160,"pu":140}}`))
+	entries, err := checker.consistencyEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	var report channelConsistency
+	if err := json.Unmarshal(entries[0].message, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Consistent || len(report.Gaps) != 1 || report.Gaps[0] != [2]int64{131, 140} {
+		t.Errorf("expected a gap after 130: %+v", report)
+	}
+	if companions := companionChannels(binanceFutures, "btcusdt@depth@100ms"); len(companions) != 1 || companions[0] != "btcusdt@rest_depth" {
+		t.Errorf("expected rest_depth to be the companion, got %v", companions)
+	}
+	if symbol := channelSymbol(binanceFutures, "btcusdt@markPrice"); symbol != "btcusdt" {
+		t.Errorf("expected btcusdt, got %s", symbol)
+	}
+}
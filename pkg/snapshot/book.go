@@ -0,0 +1,208 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// side of orderbook level
+const (
+	sideBid = 1
+	sideAsk = -1
+)
+
+// level is an orderbook level in the formatted message.
+type level struct {
+	Side  string   `json:"side"`
+	Price *float64 `json:"price"`
+	Size  *float64 `json:"size"`
+}
+
+// parseLevel parses formatted message of an orderbook level.
+// `ok` is false if the message is not an orderbook level.
+func parseLevel(message []byte) (side int, price float64, size float64, ok bool) {
+	if len(message) == 0 || message[0] != '{' {
+		return
+	}
+	var l level
+	if err := json.Unmarshal(message, &l); err != nil || l.Price == nil || l.Size == nil {
+		return
+	}
+	switch strings.ToLower(l.Side) {
+	case "buy", "bid":
+		side = sideBid
+	case "sell", "ask":
+		side = sideAsk
+	default:
+		return
+	}
+	return side, *l.Price, *l.Size, true
+}
+
+// limitDepth removes orderbook levels other than the best `depth` levels of each side per channel.
+// Entries which are not orderbook levels are left as they are.
+func limitDepth(entries []entry, depth int) []entry {
+	type indexedLevel struct {
+		index int
+		price float64
+	}
+	// levels per channel and side
+	levels := make(map[string]map[int][]indexedLevel)
+	for i, e := range entries {
+		side, price, _, ok := parseLevel(e.message)
+		if !ok {
+			continue
+		}
+		sides, ok := levels[e.channel]
+		if !ok {
+			sides = make(map[int][]indexedLevel)
+			levels[e.channel] = sides
+		}
+		sides[side] = append(sides[side], indexedLevel{index: i, price: price})
+	}
+	removed := make(map[int]bool)
+	for _, sides := range levels {
+		for side, ls := range sides {
+			if len(ls) <= depth {
+				continue
+			}
+			// best prices first
+			sort.SliceStable(ls, func(i, j int) bool {
+				if side == sideBid {
+					return ls[i].price > ls[j].price
+				}
+				return ls[i].price < ls[j].price
+			})
+			for _, l := range ls[depth:] {
+				removed[l.index] = true
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return entries
+	}
+	limited := make([]entry, 0, len(entries)-len(removed))
+	for i, e := range entries {
+		if !removed[i] {
+			limited = append(limited, e)
+		}
+	}
+	return limited
+}
+
+// aggregateLevels merges orderbook levels into price buckets of width `bucket` per channel and side.
+// Bid prices are rounded down and ask prices are rounded up to the bucket, then sizes are summed up.
+// Prices are rounded to `decimals` digits after the decimal point to avoid floating point errors.
+// A merged level takes the place of the first level in the bucket, other entries are left as they are.
+func aggregateLevels(entries []entry, bucket float64, decimals int) (aggregated []entry, err error) {
+	type bucketKey struct {
+		channel string
+		side    int
+		price   float64
+	}
+	type bucketLevel struct {
+		// original is the first level of this bucket
+		original []byte
+		size     float64
+	}
+	buckets := make(map[bucketKey]*bucketLevel)
+	// keys of buckets in the order of entries, entries which is not a level have nil
+	order := make([]*bucketKey, len(entries))
+	for i, e := range entries {
+		side, price, size, ok := parseLevel(e.message)
+		if !ok {
+			continue
+		}
+		var rounded float64
+		if side == sideBid {
+			rounded = math.Floor(price/bucket) * bucket
+		} else {
+			rounded = math.Ceil(price/bucket) * bucket
+		}
+		rounded, _ = strconv.ParseFloat(strconv.FormatFloat(rounded, 'f', decimals, 64), 64)
+		key := bucketKey{channel: e.channel, side: side, price: rounded}
+		if b, ok := buckets[key]; ok {
+			b.size += size
+			continue
+		}
+		buckets[key] = &bucketLevel{original: e.message, size: size}
+		order[i] = &key
+	}
+	aggregated = make([]entry, 0, len(entries))
+	for i, e := range entries {
+		if _, _, _, ok := parseLevel(e.message); !ok {
+			aggregated = append(aggregated, e)
+			continue
+		}
+		key := order[i]
+		if key == nil {
+			// merged into the bucket of a former level
+			continue
+		}
+		// keep fields other than price and size
+		fields := make(map[string]interface{})
+		if err = json.Unmarshal(buckets[*key].original, &fields); err != nil {
+			return
+		}
+		fields["price"] = key.price
+		fields["size"] = buckets[*key].size
+		var message []byte
+		message, err = json.Marshal(fields)
+		if err != nil {
+			return
+		}
+		aggregated = append(aggregated, entry{channel: e.channel, message: message})
+	}
+	return
+}
+
+// filterLevels removes orderbook levels other than of `side` per channel, or levels of both sides if `side` is 0.
+// Levels further than `withinPercent` percent from mid price are also removed if it is positive,
+// every level is kept in channels without levels on both sides as mid price is not known.
+// Entries which are not orderbook levels are left as they are.
+func filterLevels(entries []entry, side int, withinPercent float64) []entry {
+	type bestPrices struct {
+		bid, ask float64
+	}
+	best := make(map[string]*bestPrices)
+	if withinPercent > 0 {
+		for _, e := range entries {
+			levelSide, price, _, ok := parseLevel(e.message)
+			if !ok {
+				continue
+			}
+			b, ok := best[e.channel]
+			if !ok {
+				b = &bestPrices{bid: math.Inf(-1), ask: math.Inf(1)}
+				best[e.channel] = b
+			}
+			if levelSide == sideBid {
+				b.bid = math.Max(b.bid, price)
+			} else {
+				b.ask = math.Min(b.ask, price)
+			}
+		}
+	}
+	filtered := make([]entry, 0, len(entries))
+	for _, e := range entries {
+		levelSide, price, _, ok := parseLevel(e.message)
+		if !ok {
+			filtered = append(filtered, e)
+			continue
+		}
+		if side != 0 && levelSide != side {
+			continue
+		}
+		if b, ok := best[e.channel]; ok && !math.IsInf(b.bid, 0) && !math.IsInf(b.ask, 0) {
+			mid := (b.bid + b.ask) / 2
+			if math.Abs(price-mid) > mid*withinPercent/100 {
+				continue
+			}
+		}
+		filtered = append(filtered, e)
+	}
+	return filtered
+}
@@ -0,0 +1,127 @@
+package snapshot
+
+import "testing"
+
+func TestLimitDepth(t *testing.T) {
+	entries := []entry{
+		{channel: "book", message: []byte(`{"side":"Buy","price":99,"size":1}`)},
+		{channel: "book", message: []byte(`{"side":"Buy","price":100,"size":1}`)},
+		{channel: "book", message: []byte(`{"side":"Buy","price":98,"size":1}`)},
+		{channel: "book", message: []byte(`{"side":"Sell","price":102,"size":1}`)},
+		{channel: "book", message: []byte(`{"side":"Sell","price":101,"size":1}`)},
+		{channel: "trade", message: []byte(`{"price":100,"size":1}`)},
+	}
+	limited := limitDepth(entries, 1)
+	expected := []string{
+		`{"side":"Buy","price":100,"size":1}`,
+		`{"side":"Sell","price":101,"size":1}`,
+		`{"price":100,"size":1}`,
+	}
+	if len(limited) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(limited))
+	}
+	for i := range expected {
+		if string(limited[i].message) != expected[i] {
+			t.Errorf("entry %d: expected %s, got %s", i, expected[i], limited[i].message)
+		}
+	}
+}
+
+func TestAggregateLevels(t *testing.T) {
+	entries := []entry{
+		{channel: "book", message: []byte(`{"pair":"BTCUSD","price":100.2,"side":"Buy","size":1}`)},
+		{channel: "book", message: []byte(`{"pair":"BTCUSD","price":100.4,"side":"Buy","size":2}`)},
+		{channel: "book", message: []byte(`{"pair":"BTCUSD","price":100.6,"side":"Buy","size":3}`)},
+		{channel: "book", message: []byte(`{"pair":"BTCUSD","price":100.7,"side":"Sell","size":4}`)},
+		{channel: "book", message: []byte(`{"pair":"BTCUSD","price":100.9,"side":"Sell","size":5}`)},
+	}
+	aggregated, err := aggregateLevels(entries, 0.5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`{"pair":"BTCUSD","price":100,"side":"Buy","size":3}`,
+		`{"pair":"BTCUSD","price":100.5,"side":"Buy","size":3}`,
+		`{"pair":"BTCUSD","price":101,"side":"Sell","size":9}`,
+	}
+	if len(aggregated) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(aggregated))
+	}
+	for i := range expected {
+		if string(aggregated[i].message) != expected[i] {
+			t.Errorf("entry %d: expected %s, got %s", i, expected[i], aggregated[i].message)
+		}
+	}
+}
+
+func TestSortEntries(t *testing.T) {
+	entries := []entry{
+		{channel: "trade", message: []byte("1")},
+		{channel: "orderBookL2_XBTUSD", message: []byte("2")},
+		{channel: "orderBookL2_ETHUSD", message: []byte("3")},
+		{channel: "orderBookL2_XBTUSD", message: []byte("4")},
+	}
+	sortEntries(entries, nil)
+	expected := "3241"
+	got := ""
+	for _, e := range entries {
+		got += string(e.message)
+	}
+	if got != expected {
+		t.Fatalf("expected %s by name, got %s", expected, got)
+	}
+	sortEntries(entries, []string{"trade", "orderBookL2"})
+	got = ""
+	for _, e := range entries {
+		got += string(e.message)
+	}
+	if got != "1324" {
+		t.Fatalf("expected requested order, got %s", got)
+	}
+}
+
+func TestFilterLevels(t *testing.T) {
+	entries := []entry{
+		{channel: "book", message: []byte(`{"side":"Buy","price":99,"size":1}`)},
+		{channel: "book", message: []byte(`{"side":"Buy","price":90,"size":1}`)},
+		{channel: "book", message: []byte(`{"side":"Sell","price":101,"size":1}`)},
+		{channel: "book", message: []byte(`{"side":"Sell","price":110,"size":1}`)},
+		// mid price is not known with only one side
+		{channel: "bids", message: []byte(`{"side":"Buy","price":50,"size":1}`)},
+		{channel: "trade", message: []byte(`{"price":100,"size":1}`)},
+	}
+	cases := []struct {
+		side          int
+		withinPercent float64
+		expected      []string
+	}{
+		{side: sideBid, expected: []string{
+			`{"side":"Buy","price":99,"size":1}`,
+			`{"side":"Buy","price":90,"size":1}`,
+			`{"side":"Buy","price":50,"size":1}`,
+			`{"price":100,"size":1}`,
+		}},
+		{withinPercent: 5, expected: []string{
+			`{"side":"Buy","price":99,"size":1}`,
+			`{"side":"Sell","price":101,"size":1}`,
+			`{"side":"Buy","price":50,"size":1}`,
+			`{"price":100,"size":1}`,
+		}},
+		{side: sideAsk, withinPercent: 5, expected: []string{
+			`{"side":"Sell","price":101,"size":1}`,
+			`{"price":100,"size":1}`,
+		}},
+	}
+	for _, c := range cases {
+		filtered := filterLevels(entries, c.side, c.withinPercent)
+		if len(filtered) != len(c.expected) {
+			t.Errorf("side %d within %v: expected %d entries, got %d", c.side, c.withinPercent, len(c.expected), len(filtered))
+			continue
+		}
+		for i := range c.expected {
+			if string(filtered[i].message) != c.expected[i] {
+				t.Errorf("side %d within %v: entry %d: expected %s, got %s", c.side, c.withinPercent, i, c.expected[i], filtered[i].message)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// snapshotBudget limits snapshots taken at the same time in the process by their number and
+// the memory they could use for buffers. Snapshots beyond the limits wait until others finish.
+type snapshotBudget struct {
+	mu sync.Mutex
+	// maxRunning is the number of snapshots which can be taken at the same time, not limited if not positive
+	maxRunning int
+	// maxMemory is the bytes of buffers of all snapshots being taken, not limited if not positive
+	maxMemory int64
+	// maxQueued is the number of snapshots which can wait, snapshots beyond it are rejected, not limited if not positive
+	maxQueued int
+	running   int
+	memory    int64
+	queued    int
+	// released is closed and replaced when any of snapshots finished
+	released chan struct{}
+}
+
+func newSnapshotBudget(maxRunning int, maxMemory int64, maxQueued int) *snapshotBudget {
+	return &snapshotBudget{maxRunning: maxRunning, maxMemory: maxMemory, maxQueued: maxQueued, released: make(chan struct{})}
+}
+
+// budget is the budget shared by all snapshots in this process.
+var budget = newSnapshotBudget(MaxConcurrentSnapshots, int64(MemoryBudgetMB)*1024*1024, MaxQueuedSnapshots)
+
+// fits returns true if a snapshot using `memory` bytes can be started now, called with the lock held.
+func (b *snapshotBudget) fits(memory int64) bool {
+	if b.maxRunning > 0 && b.running >= b.maxRunning {
+		return false
+	}
+	// a snapshot always runs if nothing else is running, even if it exceeds the budget by itself
+	return b.maxMemory <= 0 || b.running == 0 || b.memory+memory <= b.maxMemory
+}
+
+// acquire waits until a snapshot using `memory` bytes of buffers can be started and returns the function to call when it finished.
+// `ErrOverloaded` is returned if too many snapshots are already waiting.
+func (b *snapshotBudget) acquire(ctx context.Context, memory int64) (release func(), err error) {
+	b.mu.Lock()
+	if !b.fits(memory) {
+		if b.maxQueued > 0 && b.queued >= b.maxQueued {
+			b.mu.Unlock()
+			return nil, newSnapshotError(ErrOverloaded, fmt.Errorf("%d snapshots are already waiting", b.queued))
+		}
+		b.queued++
+		for !b.fits(memory) {
+			released := b.released
+			b.mu.Unlock()
+			select {
+			case <-released:
+			case <-ctx.Done():
+				b.mu.Lock()
+				b.queued--
+				b.mu.Unlock()
+				return nil, ctx.Err()
+			}
+			b.mu.Lock()
+		}
+		b.queued--
+	}
+	b.running++
+	b.memory += memory
+	b.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			b.running--
+			b.memory -= memory
+			close(b.released)
+			b.released = make(chan struct{})
+			b.mu.Unlock()
+		})
+	}, nil
+}
+
+// bufferMemory returns the bytes of decompressed blocks the pipeline could buffer at most for `param`,
+// files being prepared ahead and the file being simulated.
+func bufferMemory(param SnapshotParameter) int64 {
+	depth := param.PrefetchFiles
+	if depth <= 0 {
+		depth = pipelineDepth
+	}
+	blocks := param.ReadAheadBlocks
+	if blocks <= 0 {
+		blocks = pipelineBlocks
+	}
+	return int64(depth+1) * int64(blocks) * int64(DecompressBlockKB) * 1024
+}
@@ -0,0 +1,53 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSnapshotBudget(t *testing.T) {
+	b := newSnapshotBudget(2, 100, 1)
+	first, err := b.acquire(context.Background(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// exceeds the memory with the first one
+	acquired := make(chan func())
+	go func() {
+		release, err := b.acquire(context.Background(), 60)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- release
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected to wait for the first snapshot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	// the queue is full
+	if _, err := b.acquire(context.Background(), 50); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("expected ErrOverloaded, got %v", err)
+	}
+	first()
+	// releasing twice does nothing
+	first()
+	second := <-acquired
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.acquire(ctx, 60); err != context.Canceled {
+		t.Errorf("expected canceled, got %v", err)
+	}
+	second()
+	// larger than the whole budget, but nothing else is running
+	release, err := b.acquire(context.Background(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if b.running != 0 || b.memory != 0 || b.queued != 0 {
+		t.Errorf("expected nothing left, got running %d, memory %d, queued %d", b.running, b.memory, b.queued)
+	}
+}
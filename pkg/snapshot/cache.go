@@ -0,0 +1,180 @@
+package snapshot
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheDirectory is the path to the directory to cache dataset files downloaded, disabled if empty.
+// Files are cached as they are downloaded, so that warm instances do not download the same files again.
+var CacheDirectory = os.Getenv("CACHE_DIR")
+
+// CacheMaxBytes is the maximum total size of cached files, least recently used files are removed beyond it.
+var CacheMaxBytes = int64(envInt("CACHE_MAX_BYTES", 256*1024*1024))
+
+// cacheSource is DatasetSource reading files from the cache, and from `rest` for files not cached.
+type cacheSource struct {
+	dir  string
+	keys []string
+	// cached[i] is true if keys[i] was in the cache
+	cached []bool
+	// rest is the source of files not cached in the order of `keys`
+	rest DatasetSource
+	i    int
+}
+
+// cachePath returns the path to the cached file `key` in `dir`.
+func cachePath(dir string, key string) string {
+	// keys could have prefix with slashes
+	return filepath.Join(dir, strings.ReplaceAll(key, "/", "%2F"))
+}
+
+// newCacheSource makes cacheSource for `keys` in `dir`, `open` is called to make the source of files not cached.
+// `dir` should be unique for the location of files such as buckets.
+func newCacheSource(dir string, keys []string, open func(keys []string) (DatasetSource, error)) (*cacheSource, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &cacheSource{dir: dir, keys: keys, cached: make([]bool, len(keys)), i: -1}
+	missing := make([]string, 0, len(keys))
+	for i, key := range keys {
+		if _, err := os.Stat(cachePath(dir, key)); err == nil {
+			s.cached[i] = true
+		} else {
+			missing = append(missing, key)
+		}
+	}
+	Logger.Debug("files are cached", "cached", len(keys)-len(missing), "files", len(keys))
+	rest, err := open(missing)
+	if err != nil {
+		return nil, err
+	}
+	s.rest = rest
+	return s, nil
+}
+
+func (s *cacheSource) Next() (io.ReadCloser, bool) {
+	if s.i+1 >= len(s.keys) {
+		return nil, false
+	}
+	s.i++
+	path := cachePath(s.dir, s.keys[s.i])
+	if s.cached[s.i] {
+		file, err := os.Open(path)
+		if err != nil {
+			Logger.Warn("could not open cached file", "file", s.Name(), "error", err)
+			return nil, true
+		}
+		// mark as recently used
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return file, true
+	}
+	body, ok := s.rest.Next()
+	if !ok || body == nil {
+		// files which did not exist are not cached as they might be uploaded later
+		return nil, true
+	}
+	temp, err := ioutil.TempFile(s.dir, ".download-")
+	if err != nil {
+		Logger.Warn("could not cache file", "file", s.Name(), "error", err)
+		return body, true
+	}
+	return &cachingReader{body: body, temp: temp, path: path, dir: s.dir}, true
+}
+
+// cacheStatter is DatasetSource telling how many of files were read from the cache.
+type cacheStatter interface {
+	CacheStats() (hits int, misses int)
+}
+
+func (s *cacheSource) CacheStats() (hits int, misses int) {
+	for _, cached := range s.cached {
+		if cached {
+			hits++
+		} else {
+			misses++
+		}
+	}
+	return
+}
+
+func (s *cacheSource) Name() string {
+	if s.i < 0 || s.i >= len(s.keys) {
+		return ""
+	}
+	return s.keys[s.i]
+}
+
+func (s *cacheSource) Close() error {
+	return s.rest.Close()
+}
+
+// cachingReader reads body and writes it to the cache, the file is cached only if it was read through.
+type cachingReader struct {
+	body io.ReadCloser
+	temp *os.File
+	path string
+	dir  string
+	eof  bool
+	// err is the error writing to the cache, reading continues without caching
+	err error
+}
+
+func (r *cachingReader) Read(p []byte) (n int, err error) {
+	n, err = r.body.Read(p)
+	if n > 0 && r.err == nil {
+		_, r.err = r.temp.Write(p[:n])
+	}
+	if err == io.EOF {
+		r.eof = true
+	}
+	return
+}
+
+func (r *cachingReader) Close() error {
+	err := r.body.Close()
+	serr := r.temp.Close()
+	if r.eof && r.err == nil && serr == nil {
+		if serr := os.Rename(r.temp.Name(), r.path); serr == nil {
+			evictCache(r.dir, CacheMaxBytes)
+			return err
+		}
+	}
+	os.Remove(r.temp.Name())
+	return err
+}
+
+// evictCache removes least recently used files in `dir` until the total size is not more than `max`.
+func evictCache(dir string, max int64) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		Logger.Warn("could not list cache", "error", err)
+		return
+	}
+	total := int64(0)
+	for _, file := range files {
+		total += file.Size()
+	}
+	if total <= max {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, file := range files {
+		if total <= max {
+			break
+		}
+		if strings.HasPrefix(file.Name(), ".download-") {
+			// being downloaded
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, file.Name())); err == nil {
+			total -= file.Size()
+		}
+	}
+}
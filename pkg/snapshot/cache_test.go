@@ -0,0 +1,52 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheSource(t *testing.T) {
+	remote, err := ioutil.TempDir("", "stream-snapshot-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	cache, err := ioutil.TempDir("", "stream-snapshot-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache)
+	if err := ioutil.WriteFile(filepath.Join(remote, "bitmex_1.gz"), []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"bitmex_1.gz", "bitmex_2.gz"}
+	var opened [][]string
+	open := func(keys []string) (DatasetSource, error) {
+		opened = append(opened, keys)
+		return NewDirSource(remote, keys), nil
+	}
+	for round := 0; round < 2; round++ {
+		source, err := newCacheSource(cache, keys, open)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, ok := source.Next()
+		if !ok || body == nil {
+			t.Fatal("expected first file")
+		}
+		content, err := ioutil.ReadAll(body)
+		if err != nil || string(content) != "first" {
+			t.Fatalf("unexpected content: %s, %v", content, err)
+		}
+		body.Close()
+		if body, ok := source.Next(); !ok || body != nil {
+			t.Fatal("expected missing file to be nil")
+		}
+		source.Close()
+	}
+	if len(opened[1]) != 1 || opened[1][0] != "bitmex_2.gz" {
+		t.Fatalf("expected only missing file to be fetched again: %v", opened)
+	}
+}
@@ -0,0 +1,270 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// catalogLookbackDays is the number of days before the target the nearest materialized snapshot is looked for in.
+const catalogLookbackDays = 7
+
+// sources of materialized snapshots in the catalog
+const (
+	// catalogBatch is of snapshots written by the batch, they are read from their location
+	catalogBatch = "batch"
+	// catalogCache is of results in the result cache, they are returned by requesting them again
+	catalogCache = "cache"
+)
+
+// catalogEntry is a snapshot materialized by the batch or the result cache.
+type catalogEntry struct {
+	Exchange string   `json:"exchange"`
+	Nanosec  int64    `json:"nanosec"`
+	Channels []string `json:"channels"`
+	Format   string   `json:"format"`
+	Output   string   `json:"output"`
+	// Source is the subsystem which materialized it, one of `catalog*`
+	Source string `json:"source"`
+	// Location is the location of the snapshot written by the batch, nil if it is in the result cache
+	Location json.RawMessage `json:"location,omitempty"`
+	// MadeAt is the time it was materialized in unix nanoseconds
+	MadeAt int64 `json:"madeAt"`
+}
+
+// catalogKey returns the key of `entry`, such as `bitmex/2020/09/01/1598940000000000000-5f1b2c3d.json`.
+// Keys in a day are sorted by the time of snapshots, the suffix tells entries at the same time apart.
+func catalogKey(entry catalogEntry) string {
+	id := sha1.Sum([]byte(fmt.Sprintf("%s\n%v\n%s\n%s\n", entry.Source, entry.Channels, entry.Format, entry.Output)))
+	return fmt.Sprintf("%s/%s/%019d-%s.json", entry.Exchange, time.Unix(0, entry.Nanosec).UTC().Format("2006/01/02"), entry.Nanosec, hex.EncodeToString(id[:4]))
+}
+
+// catalogNanosec returns the time of the snapshot of the entry at `key`.
+func catalogNanosec(key string) (int64, bool) {
+	name := path.Base(key)
+	dash := strings.IndexByte(name, '-')
+	if dash < 0 {
+		return 0, false
+	}
+	nanosec, err := strconv.ParseInt(name[:dash], 10, 64)
+	return nanosec, err == nil
+}
+
+// catalogStore stores entries of the catalog by keys of catalogKey.
+type catalogStore interface {
+	Put(key string, entry catalogEntry) error
+	Get(key string) (catalogEntry, error)
+	// List returns keys of entries with `prefix` in the order of key
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// catalog is the store of entries in `CatalogBucket`, nil if it is not configured.
+var catalog catalogStore
+
+// catalogStoreFor returns the store of the catalog configured, or nil if it is not.
+func catalogStoreFor() catalogStore {
+	if CatalogDirectory != "" {
+		return dirCatalogStore(CatalogDirectory)
+	}
+	return catalog
+}
+
+// recordMaterialized records `entry` in the catalog if it is configured, failing to record it is only logged.
+func recordMaterialized(ctx context.Context, entry catalogEntry) {
+	store := catalogStoreFor()
+	if store == nil {
+		return
+	}
+	entry.MadeAt = time.Now().UnixNano()
+	if err := store.Put(catalogKey(entry), entry); err != nil {
+		LoggerFrom(ctx).Warn("could not record materialized snapshot", "nanosec", entry.Nanosec, "source", entry.Source, "error", err)
+	}
+}
+
+// cacheCatalogEntry returns the entry of the result of `param` in the result cache,
+// `ok` is false if it is not of a single snapshot which can be told by the catalog.
+func cacheCatalogEntry(param SnapshotParameter) (entry catalogEntry, ok bool) {
+	if len(param.Nanosecs) != 1 || len(param.Exchanges) > 0 || len(param.Formats) > 1 || param.FanOut || param.Diff || param.State != nil {
+		return
+	}
+	return catalogEntry{Exchange: param.Exchange, Nanosec: param.Nanosecs[0], Channels: param.Channels, Format: param.Format, Output: param.Output, Source: catalogCache}, true
+}
+
+// matches returns true if `entry` has all channels of `param` in its format and output.
+func (entry catalogEntry) matches(param SnapshotParameter) bool {
+	if entry.Exchange != param.Exchange || entry.Format != param.Format || entry.Output != param.Output {
+		return false
+	}
+	has := make(map[string]bool, len(entry.Channels))
+	for _, channel := range entry.Channels {
+		has[channel] = true
+	}
+	for _, channel := range param.Channels {
+		if !has[channel] {
+			return false
+		}
+	}
+	return true
+}
+
+// nearestResult is the result of Nearest in JSON.
+type nearestResult struct {
+	Found    bool          `json:"found"`
+	Snapshot *catalogEntry `json:"snapshot,omitempty"`
+	// Behind is nanoseconds from the snapshot to the target
+	Behind int64 `json:"behind,omitempty"`
+}
+
+// Nearest returns the snapshot materialized at or before the target of `param` nearest to it in JSON,
+// which has all channels of `param` in its format and output. Snapshots are looked for within `catalogLookbackDays`,
+// so that consumers can choose it instead of reconstructing the exact snapshot.
+func Nearest(ctx context.Context, param SnapshotParameter) ([]byte, error) {
+	store := catalogStoreFor()
+	if store == nil {
+		return nil, newSnapshotError(ErrBadParameter, errors.New("the catalog of materialized snapshots is not available"))
+	}
+	target := param.Nanosecs[0]
+	day := time.Unix(0, target).UTC().Truncate(24 * time.Hour)
+	for i := 0; i <= catalogLookbackDays; i++ {
+		prefix := param.Exchange + "/" + day.AddDate(0, 0, -i).Format("2006/01/02") + "/"
+		keys, err := store.List(ctx, prefix)
+		if err != nil {
+			return nil, newSnapshotError(ErrStorage, err)
+		}
+		// the latest first
+		for j := len(keys) - 1; j >= 0; j-- {
+			nanosec, ok := catalogNanosec(keys[j])
+			if !ok || nanosec > target {
+				continue
+			}
+			entry, err := store.Get(keys[j])
+			if err != nil {
+				return nil, newSnapshotError(ErrStorage, err)
+			}
+			if entry.matches(param) {
+				return json.Marshal(nearestResult{Found: true, Snapshot: &entry, Behind: target - entry.Nanosec})
+			}
+		}
+	}
+	return json.Marshal(nearestResult{})
+}
+
+// s3CatalogStore is catalogStore saving entries as S3 objects.
+type s3CatalogStore struct {
+	client *s3.S3
+	bucket string
+}
+
+func newS3CatalogStore(bucket string) (*s3CatalogStore, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return &s3CatalogStore{client: s3.New(sess), bucket: bucket}, nil
+}
+
+func (s *s3CatalogStore) key(key string) string {
+	return "catalog/" + key
+}
+
+func (s *s3CatalogStore) Put(key string, entry catalogEntry) error {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(key)),
+		Body:        bytes.NewReader(encoded),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func (s *s3CatalogStore) Get(key string) (entry catalogEntry, err error) {
+	obj, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		return
+	}
+	defer obj.Body.Close()
+	err = json.NewDecoder(obj.Body).Decode(&entry)
+	return
+}
+
+func (s *s3CatalogStore) List(ctx context.Context, prefix string) (keys []string, err error) {
+	err = s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.key(prefix)),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.StringValue(obj.Key), s.key("")))
+		}
+		return true
+	})
+	return
+}
+
+// dirCatalogStore is catalogStore saving entries as files in the directory.
+type dirCatalogStore string
+
+func (d dirCatalogStore) path(key string) string {
+	return filepath.Join(string(d), filepath.FromSlash(key))
+}
+
+func (d dirCatalogStore) Put(key string, entry catalogEntry) error {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, encoded, 0644)
+}
+
+func (d dirCatalogStore) Get(key string) (entry catalogEntry, err error) {
+	b, err := ioutil.ReadFile(d.path(key))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &entry)
+	return
+}
+
+func (d dirCatalogStore) List(ctx context.Context, prefix string) ([]string, error) {
+	infos, err := ioutil.ReadDir(d.path(prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	keys := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".json") {
+			keys = append(keys, prefix+info.Name())
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,50 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNearest(t *testing.T) {
+	defer func(dir string) { CatalogDirectory = dir }(CatalogDirectory)
+	CatalogDirectory = ""
+	param := SnapshotParameter{Exchange: "bitmex", Nanosecs: []int64{1598941025000000000}, Channels: []string{"orderBookL2"}, Format: "raw", Output: OutputTSV}
+	if _, err := Nearest(context.Background(), param); !errors.Is(err, ErrBadParameter) {
+		t.Fatalf("expected the catalog not to be available, got %v", err)
+	}
+	CatalogDirectory = t.TempDir()
+	ctx := context.Background()
+	hour := int64(time.Hour)
+	// the day before the target, and after it
+	recordMaterialized(ctx, catalogEntry{Exchange: "bitmex", Nanosec: 1598918400000000000 - hour, Channels: []string{"orderBookL2", "trade"}, Format: "raw", Output: OutputTSV, Source: catalogBatch, Location: json.RawMessage(`{"bucket":"batch"}`)})
+	recordMaterialized(ctx, catalogEntry{Exchange: "bitmex", Nanosec: 1598943600000000000, Channels: []string{"orderBookL2"}, Format: "raw", Output: OutputTSV, Source: catalogBatch})
+	// of other formats and channels
+	recordMaterialized(ctx, catalogEntry{Exchange: "bitmex", Nanosec: 1598940000000000000, Channels: []string{"orderBookL2"}, Format: "json", Output: OutputTSV, Source: catalogCache})
+	recordMaterialized(ctx, catalogEntry{Exchange: "bitmex", Nanosec: 1598936400000000000, Channels: []string{"trade"}, Format: "raw", Output: OutputTSV, Source: catalogCache})
+	b, err := Nearest(ctx, param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result nearestResult
+	if err := json.Unmarshal(b, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Found || result.Snapshot.Nanosec != 1598918400000000000-hour || result.Snapshot.Source != catalogBatch {
+		t.Fatalf("expected the snapshot of the batch the day before, got %s", b)
+	}
+	if result.Behind != param.Nanosecs[0]-result.Snapshot.Nanosec {
+		t.Errorf("unexpected distance to the target %d", result.Behind)
+	}
+	entry, _ := cacheCatalogEntry(param)
+	recordMaterialized(ctx, entry)
+	if b, _ := Nearest(ctx, param); json.Unmarshal(b, &result) != nil || result.Snapshot.Source != catalogCache || result.Behind != 0 {
+		t.Errorf("expected the cached result at the target, got %s", b)
+	}
+	param.Exchange = "bitflyer"
+	if b, _ := Nearest(ctx, param); string(b) != `{"found":false}` {
+		t.Errorf("expected nothing to be found, got %s", b)
+	}
+}
@@ -0,0 +1,108 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ChannelReport tells which requested channels and post filters could not make output.
+// It is returned in `X-Snapshot-Channels` header if any of them did not, as typos silently make results empty.
+type ChannelReport struct {
+	// Resolved is the list of channels snapshots were taken of, including channels matched by patterns
+	Resolved []string `json:"resolved"`
+	// Unknown is the list of requested channels the simulator of the exchange does not support
+	Unknown []string `json:"unknown,omitempty"`
+	// Unformatted is the list of requested channels the formatter of the format does not support
+	Unformatted []string `json:"unformatted,omitempty"`
+	// UnmatchedPostFilter is the list of `postFilter` which matched no channel formatted in any snapshot
+	UnmatchedPostFilter []string `json:"unmatchedPostFilter,omitempty"`
+}
+
+// HasProblems returns true if any of requested channels and post filters could not make output.
+func (r *ChannelReport) HasProblems() bool {
+	return r != nil && (len(r.Unknown) > 0 || len(r.Unformatted) > 0 || len(r.UnmatchedPostFilter) > 0)
+}
+
+// Header returns the report encoded for `X-Snapshot-Channels` header.
+func (r *ChannelReport) Header() string {
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+// checkChannels returns the report of `channels` of `exchange` which the simulator or the formatter of `format` does not support.
+// Patterns are not checked as channels matching them are only known from dataset.
+func checkChannels(exchange string, channels []string, format string) *ChannelReport {
+	report := &ChannelReport{Resolved: channels}
+	for _, channel := range channels {
+		if isPattern(channel) {
+			continue
+		}
+		if _, err := getSimulator(exchange, []string{channel}); err != nil {
+			report.Unknown = append(report.Unknown, channel)
+			continue
+		}
+		if format == "raw" {
+			continue
+		}
+		form, err := newFormatter(exchange, []string{channel}, format)
+		if err != nil || !form.IsSupported(channel) {
+			report.Unformatted = append(report.Unformatted, channel)
+		}
+	}
+	return report
+}
+
+// validateChannels returns the error telling channels of `param` which could not make output,
+// so that requests with typos fail before dataset is read.
+func validateChannels(param SnapshotParameter) error {
+	report := checkChannels(param.Exchange, param.Channels, param.Format)
+	if len(report.Unknown) == 0 && len(report.Unformatted) == 0 {
+		return nil
+	}
+	return fmt.Errorf("channels can not be taken snapshot of: %s", report.Header())
+}
+
+// postFilterTracker matches channels against post filters, remembering which of them matched any channel.
+type postFilterTracker struct {
+	filters  []string
+	matchers []*channelMatcher
+	matched  []bool
+}
+
+func newPostFilterTracker(postFilter []string) (*postFilterTracker, error) {
+	t := &postFilterTracker{filters: postFilter, matched: make([]bool, len(postFilter))}
+	for _, filter := range postFilter {
+		matcher, err := newChannelMatcher([]string{filter})
+		if err != nil {
+			return nil, err
+		}
+		t.matchers = append(t.matchers, matcher)
+	}
+	return t, nil
+}
+
+// Match returns true if `channel` matches any of post filters.
+func (t *postFilterTracker) Match(channel string) bool {
+	ok := false
+	for i, matcher := range t.matchers {
+		if matcher.Match(channel) {
+			t.matched[i] = true
+			ok = true
+		}
+	}
+	return ok
+}
+
+// Unmatched returns post filters which have not matched any channel.
+func (t *postFilterTracker) Unmatched() []string {
+	var unmatched []string
+	for i, filter := range t.filters {
+		if !t.matched[i] {
+			unmatched = append(unmatched, filter)
+		}
+	}
+	return unmatched
+}
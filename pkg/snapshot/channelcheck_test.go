@@ -0,0 +1,56 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+func TestCheckChannels(t *testing.T) {
+	defer registerFixture()()
+	defer func(get func(string, []string) (simulator.Simulator, error)) { getSimulator = get }(getSimulator)
+	getSimulator = func(exchange string, channels []string) (simulator.Simulator, error) {
+		if channels[0] == "unknown" {
+			return nil, errors.New("not supported")
+		}
+		return registeredSimulator(exchange, channels)
+	}
+	report := checkChannels(fixtureExchange, []string{"book", "unknown", "book_*"}, fixtureExchange)
+	if !reflect.DeepEqual(report.Unknown, []string{"unknown"}) || len(report.Unformatted) > 0 || !report.HasProblems() {
+		t.Errorf("expected the unknown channel, got %+v", report)
+	}
+	param := SnapshotParameter{Exchange: fixtureExchange, Channels: []string{"unknown"}, Format: "raw"}
+	if err := validateChannels(param); err == nil {
+		t.Error("expected error for the unknown channel")
+	}
+	param.Channels = []string{"book"}
+	if err := validateChannels(param); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSnapshotUnmatchedPostFilter(t *testing.T) {
+	defer registerFixture()()
+	param := SnapshotParameter{
+		Exchange:   fixtureExchange,
+		Nanosecs:   []int64{fixtureAt(35 * time.Second)},
+		Channels:   []string{"book", "ticker"},
+		Format:     fixtureExchange,
+		Output:     OutputTSV,
+		PostFilter: []string{"book", "tickr"},
+	}
+	_, report, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Channels == nil || !reflect.DeepEqual(report.Channels.UnmatchedPostFilter, []string{"tickr"}) {
+		t.Errorf("expected the post filter with the typo to be reported, got %+v", report.Channels)
+	}
+	if !reflect.DeepEqual(report.Channels.Resolved, param.Channels) {
+		t.Errorf("expected requested channels to be resolved, got %v", report.Channels.Resolved)
+	}
+}
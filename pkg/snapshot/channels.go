@@ -0,0 +1,314 @@
+package snapshot
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// regexpPrefix is the prefix of channel patterns written in regular expression.
+const regexpPrefix = "re:"
+
+// allChannels is the pattern of all channels, which is taken if channels are not specified.
+// Channels the simulator does not support are skipped instead of failing, so all channels in dataset can be explored.
+const allChannels = "*"
+
+// isPattern returns true if `channel` is a pattern rather than a channel name.
+// Glob patterns are patterns having any of `*?[`, such as `orderBookL2_*`.
+func isPattern(channel string) bool {
+	return strings.HasPrefix(channel, regexpPrefix) || strings.ContainsAny(channel, "*?[")
+}
+
+// hasPattern returns true if any of `channels` is a pattern.
+func hasPattern(channels []string) bool {
+	for _, channel := range channels {
+		if isPattern(channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// channelMatcher matches channel names against names and patterns.
+type channelMatcher struct {
+	names   map[string]bool
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+func newChannelMatcher(channels []string) (*channelMatcher, error) {
+	m := &channelMatcher{names: make(map[string]bool)}
+	for _, channel := range channels {
+		if strings.HasPrefix(channel, regexpPrefix) {
+			re, err := regexp.Compile(channel[len(regexpPrefix):])
+			if err != nil {
+				return nil, fmt.Errorf("invalid channel pattern '%s': %v", channel, err)
+			}
+			m.regexps = append(m.regexps, re)
+		} else if isPattern(channel) {
+			if _, err := path.Match(channel, ""); err != nil {
+				return nil, fmt.Errorf("invalid channel pattern '%s': %v", channel, err)
+			}
+			m.globs = append(m.globs, channel)
+		} else {
+			m.names[channel] = true
+		}
+	}
+	return m, nil
+}
+
+// Empty returns true if this matcher was made from no channel.
+func (m *channelMatcher) Empty() bool {
+	return len(m.names) == 0 && len(m.globs) == 0 && len(m.regexps) == 0
+}
+
+// MatchesAll returns true if this matcher was made from `allChannels`.
+func (m *channelMatcher) MatchesAll() bool {
+	for _, glob := range m.globs {
+		if glob == allChannels {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if `channel` is one of names or matches any of patterns.
+func (m *channelMatcher) Match(channel string) bool {
+	if m.names[channel] {
+		return true
+	}
+	for _, glob := range m.globs {
+		if ok, _ := path.Match(glob, channel); ok {
+			return true
+		}
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyString returns the copy of `str` not sharing memory with it.
+func copyString(str string) string {
+	return string([]byte(str))
+}
+
+// muxSimulator is simulator for channel patterns.
+// It passes lines to a simulator per channel, which is made when a channel matching patterns is first seen.
+type muxSimulator struct {
+	simulator.Simulator
+	exchange  string
+	matcher   *channelMatcher
+	startLine []byte
+	// channels is the list of channels seen, in the order of appearance
+	channels []string
+	sims     map[string]simulator.Simulator
+}
+
+func newMuxSimulator(exchange string, matcher *channelMatcher) *muxSimulator {
+	return &muxSimulator{
+		exchange: exchange,
+		matcher:  matcher,
+		sims:     make(map[string]simulator.Simulator),
+	}
+}
+
+// channelSimulator returns the simulator for `channel`, or nil if `channel` is not requested.
+func (s *muxSimulator) channelSimulator(channel string) (simulator.Simulator, error) {
+	if sim, ok := s.sims[channel]; ok {
+		return sim, nil
+	}
+	// channel is retained, bytes it refers to might be reused
+	channel = copyString(channel)
+	if !s.matcher.Match(channel) {
+		s.sims[channel] = nil
+		return nil, nil
+	}
+	sim, err := getSimulator(s.exchange, []string{channel})
+	if err != nil {
+		if s.matcher.MatchesAll() {
+			// channels are not requested by name, ones the simulator does not support are not taken snapshot of
+			s.sims[channel] = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+	if s.startLine != nil {
+		if err := sim.ProcessStart(s.startLine); err != nil {
+			return nil, err
+		}
+	}
+	s.sims[channel] = sim
+	s.channels = append(s.channels, channel)
+	return sim, nil
+}
+
+func (s *muxSimulator) ProcessStart(line []byte) error {
+	s.startLine = line
+	for _, channel := range s.channels {
+		if err := s.sims[channel].ProcessStart(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *muxSimulator) ProcessMessageChannelKnown(channel string, line []byte) error {
+	sim, err := s.channelSimulator(channel)
+	if err != nil || sim == nil {
+		return err
+	}
+	return sim.ProcessMessageChannelKnown(channel, line)
+}
+
+func (s *muxSimulator) ProcessState(channel string, line []byte) error {
+	sim, err := s.channelSimulator(channel)
+	if err != nil || sim == nil {
+		return err
+	}
+	return sim.ProcessState(channel, line)
+}
+
+func (s *muxSimulator) TakeSnapshot() ([]simulator.Snapshot, error) {
+	snapshots := make([]simulator.Snapshot, 0, len(s.channels))
+	for _, channel := range s.channels {
+		channelSnapshots, err := s.sims[channel].TakeSnapshot()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, channelSnapshots...)
+	}
+	return snapshots, nil
+}
+
+// Channels returns the list of channels matched so far.
+func (s *muxSimulator) Channels() []string {
+	return s.channels
+}
+
+// ways to handle requested channels without data
+const (
+	// MissingChannelsIgnore produces nothing for them
+	MissingChannelsIgnore = "ignore"
+	// MissingChannelsEmpty produces an entry with empty message for channels without entries in the snapshot
+	MissingChannelsEmpty = "empty"
+	// MissingChannelsError fails if channels did not appear in dataset
+	MissingChannelsError = "error"
+)
+
+// unseenChannels returns channels in `requested` which did not appear in `seen` in the order of `requested`.
+// A channel appeared if the channel itself or any of its companions did, patterns are not checked.
+func unseenChannels(exchange string, requested []string, seen map[string]string) []string {
+	var unseen []string
+	for _, channel := range requested {
+		if isPattern(channel) {
+			continue
+		}
+		if _, ok := seen[channel]; ok {
+			continue
+		}
+		found := false
+		for _, companion := range companionChannels(exchange, channel) {
+			if _, ok := seen[companion]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unseen = append(unseen, channel)
+		}
+	}
+	return unseen
+}
+
+// channelsWithoutEntries returns channels in `requested` which no entry is of in the order of `requested`.
+// Entries of formatted channels such as `orderBookL2_XBTUSD` are of `orderBookL2`, patterns are not checked.
+func channelsWithoutEntries(requested []string, entries []entry) []string {
+	var without []string
+	for _, channel := range requested {
+		if isPattern(channel) {
+			continue
+		}
+		found := false
+		for _, e := range entries {
+			if e.channel == channel || (strings.HasPrefix(e.channel, channel) && e.channel[len(channel)] == '_') {
+				found = true
+				break
+			}
+		}
+		if !found {
+			without = append(without, channel)
+		}
+	}
+	return without
+}
+
+// companionChannels returns channels the simulator of `channel` depends on other than itself,
+// such as the channel of orderbook snapshots to initialize the orderbook from.
+func companionChannels(exchange string, channel string) []string {
+	switch exchange {
+	case "bitflyer":
+		if strings.HasPrefix(channel, "lightning_board_") && !strings.HasPrefix(channel, "lightning_board_snapshot_") {
+			return []string{"lightning_board_snapshot_" + channel[len("lightning_board_"):]}
+		}
+	case binanceSpot, binanceFutures:
+		if i := strings.IndexByte(channel, '@'); i >= 0 && strings.HasPrefix(channel[i+1:], "depth") {
+			return []string{channel[:i] + "@rest_depth"}
+		}
+	}
+	return nil
+}
+
+// channelPrefilter is the filter of channels in dataset to skip lines of channels not requested
+// before they are parsed by the simulator.
+type channelPrefilter struct {
+	matcher *channelMatcher
+	// names are requested channels and their companions, channels for each instrument of them also pass
+	names map[string]bool
+	cache map[string]bool
+}
+
+// newChannelPrefilter makes channelPrefilter for `channels`, `matcher` is used if `channels` have patterns.
+func newChannelPrefilter(exchange string, channels []string, matcher *channelMatcher) *channelPrefilter {
+	f := &channelPrefilter{names: make(map[string]bool), cache: make(map[string]bool)}
+	if hasPattern(channels) {
+		f.matcher = matcher
+		return f
+	}
+	for _, channel := range channels {
+		f.names[channel] = true
+		for _, companion := range companionChannels(exchange, channel) {
+			f.names[companion] = true
+		}
+	}
+	return f
+}
+
+// Match returns true if lines of `channel` can be needed by the simulator.
+// `channel` is retained, it must not be modified later.
+func (f *channelPrefilter) Match(channel string) bool {
+	if match, ok := f.cache[channel]; ok {
+		return match
+	}
+	match := false
+	if f.matcher != nil {
+		match = f.matcher.Match(channel)
+	} else if f.names[channel] {
+		match = true
+	} else {
+		// channels of a table for each instrument, such as orderBookL2_XBTUSD for orderBookL2
+		for i := 0; i < len(channel); i++ {
+			if channel[i] == '_' && f.names[channel[:i]] {
+				match = true
+				break
+			}
+		}
+	}
+	f.cache[channel] = match
+	return match
+}
@@ -0,0 +1,119 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+func TestChannelMatcher(t *testing.T) {
+	m, err := newChannelMatcher([]string{"trade", "orderBookL2_*", "re:^book_t(BTC|ETH)USD$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{
+		"trade":            true,
+		"trade_XBTUSD":     false,
+		"orderBookL2_25":   true,
+		"orderBookL2":      false,
+		"book_tBTCUSD":     true,
+		"book_tETHUSD":     true,
+		"book_tXRPUSD":     false,
+		"book_tBTCUSD_old": false,
+	}
+	for channel, match := range expected {
+		if m.Match(channel) != match {
+			t.Errorf("%s: expected match to be %v", channel, match)
+		}
+	}
+	if _, err := newChannelMatcher([]string{"re:("}); err == nil {
+		t.Error("expected error for invalid regular expression")
+	}
+}
+
+func TestChannelPrefilter(t *testing.T) {
+	f := newChannelPrefilter("bitflyer", []string{"lightning_board_BTC_JPY"}, nil)
+	for channel, expected := range map[string]bool{
+		"lightning_board_BTC_JPY":          true,
+		"lightning_board_snapshot_BTC_JPY": true,
+		"lightning_board_ETH_JPY":          false,
+		"lightning_executions_BTC_JPY":     false,
+	} {
+		if f.Match(channel) != expected {
+			t.Errorf("%s: expected %v", channel, expected)
+		}
+	}
+	f = newChannelPrefilter("bitmex", []string{"orderBookL2"}, nil)
+	if !f.Match("orderBookL2_XBTUSD") || f.Match("trade_XBTUSD") {
+		t.Error("expected channels of instruments of the table to pass")
+	}
+	matcher, err := newChannelMatcher([]string{"trade_*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = newChannelPrefilter("bitmex", []string{"trade_*"}, matcher)
+	if !f.Match("trade_XBTUSD") || f.Match("orderBookL2_XBTUSD") {
+		t.Error("expected patterns to be matched")
+	}
+}
+
+func TestUnseenChannels(t *testing.T) {
+	seen := map[string]string{"lightning_board_snapshot_BTC_JPY": "lightning_board_snapshot_BTC_JPY"}
+	unseen := unseenChannels("bitflyer", []string{"lightning_board_BTC_JPY", "lightning_board_ETH_JPY", "lightning_*"}, seen)
+	if len(unseen) != 1 || unseen[0] != "lightning_board_ETH_JPY" {
+		t.Errorf("expected only lightning_board_ETH_JPY to be unseen, got %v", unseen)
+	}
+	without := channelsWithoutEntries([]string{"orderBookL2", "trade", "funding"}, []entry{
+		{channel: "orderBookL2_XBTUSD"},
+		{channel: "tradeBin1m"},
+	})
+	if len(without) != 2 || without[0] != "trade" || without[1] != "funding" {
+		t.Errorf("expected trade and funding to be without entries, got %v", without)
+	}
+}
+
+func TestAllChannels(t *testing.T) {
+	event := makeLambdaEvent("bitmex", nil, "1598941025000000000", "raw")
+	delete(event.MultiValueQueryStringParameters, "channels")
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(param.Channels) != 1 || param.Channels[0] != allChannels {
+		t.Fatalf("expected all channels without channels, got %v", param.Channels)
+	}
+	RegisterSimulator("partial", func(channels []string) (simulator.Simulator, error) {
+		if channels[0] == "unsupported" {
+			return nil, errors.New("unsupported channel")
+		}
+		return &recordingSimulator{}, nil
+	})
+	defer func() {
+		simulatorsMu.Lock()
+		delete(simulators, "partial")
+		simulatorsMu.Unlock()
+	}()
+	matcher, err := newChannelMatcher([]string{allChannels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := newMuxSimulator("partial", matcher)
+	for _, channel := range []string{"book", "unsupported", "trade"} {
+		if err := sim.ProcessMessageChannelKnown(channel, []byte("{}\n")); err != nil {
+			t.Fatalf("%s: %v", channel, err)
+		}
+	}
+	if fmt.Sprint(sim.Channels()) != "[book trade]" {
+		t.Errorf("expected unsupported channels to be skipped, got %v", sim.Channels())
+	}
+	// patterns fail on them as channels requested by name do
+	matcher, err = newChannelMatcher([]string{"*d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newMuxSimulator("partial", matcher).ProcessMessageChannelKnown("unsupported", []byte("{}\n")); err == nil {
+		t.Error("expected the unsupported channel to fail")
+	}
+}
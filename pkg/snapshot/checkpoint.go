@@ -0,0 +1,277 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// checkpointStore stores checkpoints of simulator state taken at the beginning of minutes.
+// A checkpoint is encoded as a dataset file, so it can be read in place of the dataset files before that minute.
+type checkpointStore interface {
+	// Nearest returns the checkpoint taken at the latest minute in the range (after, until].
+	// `body` is nil if there is no such checkpoint.
+	Nearest(exchange string, channels []string, after int64, until int64) (minute int64, body io.ReadCloser, err error)
+	// Save stores the checkpoint taken at `minute`.
+	Save(exchange string, channels []string, minute int64, data []byte) error
+}
+
+// checkpoints is the store to load and save checkpoints, checkpoints are disabled if nil.
+var checkpoints checkpointStore
+
+// checkpointStoreFor returns the store of checkpoints for `param`, or nil if checkpoints can not be used.
+// Checkpoints are only of the default dataset of minute files, and are not taken while recorded snapshots are replayed.
+// Files of other granularities have lines before the minute of the checkpoint, which would be applied again.
+// Requests which may leave the state incomplete, skipping malformed lines or quarantining channels,
+// and requests checking sequences, which checkpoints do not carry, do not use them either.
+// The simulator filtering symbols does not have the state of other symbols of the channels.
+func checkpointStoreFor(param SnapshotParameter) checkpointStore {
+	if param.DatasetBucket != "" || param.DatasetPrefix != "" || param.replay != nil || (param.Granularity != "" && param.Granularity != GranularityMinute) {
+		return nil
+	}
+	if len(param.Symbols) > 0 || param.IsolateErrors || param.Lenient || param.Verify {
+		return nil
+	}
+	return checkpoints
+}
+
+// startRecorder is simulator remembering the last start line it processed.
+type startRecorder struct {
+	simulator.Simulator
+	startLine []byte
+}
+
+func (s *startRecorder) ProcessStart(line []byte) error {
+	s.startLine = line
+	return s.Simulator.ProcessStart(line)
+}
+
+// encodeCheckpoint encodes the state of the simulator at `nanosec` as a gzipped dataset file.
+// Snapshots are in the same format as messages of the exchange,
+// so processing them as messages after the start line restores the state.
+func encodeCheckpoint(nanosec int64, startLine []byte, snapshots []simulator.Snapshot) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	nanosecStr := strconv.FormatInt(nanosec, 10)
+	if _, err := fmt.Fprintf(writer, "start\t%s\t%s", nanosecStr, startLine); err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		if _, err := fmt.Fprintf(writer, "msg\t%s\t%s\t%s\n", nanosecStr, snapshot.Channel, snapshot.Snapshot); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// channelsKey returns the string identifying the set of channels.
+func channelsKey(channels []string) string {
+	sorted := make([]string, len(channels))
+	copy(sorted, channels)
+	sort.Strings(sorted)
+	hash := sha1.Sum([]byte(strings.Join(sorted, ",")))
+	return hex.EncodeToString(hash[:])
+}
+
+// s3CheckpointStore is checkpointStore saving checkpoints as S3 objects.
+type s3CheckpointStore struct {
+	client *s3.S3
+	bucket string
+}
+
+func newS3CheckpointStore(bucket string) (*s3CheckpointStore, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return &s3CheckpointStore{client: s3.New(sess), bucket: bucket}, nil
+}
+
+func (s *s3CheckpointStore) prefix(exchange string, channels []string) string {
+	return fmt.Sprintf("checkpoint/%s/%s/", exchange, channelsKey(channels))
+}
+
+func (s *s3CheckpointStore) key(exchange string, channels []string, minute int64) string {
+	// minutes have the same number of digits, so keys are sorted by minute
+	return fmt.Sprintf("%s%d.gz", s.prefix(exchange, channels), minute)
+}
+
+func (s *s3CheckpointStore) Nearest(exchange string, channels []string, after int64, until int64) (minute int64, body io.ReadCloser, err error) {
+	if until <= after {
+		return
+	}
+	out, err := s.client.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:     aws.String(s.bucket),
+		Prefix:     aws.String(s.prefix(exchange, channels)),
+		StartAfter: aws.String(s.key(exchange, channels, after)),
+		MaxKeys:    aws.Int64(until - after),
+	})
+	if err != nil {
+		return
+	}
+	var key string
+	for _, obj := range out.Contents {
+		m, serr := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(*obj.Key, s.prefix(exchange, channels)), ".gz"), 10, 64)
+		if serr != nil || m <= after || m > until {
+			continue
+		}
+		if m > minute {
+			minute = m
+			key = *obj.Key
+		}
+	}
+	if key == "" {
+		return
+	}
+	obj, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			// deleted after listing
+			return 0, nil, nil
+		}
+		return
+	}
+	body = obj.Body
+	return
+}
+
+func (s *s3CheckpointStore) Save(exchange string, channels []string, minute int64, data []byte) error {
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(exchange, channels, minute)),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+// prependSource is DatasetSource returning a file before files from another source.
+type prependSource struct {
+	name  string
+	first io.ReadCloser
+	rest  DatasetSource
+	// read is true once `first` is returned
+	read bool
+}
+
+func (s *prependSource) Next() (io.ReadCloser, bool) {
+	if !s.read {
+		s.read = true
+		return s.first, true
+	}
+	return s.rest.Next()
+}
+
+func (s *prependSource) Name() string {
+	if !s.read {
+		return ""
+	}
+	if s.rest.Name() == "" {
+		return s.name
+	}
+	return s.rest.Name()
+}
+
+func (s *prependSource) CacheStats() (hits int, misses int) {
+	if c, ok := s.rest.(cacheStatter); ok {
+		return c.CacheStats()
+	}
+	return 0, 0
+}
+
+func (s *prependSource) End(name string) int64 {
+	if f, ok := s.rest.(followingSource); ok {
+		return f.End(name)
+	}
+	return 0
+}
+
+func (s *prependSource) Close() error {
+	if !s.read {
+		s.first.Close()
+	}
+	return s.rest.Close()
+}
+
+// stateChannel is the channel name of the line having exported state in the output.
+const stateChannel = "$state"
+
+// writeState writes the state of the simulator at `nanosec` as a line of `stateChannel`.
+// The state is an opaque string, the base64 encoded checkpoint.
+func writeState(buffer *bufio.Writer, nanosec int64, sim *startRecorder) error {
+	if sim.startLine == nil {
+		return errors.New("state can not be exported: simulator is not started")
+	}
+	snapshots, err := sim.TakeSnapshot()
+	if err != nil {
+		return err
+	}
+	data, err := encodeCheckpoint(nanosec, sim.startLine, snapshots)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(buffer, "%d\t%s\t%s\n", nanosec, stateChannel, base64.StdEncoding.EncodeToString(data))
+	return err
+}
+
+// decodeState decodes the state exported by writeState and returns the timestamp it was exported at.
+func decodeState(encoded string) (state []byte, nanosec int64, err error) {
+	state, err = base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return
+	}
+	reader, err := newDecompressor(bytes.NewReader(state))
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	// the first line is the start line having the timestamp
+	line, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil {
+		return
+	}
+	fields := strings.SplitN(line, "\t", 3)
+	if len(fields) < 3 || fields[0] != "start" {
+		err = errors.New("state does not begin with start line")
+		return
+	}
+	nanosec, err = strconv.ParseInt(fields[1], 10, 64)
+	return
+}
+
+// restoreState applies `state` exported at `nanosec` to the simulator.
+func restoreState(ctx context.Context, state []byte, nanosec int64, sim *simulator.Simulator, setNewSim func(*simulator.Simulator) error) error {
+	reader, err := newDecompressor(bytes.NewReader(state))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, _, err = FeedToSimulator(ctx, bufio.NewReader(reader), &Feeder{
+		Sim:       sim,
+		SetNewSim: setNewSim,
+		Targets:   []int64{nanosec},
+		OnTarget:  func(int64) error { return nil },
+	})
+	return err
+}
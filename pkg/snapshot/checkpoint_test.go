@@ -0,0 +1,266 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+func TestEncodeCheckpoint(t *testing.T) {
+	data, err := encodeCheckpoint(59999999999, []byte("wss://example.com\n"), []simulator.Snapshot{
+		{Channel: "channelA", Snapshot: []byte(`{"a":1}`)},
+		{Channel: "channelB", Snapshot: []byte(`{"b":2}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := newDecompressor(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	rec := &recordingSimulator{}
+	var sim simulator.Simulator = rec
+	setNewSim := func(simp *simulator.Simulator) error {
+		*simp = rec
+		return nil
+	}
+	f := &Feeder{Sim: &sim, SetNewSim: setNewSim, Targets: []int64{60000000000}, OnTarget: func(int64) error { return nil }}
+	_, _, err = FeedToSimulator(context.Background(), bufio.NewReader(reader), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.LastTimestamp != 59999999999 {
+		t.Fatalf("expected lastTimestamp 59999999999, got %d", f.LastTimestamp)
+	}
+	if len(rec.retained) != 3 || string(rec.retained[0]) != "wss://example.com\n" {
+		t.Fatalf("start line was not restored: %q", rec.retained)
+	}
+	if len(rec.channels) != 2 || rec.channels[0] != "channelA" || rec.lines[1] != "{\"b\":2}\n" {
+		t.Fatalf("snapshots were not restored: %v %q", rec.channels, rec.lines)
+	}
+}
+
+func TestMergeTargets(t *testing.T) {
+	merged := mergeTargets([]int64{1, 3, 5}, []int64{2, 3, 6})
+	expected := []int64{1, 2, 3, 5, 6}
+	if len(merged) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, merged)
+	}
+	for i := range expected {
+		if merged[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, merged)
+		}
+	}
+}
+
+func TestCheckpointTargets(t *testing.T) {
+	// minute 26649017, the first file is of minute 26649010 unless planned
+	param := SnapshotParameter{Nanosecs: []int64{1598941025000000000}}
+	targets, minutes := checkpointTargets(param)
+	if len(targets) != 7 || targets[0] != 26649011*60*1000000000-1 || minutes[targets[6]] != 26649017 {
+		t.Errorf("expected checkpoints of minutes from 26649011, got %v", targets)
+	}
+	param.StartMinute = 26649015
+	if targets, _ := checkpointTargets(param); len(targets) != 2 {
+		t.Errorf("expected checkpoints after the planned minute, got %v", targets)
+	}
+	// the target is in the first file
+	param.StartMinute = 26649017
+	if targets, _ := checkpointTargets(param); len(targets) != 0 {
+		t.Errorf("expected no checkpoint, got %v", targets)
+	}
+}
+
+func TestExportAndDecodeState(t *testing.T) {
+	sim := &startRecorder{Simulator: &recordingSimulator{}, startLine: []byte("wss://example.com\n")}
+	buf := new(bytes.Buffer)
+	writer := bufio.NewWriter(buf)
+	if err := writeState(writer, 12345, sim); err != nil {
+		t.Fatal(err)
+	}
+	writer.Flush()
+	fields := bytes.SplitN(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), []byte("\t"), 3)
+	if len(fields) != 3 || string(fields[0]) != "12345" || string(fields[1]) != stateChannel {
+		t.Fatalf("unexpected state line: %q", buf.Bytes())
+	}
+	_, nanosec, err := decodeState(string(fields[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nanosec != 12345 {
+		t.Fatalf("expected state at 12345, got %d", nanosec)
+	}
+}
+
+// memoryCheckpointStore is checkpointStore keeping checkpoints in memory regardless of exchanges and channels.
+type memoryCheckpointStore struct {
+	mu   sync.Mutex
+	data map[int64][]byte
+}
+
+func newMemoryCheckpointStore() *memoryCheckpointStore {
+	return &memoryCheckpointStore{data: make(map[int64][]byte)}
+}
+
+func (s *memoryCheckpointStore) Nearest(exchange string, channels []string, after int64, until int64) (int64, io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var nearest int64
+	for minute := range s.data {
+		if after < minute && minute <= until && minute > nearest {
+			nearest = minute
+		}
+	}
+	if nearest == 0 {
+		return 0, nil, nil
+	}
+	return nearest, ioutil.NopCloser(bytes.NewReader(s.data[nearest])), nil
+}
+
+func (s *memoryCheckpointStore) Save(exchange string, channels []string, minute int64, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[minute] = data
+	return nil
+}
+
+func TestCheckpointStoreFor(t *testing.T) {
+	defer func(original checkpointStore) { checkpoints = original }(checkpoints)
+	checkpoints = newMemoryCheckpointStore()
+	param := SnapshotParameter{Exchange: "bitmex", Nanosecs: []int64{1598941025000000000}, Channels: []string{"orderBookL2"}}
+	if checkpointStoreFor(param) == nil {
+		t.Fatal("expected checkpoints to be used")
+	}
+	for name, set := range map[string]func(*SnapshotParameter){
+		"symbols":       func(param *SnapshotParameter) { param.Symbols = []string{"XBTUSD"} },
+		"isolateErrors": func(param *SnapshotParameter) { param.IsolateErrors = true },
+		"lenient":       func(param *SnapshotParameter) { param.Lenient = true },
+		"verify":        func(param *SnapshotParameter) { param.Verify = true },
+	} {
+		other := param
+		set(&other)
+		if checkpointStoreFor(other) != nil {
+			t.Errorf("%s: expected checkpoints to be disabled", name)
+		}
+	}
+}
+
+func TestCheckpointsAfterMissingFile(t *testing.T) {
+	defer registerFixture()()
+	defer func(original checkpointStore) { checkpoints = original }(checkpoints)
+	store := newMemoryCheckpointStore()
+	checkpoints = store
+	dir := t.TempDir()
+	if err := generateFixtures(dir); err != nil {
+		t.Fatal(err)
+	}
+	keys := fixtureKeys()
+	if err := os.Remove(filepath.Join(dir, keys[1])); err != nil {
+		t.Fatal(err)
+	}
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{fixtureAt(150 * time.Second)},
+		Channels: []string{"book", "ticker"},
+		Format:   "raw",
+		Output:   OutputTSV,
+	}
+	_, report, err := Snapshot(context.Background(), param, NewDirSource(dir, keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.MissingFiles) != 1 {
+		t.Fatalf("expected the second file to be missing, got %v", report.MissingFiles)
+	}
+	// lines of the missing file are not in the state after it
+	if _, ok := store.data[fixtureMinute+2]; ok {
+		t.Error("expected no checkpoint after the missing file")
+	}
+}
+
+func TestCheckpointsUnderSymbolFilter(t *testing.T) {
+	defer registerFixture()()
+	defer func(dataset string, store checkpointStore) { DatasetDirectory, checkpoints = dataset, store }(DatasetDirectory, checkpoints)
+	DatasetDirectory, checkpoints = goldenDir, nil
+	take := func(symbols []string) []byte {
+		param := SnapshotParameter{
+			Exchange: fixtureExchange,
+			Nanosecs: []int64{fixtureAt(150 * time.Second)},
+			Channels: []string{"book", "ticker"},
+			Symbols:  symbols,
+			Format:   "raw",
+			Output:   OutputTSV,
+		}
+		source, err := OpenSource(context.Background(), &param)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer source.Close()
+		ret, _, err := Snapshot(context.Background(), param, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+	expected := take(nil)
+	store := newMemoryCheckpointStore()
+	checkpoints = store
+	take([]string{"BTCUSD"})
+	if len(store.data) != 0 {
+		t.Fatal("expected no checkpoint to be saved under the symbol filter")
+	}
+	// the request without the filter does not load the state of the filtered simulator
+	if ret := take(nil); !bytes.Equal(ret, expected) {
+		t.Errorf("expected the same snapshot as without checkpoints:\n%s\nexpected:\n%s", ret, expected)
+	}
+	if len(store.data) == 0 {
+		t.Error("expected checkpoints to be saved without the filter")
+	}
+}
+
+func TestCheckpointsAfterTruncatedFile(t *testing.T) {
+	defer registerFixture()()
+	defer func(original checkpointStore) { checkpoints = original }(checkpoints)
+	store := newMemoryCheckpointStore()
+	checkpoints = store
+	dir := t.TempDir()
+	if err := generateFixtures(dir); err != nil {
+		t.Fatal(err)
+	}
+	keys := fixtureKeys()
+	first, err := ioutil.ReadFile(filepath.Join(dir, keys[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cut in the middle of the stream, the gzip trailer is lost
+	if err := ioutil.WriteFile(filepath.Join(dir, keys[0]), first[:len(first)-12], 0644); err != nil {
+		t.Fatal(err)
+	}
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{fixtureAt(150 * time.Second)},
+		Channels: []string{"book", "ticker"},
+		Format:   "raw",
+		Output:   OutputTSV,
+	}
+	_, report, err := Snapshot(context.Background(), param, NewDirSource(dir, keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.TruncatedFiles) != 1 {
+		t.Fatalf("expected the first file to be truncated, got %v", report.TruncatedFiles)
+	}
+	if len(store.data) != 0 {
+		t.Error("expected no checkpoint after the truncated file")
+	}
+}
@@ -0,0 +1,50 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+const (
+	// gzipBlockSize is the default size of a block pgzip decompresses at once.
+	gzipBlockSize = 1024 * 1024
+	// gzipBlocks is the default number of blocks pgzip decompresses ahead.
+	gzipBlocks = 4
+)
+
+// extensions maps compression name to file extension of dataset files compressed with it.
+var extensions = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// newDecompressor returns reader decompressing `reader`.
+// Compression is detected from the magic number, so gzip and zstd files can be mixed.
+func newDecompressor(reader io.Reader) (io.ReadCloser, error) {
+	breader := bufio.NewReader(reader)
+	magic, err := breader.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.HasPrefix(magic, zstdMagic) {
+		decoder, err := zstd.NewReader(breader)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	if bytes.HasPrefix(magic, gzipMagic) {
+		// decompress blocks in parallel as the decompression dominates the scan time
+		return getGzipReader(breader, GzipBlockKB*1024, GzipBlocks)
+	}
+	return nil, errors.New("unknown compression")
+}
@@ -0,0 +1,43 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNewDecompressor(t *testing.T) {
+	content := []byte("msg\t1\tchannel\t{}\n")
+	gzipped := new(bytes.Buffer)
+	gwriter := gzip.NewWriter(gzipped)
+	gwriter.Write(content)
+	gwriter.Close()
+	zstded := new(bytes.Buffer)
+	zwriter, err := zstd.NewWriter(zstded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zwriter.Write(content)
+	zwriter.Close()
+
+	for name, compressed := range map[string][]byte{"gzip": gzipped.Bytes(), "zstd": zstded.Bytes()} {
+		reader, err := newDecompressor(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		decompressed, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(decompressed, content) {
+			t.Fatalf("%s: expected %q, got %q", name, content, decompressed)
+		}
+	}
+	if _, err := newDecompressor(bytes.NewReader(content)); err == nil {
+		t.Fatal("expected error for uncompressed content")
+	}
+}
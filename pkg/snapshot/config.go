@@ -0,0 +1,143 @@
+package snapshot
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ConfigFile is the path to the JSON file of configuration loaded by LoadConfig, environment variables override it.
+var ConfigFile = os.Getenv("CONFIG_FILE")
+
+// DatasetDirectory is the path to the local directory to read dataset files from instead of S3, if not empty.
+var DatasetDirectory = os.Getenv("DATASET_DIR")
+
+// CheckpointBucket is the name of S3 bucket to save and load checkpoints of simulator state, disabled if empty.
+var CheckpointBucket = os.Getenv("CHECKPOINT_BUCKET")
+
+// ManifestBucket is the name of S3 bucket to save and load manifests of dataset files, disabled if empty.
+// Manifests tell channels and start lines in files so that files having nothing for requested channels are not read.
+var ManifestBucket = os.Getenv("MANIFEST_BUCKET")
+
+// ManifestDirectory is the path to the local directory to save and load manifests of dataset files instead of `ManifestBucket`, if not empty.
+var ManifestDirectory = os.Getenv("MANIFEST_DIR")
+
+// GCSBucket is the name of the Google Cloud Storage bucket to read dataset files from instead of S3, if not empty.
+var GCSBucket = os.Getenv("GCS_BUCKET")
+
+// ExportBucket is the name of S3 bucket to write snapshots in parquet output to, parquet output is disabled if empty.
+var ExportBucket = os.Getenv("EXPORT_BUCKET")
+
+// AllowedDatasetBuckets is the list of S3 buckets which can be specified as `datasetBucket`, separated by comma.
+var AllowedDatasetBuckets = strings.Split(os.Getenv("ALLOWED_DATASET_BUCKETS"), ",")
+
+// AllowedDestinationBuckets is the list of S3 buckets snapshots can be written to with `destination`, separated by comma.
+var AllowedDestinationBuckets = strings.Split(os.Getenv("ALLOWED_DESTINATION_BUCKETS"), ",")
+
+// DatasetGranularity is the default granularity of dataset files, `minute` if not set.
+var DatasetGranularity = os.Getenv("DATASET_GRANULARITY")
+
+// CatalogBucket is the name of S3 bucket to record snapshots materialized by the batch and the result cache in,
+// disabled if empty.
+var CatalogBucket = os.Getenv("CATALOG_BUCKET")
+
+// CatalogDirectory is the path to the local directory to record materialized snapshots in instead of `CatalogBucket`, if not empty.
+var CatalogDirectory = os.Getenv("CATALOG_DIR")
+
+// ResultCacheBucket is the name of S3 bucket to cache results of snapshot, disabled if empty.
+var ResultCacheBucket = os.Getenv("RESULT_CACHE_BUCKET")
+
+// SafeParsing is true if messages are always copied before given to the simulator.
+// Build with `-tags safestring` to also avoid sharing memory in string conversion.
+var SafeParsing = os.Getenv("SAFE_PARSING") == "1"
+
+// Pprof is true if profiles are served over HTTP in server mode, they should not be exposed publicly.
+var Pprof = os.Getenv("PPROF") == "1"
+
+// APIKeysFile is the path to the JSON file of API keys authorizing requests in server mode, requests are not authorized if empty.
+var APIKeysFile = os.Getenv("API_KEYS_FILE")
+
+// AuditEnabled is true if snapshots can be audited against REST APIs of exchanges with `audit`,
+// which makes requests to exchanges from the process.
+var AuditEnabled = os.Getenv("AUDIT") == "1"
+
+// PrefetchFiles is the default number of dataset files downloaded and decompressed ahead of the simulation.
+var PrefetchFiles = envInt("PREFETCH_FILES", pipelineDepth)
+
+// ReadAheadBlocks is the default number of decompressed blocks of `DecompressBlockKB` buffered for each file.
+var ReadAheadBlocks = envInt("READ_AHEAD_BLOCKS", pipelineBlocks)
+
+// DecompressBlockKB is the kilobytes of a block of decompressed dataset passed from the pipeline to the simulation.
+var DecompressBlockKB = envInt("DECOMPRESS_BLOCK_KB", pipelineBlockSize/1024)
+
+// ReadBufferKB is the kilobytes of the buffer lines of decompressed dataset are read through.
+// Small buffers make many copies of lines longer than them, see BenchmarkFeedBufferSize for the default.
+var ReadBufferKB = envInt("READ_BUFFER_KB", feedBufferSize/1024)
+
+// GzipBlockKB is the kilobytes of a block gzip files are decompressed by in parallel,
+// and GzipBlocks is the number of blocks decompressed ahead.
+var (
+	GzipBlockKB = envInt("GZIP_BLOCK_KB", gzipBlockSize/1024)
+	GzipBlocks  = envInt("GZIP_BLOCKS", gzipBlocks)
+)
+
+// FetchConcurrency is the default number of objects downloaded concurrently by sources supporting it.
+// Concurrency of S3 is fixed by streamcommons.
+var FetchConcurrency = envInt("FETCH_CONCURRENCY", gcsConcurrency)
+
+// MaxPrefetchObjects is the maximum number of objects fetched ahead from the default S3 location, which is planned
+// for each exchange from how far before targets its books were built from in recent requests.
+var MaxPrefetchObjects = envInt("MAX_PREFETCH_OBJECTS", maxFetchConcurrency)
+
+// MaxConcurrentSnapshots is the number of snapshots which can be taken at the same time in the process, not limited if not set.
+var MaxConcurrentSnapshots = envInt("MAX_CONCURRENT_SNAPSHOTS", 0)
+
+// MemoryBudgetMB is the megabytes of buffers snapshots taken at the same time can use in total, not limited if not set.
+// Snapshots wait until others finish if starting them would exceed the budget.
+var MemoryBudgetMB = envInt("MEMORY_BUDGET_MB", 0)
+
+// MemoryCeilingMB is the megabytes of outputs and prefetched dataset files held in memory in total, not limited if not set.
+// Buffers beyond it are spilled to files in `SpillDirectory` and read from them at the end.
+var MemoryCeilingMB = envInt("MEMORY_CEILING_MB", 0)
+
+// SpillDirectory is the directory buffers beyond `MemoryCeilingMB` are spilled to, the temporary directory if not set.
+var SpillDirectory = os.Getenv("SPILL_DIR")
+
+// PageKB is the default kilobytes of a page results larger than it are split into, not split if not set.
+// It should be less than the limit of payloads, such as 6MB of Lambda.
+var PageKB = envInt("PAGE_KB", 0)
+
+// MaxQueuedSnapshots is the number of snapshots which can wait for others to finish, snapshots beyond it are rejected.
+// Not limited if not set.
+var MaxQueuedSnapshots = envInt("MAX_QUEUED_SNAPSHOTS", 0)
+
+// TargetConcurrency is the number of groups of targets in disjoint time windows of a request taken snapshot of
+// at the same time, targets are taken in a single pass if it is 1.
+var TargetConcurrency = envInt("TARGET_CONCURRENCY", 4)
+
+// DefaultMaxLookbackMinutes is the default of `maxLookbackMinutes` of requests, unlimited if not set.
+var DefaultMaxLookbackMinutes = envInt("MAX_LOOKBACK_MINUTES", 0)
+
+// ListenAddress is the default address to serve HTTP on in server mode, `:8080` if not set.
+var ListenAddress = os.Getenv("LISTEN_ADDR")
+
+// GRPCAddress is the default address to serve gRPC on in server mode, gRPC is not served if not set.
+var GRPCAddress = os.Getenv("GRPC_ADDR")
+
+// MaxRequestBodyKB is the kilobytes of the body of a request read in server mode, larger requests are rejected.
+// Bodies are states exported by previous requests, they are limited to 6MB as payloads of Lambda by default.
+var MaxRequestBodyKB = envInt("MAX_REQUEST_BODY_KB", 6*1024)
+
+// envInt returns the integer in environment variable `name`, or `def` if it is not set or invalid.
+func envInt(name string, def int) int {
+	str := os.Getenv(name)
+	if str == "" {
+		return def
+	}
+	value, err := strconv.Atoi(str)
+	if err != nil || value <= 0 {
+		Logger.Warn("ignoring invalid environment variable", "name", name, "value", str)
+		return def
+	}
+	return value
+}
@@ -0,0 +1,197 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Config is the configuration of deployments in a JSON file, the same as environment variables.
+// Each field is of the environment variable in its comment, which overrides the field if it is set.
+// Fields not in the file are left as configured by environment variables.
+type Config struct {
+	// DATASET_BUCKET
+	DatasetBucket *string `json:"datasetBucket"`
+	// DATASET_ROLE_EXTERNAL_ID
+	DatasetRoleExternalID *string `json:"datasetRoleExternalId"`
+	// DATASET_DIR
+	DatasetDirectory *string `json:"datasetDir"`
+	// DATASET_GRANULARITY
+	DatasetGranularity *string `json:"datasetGranularity"`
+	// LIST_DATASET
+	ListDataset *bool `json:"listDataset"`
+	// GCS_BUCKET
+	GCSBucket *string `json:"gcsBucket"`
+	// CHECKPOINT_BUCKET
+	CheckpointBucket *string `json:"checkpointBucket"`
+	// MANIFEST_BUCKET
+	ManifestBucket *string `json:"manifestBucket"`
+	// MANIFEST_DIR
+	ManifestDirectory *string `json:"manifestDir"`
+	// EXPORT_BUCKET
+	ExportBucket *string `json:"exportBucket"`
+	// RESULT_CACHE_BUCKET
+	ResultCacheBucket *string `json:"resultCacheBucket"`
+	// CATALOG_BUCKET
+	CatalogBucket *string `json:"catalogBucket"`
+	// CATALOG_DIR
+	CatalogDirectory *string `json:"catalogDir"`
+	// CACHE_DIR
+	CacheDirectory *string `json:"cacheDir"`
+	// CACHE_MAX_BYTES
+	CacheMaxBytes *int64 `json:"cacheMaxBytes"`
+	// SPILL_DIR
+	SpillDirectory *string `json:"spillDir"`
+	// PREFETCH_FILES
+	PrefetchFiles *int `json:"prefetchFiles"`
+	// READ_AHEAD_BLOCKS
+	ReadAheadBlocks *int `json:"readAheadBlocks"`
+	// DECOMPRESS_BLOCK_KB
+	DecompressBlockKB *int `json:"decompressBlockKB"`
+	// READ_BUFFER_KB
+	ReadBufferKB *int `json:"readBufferKB"`
+	// GZIP_BLOCK_KB
+	GzipBlockKB *int `json:"gzipBlockKB"`
+	// GZIP_BLOCKS
+	GzipBlocks *int `json:"gzipBlocks"`
+	// FETCH_CONCURRENCY
+	FetchConcurrency *int `json:"fetchConcurrency"`
+	// MAX_PREFETCH_OBJECTS
+	MaxPrefetchObjects *int `json:"maxPrefetchObjects"`
+	// MAX_CONCURRENT_SNAPSHOTS
+	MaxConcurrentSnapshots *int `json:"maxConcurrentSnapshots"`
+	// MAX_QUEUED_SNAPSHOTS
+	MaxQueuedSnapshots *int `json:"maxQueuedSnapshots"`
+	// MEMORY_BUDGET_MB
+	MemoryBudgetMB *int `json:"memoryBudgetMB"`
+	// MEMORY_CEILING_MB
+	MemoryCeilingMB *int `json:"memoryCeilingMB"`
+	// PAGE_KB
+	PageKB *int `json:"pageKB"`
+	// TARGET_CONCURRENCY
+	TargetConcurrency *int `json:"targetConcurrency"`
+	// PROGRESS_INTERVAL_SECONDS
+	ProgressIntervalSeconds *int `json:"progressIntervalSeconds"`
+	// MAX_LOOKBACK_MINUTES
+	MaxLookbackMinutes *int `json:"maxLookbackMinutes"`
+	// LOG_LEVEL
+	LogLevel *string `json:"logLevel"`
+	// LISTEN_ADDR
+	ListenAddress *string `json:"listenAddr"`
+	// GRPC_ADDR
+	GRPCAddress *string `json:"grpcAddr"`
+	// METRICS_ADDR
+	MetricsAddress *string `json:"metricsAddr"`
+	// API_KEYS_FILE
+	APIKeysFile *string `json:"apiKeysFile"`
+	// WARM_CHANNELS
+	WarmChannels *string `json:"warmChannels"`
+	// WARM_QUEUE_URL
+	WarmQueueURL *string `json:"warmQueueURL"`
+}
+
+// ParseConfig parses the configuration in JSON, unknown fields are rejected as they would be ignored otherwise.
+func ParseConfig(b []byte) (*Config, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	config := new(Config)
+	if err := decoder.Decode(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadConfig applies the configuration in the file at `path` to fields not set by environment variables.
+// It has to be called before Setup and anything configured by them is used.
+func LoadConfig(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	config, err := ParseConfig(b)
+	if err != nil {
+		return fmt.Errorf("invalid configuration in %s: %v", path, err)
+	}
+	return config.Apply()
+}
+
+// Apply sets configuration to fields in the file which are not set by environment variables.
+func (c *Config) Apply() error {
+	for _, s := range []struct {
+		env   string
+		value *string
+		field *string
+	}{
+		{"DATASET_BUCKET", c.DatasetBucket, &DefaultDatasetBucket},
+		{"DATASET_ROLE_EXTERNAL_ID", c.DatasetRoleExternalID, &DatasetRoleExternalID},
+		{"DATASET_DIR", c.DatasetDirectory, &DatasetDirectory},
+		{"DATASET_GRANULARITY", c.DatasetGranularity, &DatasetGranularity},
+		{"GCS_BUCKET", c.GCSBucket, &GCSBucket},
+		{"CHECKPOINT_BUCKET", c.CheckpointBucket, &CheckpointBucket},
+		{"MANIFEST_BUCKET", c.ManifestBucket, &ManifestBucket},
+		{"MANIFEST_DIR", c.ManifestDirectory, &ManifestDirectory},
+		{"EXPORT_BUCKET", c.ExportBucket, &ExportBucket},
+		{"RESULT_CACHE_BUCKET", c.ResultCacheBucket, &ResultCacheBucket},
+		{"CATALOG_BUCKET", c.CatalogBucket, &CatalogBucket},
+		{"CATALOG_DIR", c.CatalogDirectory, &CatalogDirectory},
+		{"CACHE_DIR", c.CacheDirectory, &CacheDirectory},
+		{"SPILL_DIR", c.SpillDirectory, &SpillDirectory},
+		{"LOG_LEVEL", c.LogLevel, &LogLevel},
+		{"LISTEN_ADDR", c.ListenAddress, &ListenAddress},
+		{"GRPC_ADDR", c.GRPCAddress, &GRPCAddress},
+		{"METRICS_ADDR", c.MetricsAddress, &MetricsAddress},
+		{"API_KEYS_FILE", c.APIKeysFile, &APIKeysFile},
+		{"WARM_CHANNELS", c.WarmChannels, &WarmChannels},
+		{"WARM_QUEUE_URL", c.WarmQueueURL, &WarmQueueURL},
+	} {
+		if s.value != nil && os.Getenv(s.env) == "" {
+			*s.field = *s.value
+		}
+	}
+	for _, i := range []struct {
+		env   string
+		value *int
+		field *int
+	}{
+		{"PREFETCH_FILES", c.PrefetchFiles, &PrefetchFiles},
+		{"READ_AHEAD_BLOCKS", c.ReadAheadBlocks, &ReadAheadBlocks},
+		{"DECOMPRESS_BLOCK_KB", c.DecompressBlockKB, &DecompressBlockKB},
+		{"READ_BUFFER_KB", c.ReadBufferKB, &ReadBufferKB},
+		{"GZIP_BLOCK_KB", c.GzipBlockKB, &GzipBlockKB},
+		{"GZIP_BLOCKS", c.GzipBlocks, &GzipBlocks},
+		{"FETCH_CONCURRENCY", c.FetchConcurrency, &FetchConcurrency},
+		{"MAX_PREFETCH_OBJECTS", c.MaxPrefetchObjects, &MaxPrefetchObjects},
+		{"MAX_CONCURRENT_SNAPSHOTS", c.MaxConcurrentSnapshots, &MaxConcurrentSnapshots},
+		{"MAX_QUEUED_SNAPSHOTS", c.MaxQueuedSnapshots, &MaxQueuedSnapshots},
+		{"MEMORY_BUDGET_MB", c.MemoryBudgetMB, &MemoryBudgetMB},
+		{"MEMORY_CEILING_MB", c.MemoryCeilingMB, &MemoryCeilingMB},
+		{"PAGE_KB", c.PageKB, &PageKB},
+		{"TARGET_CONCURRENCY", c.TargetConcurrency, &TargetConcurrency},
+		{"PROGRESS_INTERVAL_SECONDS", c.ProgressIntervalSeconds, &ProgressIntervalSeconds},
+		{"MAX_LOOKBACK_MINUTES", c.MaxLookbackMinutes, &DefaultMaxLookbackMinutes},
+	} {
+		if i.value == nil || os.Getenv(i.env) != "" {
+			continue
+		}
+		if *i.value <= 0 {
+			return fmt.Errorf("%s must be positive", i.env)
+		}
+		*i.field = *i.value
+	}
+	if c.CacheMaxBytes != nil && os.Getenv("CACHE_MAX_BYTES") == "" {
+		if *c.CacheMaxBytes <= 0 {
+			return fmt.Errorf("CACHE_MAX_BYTES must be positive")
+		}
+		CacheMaxBytes = *c.CacheMaxBytes
+	}
+	if c.ListDataset != nil && os.Getenv("LIST_DATASET") == "" {
+		ListDataset = *c.ListDataset
+	}
+	// made of them when the package is initialized
+	budget = newSnapshotBudget(MaxConcurrentSnapshots, int64(MemoryBudgetMB)*1024*1024, MaxQueuedSnapshots)
+	ceiling = newMemoryCeiling(int64(MemoryCeilingMB) * 1024 * 1024)
+	Logger = NewLogger(os.Stdout, LogLevel)
+	return nil
+}
@@ -0,0 +1,54 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(bucket string, prefetch, concurrency, lookback int, cacheMax int64, level string) {
+		DefaultDatasetBucket, PrefetchFiles, FetchConcurrency, DefaultMaxLookbackMinutes, CacheMaxBytes, LogLevel = bucket, prefetch, concurrency, lookback, cacheMax, level
+		Logger = NewLogger(os.Stdout, LogLevel)
+	}(DefaultDatasetBucket, PrefetchFiles, FetchConcurrency, DefaultMaxLookbackMinutes, CacheMaxBytes, LogLevel)
+	defer os.Unsetenv("FETCH_CONCURRENCY")
+	// environment variables override the file
+	os.Setenv("FETCH_CONCURRENCY", "3")
+	FetchConcurrency = 3
+	path := filepath.Join(dir, "config.json")
+	config := `{"datasetBucket":"dataset","prefetchFiles":4,"fetchConcurrency":16,"maxLookbackMinutes":60,"cacheMaxBytes":1024,"logLevel":"quiet"}`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if DefaultDatasetBucket != "dataset" || PrefetchFiles != 4 || DefaultMaxLookbackMinutes != 60 || CacheMaxBytes != 1024 || LogLevel != "quiet" {
+		t.Errorf("configuration was not applied: %s %d %d %d %s", DefaultDatasetBucket, PrefetchFiles, DefaultMaxLookbackMinutes, CacheMaxBytes, LogLevel)
+	}
+	if FetchConcurrency != 3 {
+		t.Errorf("expected the environment variable to override the file, got %d", FetchConcurrency)
+	}
+	// the default of requests
+	param, err := ParseParameter(makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.MaxLookbackMinutes != 60 {
+		t.Errorf("expected lookback of 60 minutes, got %d", param.MaxLookbackMinutes)
+	}
+	for _, invalid := range []string{`{"prefetchFile":4}`, `{"prefetchFiles":0}`, `{"prefetchFiles":"4"}`} {
+		if err := ioutil.WriteFile(path, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadConfig(path); err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/exchangedataset/streamcommons/formatter"
+)
+
+// crossValidationChannel is the channel of entries reporting whether formatted snapshots are consistent with raw ones.
+const crossValidationChannel = "$crossValidation"
+
+// rawLevels returns the number of levels in the raw snapshot `message`, which are elements of `bids` and `asks`
+// or of `data` arrays, the message of combined streams is looked into. `ok` is false if it is not known.
+func rawLevels(message []byte) (levels int, ok bool) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(message, &fields) != nil {
+		return 0, false
+	}
+	for _, side := range []string{"bids", "asks"} {
+		if raw, found := fields[side]; found {
+			var elements []json.RawMessage
+			if json.Unmarshal(raw, &elements) != nil {
+				return 0, false
+			}
+			levels += len(elements)
+			ok = true
+		}
+	}
+	if ok {
+		return
+	}
+	if data, found := fields["data"]; found {
+		var elements []json.RawMessage
+		if json.Unmarshal(data, &elements) == nil {
+			return len(elements), true
+		}
+		return rawLevels(data)
+	}
+	return 0, false
+}
+
+// channelValidation is the message of entries in `crossValidationChannel`.
+type channelValidation struct {
+	Channel string `json:"channel"`
+	// FormattedChannels are channels of entries the raw snapshot was formatted into
+	FormattedChannels []string `json:"formattedChannels"`
+	// RawLevels is the number of levels in the raw snapshot, nil if it is not known
+	RawLevels        *int `json:"rawLevels,omitempty"`
+	FormattedEntries int  `json:"formattedEntries"`
+	Consistent       bool `json:"consistent"`
+	// Discrepancy tells how it is not consistent, empty if it is
+	Discrepancy string `json:"discrepancy,omitempty"`
+}
+
+// validatingFormatter compares raw snapshots with what the embedded formatter formats them into.
+// It has to be used for a single snapshot, as results of each channel are kept.
+type validatingFormatter struct {
+	formatter.Formatter
+	validations []channelValidation
+}
+
+func (f *validatingFormatter) FormatMessage(channel string, line []byte) ([]formatter.Result, error) {
+	results, err := f.Formatter.FormatMessage(channel, line)
+	if err != nil {
+		return nil, err
+	}
+	validation := channelValidation{Channel: channel, FormattedChannels: []string{}, FormattedEntries: len(results), Consistent: true}
+	seen := make(map[string]bool)
+	for _, result := range results {
+		if !seen[result.Channel] {
+			seen[result.Channel] = true
+			validation.FormattedChannels = append(validation.FormattedChannels, result.Channel)
+		}
+	}
+	if levels, ok := rawLevels(line); ok {
+		validation.RawLevels = &levels
+	}
+	switch {
+	case len(line) > 0 && len(results) == 0:
+		validation.Consistent = false
+		if f.Formatter.IsSupported(channel) {
+			validation.Discrepancy = "no formatted entries"
+		} else {
+			validation.Discrepancy = "channel not supported by the formatter"
+		}
+	case validation.RawLevels != nil && *validation.RawLevels > 0 && *validation.RawLevels != len(results):
+		validation.Consistent = false
+		validation.Discrepancy = "level counts differ"
+	}
+	f.validations = append(f.validations, validation)
+	return results, nil
+}
+
+// inconsistent returns the number of channels whose formatted snapshots are not consistent.
+func (f *validatingFormatter) inconsistent() (count int) {
+	for _, validation := range f.validations {
+		if !validation.Consistent {
+			count++
+		}
+	}
+	return
+}
+
+// validationEntries returns entries reporting each channel of raw snapshots in the order of channel name.
+func (f *validatingFormatter) validationEntries() ([]entry, error) {
+	validations := append([]channelValidation(nil), f.validations...)
+	sort.SliceStable(validations, func(i, j int) bool { return validations[i].Channel < validations[j].Channel })
+	entries := make([]entry, len(validations))
+	for i, validation := range validations {
+		message, err := json.Marshal(validation)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = entry{channel: crossValidationChannel, message: message}
+	}
+	return entries, nil
+}
@@ -0,0 +1,87 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exchangedataset/streamcommons/formatter"
+)
+
+func TestRawLevels(t *testing.T) {
+	for _, c := range []struct {
+		message  string
+		expected string
+	}{
+		{`{"lastUpdateId":1,"bids":[["1","2"],["0.9","1"]],"asks":[["1.1","3"]]}`, "3 true"},
+		{`{"stream":"btcusdt@depth","data":{"bids":[["1","2"]],"asks":[]}}`, "1 true"},
+		{`{"table":"orderBookL2","action":"partial","data":[{"id":1},{"id":2}]}`, "2 true"},
+		{`{"last":100.5}`, "0 false"},
+		{`[1,2]`, "0 false"},
+	} {
+		levels, ok := rawLevels([]byte(c.message))
+		if actual := fmt.Sprint(levels, ok); actual != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.message, c.expected, actual)
+		}
+	}
+}
+
+// droppingFormatter formats the fixture but drops `ticker` and the last level of `book`.
+type droppingFormatter struct {
+	fixtureFormatter
+}
+
+func (f droppingFormatter) FormatMessage(channel string, line []byte) ([]formatter.Result, error) {
+	if channel == "ticker" {
+		return nil, nil
+	}
+	results, err := f.fixtureFormatter.FormatMessage(channel, line)
+	if err != nil || len(results) == 0 {
+		return results, err
+	}
+	return results[:len(results)-1], nil
+}
+
+func TestCrossValidate(t *testing.T) {
+	defer registerFixture()()
+	param := SnapshotParameter{
+		Exchange:      fixtureExchange,
+		Nanosecs:      []int64{fixtureAt(80 * time.Second)},
+		Channels:      []string{"book", "ticker"},
+		Format:        fixtureExchange,
+		Output:        OutputTSV,
+		CrossValidate: true,
+	}
+	validations := func() []string {
+		ret, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var validations []string
+		for _, line := range strings.Split(strings.TrimSuffix(string(ret), "\n"), "\n") {
+			columns := strings.SplitN(line, "\t", 3)
+			if len(columns) == 3 && columns[1] == crossValidationChannel {
+				validations = append(validations, columns[2])
+			}
+		}
+		return validations
+	}
+	expected := `[{"channel":"book","formattedChannels":["book"],"rawLevels":7,"formattedEntries":7,"consistent":true} ` +
+		`{"channel":"ticker","formattedChannels":["ticker"],"formattedEntries":1,"consistent":true}]`
+	if actual := fmt.Sprint(validations()); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+	formattersMu.Lock()
+	delete(formatters, fixtureExchange)
+	formattersMu.Unlock()
+	RegisterFormatter(fixtureExchange, func(exchange string, channels []string) (formatter.Formatter, error) {
+		return droppingFormatter{}, nil
+	})
+	expected = `[{"channel":"book","formattedChannels":["book"],"rawLevels":7,"formattedEntries":6,"consistent":false,"discrepancy":"level counts differ"} ` +
+		`{"channel":"ticker","formattedChannels":[],"formattedEntries":0,"consistent":false,"discrepancy":"no formatted entries"}]`
+	if actual := fmt.Sprint(validations()); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
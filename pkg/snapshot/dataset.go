@@ -0,0 +1,274 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/exchangedataset/streamcommons"
+)
+
+// DatasetSource provides dataset files to reconstruct snapshot from, in the chronological order.
+type DatasetSource interface {
+	// Next returns the body of the next dataset file.
+	// `body` is nil if the file did not exist, `ok` is false if there is no more file.
+	// It is the caller's responsibility to close `body`.
+	Next() (body io.ReadCloser, ok bool)
+	// Name returns the name of the file last returned by Next.
+	Name() string
+	// Close releases the resources held by this source, including files not yet returned by Next.
+	Close() error
+}
+
+// s3Source is DatasetSource reading files from S3 concurrently.
+type s3Source struct {
+	ctx    context.Context
+	keys   []string
+	i      int
+	bodies *streamcommons.S3GetConcurrent
+	// client fetches the rest of files again, made when a file is first refetched
+	clientOnce sync.Once
+	client     objectGetter
+	clientErr  error
+}
+
+func newS3Source(ctx context.Context, keys []string) *s3Source {
+	return &s3Source{
+		ctx:    ctx,
+		keys:   keys,
+		i:      -1,
+		bodies: streamcommons.S3GetAll(ctx, keys),
+	}
+}
+
+func (s *s3Source) Next() (io.ReadCloser, bool) {
+	body, ok := s.bodies.Next()
+	if ok {
+		s.i++
+	}
+	return body, ok
+}
+
+// Refetch fetches the file `name` from `offset` with a range request to the bucket streamcommons reads from.
+func (s *s3Source) Refetch(name string, offset int64) (io.ReadCloser, error) {
+	if DefaultDatasetBucket == "" {
+		return nil, errors.New("dataset bucket is unknown, DATASET_BUCKET has to be set to refetch files")
+	}
+	s.clientOnce.Do(func() {
+		sess, err := datasetSession("")
+		if err != nil {
+			s.clientErr = err
+			return
+		}
+		s.client = s3.New(sess)
+	})
+	if s.clientErr != nil {
+		return nil, s.clientErr
+	}
+	obj, err := s.client.GetObjectWithContext(s.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(DefaultDatasetBucket),
+		Key:    aws.String(name),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-", offset)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return obj.Body, nil
+}
+
+func (s *s3Source) Name() string {
+	if s.i < 0 || s.i >= len(s.keys) {
+		return ""
+	}
+	return s.keys[s.i]
+}
+
+func (s *s3Source) Close() error {
+	return s.bodies.Close()
+}
+
+// dirSource is DatasetSource reading files from a local directory.
+// Files are expected to be named in the same way as objects in S3.
+type dirSource struct {
+	dir  string
+	keys []string
+	i    int
+}
+
+// NewDirSource returns DatasetSource reading files `keys` from the directory `dir`.
+func NewDirSource(dir string, keys []string) DatasetSource {
+	return &dirSource{
+		dir:  dir,
+		keys: keys,
+		i:    -1,
+	}
+}
+
+func (s *dirSource) Next() (io.ReadCloser, bool) {
+	if s.i+1 >= len(s.keys) {
+		return nil, false
+	}
+	s.i++
+	file, err := os.Open(filepath.Join(s.dir, s.keys[s.i]))
+	if os.IsNotExist(err) {
+		return nil, true
+	}
+	if err != nil {
+		// the file may exist, it must not be taken as missing
+		Logger.Warn("could not open file", "file", s.Name(), "error", err)
+		return failedReader{err: err}, true
+	}
+	return file, true
+}
+
+func (s *dirSource) Refetch(name string, offset int64) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *dirSource) Name() string {
+	if s.i < 0 || s.i >= len(s.keys) {
+		return ""
+	}
+	return s.keys[s.i]
+}
+
+func (s *dirSource) Close() error {
+	return nil
+}
+
+// OpenSource opens the source of dataset files to read to reconstruct snapshot for `param`.
+// `param.StartMinute` is set to the minute of the first file to read.
+func OpenSource(ctx context.Context, param *SnapshotParameter) (source DatasetSource, err error) {
+	param.StartMinute = defaultStartMinute(*param)
+	checkpoint := loadCheckpoint(ctx, param)
+	keys, err := planKeys(ctx, *param)
+	if err != nil {
+		if checkpoint != nil {
+			checkpoint.Close()
+		}
+		return nil, err
+	}
+	if store := manifestStoreFor(*param); store != nil {
+		// files having nothing for the channels are not read
+		keys, param.manifests = planByManifests(ctx, store, *param, keys)
+	}
+	param.prefetchObjects = planPrefetch(*param, len(keys))
+	LoggerFrom(ctx).Debug("dataset files to read", "keys", keys, "prefetch", param.prefetchObjects)
+	source, err = openKeys(ctx, *param, keys)
+	if err != nil {
+		if checkpoint != nil {
+			checkpoint.Close()
+		}
+		return nil, err
+	}
+	if param.manifests != nil {
+		if offsets := param.manifests.compressedOffsets(); len(offsets) > 0 {
+			// seekable files are not decompressed before the frames they are read from
+			source = newSeekSource(source, offsets)
+		}
+	}
+	param.plannedFiles = len(keys)
+	if checkpoint != nil {
+		source = &prependSource{name: "checkpoint", first: checkpoint, rest: source}
+		param.plannedFiles++
+	}
+	return
+}
+
+// loadCheckpoint returns the latest checkpoint before the first target of `param` to read instead of files before it,
+// nil if there is none. `param.StartMinute` is set to the minute of the checkpoint.
+func loadCheckpoint(ctx context.Context, param *SnapshotParameter) io.ReadCloser {
+	store := checkpointStoreFor(*param)
+	if param.State != nil || store == nil {
+		return nil
+	}
+	// files before the checkpoint do not have to be read
+	firstMinute := param.Nanosecs[0] / 60 / 1000000000
+	minute, body, err := store.Nearest(param.Exchange, param.Channels, param.StartMinute, firstMinute)
+	if err != nil {
+		LoggerFrom(ctx).Warn("could not load checkpoint", "error", err)
+		return nil
+	}
+	if body != nil {
+		LoggerFrom(ctx).Debug("loaded checkpoint", "minute", minute)
+		param.StartMinute = minute
+	}
+	return body
+}
+
+// openKeys opens the source of dataset files `keys` in the location configured for `param`.
+func openKeys(ctx context.Context, param SnapshotParameter, keys []string) (DatasetSource, error) {
+	// location identifies where files are read from in the cache
+	location := "s3"
+	open := func(keys []string) (DatasetSource, error) {
+		if window := param.prefetchObjects; window > 0 && window < len(keys) {
+			// streamcommons fetches every key given
+			return newWindowedSource(keys, window, func(keys []string) (DatasetSource, error) {
+				return newS3Source(ctx, keys), nil
+			}), nil
+		}
+		return newS3Source(ctx, keys), nil
+	}
+	if GCSBucket != "" {
+		location = "gcs-" + GCSBucket
+		open = func(keys []string) (DatasetSource, error) {
+			return newGCSSource(ctx, GCSBucket, keys, param.FetchConcurrency)
+		}
+	} else if param.DatasetBucket != "" {
+		location = "s3-" + param.DatasetBucket
+		open = func(keys []string) (DatasetSource, error) {
+			return newS3BucketSource(ctx, param.DatasetBucket, keys, param.FetchConcurrency, datasetRoleOf(param), encryptionOf(param))
+		}
+	}
+	if lister := listerFor(param); lister != nil && param.Granularity != GranularityMixed {
+		// keys of mixed granularity are already planned by listing
+		// files which do not exist are known before fetching
+		open = plannedOpen(ctx, lister, param.DatasetPrefix+param.Exchange+"_", open)
+	}
+	if DatasetDirectory != "" {
+		return NewDirSource(DatasetDirectory, keys), nil
+	}
+	if CacheDirectory != "" && !encryptionOf(param).encrypted() {
+		// decrypted objects are not left on disk
+		return newCacheSource(filepath.Join(CacheDirectory, location), keys, open)
+	}
+	return open(keys)
+}
+
+// defaultStartMinute returns the minute of the first dataset file to read for `param` without checkpoints.
+func defaultStartMinute(param SnapshotParameter) int64 {
+	if param.State != nil {
+		// files before the state are not needed
+		return param.StateNanosec / 60 / 1000000000
+	}
+	firstMinute := param.Nanosecs[0] / 60 / 1000000000
+	startMinute := (firstMinute / 10) * 10
+	if param.MaxLookbackMinutes > 0 && startMinute < firstMinute-param.MaxLookbackMinutes {
+		startMinute = firstMinute - param.MaxLookbackMinutes
+	}
+	return startMinute
+}
+
+// datasetKeys returns the names of minute files to read from `param.StartMinute` to the latest target.
+func datasetKeys(param SnapshotParameter) []string {
+	lastMinute := param.Nanosecs[len(param.Nanosecs)-1] / 60 / 1000000000
+	keys := make([]string, lastMinute-param.StartMinute+1)
+	for i := int64(0); i <= lastMinute-param.StartMinute; i++ {
+		keys[i] = minuteKey(param, param.StartMinute+i)
+	}
+	return keys
+}
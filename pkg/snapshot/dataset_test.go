@@ -0,0 +1,58 @@
+package snapshot
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "bitmex_1.gz"), []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bitmex_3.gz"), []byte("third"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	source := NewDirSource(dir, []string{"bitmex_1.gz", "bitmex_2.gz", "bitmex_3.gz"})
+	defer source.Close()
+	expected := []string{"first", "", "third"}
+	for i, exp := range expected {
+		body, ok := source.Next()
+		if !ok {
+			t.Fatalf("file %d: expected ok", i)
+		}
+		if exp == "" {
+			if body != nil {
+				t.Fatalf("file %d: expected nil body for missing file", i)
+			}
+			continue
+		}
+		content, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != exp {
+			t.Fatalf("file %d: expected %q, got %q", i, exp, content)
+		}
+	}
+	if _, ok := source.Next(); ok {
+		t.Fatal("expected no more files")
+	}
+	// files which could not be opened are not missing
+	unreadable := NewDirSource(dir, []string{"bitmex_1.gz/bitmex_2.gz"})
+	body, ok := unreadable.Next()
+	if !ok || body == nil {
+		t.Fatal("expected the file which could not be opened to be returned")
+	}
+	if _, err := ioutil.ReadAll(body); !errors.Is(err, ErrStorage) {
+		t.Errorf("expected the storage error, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// snapshotHashLength is the length of the hash of entries in snapshot tokens.
+const snapshotHashLength = 32
+
+// entriesHash returns the hash of `entries` of a snapshot, which is the same for the same snapshot in any output.
+func entriesHash(entries []entry) string {
+	hash := sha256.New()
+	for _, e := range entries {
+		hash.Write([]byte(e.channel))
+		hash.Write([]byte{'\t'})
+		hash.Write(e.message)
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:snapshotHashLength]
+}
+
+// snapshotToken returns the token identifying the snapshot of `entries` at `nanosec`,
+// clients give it as `since` to take only changes from the snapshot they have.
+func snapshotToken(nanosec int64, entries []entry) string {
+	return fmt.Sprintf("%d-%s", nanosec, entriesHash(entries))
+}
+
+// parseSnapshotToken returns the target and the hash of entries of the snapshot `token` identifies.
+func parseSnapshotToken(token string) (nanosec int64, hash string, err error) {
+	i := strings.IndexByte(token, '-')
+	if i < 0 {
+		return 0, "", errors.New("token must be in the form of 'nanosec-hash'")
+	}
+	nanosec, err = strconv.ParseInt(token[:i], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid timestamp in token: %v", err)
+	}
+	hash = token[i+1:]
+	if _, serr := hex.DecodeString(hash); serr != nil || len(hash) != snapshotHashLength {
+		return 0, "", errors.New("invalid hash in token")
+	}
+	return
+}
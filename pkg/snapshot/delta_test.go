@@ -0,0 +1,90 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSnapshotToken(t *testing.T) {
+	token := snapshotToken(1234, []entry{{channel: "book", message: []byte(`[1,2]`)}})
+	nanosec, hash, err := parseSnapshotToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nanosec != 1234 || hash != entriesHash([]entry{{channel: "book", message: []byte(`[1,2]`)}}) {
+		t.Errorf("unexpected token: %d %s", nanosec, hash)
+	}
+	for _, invalid := range []string{"", "1234", "abc-" + hash, "1234-xyz", "1234-" + hash[:10]} {
+		if _, _, err := parseSnapshotToken(invalid); err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+func TestSnapshotSince(t *testing.T) {
+	defer registerFixture()()
+	base := fixtureAt(30 * time.Second)
+	target := fixtureAt(80 * time.Second)
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{base},
+		Channels: []string{"book", "ticker"},
+		Format:   "raw",
+		Output:   OutputTSV,
+	}
+	_, report, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nanosec, hash, err := parseSnapshotToken(report.Token)
+	if err != nil || nanosec != base {
+		t.Fatalf("unexpected token %s: %v", report.Token, err)
+	}
+	// changes are the same as between two targets of diff mode
+	param.Nanosecs = []int64{base, target}
+	param.Diff = true
+	expected, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	param.SinceHash = hash
+	changes, report, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FullSnapshot || !bytes.Equal(changes, expected) {
+		t.Errorf("expected changes:\n%s\ngot:\n%s", expected, changes)
+	}
+	if !strings.HasPrefix(report.Token, strconv.FormatInt(target, 10)+"-") {
+		t.Errorf("unexpected token of the target: %s", report.Token)
+	}
+	// the full snapshot is returned if the client has another snapshot
+	param.SinceHash = strings.Repeat("0", snapshotHashLength)
+	full, report, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.FullSnapshot || !bytes.Equal(full, goldenFile(t, "raw_across_files")) {
+		t.Errorf("expected the full snapshot, got:\n%s", full)
+	}
+}
+
+func TestMakeParameterSince(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "raw")
+	event.QueryStringParameters["since"] = snapshotToken(1598941024000000000, nil)
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !param.Diff || param.SinceHash == "" || len(param.Nanosecs) != 2 || param.Nanosecs[0] != 1598941024000000000 || !param.MadeAtOnce() {
+		t.Errorf("unexpected parameter: %+v", param)
+	}
+	event.QueryStringParameters["since"] = snapshotToken(1598941026000000000, nil)
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected token after the target to be rejected")
+	}
+}
@@ -0,0 +1,254 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/exchangedataset/streamcommons/formatter"
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// fields of instruments in snapshots of derivatives channels, values are as they are in messages
+const (
+	fieldMarkPrice       = "markPrice"
+	fieldIndexPrice      = "indexPrice"
+	fieldFundingRate     = "fundingRate"
+	fieldNextFundingTime = "nextFundingTime"
+	fieldOpenInterest    = "openInterest"
+)
+
+// bitmexInstrumentFields maps fields of `instrument` table of BitMEX to fields in snapshots.
+var bitmexInstrumentFields = map[string]string{
+	"markPrice":             fieldMarkPrice,
+	"indicativeSettlePrice": fieldIndexPrice,
+	"fundingRate":           fieldFundingRate,
+	"fundingTimestamp":      fieldNextFundingTime,
+	"openInterest":          fieldOpenInterest,
+}
+
+// binanceMarkPriceFields maps fields of `markPrice` stream of Binance futures to fields in snapshots.
+var binanceMarkPriceFields = map[string]string{
+	"p": fieldMarkPrice,
+	"i": fieldIndexPrice,
+	"r": fieldFundingRate,
+	"T": fieldNextFundingTime,
+}
+
+// isDerivativesChannel returns true if `channel` has metadata of derivatives, such as funding rates,
+// which are taken snapshot of by this package rather than simulators of streamcommons.
+func isDerivativesChannel(exchange string, channel string) bool {
+	switch exchange {
+	case "bitmex":
+		return channel == "instrument" || strings.HasPrefix(channel, "instrument_")
+	case binanceFutures:
+		at := strings.IndexByte(channel, '@')
+		return at >= 0 && strings.HasPrefix(channel[at+1:], "markPrice")
+	}
+	return false
+}
+
+// splitDerivativesChannels returns channels of derivatives in `channels` and others.
+func splitDerivativesChannels(exchange string, channels []string) (derivatives []string, others []string) {
+	for _, channel := range channels {
+		if isDerivativesChannel(exchange, channel) {
+			derivatives = append(derivatives, channel)
+		} else {
+			others = append(others, channel)
+		}
+	}
+	return
+}
+
+// instrumentValues are values of fields of each instrument in effect.
+type instrumentValues map[string]map[string]json.RawMessage
+
+// derivativesSimulator is simulator of derivatives channels, which keeps the latest values of fields of each instrument.
+// Lines of other channels are passed to the embedded simulator, which is nil if no other channel is requested.
+type derivativesSimulator struct {
+	simulator.Simulator
+	exchange string
+	// channels are requested derivatives channels
+	channels []string
+	values   map[string]instrumentValues
+}
+
+func newDerivativesSimulator(exchange string, channels []string, others simulator.Simulator) *derivativesSimulator {
+	return &derivativesSimulator{Simulator: others, exchange: exchange, channels: channels, values: make(map[string]instrumentValues)}
+}
+
+// requested returns the requested channel lines of `channel` are for, such as `instrument` for `instrument_XBTUSD`,
+// or empty string if it is not of requested derivatives channels.
+func (s *derivativesSimulator) requested(channel string) string {
+	for _, requested := range s.channels {
+		if channel == requested || strings.HasPrefix(channel, requested+"_") {
+			return requested
+		}
+	}
+	return ""
+}
+
+func (s *derivativesSimulator) ProcessStart(line []byte) error {
+	// values are sent again in the new connection
+	s.values = make(map[string]instrumentValues)
+	if s.Simulator != nil {
+		return s.Simulator.ProcessStart(line)
+	}
+	return nil
+}
+
+func (s *derivativesSimulator) ProcessMessageChannelKnown(channel string, line []byte) error {
+	requested := s.requested(channel)
+	if requested == "" {
+		if s.Simulator != nil {
+			return s.Simulator.ProcessMessageChannelKnown(channel, line)
+		}
+		return nil
+	}
+	values, ok := s.values[requested]
+	if !ok {
+		values = make(instrumentValues)
+		s.values[requested] = values
+	}
+	var err error
+	switch s.exchange {
+	case "bitmex":
+		err = applyBitmexInstrument(values, line)
+	case binanceFutures:
+		err = applyBinanceMarkPrice(values, line)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", channel, err)
+	}
+	return nil
+}
+
+func (s *derivativesSimulator) ProcessState(channel string, line []byte) error {
+	if s.requested(channel) == "" {
+		if s.Simulator != nil {
+			return s.Simulator.ProcessState(channel, line)
+		}
+		return nil
+	}
+	return s.ProcessMessageChannelKnown(channel, line)
+}
+
+// TakeSnapshot returns snapshots of the embedded simulator followed by derivatives channels in the order of request.
+// A snapshot of derivatives channel is a JSON object of values of each instrument, such as
+// `{"XBTUSD":{"fundingRate":0.0001,"markPrice":11794.15}}`.
+func (s *derivativesSimulator) TakeSnapshot() ([]simulator.Snapshot, error) {
+	var snapshots []simulator.Snapshot
+	if s.Simulator != nil {
+		others, err := s.Simulator.TakeSnapshot()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = others
+	}
+	for _, channel := range s.channels {
+		values, ok := s.values[channel]
+		if !ok {
+			continue
+		}
+		message, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, simulator.Snapshot{Channel: channel, Snapshot: message})
+	}
+	return snapshots, nil
+}
+
+// applyFields sets values of fields in `fields` of `row` to `values` of the instrument.
+func applyFields(values map[string]json.RawMessage, row map[string]json.RawMessage, fields map[string]string) {
+	for name, field := range fields {
+		if value, ok := row[name]; ok {
+			// the buffer of the line is reused
+			values[field] = append(json.RawMessage(nil), value...)
+		}
+	}
+}
+
+// applyBitmexInstrument applies a message of `instrument` table to `values`.
+func applyBitmexInstrument(values instrumentValues, line []byte) error {
+	var message struct {
+		Action string                       `json:"action"`
+		Data   []map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(line, &message); err != nil {
+		return err
+	}
+	if message.Action == "partial" {
+		for symbol := range values {
+			delete(values, symbol)
+		}
+	}
+	for _, row := range message.Data {
+		var symbol string
+		if err := json.Unmarshal(row["symbol"], &symbol); err != nil {
+			return fmt.Errorf("symbol is missing: %v", err)
+		}
+		if message.Action == "delete" {
+			delete(values, symbol)
+			continue
+		}
+		instrument, ok := values[symbol]
+		if !ok {
+			instrument = make(map[string]json.RawMessage)
+			values[symbol] = instrument
+		}
+		applyFields(instrument, row, bitmexInstrumentFields)
+	}
+	return nil
+}
+
+// applyBinanceMarkPrice applies a message of `markPrice` stream to `values`, it could be of combined streams.
+func applyBinanceMarkPrice(values instrumentValues, line []byte) error {
+	var message struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(line, &message); err != nil {
+		return err
+	}
+	row := message.Data
+	if row == nil {
+		if err := json.Unmarshal(line, &row); err != nil {
+			return err
+		}
+	}
+	var symbol string
+	if err := json.Unmarshal(row["s"], &symbol); err != nil {
+		return fmt.Errorf("symbol is missing: %v", err)
+	}
+	instrument, ok := values[symbol]
+	if !ok {
+		instrument = make(map[string]json.RawMessage)
+		values[symbol] = instrument
+	}
+	applyFields(instrument, row, binanceMarkPriceFields)
+	return nil
+}
+
+// derivativesFormatter passes snapshots of derivatives channels as they are, as they are already in JSON,
+// and formats others with the embedded formatter, which is nil if no other channel is requested.
+type derivativesFormatter struct {
+	formatter.Formatter
+	exchange string
+}
+
+func (f *derivativesFormatter) FormatMessage(channel string, line []byte) ([]formatter.Result, error) {
+	if isDerivativesChannel(f.exchange, channel) {
+		return []formatter.Result{{Channel: channel, Message: line}}, nil
+	}
+	if f.Formatter == nil {
+		return nil, nil
+	}
+	return f.Formatter.FormatMessage(channel, line)
+}
+
+func (f *derivativesFormatter) IsSupported(channel string) bool {
+	if isDerivativesChannel(f.exchange, channel) {
+		return true
+	}
+	return f.Formatter != nil && f.Formatter.IsSupported(channel)
+}
@@ -0,0 +1,89 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDerivativesSimulator(t *testing.T) {
+	others := &countingSimulator{channels: []string{"orderBookL2"}, counts: make(map[string]int)}
+	sim := newDerivativesSimulator("bitmex", []string{"instrument"}, others)
+	for _, line := range []struct {
+		channel string
+		message string
+	}{
+		{"instrument", `{"table":"instrument","action":"partial","data":[{"symbol":"XBTUSD","markPrice":11794.15,"fundingRate":0.0001,"openInterest":100,"lastPrice":11790},{"symbol":"ETHUSD","markPrice":380.5}]}`},
+		{"orderBookL2", `{}`},
+		{"instrument", `{"table":"instrument","action":"update","data":[{"symbol":"XBTUSD","markPrice":11795.5}]}`},
+		{"instrument", `{"table":"instrument","action":"delete","data":[{"symbol":"ETHUSD"}]}`},
+	} {
+		if err := sim.ProcessMessageChannelKnown(line.channel, []byte(line.message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshots, err := sim.TakeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Channel != "orderBookL2" || string(snapshots[0].Snapshot) != "1" {
+		t.Fatalf("expected snapshot of the other simulator first, got %v", snapshots)
+	}
+	expected := `{"XBTUSD":{"fundingRate":0.0001,"markPrice":11795.5,"openInterest":100}}`
+	if snapshots[1].Channel != "instrument" || string(snapshots[1].Snapshot) != expected {
+		t.Errorf("expected %s, got %s: %s", expected, snapshots[1].Channel, snapshots[1].Snapshot)
+	}
+}
+
+func TestBinanceMarkPrice(t *testing.T) {
+	sim := newDerivativesSimulator(binanceFutures, []string{"btcusdt@markPrice@1s"}, nil)
+	line := `{"stream":"btcusdt@markPrice@1s","data":{"e":"markPriceUpdate","E":1598941025000,"s":"BTCUSDT","p":"11794.15000000","i":"11784.62659091","r":"0.00038167","T":1598947200000}}`
+	if err := sim.ProcessMessageChannelKnown("btcusdt@markPrice@1s", []byte(line)); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := sim.TakeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"BTCUSDT":{"fundingRate":"0.00038167","indexPrice":"11784.62659091","markPrice":"11794.15000000","nextFundingTime":1598947200000}}`
+	if len(snapshots) != 1 || string(snapshots[0].Snapshot) != expected {
+		t.Errorf("expected %s, got %v", expected, snapshots)
+	}
+	if err := sim.ProcessStart([]byte("wss://fstream.binance.com")); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ := sim.TakeSnapshot(); len(snapshots) != 0 {
+		t.Errorf("expected values to be forgotten in the new connection, got %v", snapshots)
+	}
+}
+
+func TestSnapshotDerivatives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	writer.Write([]byte("start\t100\twss://www.bitmex.com/realtime\n" +
+		"msg\t200\tinstrument\t{\"table\":\"instrument\",\"action\":\"partial\",\"data\":[{\"symbol\":\"XBTUSD\",\"fundingRate\":0.0001}]}\n" +
+		"msg\t2000\tinstrument\t{\"table\":\"instrument\",\"action\":\"update\",\"data\":[{\"symbol\":\"XBTUSD\",\"fundingRate\":0.0002}]}\n"))
+	writer.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, "bitmex_0.gz"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// simulators and formatters of streamcommons are not needed only for derivatives channels
+	param := SnapshotParameter{Exchange: "bitmex", Nanosecs: []int64{1000}, Channels: []string{"instrument"}, Format: "json", Output: OutputTSV}
+	ret, _, err := Snapshot(context.Background(), param, NewDirSource(dir, []string{"bitmex_0.gz"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(ret), `instrument	{"XBTUSD":{"fundingRate":0.0001}}`) {
+		t.Errorf("expected the funding rate in effect at the target, got %q", ret)
+	}
+}
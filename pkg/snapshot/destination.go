@@ -0,0 +1,202 @@
+package snapshot
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// destinationScheme is the scheme of `destination`, which is the only one supported.
+const destinationScheme = "s3://"
+
+// parseDestination parses `destination` in the form of `s3://bucket/key`.
+func parseDestination(destination string) (bucket string, key string, err error) {
+	if !strings.HasPrefix(destination, destinationScheme) {
+		return "", "", errors.New("'destination' must be in the form of 's3://bucket/key'")
+	}
+	fields := strings.SplitN(destination[len(destinationScheme):], "/", 2)
+	if len(fields) != 2 || fields[0] == "" || fields[1] == "" || strings.HasSuffix(fields[1], "/") {
+		return "", "", errors.New("'destination' must be in the form of 's3://bucket/key'")
+	}
+	return fields[0], fields[1], nil
+}
+
+// destinationLocation is the response of the snapshot written to `destination`.
+type destinationLocation struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	// Size is the size of the object, compressed if it is
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType"`
+	Compression string `json:"compression,omitempty"`
+	// Timestamp is the timestamp of the last line applied to the snapshot
+	Timestamp int64  `json:"timestamp"`
+	Scanned   int64  `json:"scanned"`
+	Partial   string `json:"partial,omitempty"`
+	// Format is the format of the snapshot in the object if multiple formats were requested
+	Format string `json:"format,omitempty"`
+	// Symbol is the normalized symbol of the snapshot in the object if snapshots were fanned out by symbols
+	Symbol string `json:"symbol,omitempty"`
+}
+
+// uploadObject uploads `body` to `key` in `bucket` until it ends, replaced in tests.
+var uploadObject = func(ctx context.Context, bucket string, key string, body io.Reader, contentType string, contentEncoding string) error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	}
+	if contentEncoding != "" {
+		input.ContentEncoding = aws.String(contentEncoding)
+	}
+	_, err = s3manager.NewUploader(sess).UploadWithContext(ctx, input)
+	return err
+}
+
+// destinationWriter uploads bytes written to it to an object, the upload starts at the first write
+// so that no object is made for empty snapshots.
+type destinationWriter struct {
+	ctx             context.Context
+	bucket          string
+	key             string
+	contentType     string
+	contentEncoding string
+	pipe            *io.PipeWriter
+	done            chan error
+	size            int64
+}
+
+func (w *destinationWriter) Write(p []byte) (n int, err error) {
+	if w.pipe == nil {
+		reader, writer := io.Pipe()
+		w.pipe = writer
+		w.done = make(chan error, 1)
+		go func() {
+			err := uploadObject(w.ctx, w.bucket, w.key, reader, w.contentType, w.contentEncoding)
+			// unblocks writes if the upload failed in the middle
+			reader.CloseWithError(err)
+			w.done <- err
+		}()
+	}
+	n, err = w.pipe.Write(p)
+	w.size += int64(n)
+	return
+}
+
+// Close finishes the upload and returns the error of it, the upload is aborted with `err` if it is not nil.
+func (w *destinationWriter) Close(err error) error {
+	if w.pipe == nil {
+		return nil
+	}
+	if err == nil {
+		w.pipe.Close()
+	} else {
+		w.pipe.CloseWithError(err)
+	}
+	return <-w.done
+}
+
+// countingWriter counts bytes written to the underlying writer.
+type countingWriter struct {
+	io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.Writer.Write(p)
+	w.written += int64(n)
+	return
+}
+
+// destinationOutput is the object at the destination the snapshot is written to, compressed if requested.
+type destinationOutput struct {
+	dest        *destinationWriter
+	gz          *gzip.Writer
+	front       *countingWriter
+	compression string
+}
+
+// newDestinationOutput returns the output uploading to `key` in `bucket`, `compression` is either `gzip` or empty.
+func newDestinationOutput(ctx context.Context, bucket string, key string, contentType string, compression string) *destinationOutput {
+	output := &destinationOutput{dest: &destinationWriter{ctx: ctx, bucket: bucket, key: key, contentType: contentType}, compression: compression}
+	output.front = &countingWriter{Writer: output.dest}
+	if compression == "gzip" {
+		output.dest.contentEncoding = "gzip"
+		output.gz = gzip.NewWriter(output.dest)
+		output.front.Writer = output.gz
+	}
+	return output
+}
+
+func (o *destinationOutput) Write(p []byte) (n int, err error) {
+	return o.front.Write(p)
+}
+
+// finish finishes the upload, aborted if `err` is not nil, and returns the location of the object.
+// `location` is nil if nothing was written, no object is made then.
+func (o *destinationOutput) finish(report Report, err error) (location *destinationLocation, _ error) {
+	if err == nil && o.gz != nil && o.front.written > 0 {
+		err = o.gz.Close()
+	}
+	if serr := o.dest.Close(err); serr != nil && err == nil {
+		err = newSnapshotError(ErrStorage, fmt.Errorf("could not upload to %s%s/%s: %v", destinationScheme, o.dest.bucket, o.dest.key, serr))
+	}
+	if err != nil || o.front.written == 0 {
+		return nil, err
+	}
+	return &destinationLocation{
+		Bucket:      o.dest.bucket,
+		Key:         o.dest.key,
+		Size:        o.dest.size,
+		ContentType: o.dest.contentType,
+		Compression: o.compression,
+		Timestamp:   report.LastTimestamp,
+		Scanned:     report.Scanned,
+		Partial:     report.Partial,
+	}, nil
+}
+
+// snapshotToDestination writes the snapshot for `param` to `param.Destination` and returns the location of it as JSON.
+// `location` is nil if the snapshot is empty, no object is made then.
+func snapshotToDestination(ctx context.Context, param SnapshotParameter, source DatasetSource) (location []byte, report Report, err error) {
+	if param.FanOut {
+		return snapshotSymbolsToDestination(ctx, param, source)
+	}
+	if len(param.Formats) > 1 {
+		return snapshotFormatsToDestination(ctx, param, source)
+	}
+	bucket, key, _ := parseDestination(param.Destination)
+	output := newDestinationOutput(ctx, bucket, key, ContentTypes[param.Output], param.DestinationCompression)
+	report, err = SnapshotTo(ctx, param, source, output)
+	written, err := output.finish(report, err)
+	if err != nil || written == nil {
+		return
+	}
+	location, err = json.Marshal(written)
+	return
+}
+
+// ResponseContentType returns the content type of responses of `param` with `report` of them.
+// Responses are the location of the snapshot if it is written to `destination`.
+func ResponseContentType(param SnapshotParameter, report Report) string {
+	if param.Destination != "" {
+		return "application/json"
+	}
+	if report.ContentType != "" {
+		return report.ContentType
+	}
+	return ContentTypes[param.Output]
+}
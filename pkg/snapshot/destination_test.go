@@ -0,0 +1,105 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseDestination(t *testing.T) {
+	bucket, key, err := parseDestination("s3://etl-bucket/snapshots/bitmex.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket != "etl-bucket" || key != "snapshots/bitmex.tsv" {
+		t.Errorf("unexpected location: %s %s", bucket, key)
+	}
+	for _, destination := range []string{"etl-bucket/key", "s3://etl-bucket", "s3://etl-bucket/", "s3:///key", "s3://etl-bucket/dir/", "gs://etl-bucket/key"} {
+		if _, _, err := parseDestination(destination); err == nil {
+			t.Errorf("%s: expected error", destination)
+		}
+	}
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.QueryStringParameters["destination"] = "s3://etl-bucket/snapshot.tsv"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected bucket not allowed to be rejected")
+	}
+	defer func(allowed []string) { AllowedDestinationBuckets = allowed }(AllowedDestinationBuckets)
+	AllowedDestinationBuckets = []string{"etl-bucket"}
+	event.QueryStringParameters["destinationCompression"] = "gzip"
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.Destination != "s3://etl-bucket/snapshot.tsv" || param.DestinationCompression != "gzip" {
+		t.Errorf("unexpected destination: %s %s", param.Destination, param.DestinationCompression)
+	}
+	if ResponseContentType(param, Report{}) != "application/json" {
+		t.Errorf("expected the location in JSON, got %s", ResponseContentType(param, Report{}))
+	}
+}
+
+func TestSnapshotToDestination(t *testing.T) {
+	defer registerFixture()()
+	type upload struct {
+		bucket, key, contentType, contentEncoding string
+		body                                      []byte
+	}
+	var uploads []upload
+	defer func(original func(context.Context, string, string, io.Reader, string, string) error) {
+		uploadObject = original
+	}(uploadObject)
+	uploadObject = func(ctx context.Context, bucket string, key string, body io.Reader, contentType string, contentEncoding string) error {
+		b, err := ioutil.ReadAll(body)
+		uploads = append(uploads, upload{bucket, key, contentType, contentEncoding, b})
+		return err
+	}
+	param := SnapshotParameter{
+		Exchange:               fixtureExchange,
+		Nanosecs:               []int64{fixtureAt(35 * time.Second)},
+		Channels:               []string{"book", "ticker"},
+		Format:                 "raw",
+		Output:                 OutputTSV,
+		Destination:            "s3://etl-bucket/snapshot.tsv.gz",
+		DestinationCompression: "gzip",
+	}
+	location, report, err := snapshotToDestination(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 1 {
+		t.Fatalf("expected an upload, got %d", len(uploads))
+	}
+	u := uploads[0]
+	if u.bucket != "etl-bucket" || u.key != "snapshot.tsv.gz" || u.contentType != ContentTypes[OutputTSV] || u.contentEncoding != "gzip" {
+		t.Errorf("unexpected upload: %+v", u)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(u.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile(filepath.Join(goldenDir, "raw_first_file.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, expected) {
+		t.Errorf("uploaded snapshot differs:\n%s\nexpected:\n%s", decompressed, expected)
+	}
+	var loc destinationLocation
+	if err := json.Unmarshal(location, &loc); err != nil {
+		t.Fatal(err)
+	}
+	if loc.Bucket != "etl-bucket" || loc.Key != "snapshot.tsv.gz" || loc.Size != int64(len(u.body)) || loc.Timestamp != report.LastTimestamp || loc.Compression != "gzip" {
+		t.Errorf("unexpected location: %+v", loc)
+	}
+}
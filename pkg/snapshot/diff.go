@@ -0,0 +1,81 @@
+package snapshot
+
+import (
+	"bufio"
+)
+
+// writeDiff writes lines which are removed or added from `before` to `after` per channel.
+// Each line is in the form of `nanosec\tchannel\top\tmessage` where op is either `-` (removed) or `+` (added),
+// the timestamp is written in `layout`. A changed line is reported as a pair of removed and added line.
+func writeDiff(buffer *bufio.Writer, nanosec int64, before []entry, after []entry, layout lineLayout) (err error) {
+	// the number of identical lines in `before` per channel
+	remaining := make(map[string]map[string]int)
+	// channels in the order of their first appearance
+	channels := make([]string, 0)
+	for _, e := range before {
+		lines, ok := remaining[e.channel]
+		if !ok {
+			lines = make(map[string]int)
+			remaining[e.channel] = lines
+			channels = append(channels, e.channel)
+		}
+		lines[string(e.message)]++
+	}
+	added := make(map[string][]entry)
+	for _, e := range after {
+		lines, ok := remaining[e.channel]
+		if !ok {
+			lines = make(map[string]int)
+			remaining[e.channel] = lines
+			channels = append(channels, e.channel)
+		}
+		if lines[string(e.message)] > 0 {
+			// not changed
+			lines[string(e.message)]--
+			continue
+		}
+		added[e.channel] = append(added[e.channel], e)
+	}
+	prefix := layout.prefix(nanosec, "\t")
+	for _, channel := range channels {
+		// lines are first written in the order of `before`
+		for _, e := range before {
+			if e.channel != channel || remaining[channel][string(e.message)] == 0 {
+				continue
+			}
+			remaining[channel][string(e.message)]--
+			if err = writeDiffLine(buffer, prefix, channel, '-', e.message); err != nil {
+				return
+			}
+		}
+		for _, e := range added[channel] {
+			if err = writeDiffLine(buffer, prefix, channel, '+', e.message); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func writeDiffLine(buffer *bufio.Writer, prefix string, channel string, op rune, message []byte) (err error) {
+	if _, err = buffer.WriteString(prefix); err != nil {
+		return
+	}
+	if _, err = buffer.WriteString(channel); err != nil {
+		return
+	}
+	if _, err = buffer.WriteRune('\t'); err != nil {
+		return
+	}
+	if _, err = buffer.WriteRune(op); err != nil {
+		return
+	}
+	if _, err = buffer.WriteRune('\t'); err != nil {
+		return
+	}
+	if _, err = buffer.Write(message); err != nil {
+		return
+	}
+	_, err = buffer.WriteRune('\n')
+	return
+}
@@ -0,0 +1,30 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestWriteDiff(t *testing.T) {
+	before := []entry{
+		{channel: "book", message: []byte("a")},
+		{channel: "book", message: []byte("b")},
+		{channel: "gone", message: []byte("x")},
+	}
+	after := []entry{
+		{channel: "book", message: []byte("b")},
+		{channel: "book", message: []byte("c")},
+		{channel: "new", message: []byte("y")},
+	}
+	buf := new(bytes.Buffer)
+	writer := bufio.NewWriter(buf)
+	if err := writeDiff(writer, 10, before, after, lineLayout{}); err != nil {
+		t.Fatal(err)
+	}
+	writer.Flush()
+	expected := "10\tbook\t-\ta\n10\tbook\t+\tc\n10\tgone\t-\tx\n10\tnew\t+\ty\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+}
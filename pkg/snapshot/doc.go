@@ -0,0 +1,10 @@
+// Package snapshot reconstructs snapshots of exchanges at given timestamps from the dataset.
+//
+// Snapshot feeds dataset files from DatasetSource to simulators of streamcommons and writes snapshots
+// of them at each target in SnapshotParameter. OpenSource opens the source of the location configured
+// by environment variables, and Take also serves results from the result cache if it is configured.
+// They can also be given in the JSON file loaded by LoadConfig, which environment variables override.
+//
+// Simulators of exchanges not known to streamcommons can be plugged in with RegisterSimulator, and formats
+// with RegisterFormatter, which can also post-process another format as a stage such as `json+internal`.
+package snapshot
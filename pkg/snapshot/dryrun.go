@@ -0,0 +1,166 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/exchangedataset/streamcommons"
+)
+
+// DefaultDatasetBucket is the name of the S3 bucket streamcommons reads dataset files from.
+// It is only used to get sizes of files in dry-run mode, which is unavailable for the default location if empty.
+var DefaultDatasetBucket = os.Getenv("DATASET_BUCKET")
+
+// estimatedCompressionRatio is the typical ratio of the decompressed size of dataset files to the compressed size.
+// Bytes scanned are of the decompressed files, so they are estimated from object sizes with it.
+const estimatedCompressionRatio = 8
+
+// objectSizer returns the sizes of objects of `keys`, or -1 for objects which do not exist.
+type objectSizer func(ctx context.Context, keys []string) ([]int64, error)
+
+// dryRunFile is a dataset file which would be read.
+type dryRunFile struct {
+	Name string `json:"name"`
+	// Size is the compressed size of the file, or -1 if it does not exist
+	Size int64 `json:"size"`
+}
+
+// dryRunResult is the estimated cost of a snapshot returned in dry-run mode.
+type dryRunResult struct {
+	Files []dryRunFile `json:"files"`
+	// Missing is the list of files which do not exist
+	Missing []string `json:"missing"`
+	// FilesToOpen is the number of files which would be downloaded
+	FilesToOpen int `json:"filesToOpen"`
+	// CompressedBytes is the total size of files which would be downloaded
+	CompressedBytes int64 `json:"compressedBytes"`
+	// EstimatedScanned is the estimated bytes to be scanned, the actual bytes could be less as the scan stops at the last target
+	EstimatedScanned int64 `json:"estimatedScanned"`
+	// EstimatedQuota is the quota which would be used for `EstimatedScanned`
+	EstimatedQuota int64 `json:"estimatedQuota"`
+}
+
+// DryRun lists dataset files which would be read for `param` without downloading them and returns the estimated cost in JSON.
+// Checkpoints are not considered, so the estimate is the upper bound.
+func DryRun(ctx context.Context, param SnapshotParameter) ([]byte, error) {
+	sizer, err := objectSizerFor(param)
+	if err != nil {
+		return nil, err
+	}
+	param.StartMinute = defaultStartMinute(param)
+	keys, err := planKeys(ctx, param)
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := sizer(ctx, keys)
+	if err != nil {
+		return nil, newSnapshotError(ErrStorage, err)
+	}
+	result := dryRunResult{Files: make([]dryRunFile, len(keys)), Missing: []string{}}
+	for i, key := range keys {
+		result.Files[i] = dryRunFile{Name: key, Size: sizes[i]}
+		if sizes[i] < 0 {
+			result.Missing = append(result.Missing, key)
+			continue
+		}
+		result.FilesToOpen++
+		result.CompressedBytes += sizes[i]
+	}
+	result.EstimatedScanned = result.CompressedBytes * estimatedCompressionRatio
+	result.EstimatedQuota = streamcommons.CalcQuotaUsed(result.EstimatedScanned)
+	return json.Marshal(result)
+}
+
+// objectSizerFor returns objectSizer of the location dataset files are read from for `param`.
+func objectSizerFor(param SnapshotParameter) (objectSizer, error) {
+	if DatasetDirectory != "" {
+		return dirSizes(DatasetDirectory), nil
+	}
+	if GCSBucket != "" {
+		return gcsSizes(GCSBucket), nil
+	}
+	bucket := param.DatasetBucket
+	if bucket == "" {
+		bucket = DefaultDatasetBucket
+	}
+	if bucket == "" {
+		return nil, newSnapshotError(ErrBadParameter, errors.New("dry-run is not available for the default dataset location"))
+	}
+	return s3Sizes(bucket, datasetRoleOf(param)), nil
+}
+
+func dirSizes(dir string) objectSizer {
+	return func(ctx context.Context, keys []string) ([]int64, error) {
+		sizes := make([]int64, len(keys))
+		for i, key := range keys {
+			info, err := os.Stat(filepath.Join(dir, key))
+			if os.IsNotExist(err) {
+				sizes[i] = -1
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			sizes[i] = info.Size()
+		}
+		return sizes, nil
+	}
+}
+
+func gcsSizes(bucket string) objectSizer {
+	return func(ctx context.Context, keys []string) ([]int64, error) {
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer client.Close()
+		bkt := client.Bucket(bucket)
+		sizes := make([]int64, len(keys))
+		for i, key := range keys {
+			attrs, err := bkt.Object(key).Attrs(ctx)
+			if err == storage.ErrObjectNotExist {
+				sizes[i] = -1
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			sizes[i] = attrs.Size
+		}
+		return sizes, nil
+	}
+}
+
+func s3Sizes(bucket string, role string) objectSizer {
+	return func(ctx context.Context, keys []string) ([]int64, error) {
+		sess, err := datasetSession(role)
+		if err != nil {
+			return nil, err
+		}
+		client := s3.New(sess)
+		sizes := make([]int64, len(keys))
+		for i, key := range keys {
+			out, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+			})
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+				// HEAD does not have the body to tell NoSuchKey
+				sizes[i] = -1
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			sizes[i] = aws.Int64Value(out.ContentLength)
+		}
+		return sizes, nil
+	}
+}
@@ -0,0 +1,41 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "bitmex_26649010.gz"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	DatasetDirectory = dir
+	defer func() { DatasetDirectory = "" }()
+	param := SnapshotParameter{Exchange: "bitmex", Compression: "gzip", Nanosecs: []int64{26649011 * 60 * 1000000000}}
+	encoded, err := DryRun(context.Background(), param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result dryRunResult
+	if err := json.Unmarshal(encoded, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 2 || result.FilesToOpen != 1 || result.CompressedBytes != 100 {
+		t.Fatalf("unexpected result: %s", encoded)
+	}
+	if len(result.Missing) != 1 || result.Missing[0] != "bitmex_26649011.gz" {
+		t.Fatalf("expected the missing file to be listed: %s", encoded)
+	}
+	if result.EstimatedScanned != 100*estimatedCompressionRatio {
+		t.Fatalf("unexpected estimate: %d", result.EstimatedScanned)
+	}
+}
@@ -0,0 +1,83 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// EMFNamespace is the CloudWatch namespace of metrics emitted in Embedded Metric Format, disabled if empty.
+// Lambda sends records printed to stdout to CloudWatch Logs, which extracts metrics from them.
+var EMFNamespace = os.Getenv("EMF_NAMESPACE")
+
+// emfOutput is where EMF records are written.
+var emfOutput io.Writer = os.Stdout
+
+// emfMetric is the definition of a metric in EMF record.
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+// emfDirective tells CloudWatch which members of the record are metrics.
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// emfMetrics is the list of metrics in records of snapshots.
+var emfMetrics = []emfMetric{
+	{Name: "ScannedBytes", Unit: "Bytes"},
+	{Name: "FilesRead", Unit: "Count"},
+	{Name: "SimulatorTime", Unit: "Milliseconds"},
+	{Name: "Latency", Unit: "Milliseconds"},
+	{Name: "CacheHits", Unit: "Count"},
+	{Name: "CacheMisses", Unit: "Count"},
+	{Name: "Errors", Unit: "Count"},
+}
+
+// emfRecord makes the EMF record of a snapshot of `exchange` at `now`.
+func emfRecord(namespace string, now time.Time, exchange string, report Report, elapsed time.Duration, err error) map[string]interface{} {
+	failed := 0
+	if err != nil {
+		failed = 1
+	}
+	return map[string]interface{}{
+		"_aws": emfMetadata{
+			Timestamp: now.UnixNano() / int64(time.Millisecond),
+			CloudWatchMetrics: []emfDirective{{
+				Namespace:  namespace,
+				Dimensions: [][]string{{"Exchange"}},
+				Metrics:    emfMetrics,
+			}},
+		},
+		"Exchange":      exchange,
+		"ScannedBytes":  report.Scanned,
+		"FilesRead":     report.FilesRead,
+		"SimulatorTime": float64(report.ProcessTime) / float64(time.Millisecond),
+		"Latency":       float64(elapsed) / float64(time.Millisecond),
+		"CacheHits":     report.CacheHits,
+		"CacheMisses":   report.CacheMisses,
+		"Errors":        failed,
+	}
+}
+
+// emitEMF writes the EMF record of a snapshot if it is enabled.
+func emitEMF(exchange string, report Report, elapsed time.Duration, err error) {
+	if EMFNamespace == "" {
+		return
+	}
+	line, serr := json.Marshal(emfRecord(EMFNamespace, time.Now(), exchange, report, elapsed, err))
+	if serr != nil {
+		Logger.Warn("could not make EMF record", "error", serr)
+		return
+	}
+	emfOutput.Write(append(line, '\n'))
+}
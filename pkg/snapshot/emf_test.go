@@ -0,0 +1,51 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEmitEMF(t *testing.T) {
+	buf := new(bytes.Buffer)
+	output := emfOutput
+	emfOutput = buf
+	EMFNamespace = "test"
+	defer func() {
+		EMFNamespace = ""
+		emfOutput = output
+	}()
+	emitEMF("bitmex", Report{Scanned: 100, FilesRead: 2, ProcessTime: 3 * time.Millisecond, CacheHits: 1, CacheMisses: 1}, 5*time.Millisecond, nil)
+	var record struct {
+		AWS struct {
+			CloudWatchMetrics []struct {
+				Namespace  string
+				Dimensions [][]string
+				Metrics    []struct{ Name string }
+			}
+		} `json:"_aws"`
+		Exchange      string
+		ScannedBytes  int64
+		SimulatorTime float64
+		Latency       float64
+		CacheHits     int
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if len(record.AWS.CloudWatchMetrics) != 1 || record.AWS.CloudWatchMetrics[0].Namespace != "test" {
+		t.Fatalf("unexpected directive: %s", buf.String())
+	}
+	if record.Exchange != "bitmex" || record.ScannedBytes != 100 || record.SimulatorTime != 3 || record.Latency != 5 || record.CacheHits != 1 {
+		t.Fatalf("unexpected record: %s", buf.String())
+	}
+	// every metric in the directive must be in the record
+	var members map[string]interface{}
+	json.Unmarshal(buf.Bytes(), &members)
+	for _, metric := range record.AWS.CloudWatchMetrics[0].Metrics {
+		if _, ok := members[metric.Name]; !ok {
+			t.Errorf("metric %s is not in the record", metric.Name)
+		}
+	}
+}
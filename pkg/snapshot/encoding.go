@@ -0,0 +1,112 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// content encodings responses can be compressed in
+const (
+	// EncodingGzip compresses responses in gzip
+	EncodingGzip = "gzip"
+	// EncodingDeflate compresses responses in zlib format, which is what `deflate` means in HTTP
+	EncodingDeflate = "deflate"
+	// EncodingIdentity leaves responses as they are even if the client accepts compression
+	EncodingIdentity = "identity"
+)
+
+// negotiateEncoding returns the encoding the client prefers in `accept`, the value of `Accept-Encoding`,
+// or empty if it accepts neither gzip nor deflate. gzip is preferred over deflate of the same quality.
+func negotiateEncoding(accept string) string {
+	best := ""
+	bestQuality := 0.0
+	for _, coding := range strings.Split(accept, ",") {
+		name, quality := strings.TrimSpace(coding), 1.0
+		if i := strings.IndexByte(name, ';'); i >= 0 {
+			param := strings.TrimSpace(name[i+1:])
+			name = strings.TrimSpace(name[:i])
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					continue
+				}
+				quality = q
+			}
+		}
+		name = strings.ToLower(name)
+		if name == "*" {
+			name = EncodingGzip
+		}
+		if name != EncodingGzip && name != EncodingDeflate {
+			continue
+		}
+		if quality > bestQuality || (quality == bestQuality && name == EncodingGzip) {
+			best, bestQuality = name, quality
+		}
+	}
+	return best
+}
+
+// encodingWriter compresses what is written to it, the compressed stream is only started at the first write.
+type encodingWriter struct {
+	compressor io.WriteCloser
+	written    bool
+}
+
+func (e *encodingWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		e.written = true
+	}
+	return e.compressor.Write(p)
+}
+
+// Close writes the rest of the compressed stream, nothing is written if nothing was written to it.
+func (e *encodingWriter) Close() error {
+	if !e.written {
+		return nil
+	}
+	return e.compressor.Close()
+}
+
+// nopWriteCloser writes to the writer as it is.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// NewEncodingWriter returns the writer compressing what is written to `w` in `encoding`, it has to be closed at the end.
+// Nothing is written to `w` if nothing is written to it, so that empty results stay empty.
+// What is written is passed as it is if `encoding` is empty or identity.
+func NewEncodingWriter(w io.Writer, encoding string) io.WriteCloser {
+	switch encoding {
+	case EncodingGzip:
+		return &encodingWriter{compressor: gzip.NewWriter(w)}
+	case EncodingDeflate:
+		return &encodingWriter{compressor: zlib.NewWriter(w)}
+	default:
+		return nopWriteCloser{w}
+	}
+}
+
+// EncodeResult returns `result` compressed in `encoding`, empty result is returned as it is.
+func EncodeResult(result []byte, encoding string) ([]byte, error) {
+	if len(result) == 0 || encoding == "" || encoding == EncodingIdentity {
+		return result, nil
+	}
+	buffer := new(bytes.Buffer)
+	writer := NewEncodingWriter(buffer, encoding)
+	if _, err := writer.Write(result); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
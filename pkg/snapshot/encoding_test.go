@@ -0,0 +1,89 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for accept, expected := range map[string]string{
+		"":                            "",
+		"gzip":                        EncodingGzip,
+		"deflate, gzip":               EncodingGzip,
+		"deflate":                     EncodingDeflate,
+		"gzip;q=0.5, deflate":         EncodingDeflate,
+		"gzip;q=0, deflate;q=0":       "",
+		"br, *":                       EncodingGzip,
+		"identity":                    "",
+		"GZIP ; q=0.8, deflate;q=0.2": EncodingGzip,
+	} {
+		if encoding := negotiateEncoding(accept); encoding != expected {
+			t.Errorf("%q: expected %q, got %q", accept, expected, encoding)
+		}
+	}
+}
+
+func TestEncodeResult(t *testing.T) {
+	result := bytes.Repeat([]byte("1598941025000000000\torderBookL2\t{\"price\":11000}\n"), 100)
+	for encoding, decompress := range map[string]func(io.Reader) (io.Reader, error){
+		EncodingGzip:    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		EncodingDeflate: func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	} {
+		encoded, err := EncodeResult(result, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(encoded) >= len(result)/5 {
+			t.Errorf("%s: expected to be compressed, got %d bytes of %d", encoding, len(encoded), len(result))
+		}
+		reader, err := decompress(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, result) {
+			t.Errorf("%s: expected the result after decompression", encoding)
+		}
+	}
+	if encoded, err := EncodeResult(result, ""); err != nil || !bytes.Equal(encoded, result) {
+		t.Error("expected the result as it is without encoding")
+	}
+}
+
+func TestEncodingWriterEmpty(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer := NewEncodingWriter(buffer, EncodingGzip)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.Len() != 0 {
+		t.Errorf("expected nothing to be written, got %d bytes", buffer.Len())
+	}
+}
+
+func TestMakeParameterEncoding(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.Headers = map[string]string{"accept-encoding": "gzip, deflate, br"}
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.Encoding != EncodingGzip {
+		t.Errorf("expected gzip accepted by the client, got %q", param.Encoding)
+	}
+	event.QueryStringParameters["encoding"] = EncodingIdentity
+	if param, err = ParseParameter(event); err != nil || param.Encoding != "" {
+		t.Errorf("expected not to be encoded, got %q %v", param.Encoding, err)
+	}
+	event.QueryStringParameters["encoding"] = "br"
+	if _, err = ParseParameter(event); err == nil {
+		t.Error("expected error for unsupported encoding")
+	}
+}
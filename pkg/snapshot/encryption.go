@@ -0,0 +1,102 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3crypto"
+)
+
+// errNotEncrypted means a dataset object is not encrypted as the request requires, it is not read.
+var errNotEncrypted = errors.New("object is not encrypted with the required key")
+
+// datasetEncryption is how objects in the dataset bucket of the request are encrypted.
+type datasetEncryption struct {
+	// kmsKeyARN is the ARN of the KMS key objects have to be encrypted with by SSE-KMS, not checked if empty.
+	// S3 decrypts them for the role reading them, so the key is only compared.
+	kmsKeyARN string
+	// clientSide is true if objects are encrypted by the S3 encryption client with envelope keys wrapped by KMS
+	clientSide bool
+}
+
+func encryptionOf(param SnapshotParameter) datasetEncryption {
+	return datasetEncryption{kmsKeyARN: param.DatasetKMSKeyARN, clientSide: param.DatasetClientSideEncryption}
+}
+
+// encrypted returns true if objects are required to be encrypted in any way.
+func (e datasetEncryption) encrypted() bool {
+	return e.kmsKeyARN != "" || e.clientSide
+}
+
+// isKMSKeyARN returns true if `arn` is of a KMS key, aliases are not accepted as S3 reports the ARN of the key itself.
+func isKMSKeyARN(arn string) bool {
+	parts := strings.SplitN(arn, ":", 6)
+	return len(parts) == 6 && parts[0] == "arn" && parts[2] == "kms" && strings.HasPrefix(parts[5], "key/")
+}
+
+// checkObject returns the error if the object `key` having `sse` and `keyID` of the response is not encrypted
+// with the required KMS key.
+func (e datasetEncryption) checkObject(key string, sse *string, keyID *string) error {
+	if e.kmsKeyARN == "" {
+		return nil
+	}
+	if aws.StringValue(sse) != s3.ServerSideEncryptionAwsKms || aws.StringValue(keyID) != e.kmsKeyARN {
+		return fmt.Errorf("%w: %s is encrypted with '%s' by '%s'", errNotEncrypted, key, aws.StringValue(keyID), aws.StringValue(sse))
+	}
+	return nil
+}
+
+// objectGetter gets objects from S3, which is either the S3 client or the decryption client.
+type objectGetter interface {
+	GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+}
+
+// newObjectGetter returns the client getting objects encrypted as `e`. Objects encrypted on the client side are
+// decrypted by the S3 encryption client, which supports AES/GCM content keys wrapped by KMS with context.
+func (e datasetEncryption) newObjectGetter(sess *session.Session) (objectGetter, error) {
+	if !e.clientSide {
+		return s3.New(sess), nil
+	}
+	registry := s3crypto.NewCryptoRegistry()
+	if err := s3crypto.RegisterAESGCMContentCipher(registry); err != nil {
+		return nil, err
+	}
+	// keys of envelopes tell which CMK they are wrapped by
+	if err := s3crypto.RegisterKMSContextWrapWithAnyCMK(registry, kms.New(sess)); err != nil {
+		return nil, err
+	}
+	return s3crypto.NewDecryptionClientV2(sess, registry)
+}
+
+// failedReader is the body of an object which could not be read, every read fails with the error of the storage.
+type failedReader struct {
+	err error
+}
+
+func (r failedReader) Read(p []byte) (int, error) {
+	return 0, newSnapshotError(ErrStorage, r.err)
+}
+
+func (r failedReader) Close() error {
+	return nil
+}
+
+// checkedGetObject gets the object with `client`, it fails if the object is not encrypted as `e`.
+func checkedGetObject(ctx context.Context, client objectGetter, e datasetEncryption, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	obj, err := client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkObject(aws.StringValue(input.Key), obj.ServerSideEncryption, obj.SSEKMSKeyId); err != nil {
+		obj.Body.Close()
+		return nil, err
+	}
+	return obj, nil
+}
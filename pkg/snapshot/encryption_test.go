@@ -0,0 +1,70 @@
+package snapshot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const testKMSKeyARN = "arn:aws:kms:ap-northeast-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+func TestIsKMSKeyARN(t *testing.T) {
+	for _, c := range []struct {
+		arn      string
+		expected bool
+	}{
+		{testKMSKeyARN, true},
+		{"arn:aws:kms:ap-northeast-1:123456789012:alias/dataset", false},
+		{"arn:aws:s3:::dataset", false},
+		{"1234abcd-12ab-34cd-56ef-1234567890ab", false},
+	} {
+		if actual := isKMSKeyARN(c.arn); actual != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.arn, c.expected, actual)
+		}
+	}
+}
+
+func TestCheckObjectEncryption(t *testing.T) {
+	encryption := datasetEncryption{kmsKeyARN: testKMSKeyARN}
+	if err := encryption.checkObject("bitmex_26649017.gz", aws.String("aws:kms"), aws.String(testKMSKeyARN)); err != nil {
+		t.Errorf("expected the object encrypted with the key to be read, got %v", err)
+	}
+	for _, c := range []struct {
+		sse   *string
+		keyID *string
+	}{
+		{nil, nil},
+		{aws.String("AES256"), nil},
+		{aws.String("aws:kms"), aws.String("arn:aws:kms:ap-northeast-1:123456789012:key/other")},
+	} {
+		if err := encryption.checkObject("bitmex_26649017.gz", c.sse, c.keyID); !errors.Is(err, errNotEncrypted) {
+			t.Errorf("expected the object encrypted by %s with %s to be refused, got %v", aws.StringValue(c.sse), aws.StringValue(c.keyID), err)
+		}
+	}
+	if err := (datasetEncryption{}).checkObject("bitmex_26649017.gz", nil, nil); err != nil {
+		t.Errorf("expected objects not to be checked without the key, got %v", err)
+	}
+}
+
+func TestMakeParameterDatasetEncryption(t *testing.T) {
+	defer func(buckets []string) { AllowedDatasetBuckets = buckets }(AllowedDatasetBuckets)
+	AllowedDatasetBuckets = []string{"mirror"}
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.QueryStringParameters["datasetKmsKeyArn"] = testKMSKeyARN
+	if _, err := ParseParameter(event); err == nil {
+		t.Fatal("expected the key to be rejected without the dataset bucket")
+	}
+	event.QueryStringParameters["datasetBucket"] = "mirror"
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.DatasetKMSKeyARN != testKMSKeyARN {
+		t.Errorf("expected the key, got '%s'", param.DatasetKMSKeyARN)
+	}
+	event.QueryStringParameters["datasetKmsKeyArn"] = "alias/dataset"
+	if _, err := ParseParameter(event); err == nil {
+		t.Fatal("expected the alias to be rejected")
+	}
+}
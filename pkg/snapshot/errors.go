@@ -0,0 +1,82 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// kinds of errors of snapshot, use `errors.Is` to tell the kind of the returned error
+var (
+	// ErrBadParameter means the request can not be fulfilled as requested, such as unknown channels
+	ErrBadParameter = errors.New("bad parameter")
+	// ErrDatasetGap means dataset files are broken or truncated so that they can not be read through
+	ErrDatasetGap = errors.New("dataset gap")
+	// ErrSimulator means the simulator or the formatter failed to process dataset
+	ErrSimulator = errors.New("simulator error")
+	// ErrStorage means dataset files could not be fetched from the storage
+	ErrStorage = errors.New("storage error")
+	// ErrScanLimit means more bytes than the limit of the request would have to be scanned
+	ErrScanLimit = errors.New("scan limit exceeded")
+	// ErrInsufficientHistory means the simulator was not started within the lookback window before the target
+	ErrInsufficientHistory = errors.New("insufficient history within lookback")
+	// ErrBookReset means the connection started again within lookback before the target, so the book might be incomplete
+	ErrBookReset = errors.New("book reset within lookback")
+	// ErrOverloaded means too many snapshots are being taken and waiting in this process to take another
+	ErrOverloaded = errors.New("overloaded")
+)
+
+// SnapshotError is an error of snapshot with the context where it occurred.
+type SnapshotError struct {
+	// Kind is one of the kinds of errors such as `ErrBadParameter`
+	Kind error
+	// File is the name of the dataset file being read, or empty if it is not of a file
+	File string
+	// FileIndex is the index of `File` in files read in the request
+	FileIndex int
+	// Offset is the offset in decompressed `File` around which it occurred
+	Offset int64
+	Err    error
+}
+
+func newSnapshotError(kind error, err error) *SnapshotError {
+	return &SnapshotError{Kind: kind, Err: err}
+}
+
+func (e *SnapshotError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%v: file %s (#%d) at offset %d: %v", e.Kind, e.File, e.FileIndex, e.Offset, e.Err)
+}
+
+// Is reports whether `target` is the kind of this error.
+func (e *SnapshotError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *SnapshotError) Unwrap() error {
+	return e.Err
+}
+
+// asSnapshotError returns `err` as SnapshotError, errors not having kind are considered to be `kind`.
+func asSnapshotError(err error, kind error) *SnapshotError {
+	var serr *SnapshotError
+	if errors.As(err, &serr) {
+		return serr
+	}
+	return newSnapshotError(kind, err)
+}
+
+// storageReader is reader marking errors reading from it as `ErrStorage`.
+type storageReader struct {
+	io.ReadCloser
+}
+
+func (r storageReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = newSnapshotError(ErrStorage, err)
+	}
+	return
+}
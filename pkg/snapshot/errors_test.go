@@ -0,0 +1,41 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+func TestSnapshotErrorKind(t *testing.T) {
+	err := fmt.Errorf("bitmex: %w", &SnapshotError{Kind: ErrDatasetGap, File: "bitmex_1", FileIndex: 2, Offset: 10, Err: errors.New("broken")})
+	if !errors.Is(err, ErrDatasetGap) || errors.Is(err, ErrStorage) {
+		t.Fatalf("unexpected kind: %v", err)
+	}
+	var serr *SnapshotError
+	if !errors.As(err, &serr) || serr.FileIndex != 2 {
+		t.Fatalf("expected context to be kept: %v", err)
+	}
+	if asSnapshotError(err, ErrSimulator) != serr {
+		t.Fatal("expected kind not to be overwritten")
+	}
+}
+
+func TestFeedErrorKind(t *testing.T) {
+	var f Feeder
+	f.Targets = []int64{1000}
+	_, _, err := Feed(context.Background(), ioutil.NopCloser(strings.NewReader("msg\tnot a timestamp\tchannel\t{}\n")), &f)
+	if err == nil || errors.Is(err, ErrSimulator) {
+		t.Fatalf("expected error not to be of the simulator: %v", err)
+	}
+	var sim simulator.Simulator = &failingSimulator{failing: "broken"}
+	f = Feeder{Sim: &sim, Targets: []int64{1000}}
+	_, _, err = Feed(context.Background(), ioutil.NopCloser(strings.NewReader("msg\t100\tbroken\t{}\n")), &f)
+	if !errors.Is(err, ErrSimulator) {
+		t.Fatalf("expected error of the simulator: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ETag returns the entity tag of `result`, the quoted hash of its content which is the same for the same snapshot.
+func ETag(result []byte) string {
+	sum := sha256.Sum256(result)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// pageETag returns the entity tag of the page at `pageToken` of the result identified by the result cache key `key`,
+// split into pages of `pageBytes`. `pageToken` is empty for the first page.
+func pageETag(key string, pageBytes int64, pageToken string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%d\n%s", key, pageBytes, pageToken)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// matchesETag returns true if `etag` is in `ifNoneMatch`, a list of entity tags separated by comma as If-None-Match header.
+// Weak tags are compared as strong ones as the hash is of the content.
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// headerValue returns the value of the header `name` of `event`, names are case-insensitive.
+func headerValue(event events.APIGatewayProxyRequest, name string) string {
+	if value, ok := event.Headers[name]; ok {
+		return value
+	}
+	for header, value := range event.Headers {
+		if strings.EqualFold(header, name) {
+			return value
+		}
+	}
+	return ""
+}
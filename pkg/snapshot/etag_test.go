@@ -0,0 +1,60 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestETag(t *testing.T) {
+	etag := ETag([]byte("snapshot"))
+	if etag != ETag([]byte("snapshot")) || etag == ETag([]byte("snapshot2")) {
+		t.Error("expected the same tag only for the same content")
+	}
+	for ifNoneMatch, expected := range map[string]bool{
+		etag:                  true,
+		"W/" + etag:           true,
+		`"other", ` + etag:    true,
+		"*":                   true,
+		`"other"`:             false,
+		etag[1 : len(etag)-1]: false,
+	} {
+		if matchesETag(ifNoneMatch, etag) != expected {
+			t.Errorf("%s: expected %t", ifNoneMatch, expected)
+		}
+	}
+}
+
+func TestTakeIfNoneMatch(t *testing.T) {
+	defer registerFixture()()
+	defer func(dir string) { DatasetDirectory = dir }(DatasetDirectory)
+	DatasetDirectory = goldenDir
+	param := SnapshotParameter{
+		Exchange:    fixtureExchange,
+		Nanosecs:    []int64{fixtureAt(80 * time.Second)},
+		Channels:    []string{"book", "ticker"},
+		Format:      "raw",
+		Output:      OutputTSV,
+		Compression: "gzip",
+	}
+	result, report, err := Take(context.Background(), param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, goldenFile(t, "raw_across_files")) || report.ETag != ETag(result) || report.NotModified {
+		t.Fatalf("unexpected result with tag %s", report.ETag)
+	}
+	param.IfNoneMatch = report.ETag
+	result, notModified, err := Take(context.Background(), param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != nil || !notModified.NotModified || notModified.ETag != report.ETag {
+		t.Errorf("expected not modified, got %d bytes %+v", len(result), notModified)
+	}
+	param.IfNoneMatch = `"stale"`
+	if result, _, err := Take(context.Background(), param); err != nil || len(result) == 0 {
+		t.Errorf("expected the result for the stale tag, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// exportLocation is the response of the snapshot written to S3.
+type exportLocation struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Size   int    `json:"size"`
+}
+
+// exportResult writes `result` to `key` in `bucket` and returns the location of it as JSON.
+func exportResult(bucket string, key string, result []byte) (location []byte, err error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return
+	}
+	_, err = s3.New(sess).PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(result),
+	})
+	if err != nil {
+		return
+	}
+	return json.Marshal(exportLocation{Bucket: bucket, Key: key, Size: len(result)})
+}
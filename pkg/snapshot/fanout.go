@@ -0,0 +1,134 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// symbolPlaceholder is replaced by the normalized symbol in `destination` of requests fanned out by symbols.
+const symbolPlaceholder = "{symbol}"
+
+// validateFanOut returns the error if `param` fanned out by symbols has parameters which depend on the simulation of each pass.
+func validateFanOut(param SnapshotParameter) error {
+	if !param.FanOut {
+		return nil
+	}
+	if len(param.Symbols) == 0 {
+		return errors.New("'fanOut' needs 'symbols'")
+	}
+	seen := make(map[string]bool)
+	for _, symbol := range param.Symbols {
+		normalized := normalizeSymbol(symbol)
+		if normalized == "" || seen[normalized] {
+			return errors.New("'symbols' must not be empty nor repeated with 'fanOut'")
+		}
+		seen[normalized] = true
+	}
+	if len(param.Formats) > 1 || len(param.Exchanges) > 0 || param.Output == OutputParquet {
+		return errors.New("'fanOut' can not be used with multiple formats, 'exchanges' and parquet output")
+	}
+	if hasPattern(param.Channels) || param.IsolateErrors || param.Verify || param.Audit || param.AsOf || param.Stats || param.CrossValidate {
+		return errors.New("'fanOut' can not be used with channel patterns, 'isolateErrors', 'verify', 'audit', 'asOf', 'stats' and 'crossValidate'")
+	}
+	if param.ReplayUntil != 0 || param.ExportState || param.MissingChannels == MissingChannelsError || param.Reconnects == ReconnectsFlag || param.Resumable {
+		return errors.New("'fanOut' can not be used with 'replayUntil', 'exportState', 'missingChannels=error', 'reconnects=flag' and 'resumable'")
+	}
+	return nil
+}
+
+// symbolOutput opens where snapshots of `symbol` are written, `finish` is called with the result after they are written.
+type symbolOutput func(symbol string) (w io.Writer, finish func(report Report, err error) error, err error)
+
+// snapshotSymbols writes snapshots of each of `param.Symbols` to outputs opened by `open`, scanning dataset only once.
+// Snapshots of all symbols are recorded while dataset is simulated, and formatted again for each symbol.
+// Channels of which symbol is not known from its name are written for every symbol.
+func snapshotSymbols(ctx context.Context, param SnapshotParameter, source DatasetSource, open symbolOutput) (report Report, err error) {
+	record := new(snapshotRecord)
+	scan := param
+	scan.FanOut = false
+	scan.record = record
+	// the scan only records snapshots
+	report, err = SnapshotTo(ctx, scan, source, ioutil.Discard)
+	if err != nil {
+		return
+	}
+	for _, symbol := range param.Symbols {
+		pass := param
+		pass.FanOut = false
+		pass.Symbols = []string{symbol}
+		// the state and lookback were already applied in the scan
+		pass.replay = &snapshotRecord{snapshots: record.snapshots}
+		pass.State = nil
+		pass.StateNanosec = 0
+		pass.MaxLookbackMinutes = 0
+		w, finish, serr := open(symbol)
+		if serr != nil {
+			return report, serr
+		}
+		_, serr = SnapshotTo(ctx, pass, emptySource{}, w)
+		if serr = finish(report, serr); serr != nil {
+			return report, serr
+		}
+	}
+	return
+}
+
+// snapshotSymbolParts returns snapshots of each of `param.Symbols` as parts of a multipart response.
+// `report.ContentType` is set to the content type with the boundary of parts.
+func snapshotSymbolParts(ctx context.Context, param SnapshotParameter, source DatasetSource) (result []byte, report Report, err error) {
+	buffer := new(bytes.Buffer)
+	parts := multipart.NewWriter(buffer)
+	report, err = snapshotSymbols(ctx, param, source, func(symbol string) (io.Writer, func(Report, error) error, error) {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", ContentTypes[param.Output])
+		header.Set("X-Snapshot-Symbol", normalizeSymbol(symbol))
+		part, serr := parts.CreatePart(header)
+		return part, func(report Report, err error) error { return err }, serr
+	})
+	if err != nil {
+		return
+	}
+	if err = parts.Close(); err != nil {
+		return
+	}
+	report.ContentType = "multipart/mixed; boundary=" + parts.Boundary()
+	return buffer.Bytes(), report, nil
+}
+
+// snapshotSymbolsToDestination writes snapshots of each of `param.Symbols` to objects at `param.Destination`
+// with the normalized symbol in place of `{symbol}`, and returns locations of objects written as a JSON array.
+// `location` is nil if all snapshots are empty.
+func snapshotSymbolsToDestination(ctx context.Context, param SnapshotParameter, source DatasetSource) (location []byte, report Report, err error) {
+	var locations []destinationLocation
+	report, err = snapshotSymbols(ctx, param, source, func(symbol string) (io.Writer, func(Report, error) error, error) {
+		destination := strings.ReplaceAll(param.Destination, symbolPlaceholder, normalizeSymbol(symbol))
+		bucket, key, serr := parseDestination(destination)
+		if serr != nil {
+			return nil, nil, newSnapshotError(ErrBadParameter, serr)
+		}
+		output := newDestinationOutput(ctx, bucket, key, ContentTypes[param.Output], param.DestinationCompression)
+		return output, func(report Report, err error) error {
+			written, err := output.finish(report, err)
+			if err != nil {
+				return err
+			}
+			if written != nil {
+				written.Symbol = normalizeSymbol(symbol)
+				locations = append(locations, *written)
+			}
+			return nil
+		}, nil
+	})
+	if err != nil || len(locations) == 0 {
+		return
+	}
+	location, err = json.Marshal(locations)
+	return
+}
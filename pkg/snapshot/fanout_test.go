@@ -0,0 +1,138 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"testing"
+	"time"
+)
+
+func TestParseFanOut(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.QueryStringParameters["fanOut"] = "true"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected fanOut to be rejected without symbols")
+	}
+	event.MultiValueQueryStringParameters["symbols"] = []string{"XBTUSD", "xbt_usd"}
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected symbols normalized to the same to be rejected")
+	}
+	event.MultiValueQueryStringParameters["symbols"] = []string{"XBTUSD", "ETHUSD"}
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !param.FanOut || !param.MadeAtOnce() {
+		t.Errorf("expected the result to be made at once, got %+v", param)
+	}
+	event.QueryStringParameters["stats"] = "true"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected stats to be rejected with fanOut")
+	}
+	delete(event.QueryStringParameters, "stats")
+	defer func(allowed []string) { AllowedDestinationBuckets = allowed }(AllowedDestinationBuckets)
+	AllowedDestinationBuckets = []string{"etl-bucket"}
+	event.QueryStringParameters["destination"] = "s3://etl-bucket/snapshot.tsv"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected destination without the symbol to be rejected")
+	}
+	event.QueryStringParameters["destination"] = "s3://etl-bucket/{symbol}/snapshot.tsv"
+	if _, err := ParseParameter(event); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSnapshotSymbolParts(t *testing.T) {
+	defer registerFixture()()
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{fixtureAt(80 * time.Second)},
+		Channels: []string{"book", "ticker"},
+		Format:   fixtureExchange,
+		Output:   OutputTSV,
+		Symbols:  []string{"btc_usd", "ETHUSD"},
+		FanOut:   true,
+	}
+	result, report, err := snapshotSymbolParts(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FilesRead != 2 || report.Scanned == 0 {
+		t.Errorf("dataset should be read once, got %+v", report)
+	}
+	mediaType, params, err := mime.ParseMediaType(ResponseContentType(param, report))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("unexpected content type: %s %v", mediaType, err)
+	}
+	reader := multipart.NewReader(bytes.NewReader(result), params["boundary"])
+	// channels of the fixture are not of any symbol, they are written for every symbol
+	expected := goldenFile(t, "formatted_across_files")
+	for _, symbol := range []string{"BTCUSD", "ETHUSD"} {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.Header.Get("X-Snapshot-Symbol") != symbol {
+			t.Errorf("unexpected header: %v", part.Header)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, expected) {
+			t.Errorf("%s: part differs:\n%s\nexpected:\n%s", symbol, body, expected)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected only parts of symbols, got %v", err)
+	}
+}
+
+func TestSnapshotSymbolsToDestination(t *testing.T) {
+	defer registerFixture()()
+	uploads := make(map[string][]byte)
+	defer func(original func(context.Context, string, string, io.Reader, string, string) error) {
+		uploadObject = original
+	}(uploadObject)
+	uploadObject = func(ctx context.Context, bucket string, key string, body io.Reader, contentType string, contentEncoding string) error {
+		b, err := ioutil.ReadAll(body)
+		uploads[key] = b
+		return err
+	}
+	param := SnapshotParameter{
+		Exchange:    fixtureExchange,
+		Nanosecs:    []int64{fixtureAt(80 * time.Second)},
+		Channels:    []string{"book", "ticker"},
+		Format:      "raw",
+		Output:      OutputTSV,
+		Symbols:     []string{"btc_usd", "ETHUSD"},
+		FanOut:      true,
+		Destination: "s3://etl-bucket/{symbol}/snapshot.tsv",
+	}
+	location, report, err := snapshotToDestination(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var locations []destinationLocation
+	if err := json.Unmarshal(location, &locations); err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != 2 || len(uploads) != 2 {
+		t.Fatalf("expected an object of each symbol, got %+v", locations)
+	}
+	expected := goldenFile(t, "raw_across_files")
+	for i, symbol := range []string{"BTCUSD", "ETHUSD"} {
+		loc := locations[i]
+		if loc.Symbol != symbol || loc.Key != symbol+"/snapshot.tsv" || loc.Scanned != report.Scanned || loc.Timestamp != report.LastTimestamp {
+			t.Errorf("unexpected location: %+v", loc)
+		}
+		if !bytes.Equal(uploads[loc.Key], expected) {
+			t.Errorf("%s: object differs:\n%s\nexpected:\n%s", symbol, uploads[loc.Key], expected)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// filterFields removes fields other than `fields` from messages which are JSON objects and not orderbook levels,
+// such as tickers. Fields are written in the order of `fields`, and other entries such as metrics are left as they are.
+func filterFields(entries []entry, fields []string) (filtered []entry, err error) {
+	filtered = make([]entry, 0, len(entries))
+	for _, e := range entries {
+		if len(e.message) == 0 || e.message[0] != '{' || strings.HasPrefix(e.channel, metricsChannelPrefix) {
+			filtered = append(filtered, e)
+			continue
+		}
+		if _, _, _, ok := parseLevel(e.message); ok {
+			filtered = append(filtered, e)
+			continue
+		}
+		var values map[string]json.RawMessage
+		if serr := json.Unmarshal(e.message, &values); serr != nil {
+			// not a JSON object, such as a message of raw format
+			filtered = append(filtered, e)
+			continue
+		}
+		buf := new(bytes.Buffer)
+		buf.WriteByte('{')
+		for _, field := range fields {
+			value, ok := values[field]
+			if !ok {
+				continue
+			}
+			if buf.Len() > 1 {
+				buf.WriteByte(',')
+			}
+			name, serr := json.Marshal(field)
+			if serr != nil {
+				return nil, serr
+			}
+			buf.Write(name)
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+		buf.WriteByte('}')
+		e.message = buf.Bytes()
+		filtered = append(filtered, e)
+	}
+	return
+}
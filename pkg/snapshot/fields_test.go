@@ -0,0 +1,30 @@
+package snapshot
+
+import "testing"
+
+func TestFilterFields(t *testing.T) {
+	entries := []entry{
+		{channel: "ticker", message: []byte(`{"symbol":"XBTUSD","last":100.5,"volume":{"24h":1000},"funding":0.01}`)},
+		{channel: "book", message: []byte(`{"side":"Buy","price":99,"size":1,"symbol":"XBTUSD"}`)},
+		{channel: metricsChannelPrefix + "book", message: []byte(`{"mid":100}`)},
+		{channel: "raw", message: []byte(`[1,2]`)},
+	}
+	filtered, err := filterFields(entries, []string{"volume", "last", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`{"volume":{"24h":1000},"last":100.5}`,
+		`{"side":"Buy","price":99,"size":1,"symbol":"XBTUSD"}`,
+		`{"mid":100}`,
+		`[1,2]`,
+	}
+	if len(filtered) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(filtered))
+	}
+	for i := range expected {
+		if string(filtered[i].message) != expected[i] {
+			t.Errorf("entry %d: expected %s, got %s", i, expected[i], filtered[i].message)
+		}
+	}
+}
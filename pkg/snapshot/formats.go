@@ -0,0 +1,205 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// formatPlaceholder is replaced by the format in `destination` of requests of multiple formats.
+const formatPlaceholder = "{format}"
+
+// snapshotRecord is snapshots the simulator returned at each target in order.
+type snapshotRecord struct {
+	snapshots [][]simulator.Snapshot
+	next      int
+}
+
+// recordingSnapshots records snapshots taken from the simulator.
+type recordingSnapshots struct {
+	simulator.Simulator
+	record *snapshotRecord
+}
+
+func (s *recordingSnapshots) TakeSnapshot() ([]simulator.Snapshot, error) {
+	snapshots, err := s.Simulator.TakeSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	// the simulator could reuse bytes of snapshots
+	recorded := make([]simulator.Snapshot, len(snapshots))
+	for i, snapshot := range snapshots {
+		recorded[i] = simulator.Snapshot{Channel: snapshot.Channel, Snapshot: append([]byte(nil), snapshot.Snapshot...)}
+	}
+	s.record.snapshots = append(s.record.snapshots, recorded)
+	return snapshots, nil
+}
+
+// replayedSimulator returns snapshots recorded in the previous pass instead of simulating dataset.
+type replayedSimulator struct {
+	simulator.Simulator
+	record *snapshotRecord
+}
+
+func (s *replayedSimulator) TakeSnapshot() ([]simulator.Snapshot, error) {
+	if s.record.next >= len(s.record.snapshots) {
+		return nil, errors.New("no more snapshots were recorded")
+	}
+	snapshots := s.record.snapshots[s.record.next]
+	s.record.next++
+	return snapshots, nil
+}
+
+// emptySource is the source without any dataset file.
+type emptySource struct{}
+
+func (emptySource) Next() (io.ReadCloser, bool) {
+	return nil, false
+}
+
+func (emptySource) Name() string {
+	return ""
+}
+
+func (emptySource) Close() error {
+	return nil
+}
+
+// rawFormatIn returns true if `param` has raw format in any of its formats.
+func rawFormatIn(param SnapshotParameter) bool {
+	if param.Format == "raw" {
+		return true
+	}
+	for _, format := range param.Formats {
+		if format == "raw" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFormats returns the error if `param` of multiple formats has parameters which depend on the simulation of each pass.
+func validateFormats(param SnapshotParameter) error {
+	if len(param.Formats) < 2 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, format := range param.Formats {
+		if format == "" || seen[format] {
+			return errors.New("'format' must not be empty nor repeated")
+		}
+		seen[format] = true
+	}
+	if hasPattern(param.Channels) || param.IsolateErrors || param.Verify || param.Audit || param.AsOf {
+		return errors.New("multiple formats can not be used with channel patterns, 'isolateErrors', 'verify', 'audit' and 'asOf'")
+	}
+	if param.ReplayUntil != 0 || param.ExportState || param.MissingChannels == MissingChannelsError {
+		return errors.New("multiple formats can not be used with 'replayUntil', 'exportState' and 'missingChannels=error'")
+	}
+	if param.Output == OutputParquet || len(param.Exchanges) > 0 {
+		return errors.New("multiple formats can not be used with parquet output and 'exchanges'")
+	}
+	return nil
+}
+
+// formatOutput opens where snapshots in `format` are written, `finish` is called with the result after they are written.
+type formatOutput func(format string) (w io.Writer, finish func(report Report, err error) error, err error)
+
+// snapshotFormats writes snapshots in each of `param.Formats` to outputs opened by `open`, scanning dataset only once.
+// Snapshots of the first format are recorded while dataset is simulated, and formatted again for the rest.
+func snapshotFormats(ctx context.Context, param SnapshotParameter, source DatasetSource, open formatOutput) (report Report, err error) {
+	record := new(snapshotRecord)
+	for i, format := range param.Formats {
+		pass := param
+		pass.Format = format
+		pass.Formats = nil
+		passSource := source
+		if i == 0 {
+			pass.record = record
+		} else {
+			// the state and lookback were already applied in the first pass
+			pass.replay = &snapshotRecord{snapshots: record.snapshots}
+			pass.State = nil
+			pass.StateNanosec = 0
+			pass.MaxLookbackMinutes = 0
+			passSource = emptySource{}
+		}
+		w, finish, serr := open(format)
+		if serr != nil {
+			return report, serr
+		}
+		passReport, serr := SnapshotTo(ctx, pass, passSource, w)
+		if i == 0 {
+			report = passReport
+		}
+		if serr = finish(report, serr); serr != nil {
+			return report, serr
+		}
+	}
+	return
+}
+
+// snapshotParts returns snapshots in each of `param.Formats` as parts of a multipart response.
+// `report.ContentType` is set to the content type with the boundary of parts.
+func snapshotParts(ctx context.Context, param SnapshotParameter, source DatasetSource) (result []byte, report Report, err error) {
+	buffer := new(bytes.Buffer)
+	parts := multipart.NewWriter(buffer)
+	report, err = snapshotFormats(ctx, param, source, func(format string) (io.Writer, func(Report, error) error, error) {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", ContentTypes[param.Output])
+		header.Set("X-Snapshot-Format", format)
+		part, serr := parts.CreatePart(header)
+		return part, func(report Report, err error) error { return err }, serr
+	})
+	if err != nil {
+		return
+	}
+	if err = parts.Close(); err != nil {
+		return
+	}
+	report.ContentType = "multipart/mixed; boundary=" + parts.Boundary()
+	return buffer.Bytes(), report, nil
+}
+
+// snapshotFormatsToDestination writes snapshots in each of `param.Formats` to objects at `param.Destination`
+// with the format in place of `{format}`, and returns locations of objects written as a JSON array.
+// `location` is nil if all snapshots are empty.
+func snapshotFormatsToDestination(ctx context.Context, param SnapshotParameter, source DatasetSource) (location []byte, report Report, err error) {
+	var locations []destinationLocation
+	report, err = snapshotFormats(ctx, param, source, func(format string) (io.Writer, func(Report, error) error, error) {
+		destination := replaceFormat(param.Destination, format)
+		bucket, key, serr := parseDestination(destination)
+		if serr != nil {
+			return nil, nil, newSnapshotError(ErrBadParameter, serr)
+		}
+		output := newDestinationOutput(ctx, bucket, key, ContentTypes[param.Output], param.DestinationCompression)
+		return output, func(report Report, err error) error {
+			written, err := output.finish(report, err)
+			if err != nil {
+				return err
+			}
+			if written != nil {
+				written.Format = format
+				locations = append(locations, *written)
+			}
+			return nil
+		}, nil
+	})
+	if err != nil || len(locations) == 0 {
+		return
+	}
+	location, err = json.Marshal(locations)
+	return
+}
+
+// replaceFormat returns `destination` with `format` in place of `{format}`.
+func replaceFormat(destination string, format string) string {
+	return strings.ReplaceAll(destination, formatPlaceholder, format)
+}
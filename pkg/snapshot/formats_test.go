@@ -0,0 +1,160 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseFormats(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "raw,json")
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.Format != "raw" || len(param.Formats) != 2 || param.Formats[1] != "json" {
+		t.Errorf("unexpected formats: %s %v", param.Format, param.Formats)
+	}
+	event.QueryStringParameters["format"] = "json"
+	event.MultiValueQueryStringParameters["format"] = []string{"json", "raw"}
+	param, err = ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.Format != "json" || len(param.Formats) != 2 {
+		t.Errorf("unexpected formats: %s %v", param.Format, param.Formats)
+	}
+	// restrictions of raw format apply to any of formats
+	event.QueryStringParameters["depth"] = "10"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected depth to be rejected with raw format in formats")
+	}
+	delete(event.QueryStringParameters, "depth")
+	event.QueryStringParameters["verify"] = "true"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected verify to be rejected with multiple formats")
+	}
+	delete(event.QueryStringParameters, "verify")
+	event.MultiValueQueryStringParameters["format"] = []string{"json", "json"}
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected repeated format to be rejected")
+	}
+	event.MultiValueQueryStringParameters["format"] = []string{"json", "raw"}
+	defer func(allowed []string) { AllowedDestinationBuckets = allowed }(AllowedDestinationBuckets)
+	AllowedDestinationBuckets = []string{"etl-bucket"}
+	event.QueryStringParameters["destination"] = "s3://etl-bucket/snapshot.tsv"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected destination without the format to be rejected")
+	}
+	event.QueryStringParameters["destination"] = "s3://etl-bucket/snapshot.{format}.tsv"
+	if _, err := ParseParameter(event); err != nil {
+		t.Error(err)
+	}
+}
+
+// goldenFile returns the content of the golden file `name`.
+func goldenFile(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(goldenDir, name+".tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSnapshotParts(t *testing.T) {
+	defer registerFixture()()
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{fixtureAt(80 * time.Second)},
+		Channels: []string{"book", "ticker"},
+		Format:   "raw",
+		Formats:  []string{"raw", fixtureExchange},
+		Output:   OutputTSV,
+	}
+	result, report, err := snapshotParts(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FilesRead != 2 || report.Scanned == 0 {
+		t.Errorf("dataset should be read once, got %+v", report)
+	}
+	mediaType, params, err := mime.ParseMediaType(ResponseContentType(param, report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/mixed" {
+		t.Fatalf("unexpected content type: %s", mediaType)
+	}
+	reader := multipart.NewReader(bytes.NewReader(result), params["boundary"])
+	expected := map[string][]byte{"raw": goldenFile(t, "raw_across_files"), fixtureExchange: goldenFile(t, "formatted_across_files")}
+	for _, format := range param.Formats {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.Header.Get("X-Snapshot-Format") != format || part.Header.Get("Content-Type") != ContentTypes[OutputTSV] {
+			t.Errorf("unexpected header: %v", part.Header)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, expected[format]) {
+			t.Errorf("%s: part differs:\n%s\nexpected:\n%s", format, body, expected[format])
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected only parts of formats, got %v", err)
+	}
+}
+
+func TestSnapshotFormatsToDestination(t *testing.T) {
+	defer registerFixture()()
+	uploads := make(map[string][]byte)
+	defer func(original func(context.Context, string, string, io.Reader, string, string) error) {
+		uploadObject = original
+	}(uploadObject)
+	uploadObject = func(ctx context.Context, bucket string, key string, body io.Reader, contentType string, contentEncoding string) error {
+		b, err := ioutil.ReadAll(body)
+		uploads[key] = b
+		return err
+	}
+	param := SnapshotParameter{
+		Exchange:    fixtureExchange,
+		Nanosecs:    []int64{fixtureAt(80 * time.Second)},
+		Channels:    []string{"book", "ticker"},
+		Format:      fixtureExchange,
+		Formats:     []string{fixtureExchange, "raw"},
+		Output:      OutputTSV,
+		Destination: "s3://etl-bucket/snapshot.{format}.tsv",
+	}
+	location, report, err := snapshotToDestination(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var locations []destinationLocation
+	if err := json.Unmarshal(location, &locations); err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != 2 || len(uploads) != 2 {
+		t.Fatalf("expected an object of each format, got %+v", locations)
+	}
+	expected := map[string][]byte{"raw": goldenFile(t, "raw_across_files"), fixtureExchange: goldenFile(t, "formatted_across_files")}
+	for i, format := range param.Formats {
+		loc := locations[i]
+		if loc.Format != format || loc.Key != "snapshot."+format+".tsv" || loc.Scanned != report.Scanned || loc.Timestamp != report.LastTimestamp {
+			t.Errorf("unexpected location: %+v", loc)
+		}
+		if !bytes.Equal(uploads[loc.Key], expected[format]) {
+			t.Errorf("%s: object differs:\n%s\nexpected:\n%s", format, uploads[loc.Key], expected[format])
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// gcsConcurrency is the default number of objects gcsSource downloads concurrently.
+const gcsConcurrency = 5
+
+type gcsResult struct {
+	body *spillBuffer
+	// reader is the body of the last object which is fetched in chunks
+	reader io.ReadCloser
+	err    error
+}
+
+// gcsSource is DatasetSource reading objects from Google Cloud Storage.
+// Objects are prefetched concurrently in the same way S3GetConcurrent does, except the last one fetched in chunks as it is read.
+type gcsSource struct {
+	client  *storage.Client
+	keys    []string
+	i       int
+	results []chan gcsResult
+	cancel  context.CancelFunc
+}
+
+// newGCSSource makes gcsSource downloading `concurrency` objects concurrently, or `gcsConcurrency` if not positive.
+func newGCSSource(ctx context.Context, bucket string, keys []string, concurrency int) (*gcsSource, error) {
+	if concurrency <= 0 {
+		concurrency = gcsConcurrency
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s := &gcsSource{
+		client:  client,
+		keys:    keys,
+		i:       -1,
+		results: make([]chan gcsResult, len(keys)),
+		cancel:  cancel,
+	}
+	sem := make(chan struct{}, concurrency)
+	bkt := client.Bucket(bucket)
+	for i, key := range keys {
+		// buffered so that goroutines can exit even if no one receives the result
+		result := make(chan gcsResult, 1)
+		s.results[i] = result
+		last := i == len(keys)-1
+		go func(key string) {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				result <- gcsResult{err: ctx.Err()}
+				return
+			}
+			defer func() { <-sem }()
+			if last {
+				reader, err := openChunked(ctx, key, rangeChunkSize, gcsRangeFetcher(bkt.Object(key)))
+				if err != nil {
+					result <- gcsResult{err: err}
+					return
+				}
+				result <- gcsResult{reader: reader}
+				return
+			}
+			body, err := download(ctx, bkt.Object(key))
+			result <- gcsResult{body: body, err: err}
+		}(key)
+	}
+	return s, nil
+}
+
+// download downloads the whole object, retrying on transient errors.
+// Objects beyond the memory ceiling are spilled to disk.
+func download(ctx context.Context, obj *storage.ObjectHandle) (body *spillBuffer, err error) {
+	for attempt := 0; ; attempt++ {
+		body, err = downloadOnce(ctx, obj)
+		if err == nil || attempt >= maxRetries || !isTransient(err) || ctx.Err() != nil {
+			return
+		}
+		LoggerFrom(ctx).Warn("retrying to download", "object", obj.ObjectName(), "attempt", attempt, "error", err)
+		if err = backoff(ctx, attempt); err != nil {
+			return
+		}
+	}
+}
+
+func downloadOnce(ctx context.Context, obj *storage.ObjectHandle) (*spillBuffer, error) {
+	reader, err := obj.NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	body := newSpillBuffer(ceiling, 0)
+	if _, err := io.Copy(body, reader); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return body, nil
+}
+
+func (s *gcsSource) Next() (io.ReadCloser, bool) {
+	if s.i+1 >= len(s.keys) {
+		return nil, false
+	}
+	s.i++
+	result := <-s.results[s.i]
+	if errors.Is(result.err, storage.ErrObjectNotExist) {
+		return nil, true
+	}
+	if result.err != nil {
+		// the object may exist, it must not be taken as missing
+		Logger.Warn("could not download", "object", s.Name(), "error", result.err)
+		return failedReader{err: result.err}, true
+	}
+	if result.reader != nil {
+		return result.reader, true
+	}
+	body, err := result.body.Reader()
+	if err != nil {
+		Logger.Warn("could not read downloaded object", "object", s.Name(), "error", err)
+		result.body.Close()
+		return failedReader{err: err}, true
+	}
+	return body, true
+}
+
+func (s *gcsSource) Name() string {
+	if s.i < 0 || s.i >= len(s.keys) {
+		return ""
+	}
+	return s.keys[s.i]
+}
+
+func (s *gcsSource) Close() error {
+	s.cancel()
+	if s.i+1 < len(s.results) {
+		// objects not returned hold connections or files spilled to until they are closed
+		go func(results []chan gcsResult) {
+			for _, result := range results {
+				r := <-result
+				if r.reader != nil {
+					r.reader.Close()
+				}
+				if r.body != nil {
+					r.body.Close()
+				}
+			}
+		}(s.results[s.i+1:])
+		s.results = s.results[:s.i+1]
+	}
+	return s.client.Close()
+}
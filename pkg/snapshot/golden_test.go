@@ -0,0 +1,268 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/exchangedataset/streamcommons/formatter"
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// update regenerates fixtures and golden files in testdata/golden, run `go test -run TestGolden -update`
+// and review the diff when the output is meant to change.
+var update = flag.Bool("update", false, "regenerate fixtures and golden files of TestGolden")
+
+// goldenDir is the directory of fixtures and golden files.
+var goldenDir = filepath.Join("testdata", "golden")
+
+// fixtureExchange is the synthetic exchange of fixtures, simulated by fixtureSimulator.
+const fixtureExchange = "fixture"
+
+// fixtureMinute is the minute of the first fixture file.
+const fixtureMinute = 26636817
+
+// fixtureAt returns the timestamp `offset` after the beginning of the first fixture file.
+func fixtureAt(offset time.Duration) int64 {
+	return fixtureMinute*int64(time.Minute) + int64(offset)
+}
+
+// fixtureBook is a message of `book` channel of the fixture exchange, levels of size 0 are removed by updates.
+type fixtureBook struct {
+	Type string       `json:"type"`
+	Bids [][2]float64 `json:"bids"`
+	Asks [][2]float64 `json:"asks"`
+}
+
+// fixtureFiles are lines of fixture files of each minute from fixtureMinute.
+var fixtureFiles = [][]string{
+	{
+		fmt.Sprintf("start\t%d\twss://fixture.example.com/ws", fixtureAt(time.Second)),
+		fmt.Sprintf(`msg	%d	book	{"type":"snapshot","bids":[[100,1],[99,2],[98,3]],"asks":[[101,1],[102,2],[103,3]]}`, fixtureAt(2*time.Second)),
+		fmt.Sprintf(`msg	%d	ticker	{"last":100.5}`, fixtureAt(3*time.Second)),
+		fmt.Sprintf(`msg	%d	book	{"type":"update","bids":[[100,0],[99.5,1]],"asks":[]}`, fixtureAt(10*time.Second)),
+		fmt.Sprintf(`msg	%d	book	{"type":"update","bids":[],"asks":[[101,4]]}`, fixtureAt(20*time.Second)),
+		fmt.Sprintf(`msg	%d	ticker	{"last":101}`, fixtureAt(30*time.Second)),
+	},
+	{
+		fmt.Sprintf(`msg	%d	book	{"type":"update","bids":[],"asks":[[101.5,1]]}`, fixtureAt(65*time.Second)),
+		fmt.Sprintf(`msg	%d	ticker	{"last":101.5}`, fixtureAt(70*time.Second)),
+		// reconnected, everything is sent again
+		fmt.Sprintf("start\t%d\twss://fixture.example.com/ws", fixtureAt(90*time.Second)),
+		fmt.Sprintf(`msg	%d	book	{"type":"snapshot","bids":[[97,1]],"asks":[[104,1]]}`, fixtureAt(91*time.Second)),
+	},
+	{
+		fmt.Sprintf(`msg	%d	book	{"type":"update","bids":[[97.5,2]],"asks":[]}`, fixtureAt(125*time.Second)),
+		fmt.Sprintf(`msg	%d	ticker	{"last":98}`, fixtureAt(126*time.Second)),
+	},
+}
+
+// fixtureKeys returns the names of fixture files.
+func fixtureKeys() []string {
+	keys := make([]string, len(fixtureFiles))
+	for i := range fixtureFiles {
+		keys[i] = fmt.Sprintf("%s_%d.gz", fixtureExchange, fixtureMinute+i)
+	}
+	return keys
+}
+
+// generateFixtures writes fixture files to `dir`.
+func generateFixtures(dir string) error {
+	for i, key := range fixtureKeys() {
+		buf := new(bytes.Buffer)
+		// the header has no time so that files are the same every time
+		writer := gzip.NewWriter(buf)
+		for _, line := range fixtureFiles[i] {
+			fmt.Fprintln(writer, line)
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, key), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixtureSimulator simulates `book` and `ticker` channels of the fixture exchange.
+type fixtureSimulator struct {
+	simulator.Simulator
+	channels []string
+	bids     map[float64]float64
+	asks     map[float64]float64
+	ticker   []byte
+}
+
+func (s *fixtureSimulator) ProcessStart(line []byte) error {
+	s.bids, s.asks, s.ticker = nil, nil, nil
+	return nil
+}
+
+func (s *fixtureSimulator) ProcessMessageChannelKnown(channel string, line []byte) error {
+	switch channel {
+	case "book":
+		var book fixtureBook
+		if err := json.Unmarshal(line, &book); err != nil {
+			return err
+		}
+		if book.Type == "snapshot" || s.bids == nil {
+			s.bids, s.asks = make(map[float64]float64), make(map[float64]float64)
+		}
+		for side, levels := range map[*map[float64]float64][][2]float64{&s.bids: book.Bids, &s.asks: book.Asks} {
+			for _, l := range levels {
+				if l[1] == 0 {
+					delete(*side, l[0])
+				} else {
+					(*side)[l[0]] = l[1]
+				}
+			}
+		}
+	case "ticker":
+		s.ticker = append([]byte(nil), line...)
+	}
+	return nil
+}
+
+func (s *fixtureSimulator) ProcessState(channel string, line []byte) error {
+	return s.ProcessMessageChannelKnown(channel, line)
+}
+
+// sortedLevels returns levels in `side` with best prices first.
+func sortedLevels(side map[float64]float64, descending bool) [][2]float64 {
+	levels := make([][2]float64, 0, len(side))
+	for price, size := range side {
+		levels = append(levels, [2]float64{price, size})
+	}
+	sort.Slice(levels, func(i, j int) bool { return (levels[i][0] > levels[j][0]) == descending })
+	return levels
+}
+
+func (s *fixtureSimulator) TakeSnapshot() ([]simulator.Snapshot, error) {
+	var snapshots []simulator.Snapshot
+	for _, channel := range s.channels {
+		switch {
+		case channel == "book" && s.bids != nil:
+			message, err := json.Marshal(fixtureBook{Type: "snapshot", Bids: sortedLevels(s.bids, true), Asks: sortedLevels(s.asks, false)})
+			if err != nil {
+				return nil, err
+			}
+			snapshots = append(snapshots, simulator.Snapshot{Channel: channel, Snapshot: message})
+		case channel == "ticker" && s.ticker != nil:
+			snapshots = append(snapshots, simulator.Snapshot{Channel: channel, Snapshot: s.ticker})
+		}
+	}
+	return snapshots, nil
+}
+
+// fixtureFormatter formats `book` into a message per level and leaves `ticker` as it is.
+type fixtureFormatter struct{}
+
+func (fixtureFormatter) FormatMessage(channel string, line []byte) ([]formatter.Result, error) {
+	if channel != "book" {
+		return []formatter.Result{{Channel: channel, Message: line}}, nil
+	}
+	var book fixtureBook
+	if err := json.Unmarshal(line, &book); err != nil {
+		return nil, err
+	}
+	var results []formatter.Result
+	for side, levels := range [][][2]float64{book.Bids, book.Asks} {
+		name := "buy"
+		if side == 1 {
+			name = "sell"
+		}
+		for _, l := range levels {
+			message := fmt.Sprintf(`{"side":"%s","price":%s,"size":%s}`, name, strconv.FormatFloat(l[0], 'f', -1, 64), strconv.FormatFloat(l[1], 'f', -1, 64))
+			results = append(results, formatter.Result{Channel: channel, Message: []byte(message)})
+		}
+	}
+	return results, nil
+}
+
+func (fixtureFormatter) IsSupported(channel string) bool {
+	return true
+}
+
+// registerFixture registers the simulator and the formatter of the fixture exchange, the returned function unregisters them.
+func registerFixture() (unregister func()) {
+	RegisterSimulator(fixtureExchange, func(channels []string) (simulator.Simulator, error) {
+		return &fixtureSimulator{channels: channels}, nil
+	})
+	RegisterFormatter(fixtureExchange, func(exchange string, channels []string) (formatter.Formatter, error) {
+		return fixtureFormatter{}, nil
+	})
+	return func() {
+		simulatorsMu.Lock()
+		delete(simulators, fixtureExchange)
+		simulatorsMu.Unlock()
+		formattersMu.Lock()
+		delete(formatters, fixtureExchange)
+		formattersMu.Unlock()
+	}
+}
+
+func TestGolden(t *testing.T) {
+	defer registerFixture()()
+	if *update {
+		if err := generateFixtures(goldenDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		name      string
+		nanosecs  []int64
+		format    string
+		depth     int
+		exclusive bool
+	}{
+		{name: "raw_first_file", nanosecs: []int64{fixtureAt(35 * time.Second)}, format: "raw"},
+		{name: "raw_across_files", nanosecs: []int64{fixtureAt(80 * time.Second)}, format: "raw"},
+		{name: "raw_reconnected", nanosecs: []int64{fixtureAt(130 * time.Second)}, format: "raw"},
+		{name: "raw_multiple_targets", nanosecs: []int64{fixtureAt(35 * time.Second), fixtureAt(130 * time.Second)}, format: "raw"},
+		{name: "raw_inclusive", nanosecs: []int64{fixtureAt(20 * time.Second)}, format: "raw"},
+		{name: "raw_exclusive", nanosecs: []int64{fixtureAt(20 * time.Second)}, format: "raw", exclusive: true},
+		{name: "formatted_across_files", nanosecs: []int64{fixtureAt(80 * time.Second)}, format: fixtureExchange},
+		{name: "formatted_depth", nanosecs: []int64{fixtureAt(35 * time.Second)}, format: fixtureExchange, depth: 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			param := SnapshotParameter{
+				Exchange:  fixtureExchange,
+				Nanosecs:  c.nanosecs,
+				Channels:  []string{"book", "ticker"},
+				Format:    c.format,
+				Output:    OutputTSV,
+				Depth:     c.depth,
+				Exclusive: c.exclusive,
+			}
+			ret, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join(goldenDir, c.name+".tsv")
+			if *update {
+				if err := ioutil.WriteFile(golden, ret, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(ret, expected) {
+				t.Errorf("output differs from %s:\n%s\nexpected:\n%s", golden, ret, expected)
+			}
+		})
+	}
+}
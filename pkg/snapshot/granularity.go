@@ -0,0 +1,83 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// granularities of dataset files
+const (
+	// GranularityMinute is of dataset files each of which has lines of a minute
+	GranularityMinute = "minute"
+	// GranularityHour is of dataset files each of which has lines of an hour, named as `exchange_h{hour}`
+	GranularityHour = "hour"
+	// GranularityMixed is of dataset where some hours are repacked into hourly files and the others are in minute files
+	GranularityMixed = "mixed"
+)
+
+// minutesPerHour is the number of minute files an hourly file has lines of.
+const minutesPerHour = 60
+
+// minuteKey returns the name of the dataset file of `minute` since the epoch for `param`.
+func minuteKey(param SnapshotParameter, minute int64) string {
+	return fmt.Sprintf("%s%s_%d%s", param.DatasetPrefix, param.Exchange, minute, extensions[param.Compression])
+}
+
+// hourKey returns the name of the dataset file of `hour` since the epoch for `param`,
+// which has `h` before the hour so that it is not taken for a file of a minute.
+func hourKey(param SnapshotParameter, hour int64) string {
+	return fmt.Sprintf("%s%s_h%d%s", param.DatasetPrefix, param.Exchange, hour, extensions[param.Compression])
+}
+
+// hourKeys returns the names of hourly files from the hour of `param.StartMinute` to the hour of the latest target.
+// Lines before `param.StartMinute` in the first file are also read, the time filter of targets is not changed by it.
+func hourKeys(param SnapshotParameter) []string {
+	lastHour := param.Nanosecs[len(param.Nanosecs)-1] / 60 / 1000000000 / minutesPerHour
+	firstHour := param.StartMinute / minutesPerHour
+	keys := make([]string, 0, lastHour-firstHour+1)
+	for hour := firstHour; hour <= lastHour; hour++ {
+		keys = append(keys, hourKey(param, hour))
+	}
+	return keys
+}
+
+// mixedKeys returns the names of dataset files to read for `param` in mixed granularity,
+// the hourly file is read for hours `hourExists` returns true for and minute files are read for the others.
+func mixedKeys(param SnapshotParameter, hourExists func(key string) bool) []string {
+	lastMinute := param.Nanosecs[len(param.Nanosecs)-1] / 60 / 1000000000
+	var keys []string
+	for minute := param.StartMinute; minute <= lastMinute; {
+		hour := minute / minutesPerHour
+		if key := hourKey(param, hour); hourExists(key) {
+			keys = append(keys, key)
+			minute = (hour + 1) * minutesPerHour
+			continue
+		}
+		keys = append(keys, minuteKey(param, minute))
+		minute++
+	}
+	return keys
+}
+
+// planKeys returns the names of dataset files to read for `param` in its granularity,
+// hourly files are listed to know which hours are repacked in mixed granularity.
+func planKeys(ctx context.Context, param SnapshotParameter) ([]string, error) {
+	switch param.Granularity {
+	case GranularityHour:
+		return hourKeys(param), nil
+	case GranularityMixed:
+		lister := datasetLister(param)
+		if lister == nil {
+			return nil, newSnapshotError(ErrBadParameter, errors.New("mixed granularity is not available for the default dataset location"))
+		}
+		lastMinute := param.Nanosecs[len(param.Nanosecs)-1] / 60 / 1000000000
+		listed, err := lister(ctx, fmt.Sprintf("%s%s_h", param.DatasetPrefix, param.Exchange), hourKey(param, param.StartMinute/minutesPerHour), hourKey(param, lastMinute/minutesPerHour))
+		if err != nil {
+			return nil, newSnapshotError(ErrStorage, fmt.Errorf("could not list hourly files: %v", err))
+		}
+		return mixedKeys(param, func(key string) bool { return listed[key] }), nil
+	default:
+		return datasetKeys(param), nil
+	}
+}
@@ -0,0 +1,102 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHourKeys(t *testing.T) {
+	param := SnapshotParameter{Exchange: "bitmex", Compression: "gzip", Granularity: GranularityHour, StartMinute: 26636810, Nanosecs: []int64{fixtureAt(5 * time.Minute)}}
+	keys, err := planKeys(context.Background(), param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// minutes 26636810 to 26636822 are in hours 443946 and 443947
+	expected := []string{"bitmex_h443946.gz", "bitmex_h443947.gz"}
+	if fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+}
+
+func TestMixedKeys(t *testing.T) {
+	param := SnapshotParameter{Exchange: "bitmex", Compression: "gzip", StartMinute: 26636818, Nanosecs: []int64{fixtureAt(4 * time.Minute)}}
+	// the first hour is repacked into an hourly file
+	keys := mixedKeys(param, func(key string) bool { return key == "bitmex_h443946.gz" })
+	expected := []string{"bitmex_h443946.gz", "bitmex_26636820.gz", "bitmex_26636821.gz"}
+	if fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+	keys = mixedKeys(param, func(key string) bool { return false })
+	expected = []string{"bitmex_26636818.gz", "bitmex_26636819.gz", "bitmex_26636820.gz", "bitmex_26636821.gz"}
+	if fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+}
+
+// writeHourFixture writes the hourly file having lines of all fixture files to `dir`.
+func writeHourFixture(dir string) error {
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	for _, lines := range fixtureFiles {
+		for _, line := range lines {
+			fmt.Fprintln(writer, line)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	param := SnapshotParameter{Exchange: fixtureExchange, Compression: "gzip"}
+	return ioutil.WriteFile(filepath.Join(dir, hourKey(param, fixtureMinute/minutesPerHour)), buf.Bytes(), 0644)
+}
+
+func TestSnapshotHourGranularity(t *testing.T) {
+	defer registerFixture()()
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := writeHourFixture(dir); err != nil {
+		t.Fatal(err)
+	}
+	DatasetDirectory = dir
+	defer func() { DatasetDirectory = "" }()
+	expected, err := ioutil.ReadFile(filepath.Join(goldenDir, "raw_across_files.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the hourly file is found by listing in mixed granularity
+	for _, granularity := range []string{GranularityHour, GranularityMixed} {
+		param := SnapshotParameter{
+			Exchange:    fixtureExchange,
+			Nanosecs:    []int64{fixtureAt(80 * time.Second)},
+			Channels:    []string{"book", "ticker"},
+			Format:      "raw",
+			Output:      OutputTSV,
+			Compression: "gzip",
+			Granularity: granularity,
+		}
+		source, err := OpenSource(context.Background(), &param)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret, report, err := Snapshot(context.Background(), param, source)
+		source.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.FilesRead != 1 {
+			t.Errorf("%s: expected only the hourly file to be read, got %+v", granularity, report.Files)
+		}
+		if !bytes.Equal(ret, expected) {
+			t.Errorf("%s: output differs:\n%s\nexpected:\n%s", granularity, ret, expected)
+		}
+	}
+}
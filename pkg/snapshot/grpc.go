@@ -0,0 +1,248 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// field numbers of `SnapshotRequest` in proto/snapshot_service.proto
+const (
+	pbRequestExchange   = 1
+	pbRequestTime       = 2
+	pbRequestChannels   = 3
+	pbRequestFormat     = 4
+	pbRequestParameters = 5
+
+	pbMapKey   = 1
+	pbMapValue = 2
+)
+
+// rawCodec passes encoded messages as they are, messages are encoded with protowire as in protobuf output.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, errors.New("message must be encoded")
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return errors.New("message must be decoded into bytes")
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+var _ encoding.Codec = rawCodec{}
+
+// snapshotServiceDesc describes `SnapshotService` in proto/snapshot_service.proto.
+var snapshotServiceDesc = grpc.ServiceDesc{
+	ServiceName: "exchangedataset.snapshot.SnapshotService",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "GetSnapshot",
+		Handler:       getSnapshot,
+		ServerStreams: true,
+	}},
+	Metadata: "snapshot_service.proto",
+}
+
+// NewGRPCServer returns the server of `SnapshotService` reading dataset from the location configured.
+// Requests are neither authorized nor billed.
+func NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(append(opts, grpc.ForceServerCodec(rawCodec{}))...)
+	server.RegisterService(&snapshotServiceDesc, struct{}{})
+	return server
+}
+
+// decodeSnapshotRequest makes the request of the HTTP API equivalent to `SnapshotRequest` in `b`.
+func decodeSnapshotRequest(b []byte) (event events.APIGatewayProxyRequest, err error) {
+	event.PathParameters = make(map[string]string)
+	event.QueryStringParameters = map[string]string{"format": "raw"}
+	event.MultiValueQueryStringParameters = map[string][]string{"channels": nil}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return event, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return event, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return event, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch num {
+		case pbRequestExchange:
+			event.PathParameters["exchange"] = string(value)
+		case pbRequestTime:
+			event.PathParameters["nanosec"] = string(value)
+		case pbRequestChannels:
+			event.MultiValueQueryStringParameters["channels"] = append(event.MultiValueQueryStringParameters["channels"], string(value))
+		case pbRequestFormat:
+			if len(value) > 0 {
+				event.QueryStringParameters["format"] = string(value)
+			}
+		case pbRequestParameters:
+			key, val, serr := decodeMapEntry(value)
+			if serr != nil {
+				return event, serr
+			}
+			event.QueryStringParameters[key] = val
+			event.MultiValueQueryStringParameters[key] = append(event.MultiValueQueryStringParameters[key], val)
+		}
+	}
+	if _, ok := event.PathParameters["exchange"]; !ok {
+		return event, errors.New("'exchange' must be specified")
+	}
+	if _, ok := event.PathParameters["nanosec"]; !ok {
+		return event, errors.New("'time' must be specified")
+	}
+	// snapshots are sent as messages
+	event.QueryStringParameters["output"] = OutputProtobuf
+	return
+}
+
+// decodeMapEntry decodes an entry of map<string, string>.
+func decodeMapEntry(b []byte) (key string, value string, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			return "", "", errors.New("map entry must be of strings")
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == pbMapKey {
+			key = string(v)
+		} else if num == pbMapValue {
+			value = string(v)
+		}
+	}
+	return
+}
+
+// snapshotStream sends each `Snapshot` in `SnapshotResponse` written to it as a message of the stream.
+type snapshotStream struct {
+	stream grpc.ServerStream
+	buf    []byte
+	sent   bool
+}
+
+func (s *snapshotStream) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for len(s.buf) > 0 {
+		num, typ, n := protowire.ConsumeTag(s.buf)
+		if n < 0 {
+			// the tag is not complete yet
+			return len(p), nil
+		}
+		m := protowire.ConsumeFieldValue(num, typ, s.buf[n:])
+		if m < 0 {
+			return len(p), nil
+		}
+		if num == pbResponseSnapshots {
+			message, _ := protowire.ConsumeBytes(s.buf[n:])
+			if err := s.stream.SendMsg(&message); err != nil {
+				return 0, err
+			}
+			s.sent = true
+		}
+		s.buf = s.buf[n+m:]
+	}
+	return len(p), nil
+}
+
+// grpcError returns the status of `err` of snapshot, details of errors not of the request are not shown to clients.
+func grpcError(ctx context.Context, err error) error {
+	switch {
+	case ctx.Err() != nil:
+		return status.FromContextError(ctx.Err()).Err()
+	case errors.Is(err, ErrBadParameter), errors.Is(err, ErrScanLimit):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrInsufficientHistory), errors.Is(err, ErrBookReset):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrOverloaded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ErrStorage):
+		LoggerFrom(ctx).Error("snapshot failed", "error", err)
+		return status.Error(codes.Unavailable, "storage is unavailable")
+	default:
+		LoggerFrom(ctx).Error("snapshot failed", "error", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+func getSnapshot(_ interface{}, stream grpc.ServerStream) (err error) {
+	ctx := stream.Context()
+	st := time.Now()
+	var request []byte
+	if err = stream.RecvMsg(&request); err != nil {
+		return
+	}
+	event, err := decodeSnapshotRequest(request)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	param, err := ParseParameter(event)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(param.Exchanges) > 0 || param.Destination != "" {
+		return status.Error(codes.InvalidArgument, "'exchanges' and 'destination' can not be used with gRPC")
+	}
+	log := Logger.With("exchange", param.Exchange)
+	ctx = WithLogger(ctx, log)
+	if param.Latest {
+		if err = ResolveLatest(ctx, &param, time.Now()); err != nil {
+			if errors.Is(err, ErrDatasetGap) {
+				return status.Error(codes.NotFound, err.Error())
+			}
+			return grpcError(ctx, err)
+		}
+	}
+	source, err := OpenSource(ctx, &param)
+	if err != nil {
+		return grpcError(ctx, asSnapshotError(err, ErrStorage))
+	}
+	defer source.Close()
+	out := &snapshotStream{stream: stream}
+	report, err := SnapshotTo(ctx, param, source, out)
+	if err != nil {
+		return grpcError(ctx, err)
+	}
+	if !out.sent {
+		return status.Error(codes.NotFound, "no snapshot")
+	}
+	log.Info("snapshot end", "scanned", report.Scanned, "elapsed", time.Now().Sub(st))
+	return nil
+}
@@ -0,0 +1,127 @@
+package snapshot
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestDecodeSnapshotRequest(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, pbRequestExchange, protowire.BytesType)
+	b = protowire.AppendString(b, "bitmex")
+	b = protowire.AppendTag(b, pbRequestTime, protowire.BytesType)
+	b = protowire.AppendString(b, "2020-09-01T06:17:05Z")
+	b = protowire.AppendTag(b, pbRequestChannels, protowire.BytesType)
+	b = protowire.AppendString(b, "orderBookL2")
+	b = protowire.AppendTag(b, pbRequestFormat, protowire.BytesType)
+	b = protowire.AppendString(b, "json")
+	var entry []byte
+	entry = protowire.AppendTag(entry, pbMapKey, protowire.BytesType)
+	entry = protowire.AppendString(entry, "depth")
+	entry = protowire.AppendTag(entry, pbMapValue, protowire.BytesType)
+	entry = protowire.AppendString(entry, "10")
+	b = protowire.AppendTag(b, pbRequestParameters, protowire.BytesType)
+	b = protowire.AppendBytes(b, entry)
+	event, err := decodeSnapshotRequest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.Exchange != "bitmex" || param.Nanosecs[0] != 1598941025000000000 || param.Channels[0] != "orderBookL2" {
+		t.Errorf("unexpected parameter: %+v", param)
+	}
+	if param.Depth != 10 || param.Format != "json" || param.Output != OutputProtobuf {
+		t.Errorf("unexpected options: depth %d, format %s, output %s", param.Depth, param.Format, param.Output)
+	}
+	if _, err := decodeSnapshotRequest(b[:len(b)-1]); err == nil {
+		t.Error("truncated request: expected error")
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	sent [][]byte
+}
+
+func (s *fakeServerStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, append([]byte(nil), *m.(*[]byte)...))
+	return nil
+}
+
+func TestSnapshotStream(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, pbResponseExchange, protowire.BytesType)
+	b = protowire.AppendString(b, "bitmex")
+	for _, timestamp := range []uint64{1, 2} {
+		var message []byte
+		message = protowire.AppendTag(message, pbSnapshotTimestamp, protowire.VarintType)
+		message = protowire.AppendVarint(message, timestamp)
+		b = protowire.AppendTag(b, pbResponseSnapshots, protowire.BytesType)
+		b = protowire.AppendBytes(b, message)
+	}
+	fake := &fakeServerStream{}
+	stream := &snapshotStream{stream: fake}
+	// written in pieces as buffered writers would do
+	for i := range b {
+		if _, err := stream.Write(b[i : i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(fake.sent) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(fake.sent))
+	}
+	for i, message := range fake.sent {
+		_, _, n := protowire.ConsumeTag(message)
+		timestamp, _ := protowire.ConsumeVarint(message[n:])
+		if timestamp != uint64(i+1) {
+			t.Errorf("message %d: expected timestamp %d, got %d", i, i+1, timestamp)
+		}
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	server := NewGRPCServer()
+	go server.Serve(listener)
+	defer server.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var request []byte
+	request = protowire.AppendTag(request, pbRequestExchange, protowire.BytesType)
+	request = protowire.AppendString(request, "bitmex")
+	request = protowire.AppendTag(request, pbRequestTime, protowire.BytesType)
+	request = protowire.AppendString(request, "1598941025000000000")
+	// channels are missing
+	stream, err := conn.NewStream(context.Background(), &snapshotServiceDesc.Streams[0], "/exchangedataset.snapshot.SnapshotService/GetSnapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(&request); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	var message []byte
+	err = stream.RecvMsg(&message)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+}
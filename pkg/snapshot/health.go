@@ -0,0 +1,94 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// readinessTimeout is the time the storage has to respond to the readiness check.
+const readinessTimeout = 5 * time.Second
+
+// readinessSimulators are the channels of exchanges whose simulators must be available to be ready.
+var readinessSimulators = map[string]string{
+	"bitmex":   "orderBookL2",
+	"bitfinex": "book_tBTCUSD",
+	"binance":  "btcusdt@depth@100ms",
+	"bitflyer": "lightning_board_BTC_JPY",
+	"liquid":   "price_ladders_cash_btcjpy_buy",
+}
+
+// readiness is the body of the response of `/readyz`.
+type readiness struct {
+	Ready bool `json:"ready"`
+	// Checks are results of each check, "ok" if it passed or "skipped" if it could not be checked
+	Checks map[string]string `json:"checks"`
+}
+
+// checkStorage returns nil if the location dataset files are read from can be listed.
+// `skipped` is true if the location can not be listed, such as S3 without DATASET_BUCKET.
+func checkStorage(ctx context.Context) (skipped bool, err error) {
+	lister := datasetLister(SnapshotParameter{})
+	if lister == nil {
+		return true, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	// nothing has to be listed, it only has to respond
+	_, err = lister(ctx, "readyz", "readyz", "readyz")
+	return false, err
+}
+
+// checkSimulators returns the first error of making simulators of `readinessSimulators`, in order of exchanges.
+func checkSimulators() error {
+	exchanges := make([]string, 0, len(readinessSimulators))
+	for exchange := range readinessSimulators {
+		exchanges = append(exchanges, exchange)
+	}
+	sort.Strings(exchanges)
+	for _, exchange := range exchanges {
+		if _, err := getSimulator(exchange, []string{readinessSimulators[exchange]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serveHealth responds as long as the server is running.
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// serveReady responds 200 if the storage is reachable and simulators are available, or 503 otherwise.
+func serveReady(w http.ResponseWriter, r *http.Request) {
+	result := readiness{Ready: true, Checks: make(map[string]string)}
+	skipped, err := checkStorage(r.Context())
+	switch {
+	case err != nil:
+		result.Ready = false
+		result.Checks["storage"] = err.Error()
+	case skipped:
+		result.Checks["storage"] = "skipped"
+	default:
+		result.Checks["storage"] = "ok"
+	}
+	if err := checkSimulators(); err != nil {
+		result.Ready = false
+		result.Checks["simulators"] = err.Error()
+	} else {
+		result.Checks["simulators"] = "ok"
+	}
+	if !result.Ready {
+		LoggerFrom(r.Context()).Warn("not ready", "checks", result.Checks)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if result.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(result)
+}
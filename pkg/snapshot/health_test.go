@@ -0,0 +1,62 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(simulators map[string]string) { readinessSimulators = simulators }(readinessSimulators)
+	readinessSimulators = map[string]string{}
+	defer func() { DatasetDirectory = "" }()
+	server := httptest.NewServer(NewHTTPHandler())
+	defer server.Close()
+	for _, c := range []struct {
+		dir      string
+		expected int
+	}{
+		{dir, http.StatusOK},
+		{filepath.Join(dir, "missing"), http.StatusServiceUnavailable},
+	} {
+		DatasetDirectory = c.dir
+		res, err := http.Get(server.URL + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body readiness
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != c.expected || body.Ready != (c.expected == http.StatusOK) {
+			t.Errorf("%s: expected %d, got %d: %v", c.dir, c.expected, res.StatusCode, body.Checks)
+		}
+	}
+	res, err := http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("healthz: expected 200, got %d", res.StatusCode)
+	}
+	res, err = http.Get(server.URL + "/debug/pprof/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("pprof: expected 404 unless enabled, got %d", res.StatusCode)
+	}
+}
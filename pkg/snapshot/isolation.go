@@ -0,0 +1,83 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// errorChannel is the channel of entries reporting errors of quarantined channels.
+const errorChannel = "$error"
+
+// isolatingSimulator is simulator quarantining channels failed to be processed
+// so that other channels can be simulated.
+type isolatingSimulator struct {
+	simulator.Simulator
+	// quarantined is the map of quarantined channels to the error, shared among simulators in a request
+	quarantined map[string]string
+}
+
+func (s *isolatingSimulator) quarantine(channel string, err error) {
+	Logger.Warn("quarantined channel", "channel", channel, "error", err)
+	s.quarantined[copyString(channel)] = err.Error()
+}
+
+func (s *isolatingSimulator) ProcessMessageChannelKnown(channel string, line []byte) error {
+	if _, ok := s.quarantined[channel]; ok {
+		return nil
+	}
+	if err := s.Simulator.ProcessMessageChannelKnown(channel, line); err != nil {
+		s.quarantine(channel, err)
+	}
+	return nil
+}
+
+func (s *isolatingSimulator) ProcessState(channel string, line []byte) error {
+	if _, ok := s.quarantined[channel]; ok {
+		return nil
+	}
+	if err := s.Simulator.ProcessState(channel, line); err != nil {
+		s.quarantine(channel, err)
+	}
+	return nil
+}
+
+// TakeSnapshot takes snapshot without quarantined channels as they would be broken.
+func (s *isolatingSimulator) TakeSnapshot() ([]simulator.Snapshot, error) {
+	snapshots, err := s.Simulator.TakeSnapshot()
+	if err != nil || len(s.quarantined) == 0 {
+		return snapshots, err
+	}
+	healthy := make([]simulator.Snapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		if _, ok := s.quarantined[snapshot.Channel]; !ok {
+			healthy = append(healthy, snapshot)
+		}
+	}
+	return healthy, nil
+}
+
+// channelError is the message of entries in `errorChannel`.
+type channelError struct {
+	Channel string `json:"channel"`
+	Error   string `json:"error"`
+}
+
+// errorEntries returns entries reporting errors of the quarantined channels in the order of channel name.
+func errorEntries(quarantined map[string]string) ([]entry, error) {
+	channels := make([]string, 0, len(quarantined))
+	for channel := range quarantined {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	entries := make([]entry, len(channels))
+	for i, channel := range channels {
+		message, err := json.Marshal(channelError{Channel: channel, Error: quarantined[channel]})
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = entry{channel: errorChannel, message: message}
+	}
+	return entries, nil
+}
@@ -0,0 +1,57 @@
+package snapshot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// failingSimulator fails to process messages of `failing` channel.
+type failingSimulator struct {
+	simulator.Simulator
+	failing   string
+	processed []string
+}
+
+func (s *failingSimulator) ProcessMessageChannelKnown(channel string, line []byte) error {
+	if channel == s.failing {
+		return errors.New("malformed")
+	}
+	s.processed = append(s.processed, channel)
+	return nil
+}
+
+func (s *failingSimulator) TakeSnapshot() ([]simulator.Snapshot, error) {
+	return []simulator.Snapshot{
+		{Channel: "healthy", Snapshot: []byte("{}")},
+		{Channel: "broken", Snapshot: []byte("{}")},
+	}, nil
+}
+
+func TestIsolatingSimulator(t *testing.T) {
+	failing := &failingSimulator{failing: "broken"}
+	sim := &isolatingSimulator{Simulator: failing, quarantined: make(map[string]string)}
+	for _, channel := range []string{"healthy", "broken", "healthy"} {
+		if err := sim.ProcessMessageChannelKnown(channel, []byte("{}")); err != nil {
+			t.Fatalf("expected error to be isolated: %v", err)
+		}
+	}
+	if len(failing.processed) != 2 {
+		t.Fatalf("expected healthy channel to be processed: %v", failing.processed)
+	}
+	snapshots, err := sim.TakeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Channel != "healthy" {
+		t.Fatalf("expected quarantined channel to be removed: %v", snapshots)
+	}
+	entries, err := errorEntries(sim.quarantined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || string(entries[0].message) != `{"channel":"broken","error":"malformed"}` {
+		t.Fatalf("unexpected error entries: %v", entries)
+	}
+}
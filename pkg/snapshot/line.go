@@ -0,0 +1,64 @@
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// datasetLine is a line of dataset files split into fields, slices refer to bytes of the line.
+type datasetLine struct {
+	typ       []byte
+	timestamp int64
+	// channel is nil for lines other than msg and state lines
+	channel []byte
+	// message is the rest of msg and state lines after the channel, including the newline
+	message []byte
+}
+
+// maxTimestampLength is the longest timestamp in nanoseconds int64 can have.
+const maxTimestampLength = 19
+
+// parseLine splits `line` into fields, msg and state lines must have the channel.
+// Errors do not refer to bytes of `line`, as they are reused.
+func parseLine(line []byte) (parsed datasetLine, err error) {
+	tab := bytes.IndexByte(line, '\t')
+	if tab < 0 {
+		return parsed, fmt.Errorf("line does not have type: %q", line)
+	}
+	parsed.typ = line[:tab]
+	rest := line[tab+1:]
+	var timestampBytes []byte
+	if tab = bytes.IndexByte(rest, '\t'); tab >= 0 {
+		timestampBytes = rest[:tab]
+		rest = rest[tab+1:]
+	} else {
+		// end line does not have anything after the timestamp
+		timestampBytes = bytes.TrimSuffix(rest, []byte{'\n'})
+		rest = nil
+	}
+	if len(timestampBytes) == 0 || len(timestampBytes) > maxTimestampLength {
+		return parsed, fmt.Errorf("invalid timestamp: %q", timestampBytes)
+	}
+	for _, b := range timestampBytes {
+		if b < '0' || b > '9' {
+			return parsed, fmt.Errorf("invalid timestamp: %q", timestampBytes)
+		}
+	}
+	parsed.timestamp, err = strconv.ParseInt(bytesToString(timestampBytes), 10, 64)
+	if err != nil {
+		// the error of strconv refers to the string sharing bytes of the line
+		return parsed, fmt.Errorf("invalid timestamp: %q", timestampBytes)
+	}
+	if !bytes.Equal(parsed.typ, typeMsg) && !bytes.Equal(parsed.typ, typeState) {
+		parsed.message = rest
+		return
+	}
+	tab = bytes.IndexByte(rest, '\t')
+	if tab < 0 {
+		return parsed, fmt.Errorf("line does not have channel: %q", line)
+	}
+	parsed.channel = rest[:tab]
+	parsed.message = rest[tab+1:]
+	return
+}
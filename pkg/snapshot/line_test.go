@@ -0,0 +1,96 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// lineSeeds are lines of edge cases to start fuzzing from.
+var lineSeeds = []string{
+	"msg\t1598941025000000000\torderBookL2\t{\"action\":\"insert\"}\n",
+	"state\t1598941025000000000\torderBookL2\t{}\n",
+	"start\t1598941025000000000\twss://www.bitmex.com/realtime\n",
+	"end\t1598941025000000000\n",
+	"end\t1598941025000000000",
+	"msg\t1598941025000000000\n",
+	"msg\t\torderBookL2\t{}\n",
+	"msg\t-1\torderBookL2\t{}\n",
+	"msg\t+1\torderBookL2\t{}\n",
+	"msg\t99999999999999999999\torderBookL2\t{}\n",
+	"msg\t1598941025000000000\t\t\n",
+	"\t\t\t\n",
+	"\n",
+	"msg\t1\tchannel\t" + strings.Repeat("x", 8192) + "\n",
+}
+
+func TestParseLine(t *testing.T) {
+	parsed, err := parseLine([]byte("msg\t1598941025000000000\torderBookL2\t{}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(parsed.typ) != "msg" || parsed.timestamp != 1598941025000000000 || string(parsed.channel) != "orderBookL2" || string(parsed.message) != "{}\n" {
+		t.Errorf("unexpected fields: %+v", parsed)
+	}
+	for _, line := range []string{"msg\t-1\tc\t{}\n", "msg\t+1\tc\t{}\n", "msg\t\tc\t{}\n", "msg\t99999999999999999999\tc\t{}\n", "msg\t1\n", "msg\n"} {
+		if _, err := parseLine([]byte(line)); err == nil {
+			t.Errorf("%q: expected error", line)
+		}
+	}
+	// errors must not change when the line is reused
+	line := []byte("msg\t9999999999999999999\tc\t{}\n")
+	_, err = parseLine(line)
+	if err == nil {
+		t.Fatal("expected overflow to fail")
+	}
+	message := err.Error()
+	copy(line, bytes.Repeat([]byte{'0'}, len(line)))
+	if err.Error() != message {
+		t.Errorf("error changed after the line was reused: %s", err)
+	}
+}
+
+func FuzzParseLine(f *testing.F) {
+	for _, seed := range lineSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, line []byte) {
+		parsed, err := parseLine(line)
+		if err != nil {
+			return
+		}
+		if parsed.timestamp < 0 {
+			t.Fatalf("negative timestamp %d in %q", parsed.timestamp, line)
+		}
+		if (bytes.Equal(parsed.typ, typeMsg) || bytes.Equal(parsed.typ, typeState)) && parsed.channel == nil {
+			t.Fatalf("%s line without channel: %q", parsed.typ, line)
+		}
+		// fields are in the line in order
+		prefix := string(parsed.typ) + "\t" + strconv.FormatInt(parsed.timestamp, 10)
+		if !strings.HasPrefix(string(line), string(parsed.typ)+"\t") || !strings.HasSuffix(string(line), string(parsed.message)) {
+			t.Fatalf("fields %q and %q are not of %q", prefix, parsed.message, line)
+		}
+	})
+}
+
+func FuzzFeedToSimulator(f *testing.F) {
+	f.Add([]byte(strings.Join(lineSeeds, "")), false)
+	f.Add([]byte(strings.Join(lineSeeds, "")), true)
+	f.Add([]byte("start\t100\twss://example.com\nmsg\t200\tchannel\t{}\nmsg\t2000\tchannel\t{}\n"), false)
+	f.Fuzz(func(t *testing.T, content []byte, lenient bool) {
+		rec := &recordingSimulator{}
+		var sim simulator.Simulator = rec
+		feeder := &Feeder{Sim: &sim, SetNewSim: func(simp *simulator.Simulator) error { *simp = rec; return nil }, Targets: []int64{1000}, OnTarget: func(int64) error { return nil }, lenient: lenient}
+		// the smallest buffer makes long lines go through the buffer of the feeder
+		reader := bufio.NewReaderSize(bytes.NewReader(content), 16)
+		scanned, _, err := FeedToSimulator(context.Background(), reader, feeder)
+		if err == nil && scanned > len(content) {
+			t.Fatalf("scanned %d bytes of %d", scanned, len(content))
+		}
+	})
+}
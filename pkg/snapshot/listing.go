@@ -0,0 +1,253 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"google.golang.org/api/iterator"
+)
+
+// ListDataset is true if dataset files are listed before fetching them, so that files which do not exist are skipped
+// without requests for them. Listing the default location requires DATASET_BUCKET.
+var ListDataset = os.Getenv("LIST_DATASET") == "1"
+
+// objectLister returns the set of names of objects which start with `prefix` and are between `first` and `last` inclusive.
+type objectLister func(ctx context.Context, prefix string, first string, last string) (map[string]bool, error)
+
+// listerFor returns the objectLister of the location dataset files are read from for `param`,
+// or nil if it can not be listed or listing is disabled.
+func listerFor(param SnapshotParameter) objectLister {
+	if !ListDataset || DatasetDirectory != "" {
+		// files in the directory are not fetched
+		return nil
+	}
+	return datasetLister(param)
+}
+
+// datasetLister returns the objectLister of the location dataset files are read from for `param`,
+// or nil if it can not be listed.
+func datasetLister(param SnapshotParameter) objectLister {
+	if DatasetDirectory != "" {
+		return listDir(DatasetDirectory)
+	}
+	if GCSBucket != "" {
+		return listGCS(GCSBucket)
+	}
+	bucket := param.DatasetBucket
+	if bucket == "" {
+		bucket = DefaultDatasetBucket
+	}
+	if bucket == "" {
+		return nil
+	}
+	return listS3(bucket, datasetRoleOf(param))
+}
+
+// plannedOpen returns the function opening the source of `keys` which skips keys not listed by `lister`.
+// All keys are opened if listing failed.
+func plannedOpen(ctx context.Context, lister objectLister, prefix string, open func(keys []string) (DatasetSource, error)) func(keys []string) (DatasetSource, error) {
+	return func(keys []string) (DatasetSource, error) {
+		if len(keys) == 0 {
+			return open(keys)
+		}
+		listed, err := lister(ctx, prefix, keys[0], keys[len(keys)-1])
+		if err != nil {
+			LoggerFrom(ctx).Warn("could not list dataset, fetching all files", "error", err)
+			return open(keys)
+		}
+		exists := make([]bool, len(keys))
+		existing := make([]string, 0, len(keys))
+		for i, key := range keys {
+			if listed[key] {
+				exists[i] = true
+				existing = append(existing, key)
+			}
+		}
+		LoggerFrom(ctx).Debug("planned files to fetch", "files", len(existing), "missing", len(keys)-len(existing))
+		rest, err := open(existing)
+		if err != nil {
+			return nil, err
+		}
+		return &plannedSource{keys: keys, exists: exists, rest: rest, i: -1}, nil
+	}
+}
+
+// plannedSource is DatasetSource returning nil without fetching for files known not to exist.
+type plannedSource struct {
+	keys []string
+	// exists[i] is true if keys[i] was listed
+	exists []bool
+	// rest is the source of files which exist in the order of `keys`
+	rest DatasetSource
+	i    int
+}
+
+func (s *plannedSource) Next() (io.ReadCloser, bool) {
+	if s.i+1 >= len(s.keys) {
+		return nil, false
+	}
+	s.i++
+	if !s.exists[s.i] {
+		return nil, true
+	}
+	body, ok := s.rest.Next()
+	if !ok {
+		// deleted after listing
+		return nil, true
+	}
+	return body, true
+}
+
+func (s *plannedSource) Name() string {
+	if s.i < 0 || s.i >= len(s.keys) {
+		return ""
+	}
+	return s.keys[s.i]
+}
+
+// Refetch fetches the file again from the source of files which exist.
+func (s *plannedSource) Refetch(name string, offset int64) (io.ReadCloser, error) {
+	r, ok := s.rest.(refetcher)
+	if !ok {
+		return nil, fmt.Errorf("%s can not be fetched again", name)
+	}
+	return r.Refetch(name, offset)
+}
+
+func (s *plannedSource) Close() error {
+	return s.rest.Close()
+}
+
+// latestSearchMinutes is how many minutes before now dataset files are searched for the latest target.
+const latestSearchMinutes = 60
+
+// ResolveLatest sets the target of `param` to the end of the newest dataset file of the exchange,
+// so that the snapshot is taken at its last message.
+func ResolveLatest(ctx context.Context, param *SnapshotParameter, now time.Time) error {
+	lister := datasetLister(*param)
+	if lister == nil {
+		return newSnapshotError(ErrBadParameter, errors.New("'latest' is not available for the default dataset location"))
+	}
+	prefix := param.DatasetPrefix + param.Exchange + "_"
+	extension := extensions[param.Compression]
+	nowMinute := now.UnixNano() / 60 / 1000000000
+	key := func(minute int64) string {
+		return fmt.Sprintf("%s%d%s", prefix, minute, extension)
+	}
+	listed, err := lister(ctx, prefix, key(nowMinute-latestSearchMinutes), key(nowMinute))
+	if err != nil {
+		return newSnapshotError(ErrStorage, err)
+	}
+	latest := int64(-1)
+	for name := range listed {
+		if !strings.HasSuffix(name, extension) {
+			continue
+		}
+		minute, err := strconv.ParseInt(name[len(prefix):len(name)-len(extension)], 10, 64)
+		if err != nil {
+			continue
+		}
+		if minute > latest {
+			latest = minute
+		}
+	}
+	if latest < 0 {
+		return newSnapshotError(ErrDatasetGap, fmt.Errorf("no dataset file in the last %d minutes", latestSearchMinutes))
+	}
+	LoggerFrom(ctx).Debug("resolved latest target", "minute", latest)
+	param.Nanosecs = []int64{(latest+1)*60*1000000000 - 1}
+	return nil
+}
+
+func listDir(dir string) objectLister {
+	return func(ctx context.Context, prefix string, first string, last string) (map[string]bool, error) {
+		infos, err := ioutil.ReadDir(filepath.Join(dir, filepath.Dir(prefix)))
+		if err != nil {
+			return nil, err
+		}
+		listed := make(map[string]bool)
+		for _, info := range infos {
+			name := info.Name()
+			if d := filepath.Dir(prefix); d != "." {
+				name = d + "/" + name
+			}
+			if strings.HasPrefix(name, prefix) && name >= first && name <= last {
+				listed[name] = true
+			}
+		}
+		return listed, nil
+	}
+}
+
+func listS3(bucket string, role string) objectLister {
+	return func(ctx context.Context, prefix string, first string, last string) (map[string]bool, error) {
+		sess, err := datasetSession(role)
+		if err != nil {
+			return nil, err
+		}
+		client := s3.New(sess)
+		listed := make(map[string]bool)
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+			// keys have minutes of the same number of digits, so they are sorted by minute
+			// StartAfter is exclusive, this is right before `first`
+			StartAfter: aws.String(first[:len(first)-1] + string(first[len(first)-1]-1)),
+		}
+		done := false
+		err = client.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				key := aws.StringValue(obj.Key)
+				if key > last {
+					done = true
+					return false
+				}
+				if key >= first {
+					listed[key] = true
+				}
+			}
+			return !done
+		})
+		if err != nil {
+			return nil, err
+		}
+		return listed, nil
+	}
+}
+
+func listGCS(bucket string) objectLister {
+	return func(ctx context.Context, prefix string, first string, last string) (map[string]bool, error) {
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer client.Close()
+		query := &storage.Query{Prefix: prefix, StartOffset: first, EndOffset: last + "\x00"}
+		if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+			return nil, err
+		}
+		listed := make(map[string]bool)
+		it := client.Bucket(bucket).Objects(ctx, query)
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				return listed, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			listed[attrs.Name] = true
+		}
+	}
+}
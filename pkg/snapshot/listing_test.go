@@ -0,0 +1,98 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSource is DatasetSource returning files of `keys` having their names as contents.
+type fakeSource struct {
+	keys []string
+	i    int
+}
+
+func (s *fakeSource) Next() (io.ReadCloser, bool) {
+	if s.i >= len(s.keys) {
+		return nil, false
+	}
+	s.i++
+	return ioutil.NopCloser(strings.NewReader(s.keys[s.i-1])), true
+}
+
+func (s *fakeSource) Name() string { return s.keys[s.i-1] }
+func (s *fakeSource) Close() error { return nil }
+
+func TestPlannedOpen(t *testing.T) {
+	lister := func(ctx context.Context, prefix string, first string, last string) (map[string]bool, error) {
+		if prefix != "bitmex_" || first != "bitmex_1.gz" || last != "bitmex_3.gz" {
+			t.Fatalf("unexpected range: %s %s %s", prefix, first, last)
+		}
+		return map[string]bool{"bitmex_1.gz": true, "bitmex_3.gz": true}, nil
+	}
+	var opened []string
+	open := func(keys []string) (DatasetSource, error) {
+		opened = keys
+		return &fakeSource{keys: keys}, nil
+	}
+	source, err := plannedOpen(context.Background(), lister, "bitmex_", open)([]string{"bitmex_1.gz", "bitmex_2.gz", "bitmex_3.gz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opened) != 2 {
+		t.Fatalf("expected only listed files to be fetched, got %v", opened)
+	}
+	expected := []string{"bitmex_1.gz", "", "bitmex_3.gz"}
+	for _, content := range expected {
+		body, ok := source.Next()
+		if !ok {
+			t.Fatal("source ended early")
+		}
+		if content == "" {
+			if body != nil || source.Name() != "bitmex_2.gz" {
+				t.Fatalf("expected missing file to be nil: %s", source.Name())
+			}
+			continue
+		}
+		read, _ := ioutil.ReadAll(body)
+		if string(read) != content || source.Name() != content {
+			t.Fatalf("expected %s, got %s as %s", content, read, source.Name())
+		}
+	}
+	if _, ok := source.Next(); ok {
+		t.Fatal("expected no more files")
+	}
+}
+
+func TestResolveLatest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"bitmex_26649010.gz", "bitmex_26649011.gz", "bitflyer_26649012.gz"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	DatasetDirectory = dir
+	defer func() { DatasetDirectory = "" }()
+	now := time.Unix(26649012*60, 0)
+	param := SnapshotParameter{Exchange: "bitmex", Compression: "gzip"}
+	if err := ResolveLatest(context.Background(), &param, now); err != nil {
+		t.Fatal(err)
+	}
+	if len(param.Nanosecs) != 1 || param.Nanosecs[0] != 26649012*60*1000000000-1 {
+		t.Fatalf("expected the end of the newest file, got %v", param.Nanosecs)
+	}
+	param = SnapshotParameter{Exchange: "bitmex", Compression: "gzip"}
+	if err := ResolveLatest(context.Background(), &param, now.Add(2*time.Hour)); !errors.Is(err, ErrDatasetGap) {
+		t.Fatalf("expected no dataset to be found, got %v", err)
+	}
+}
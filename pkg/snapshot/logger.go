@@ -0,0 +1,44 @@
+package snapshot
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// LogLevel is the minimum level of logs printed, one of `debug`, `info`, `warn` and `error`, set by LOG_LEVEL.
+// `quiet` disables logs entirely. Defaults to `info`.
+var LogLevel = os.Getenv("LOG_LEVEL")
+
+// Logger is the logger used where no request is associated, logs of requests should use `LoggerFrom`.
+var Logger = NewLogger(os.Stdout, LogLevel)
+
+// levelQuiet is the level above any log, no log is printed at this level.
+const levelQuiet = slog.Level(100)
+
+// NewLogger returns the logger writing logs of `level` or above to `w` in JSON.
+func NewLogger(w io.Writer, level string) *slog.Logger {
+	var l slog.Level
+	if level == "quiet" {
+		l = levelQuiet
+	} else if err := l.UnmarshalText([]byte(level)); err != nil {
+		l = slog.LevelInfo
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l}))
+}
+
+type loggerKey struct{}
+
+// WithLogger returns the context carrying `l` to be used in the request.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// LoggerFrom returns the logger of the request `ctx` is of, or `Logger` if it does not have one.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return Logger
+}
@@ -0,0 +1,35 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewLogger(buf, "warn")
+	l.Info("hidden")
+	l.Warn("shown", "file", "a.gz")
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), `"file":"a.gz"`) {
+		t.Fatalf("unexpected logs: %s", buf.String())
+	}
+	buf.Reset()
+	NewLogger(buf, "quiet").Error("hidden")
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing to be logged in quiet mode: %s", buf.String())
+	}
+}
+
+func TestLoggerFrom(t *testing.T) {
+	if LoggerFrom(context.Background()) != Logger {
+		t.Fatal("expected the default logger without request")
+	}
+	buf := new(bytes.Buffer)
+	ctx := WithLogger(context.Background(), NewLogger(buf, "").With("request_id", "abc"))
+	LoggerFrom(ctx).Info("message")
+	if !strings.Contains(buf.String(), `"request_id":"abc"`) {
+		t.Fatalf("expected request ID to be attached: %s", buf.String())
+	}
+}
@@ -0,0 +1,364 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// channelManifest is the sidecar index of a dataset file telling which channels appear in it and where connections start,
+// so that files having nothing for requested channels are not read.
+type channelManifest struct {
+	// Channels is the sorted list of channels of msg and state lines in the file
+	Channels []string `json:"channels"`
+	// Starts is the list of start lines in the file in the order they appear
+	Starts []manifestStart `json:"starts"`
+	// Frames is the list of independently compressed frames of seekable files, empty if the file is not seekable
+	Frames []manifestFrame `json:"frames,omitempty"`
+}
+
+// manifestStart is a start line in a dataset file.
+type manifestStart struct {
+	Timestamp int64 `json:"timestamp"`
+	// Offset is the byte offset of the line in the decompressed file
+	Offset int64 `json:"offset"`
+}
+
+// manifestFrame is a frame of a seekable dataset file, which can be decompressed without the frames before it,
+// such as a member of multistream gzip files or a frame of seekable zstd files.
+type manifestFrame struct {
+	// Offset is the byte offset of the frame in the compressed file
+	Offset int64 `json:"offset"`
+	// Decompressed is the byte offset the frame begins at in the decompressed file
+	Decompressed int64 `json:"decompressed"`
+	// Line is the byte offset of the first line beginning in the frame in the decompressed file, -1 if there is none
+	Line int64 `json:"line"`
+	// Timestamp is the timestamp of the line at `Line`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// seek returns the frame to decompress from to read the decompressed file from `offset`,
+// the zero frame if the file has to be decompressed from the beginning.
+func (m *channelManifest) seek(offset int64) manifestFrame {
+	var frame manifestFrame
+	for _, f := range m.Frames {
+		if f.Decompressed > offset {
+			break
+		}
+		frame = f
+	}
+	return frame
+}
+
+// seekBefore returns the last frame having a line at or before `nanosec` at the beginning,
+// lines before the line are also at or before it. The zero frame is returned if there is none.
+func (m *channelManifest) seekBefore(nanosec int64) (frame manifestFrame, line int64) {
+	for _, f := range m.Frames {
+		if f.Line < 0 {
+			continue
+		}
+		if f.Timestamp > nanosec {
+			break
+		}
+		frame, line = f, f.Line
+	}
+	return
+}
+
+// manifestBuilder builds channelManifest of a file as lines of it are fed.
+type manifestBuilder struct {
+	channels map[string]bool
+	starts   []manifestStart
+}
+
+func newManifestBuilder() *manifestBuilder {
+	return &manifestBuilder{channels: make(map[string]bool)}
+}
+
+// add records `line` at `offset` of the file.
+func (b *manifestBuilder) add(line datasetLine, offset int64) {
+	if line.channel != nil {
+		if !b.channels[string(line.channel)] {
+			b.channels[string(line.channel)] = true
+		}
+		return
+	}
+	if bytes.Equal(line.typ, typeStart) {
+		b.starts = append(b.starts, manifestStart{Timestamp: line.timestamp, Offset: offset})
+	}
+}
+
+func (b *manifestBuilder) manifest() channelManifest {
+	m := channelManifest{Channels: make([]string, 0, len(b.channels)), Starts: b.starts}
+	for channel := range b.channels {
+		m.Channels = append(m.Channels, channel)
+	}
+	sort.Strings(m.Channels)
+	if m.Starts == nil {
+		m.Starts = []manifestStart{}
+	}
+	return m
+}
+
+// manifestStore stores manifests of dataset files by their names.
+type manifestStore interface {
+	// Get returns the manifest of the file `key`, nil if it is not indexed yet.
+	Get(key string) (*channelManifest, error)
+	Put(key string, manifest channelManifest) error
+}
+
+// manifests is the store of manifests in `ManifestBucket`, nil if it is not configured.
+var manifests manifestStore
+
+// manifestStoreFor returns the store of manifests for `param`, or nil if files can not be planned by them.
+// Manifests are only of the default dataset location, and are not used while recorded snapshots are replayed
+// or lines of all channels are asked for.
+func manifestStoreFor(param SnapshotParameter) manifestStore {
+	if param.DatasetBucket != "" || param.replay != nil || param.NoPrefilter {
+		return nil
+	}
+	if ManifestDirectory != "" {
+		return dirManifestStore(ManifestDirectory)
+	}
+	return manifests
+}
+
+// manifestPlan is how dataset files are read as planned by their manifests.
+type manifestPlan struct {
+	store manifestStore
+	// unindexed is the set of files without manifests, manifests of them are made as they are read through
+	unindexed map[string]bool
+	// seeks is the map of files to where they are read from, lines before it are superseded by a start line
+	// or already applied as of the state
+	seeks map[string]manifestSeek
+}
+
+// manifestSeek is where a file is read from.
+type manifestSeek struct {
+	// compressed is the byte offset of the compressed frame the file is decompressed from
+	compressed int64
+	// discard is the decompressed bytes from the frame which are read without being parsed
+	discard int64
+}
+
+// seekTo returns where the file of `m` is read from to read it from `offset` of the decompressed file.
+func seekTo(m *channelManifest, offset int64) manifestSeek {
+	frame := m.seek(offset)
+	return manifestSeek{compressed: frame.Offset, discard: offset - frame.Decompressed}
+}
+
+// offset returns the decompressed bytes of the file `key` read without being parsed.
+func (p *manifestPlan) offset(key string) int64 {
+	if p == nil {
+		return 0
+	}
+	return p.seeks[key].discard
+}
+
+// compressedOffsets returns the map of files to the byte offset of compressed frames they are read from.
+func (p *manifestPlan) compressedOffsets() map[string]int64 {
+	offsets := make(map[string]int64)
+	for key, seek := range p.seeks {
+		if seek.compressed > 0 {
+			offsets[key] = seek.compressed
+		}
+	}
+	return offsets
+}
+
+// indexes returns true if the manifest of the file `key` is made as it is read.
+func (p *manifestPlan) indexes(key string) bool {
+	return p != nil && p.unindexed[key]
+}
+
+// save stores the manifest of the file `key` made by `builder`, failing to save it does not affect snapshots.
+func (p *manifestPlan) save(ctx context.Context, key string, builder *manifestBuilder) {
+	if err := p.store.Put(key, builder.manifest()); err != nil {
+		LoggerFrom(ctx).Warn("could not save manifest", "file", key, "error", err)
+	}
+}
+
+// planByManifests returns `keys` without files having nothing the simulator for `param` needs,
+// nor files before the last start line before the first target, with the plan to read the rest.
+// Files without manifests are always read.
+func planByManifests(ctx context.Context, store manifestStore, param SnapshotParameter, keys []string) ([]string, *manifestPlan) {
+	log := LoggerFrom(ctx)
+	plan := &manifestPlan{store: store, unindexed: make(map[string]bool), seeks: make(map[string]manifestSeek)}
+	loaded := make([]*channelManifest, len(keys))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, FetchConcurrency)
+	for i, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			m, err := store.Get(key)
+			if err != nil {
+				log.Warn("could not load manifest", "file", key, "error", err)
+				return
+			}
+			loaded[i] = m
+		}(i, key)
+	}
+	wg.Wait()
+	matcher, err := newChannelMatcher(param.Channels)
+	if err != nil {
+		return keys, plan
+	}
+	prefilter := newChannelPrefilter(param.Exchange, param.Channels, matcher)
+	first := 0
+	if param.State == nil {
+		// the simulator is made again at start lines, lines before the last one before the first target are not needed
+	search:
+		for i := len(keys) - 1; i >= 0; i-- {
+			if loaded[i] == nil {
+				continue
+			}
+			for j := len(loaded[i].Starts) - 1; j >= 0; j-- {
+				if start := loaded[i].Starts[j]; start.Timestamp < param.Nanosecs[0] {
+					first = i
+					plan.seeks[keys[i]] = seekTo(loaded[i], start.Offset)
+					break search
+				}
+			}
+		}
+	}
+	planned := make([]string, 0, len(keys)-first)
+	for i := first; i < len(keys); i++ {
+		m := loaded[i]
+		if m == nil {
+			plan.unindexed[keys[i]] = true
+			planned = append(planned, keys[i])
+			continue
+		}
+		needed := len(m.Starts) > 0 || i == first
+		for _, channel := range m.Channels {
+			if needed {
+				break
+			}
+			needed = prefilter.Match(channel)
+		}
+		if !needed {
+			continue
+		}
+		planned = append(planned, keys[i])
+		if param.State != nil {
+			// lines as of the state are skipped, seekable files are read from the frame right before the state
+			if frame, line := m.seekBefore(param.StateNanosec); line > 0 {
+				plan.seeks[keys[i]] = manifestSeek{compressed: frame.Offset, discard: line - frame.Decompressed}
+			}
+		}
+	}
+	log.Debug("planned files by manifests", "files", len(planned), "skipped", len(keys)-len(planned), "seeks", len(plan.seeks))
+	return planned, plan
+}
+
+// s3ManifestStore is manifestStore saving manifests as S3 objects.
+type s3ManifestStore struct {
+	client *s3.S3
+	bucket string
+}
+
+func newS3ManifestStore(bucket string) (*s3ManifestStore, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return &s3ManifestStore{client: s3.New(sess), bucket: bucket}, nil
+}
+
+func (s *s3ManifestStore) key(key string) string {
+	return "manifest/" + key + ".json"
+}
+
+func (s *s3ManifestStore) Get(key string) (*channelManifest, error) {
+	obj, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer obj.Body.Close()
+	m := new(channelManifest)
+	if err := json.NewDecoder(obj.Body).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *s3ManifestStore) Put(key string, manifest channelManifest) error {
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(key)),
+		Body:        bytes.NewReader(encoded),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// dirManifestStore is manifestStore saving manifests as files in the directory, named after dataset files.
+type dirManifestStore string
+
+func (d dirManifestStore) path(key string) string {
+	return filepath.Join(string(d), key+".json")
+}
+
+func (d dirManifestStore) Get(key string) (*channelManifest, error) {
+	b, err := ioutil.ReadFile(d.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	m := new(channelManifest)
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (d dirManifestStore) Put(key string, manifest channelManifest) error {
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// other requests could be reading it
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlanByManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := dirManifestStore(dir)
+	saved := map[string]channelManifest{
+		"bitmex_1.gz": {Channels: []string{"orderBookL2_XBTUSD"}, Starts: []manifestStart{{Timestamp: 100, Offset: 0}, {Timestamp: 150, Offset: 40}}},
+		"bitmex_2.gz": {Channels: []string{"trade_XBTUSD"}, Starts: []manifestStart{}},
+		"bitmex_4.gz": {Channels: []string{"orderBookL2_XBTUSD"}, Starts: []manifestStart{{Timestamp: 500, Offset: 80}}},
+	}
+	for key, m := range saved {
+		if err := store.Put(key, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := []string{"bitmex_0.gz", "bitmex_1.gz", "bitmex_2.gz", "bitmex_3.gz", "bitmex_4.gz"}
+	param := SnapshotParameter{Exchange: "bitmex", Channels: []string{"orderBookL2"}, Nanosecs: []int64{300}}
+	planned, plan := planByManifests(context.Background(), store, param, keys)
+	// files before the start line at 150 are not needed, nor the file of trades
+	expected := []string{"bitmex_1.gz", "bitmex_3.gz", "bitmex_4.gz"}
+	if fmt.Sprint(planned) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, planned)
+	}
+	if plan.offset("bitmex_1.gz") != 40 || plan.offset("bitmex_4.gz") != 0 {
+		t.Errorf("unexpected seeks %v", plan.seeks)
+	}
+	if !plan.indexes("bitmex_3.gz") || plan.indexes("bitmex_4.gz") {
+		t.Errorf("expected only files without manifests to be indexed, got %v", plan.unindexed)
+	}
+	// the state is not of start lines
+	param.State = []byte("state")
+	if planned, _ = planByManifests(context.Background(), store, param, keys); len(planned) != 4 {
+		t.Errorf("expected files before the start line to be read with state, got %v", planned)
+	}
+}
+
+func TestManifestsOnFixture(t *testing.T) {
+	defer registerFixture()()
+	dir, err := ioutil.TempDir("", "stream-snapshot-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dataset, manifest string) { DatasetDirectory, ManifestDirectory = dataset, manifest }(DatasetDirectory, ManifestDirectory)
+	DatasetDirectory, ManifestDirectory = goldenDir, dir
+	expected, err := ioutil.ReadFile(filepath.Join(goldenDir, "raw_reconnected.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	take := func() Report {
+		param := SnapshotParameter{Exchange: fixtureExchange, Nanosecs: []int64{fixtureAt(130 * time.Second)}, Channels: []string{"book", "ticker"}, Format: "raw", Output: OutputTSV, Compression: "gzip"}
+		source, err := OpenSource(context.Background(), &param)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer source.Close()
+		ret, report, err := Snapshot(context.Background(), param, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ret, expected) {
+			t.Errorf("expected the same snapshot as without manifests:\n%s\nexpected:\n%s", ret, expected)
+		}
+		return report
+	}
+	// manifests are made as files are read
+	first := take()
+	m, err := dirManifestStore(dir).Get(fixtureKeys()[1])
+	if err != nil || m == nil {
+		t.Fatalf("expected the manifest to be saved, got %v", err)
+	}
+	offset := int64(len(fixtureFiles[1][0]) + len(fixtureFiles[1][1]) + 2)
+	if fmt.Sprint(m.Channels) != "[book ticker]" || len(m.Starts) != 1 || m.Starts[0].Offset != offset {
+		t.Errorf("unexpected manifest %+v", *m)
+	}
+	// files before the reconnection are not read
+	second := take()
+	if len(second.Files) != 2 || second.Files[0].Name != fixtureKeys()[1] {
+		t.Errorf("expected files from the reconnection to be read, got %+v", second.Files)
+	}
+	if second.Scanned >= first.Scanned {
+		t.Errorf("expected less to be read, got %d of %d", second.Scanned, first.Scanned)
+	}
+}
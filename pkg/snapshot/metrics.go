@@ -0,0 +1,95 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// metricsChannelPrefix is the prefix of synthetic channels having metrics of orderbook of the channel.
+// They are only returned when selected by postFilter, such as `metrics_orderBookL2_XBTUSD` or `metrics_*`.
+const metricsChannelPrefix = "metrics_"
+
+// defaultMetricsBps is the default range around mid price in basis points to sum sizes of levels within.
+const defaultMetricsBps = 10
+
+// bookMetrics is the message of metrics channels.
+type bookMetrics struct {
+	BestBid float64 `json:"bestBid"`
+	BestAsk float64 `json:"bestAsk"`
+	Mid     float64 `json:"mid"`
+	Spread  float64 `json:"spread"`
+	// Bps is the range around mid price which sizes are summed up within
+	Bps           float64 `json:"bps"`
+	BidSizeWithin float64 `json:"bidSizeWithin"`
+	AskSizeWithin float64 `json:"askSizeWithin"`
+	// Imbalance is (bid size - ask size) / (bid size + ask size) within the range, in [-1, 1]
+	Imbalance float64 `json:"imbalance"`
+}
+
+// appendMetrics appends entries of metrics channels computed from orderbook levels in `entries`
+// for channels which `selected` returns true for their metrics channels.
+// Channels without level on both sides do not have metrics.
+func appendMetrics(entries []entry, bps float64, selected func(channel string) bool) ([]entry, error) {
+	type channelLevels struct {
+		bids [][2]float64
+		asks [][2]float64
+	}
+	levels := make(map[string]*channelLevels)
+	// channels in the order of appearance
+	channels := make([]string, 0)
+	for _, e := range entries {
+		if !selected(metricsChannelPrefix + e.channel) {
+			continue
+		}
+		side, price, size, ok := parseLevel(e.message)
+		if !ok {
+			continue
+		}
+		cl, ok := levels[e.channel]
+		if !ok {
+			cl = &channelLevels{}
+			levels[e.channel] = cl
+			channels = append(channels, e.channel)
+		}
+		if side == sideBid {
+			cl.bids = append(cl.bids, [2]float64{price, size})
+		} else {
+			cl.asks = append(cl.asks, [2]float64{price, size})
+		}
+	}
+	for _, channel := range channels {
+		cl := levels[channel]
+		if len(cl.bids) == 0 || len(cl.asks) == 0 {
+			continue
+		}
+		m := bookMetrics{BestBid: math.Inf(-1), BestAsk: math.Inf(1), Bps: bps}
+		for _, l := range cl.bids {
+			m.BestBid = math.Max(m.BestBid, l[0])
+		}
+		for _, l := range cl.asks {
+			m.BestAsk = math.Min(m.BestAsk, l[0])
+		}
+		m.Mid = (m.BestBid + m.BestAsk) / 2
+		m.Spread = m.BestAsk - m.BestBid
+		within := m.Mid * bps / 10000
+		for _, l := range cl.bids {
+			if l[0] >= m.Mid-within {
+				m.BidSizeWithin += l[1]
+			}
+		}
+		for _, l := range cl.asks {
+			if l[0] <= m.Mid+within {
+				m.AskSizeWithin += l[1]
+			}
+		}
+		if total := m.BidSizeWithin + m.AskSizeWithin; total > 0 {
+			m.Imbalance = (m.BidSizeWithin - m.AskSizeWithin) / total
+		}
+		message, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{channel: metricsChannelPrefix + channel, message: message})
+	}
+	return entries, nil
+}
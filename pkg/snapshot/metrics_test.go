@@ -0,0 +1,30 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAppendMetrics(t *testing.T) {
+	entries := []entry{
+		{channel: "book", message: []byte(`{"side":"Buy","price":99.99,"size":3}`)},
+		{channel: "book", message: []byte(`{"side":"Buy","price":90,"size":100}`)},
+		{channel: "book", message: []byte(`{"side":"Sell","price":100.01,"size":1}`)},
+		{channel: "other", message: []byte(`{"side":"Buy","price":1,"size":1}`)},
+	}
+	selected := func(channel string) bool { return channel == "metrics_book" }
+	entries, err := appendMetrics(entries, 10, selected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 || entries[4].channel != "metrics_book" {
+		t.Fatalf("expected metrics of book to be appended: %v", entries)
+	}
+	var m bookMetrics
+	if err := json.Unmarshal(entries[4].message, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Mid != 100 || m.BidSizeWithin != 3 || m.AskSizeWithin != 1 || m.Imbalance != 0.5 {
+		t.Fatalf("unexpected metrics: %+v", m)
+	}
+}
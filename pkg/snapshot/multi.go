@@ -0,0 +1,92 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ExchangeChannels is an exchange and channels of it to take snapshot of.
+type ExchangeChannels struct {
+	Exchange string
+	// Channels is the list of channels of `Exchange`, which can have patterns
+	Channels []string
+	// NormalizedChannels is the requested channels if they are in the normalized naming, `Channels` are native channels of them
+	NormalizedChannels []string
+}
+
+// exchangeResult is the result of snapshot for an exchange in multi-exchange request.
+type exchangeResult struct {
+	ret    []byte
+	report Report
+	err    error
+}
+
+// snapshotExchanges takes snapshots of all exchanges in `param.Exchanges` concurrently at the same targets.
+// Lines of snapshots are prefixed with the exchange column, and are in the order of `param.Exchanges`.
+// In the report, `lastTimestamp` is the earliest of the last timestamps of exchanges, and `partial` is the first reason
+// any of snapshots is partial.
+func snapshotExchanges(ctx context.Context, param SnapshotParameter) (ret []byte, report Report, err error) {
+	results := make([]exchangeResult, len(param.Exchanges))
+	var wg sync.WaitGroup
+	for i, ec := range param.Exchanges {
+		exParam := param
+		exParam.Exchange = ec.Exchange
+		exParam.Channels = ec.Channels
+		exParam.NormalizedChannels = ec.NormalizedChannels
+		exParam.Exchanges = nil
+		wg.Add(1)
+		go func(i int, exParam SnapshotParameter) {
+			defer wg.Done()
+			result := &results[i]
+			ctx := WithLogger(ctx, LoggerFrom(ctx).With("exchange", exParam.Exchange))
+			source, serr := OpenSource(ctx, &exParam)
+			if serr != nil {
+				result.err = serr
+				return
+			}
+			result.ret, result.report, result.err = Snapshot(ctx, exParam, source)
+			if serr := source.Close(); serr != nil && result.err == nil {
+				result.err = serr
+			}
+		}(i, exParam)
+	}
+	wg.Wait()
+	buffer := new(bytes.Buffer)
+	for i, result := range results {
+		exchange := param.Exchanges[i].Exchange
+		report.Scanned += result.report.Scanned
+		report.SkippedLines += result.report.SkippedLines
+		report.SkippedStates += result.report.SkippedStates
+		report.FilesRead += result.report.FilesRead
+		report.ProcessTime += result.report.ProcessTime
+		report.CacheHits += result.report.CacheHits
+		report.CacheMisses += result.report.CacheMisses
+		report.Files = append(report.Files, result.report.Files...)
+		report.MissingFiles = append(report.MissingFiles, result.report.MissingFiles...)
+		report.TruncatedFiles = append(report.TruncatedFiles, result.report.TruncatedFiles...)
+		report.ChannelTimes = append(report.ChannelTimes, result.report.ChannelTimes...)
+		if result.err != nil {
+			// keep the kind of the error
+			err = fmt.Errorf("%s: %w", exchange, result.err)
+			return
+		}
+		if report.Partial == "" {
+			report.Partial = result.report.Partial
+		}
+		if last := result.report.LastTimestamp; last != 0 && (report.LastTimestamp == 0 || last < report.LastTimestamp) {
+			report.LastTimestamp = last
+		}
+		for _, line := range bytes.SplitAfter(result.ret, []byte{'\n'}) {
+			if len(line) == 0 {
+				continue
+			}
+			buffer.WriteString(exchange)
+			buffer.WriteByte('\t')
+			buffer.Write(line)
+		}
+	}
+	ret = buffer.Bytes()
+	return
+}
@@ -0,0 +1,267 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// namings of channels in requests and outputs
+const (
+	// NamingNative uses channel names of exchanges as they are in dataset
+	NamingNative = "native"
+	// NamingNormalized uses the same names among exchanges in the form of `kind:BASE/QUOTE`, such as `book:BTC/USD`
+	NamingNormalized = "normalized"
+)
+
+// kinds of normalized channels
+const (
+	kindBook   = "book"
+	kindTrades = "trades"
+)
+
+// quoteCurrencies are currencies symbols are split at when symbols do not have separators, longer ones first.
+var quoteCurrencies = []string{"USDT", "USDC", "BUSD", "USD", "JPY", "EUR", "BTC", "ETH", "BNB"}
+
+// channelNaming translates channels of an exchange between native and normalized names.
+type channelNaming struct {
+	// native returns native channels having `kind` of the pair, or nil if the kind is not known
+	native func(kind string, base string, quote string) []string
+	// normalize returns the kind and the pair of the native channel, `ok` is false if it is not known
+	normalize func(channel string) (kind string, base string, quote string, ok bool)
+}
+
+// bitmexCurrency is the currency of BitMEX, which calls BTC XBT.
+func bitmexCurrency(currency string) string {
+	if currency == "BTC" {
+		return "XBT"
+	}
+	if currency == "XBT" {
+		return "BTC"
+	}
+	return currency
+}
+
+// splitPair splits `symbol` without separators into the base and the quote currency.
+func splitPair(symbol string) (base string, quote string, ok bool) {
+	symbol = strings.ToUpper(symbol)
+	for _, quote := range quoteCurrencies {
+		if len(symbol) > len(quote) && strings.HasSuffix(symbol, quote) {
+			return symbol[:len(symbol)-len(quote)], quote, true
+		}
+	}
+	return "", "", false
+}
+
+var binanceNaming = channelNaming{
+	native: func(kind, base, quote string) []string {
+		symbol := strings.ToLower(base + quote)
+		switch kind {
+		case kindBook:
+			return []string{symbol + "@depth@100ms"}
+		case kindTrades:
+			return []string{symbol + "@trade"}
+		}
+		return nil
+	},
+	normalize: func(channel string) (kind, base, quote string, ok bool) {
+		at := strings.IndexByte(channel, '@')
+		if at < 0 {
+			return
+		}
+		switch stream := channel[at+1:]; {
+		case strings.HasPrefix(stream, "depth"):
+			kind = kindBook
+		case stream == "trade":
+			kind = kindTrades
+		default:
+			return
+		}
+		base, quote, ok = splitPair(channel[:at])
+		return
+	},
+}
+
+// channelNamings has namings of exchanges whose channels can be normalized.
+var channelNamings = map[string]channelNaming{
+	"bitmex": {
+		// tables have messages of all instruments, entries are of each of them in formatted output
+		native: func(kind, base, quote string) []string {
+			switch kind {
+			case kindBook:
+				return []string{"orderBookL2"}
+			case kindTrades:
+				return []string{"trade"}
+			}
+			return nil
+		},
+		normalize: func(channel string) (kind, base, quote string, ok bool) {
+			var symbol string
+			switch {
+			case strings.HasPrefix(channel, "orderBookL2_"):
+				kind, symbol = kindBook, channel[len("orderBookL2_"):]
+			case strings.HasPrefix(channel, "trade_"):
+				kind, symbol = kindTrades, channel[len("trade_"):]
+			default:
+				return
+			}
+			base, quote, ok = splitPair(symbol)
+			return kind, bitmexCurrency(base), quote, ok
+		},
+	},
+	"bitfinex": {
+		native: func(kind, base, quote string) []string {
+			symbol := "t" + base + quote
+			if len(base) > 3 || len(quote) > 3 {
+				symbol = "t" + base + ":" + quote
+			}
+			switch kind {
+			case kindBook:
+				return []string{"book_" + symbol}
+			case kindTrades:
+				return []string{"trades_" + symbol}
+			}
+			return nil
+		},
+		normalize: func(channel string) (kind, base, quote string, ok bool) {
+			var symbol string
+			switch {
+			case strings.HasPrefix(channel, "book_t"):
+				kind, symbol = kindBook, channel[len("book_t"):]
+			case strings.HasPrefix(channel, "trades_t"):
+				kind, symbol = kindTrades, channel[len("trades_t"):]
+			default:
+				return
+			}
+			if i := strings.IndexByte(symbol, ':'); i >= 0 {
+				return kind, symbol[:i], symbol[i+1:], true
+			}
+			if len(symbol) != 6 {
+				return
+			}
+			return kind, symbol[:3], symbol[3:], true
+		},
+	},
+	binanceSpot:    binanceNaming,
+	binanceFutures: binanceNaming,
+	"bitflyer": {
+		native: func(kind, base, quote string) []string {
+			switch kind {
+			case kindBook:
+				return []string{"lightning_board_" + base + "_" + quote}
+			case kindTrades:
+				return []string{"lightning_executions_" + base + "_" + quote}
+			}
+			return nil
+		},
+		normalize: func(channel string) (kind, base, quote string, ok bool) {
+			var symbol string
+			switch {
+			case strings.HasPrefix(channel, "lightning_board_snapshot_"):
+				kind, symbol = kindBook, channel[len("lightning_board_snapshot_"):]
+			case strings.HasPrefix(channel, "lightning_board_"):
+				kind, symbol = kindBook, channel[len("lightning_board_"):]
+			case strings.HasPrefix(channel, "lightning_executions_"):
+				kind, symbol = kindTrades, channel[len("lightning_executions_"):]
+			default:
+				return
+			}
+			// FX_BTC_JPY is of FX_BTC
+			i := strings.LastIndexByte(symbol, '_')
+			if i <= 0 {
+				return
+			}
+			return kind, symbol[:i], symbol[i+1:], true
+		},
+	},
+	"liquid": {
+		native: func(kind, base, quote string) []string {
+			symbol := strings.ToLower(base + quote)
+			switch kind {
+			case kindBook:
+				// sides are in separate channels
+				return []string{"price_ladders_cash_" + symbol + "_buy", "price_ladders_cash_" + symbol + "_sell"}
+			case kindTrades:
+				return []string{"executions_cash_" + symbol}
+			}
+			return nil
+		},
+		normalize: func(channel string) (kind, base, quote string, ok bool) {
+			var symbol string
+			switch {
+			case strings.HasPrefix(channel, "price_ladders_cash_"):
+				kind = kindBook
+				symbol = strings.TrimSuffix(strings.TrimSuffix(channel[len("price_ladders_cash_"):], "_buy"), "_sell")
+			case strings.HasPrefix(channel, "executions_cash_"):
+				kind, symbol = kindTrades, channel[len("executions_cash_"):]
+			default:
+				return
+			}
+			base, quote, ok = splitPair(symbol)
+			return
+		},
+	},
+}
+
+// parseNormalizedChannel parses a normalized channel `kind:BASE/QUOTE`.
+func parseNormalizedChannel(channel string) (kind string, base string, quote string, err error) {
+	colon := strings.IndexByte(channel, ':')
+	slash := strings.IndexByte(channel, '/')
+	if colon <= 0 || slash <= colon+1 || slash == len(channel)-1 {
+		return "", "", "", fmt.Errorf("normalized channel '%s' must be in the form of 'kind:BASE/QUOTE' such as 'book:BTC/USD'", channel)
+	}
+	return channel[:colon], strings.ToUpper(channel[colon+1 : slash]), strings.ToUpper(channel[slash+1:]), nil
+}
+
+// nativeChannels returns native channels of `exchange` for normalized `channels`, without duplicates.
+func nativeChannels(exchange string, channels []string) ([]string, error) {
+	naming, ok := channelNamings[exchange]
+	if !ok {
+		return nil, fmt.Errorf("channels of %s can not be normalized", exchange)
+	}
+	var native []string
+	added := make(map[string]bool)
+	for _, channel := range channels {
+		kind, base, quote, err := parseNormalizedChannel(channel)
+		if err != nil {
+			return nil, err
+		}
+		if exchange == "bitmex" {
+			base = bitmexCurrency(base)
+		}
+		names := naming.native(kind, base, quote)
+		if names == nil {
+			return nil, fmt.Errorf("kind of channel '%s' must be either '%s' or '%s'", channel, kindBook, kindTrades)
+		}
+		for _, name := range names {
+			if !added[name] {
+				added[name] = true
+				native = append(native, name)
+			}
+		}
+	}
+	return native, nil
+}
+
+// normalizedChannel returns the normalized name of the native `channel` of `exchange`,
+// `ok` is false if it can not be normalized, such as tables of all instruments in raw format.
+func normalizedChannel(exchange string, channel string) (normalized string, ok bool) {
+	naming, found := channelNamings[exchange]
+	if !found {
+		return "", false
+	}
+	kind, base, quote, ok := naming.normalize(channel)
+	if !ok {
+		return "", false
+	}
+	return kind + ":" + base + "/" + quote, true
+}
+
+// normalizeEntries renames channels of `entries` to normalized names, channels which can not be normalized are kept.
+func normalizeEntries(exchange string, entries []entry) []entry {
+	for i := range entries {
+		if normalized, ok := normalizedChannel(exchange, entries[i].channel); ok {
+			entries[i].channel = normalized
+		}
+	}
+	return entries
+}
@@ -0,0 +1,88 @@
+package snapshot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNativeChannels(t *testing.T) {
+	cases := []struct {
+		exchange string
+		channels []string
+		native   []string
+	}{
+		{"bitmex", []string{"book:BTC/USD", "book:ETH/USD", "trades:BTC/USD"}, []string{"orderBookL2", "trade"}},
+		{"bitfinex", []string{"book:BTC/USD", "trades:btc/usd", "book:LINK/USDT"}, []string{"book_tBTCUSD", "trades_tBTCUSD", "book_tLINK:USDT"}},
+		{"binance", []string{"book:BTC/USDT", "trades:BTC/USDT"}, []string{"btcusdt@depth@100ms", "btcusdt@trade"}},
+		{"bitflyer", []string{"book:FX_BTC/JPY"}, []string{"lightning_board_FX_BTC_JPY"}},
+		{"liquid", []string{"book:BTC/JPY"}, []string{"price_ladders_cash_btcjpy_buy", "price_ladders_cash_btcjpy_sell"}},
+	}
+	for _, c := range cases {
+		native, err := nativeChannels(c.exchange, c.channels)
+		if err != nil {
+			t.Errorf("%s: %v", c.exchange, err)
+			continue
+		}
+		if !reflect.DeepEqual(native, c.native) {
+			t.Errorf("%s: expected %v, got %v", c.exchange, c.native, native)
+		}
+	}
+	for _, channel := range []string{"book", "book:BTC", "book:/USD", "ticker:BTC/USD", "orderBookL2"} {
+		if _, err := nativeChannels("bitmex", []string{channel}); err == nil {
+			t.Errorf("%s: expected error", channel)
+		}
+	}
+	if _, err := nativeChannels("okex", []string{"book:BTC/USD"}); err == nil {
+		t.Error("expected error for exchange without naming")
+	}
+}
+
+func TestNormalizedChannel(t *testing.T) {
+	cases := []struct {
+		exchange   string
+		channel    string
+		normalized string
+	}{
+		{"bitmex", "orderBookL2_XBTUSD", "book:BTC/USD"},
+		{"bitmex", "trade_ETHUSD", "trades:ETH/USD"},
+		{"bitfinex", "book_tBTCUSD", "book:BTC/USD"},
+		{"bitfinex", "trades_tLINK:USDT", "trades:LINK/USDT"},
+		{"binance-futures", "btcusdt@depth@100ms", "book:BTC/USDT"},
+		{"bitflyer", "lightning_executions_FX_BTC_JPY", "trades:FX_BTC/JPY"},
+		{"liquid", "price_ladders_cash_btcjpy_sell", "book:BTC/JPY"},
+	}
+	for _, c := range cases {
+		normalized, ok := normalizedChannel(c.exchange, c.channel)
+		if !ok || normalized != c.normalized {
+			t.Errorf("%s %s: expected %s, got %s", c.exchange, c.channel, c.normalized, normalized)
+		}
+	}
+	// tables of all instruments can not be normalized
+	if _, ok := normalizedChannel("bitmex", "orderBookL2"); ok {
+		t.Error("expected orderBookL2 not to be normalized")
+	}
+	entries := normalizeEntries("bitmex", []entry{{channel: "orderBookL2_XBTUSD"}, {channel: "$metrics"}})
+	if entries[0].channel != "book:BTC/USD" || entries[1].channel != "$metrics" {
+		t.Errorf("unexpected channels: %s, %s", entries[0].channel, entries[1].channel)
+	}
+}
+
+func TestParseNormalizedNaming(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"book:BTC/USD"}, "1598941025000000000", "json")
+	event.QueryStringParameters["naming"] = NamingNormalized
+	event.MultiValueQueryStringParameters["exchanges"] = []string{"binance:book:BTC/USDT"}
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(param.Channels, []string{"orderBookL2"}) || !reflect.DeepEqual(param.NormalizedChannels, []string{"book:BTC/USD"}) {
+		t.Errorf("unexpected channels: %v, %v", param.Channels, param.NormalizedChannels)
+	}
+	if len(param.Exchanges) != 2 || !reflect.DeepEqual(param.Exchanges[1].Channels, []string{"btcusdt@depth@100ms"}) {
+		t.Errorf("unexpected exchanges: %+v", param.Exchanges)
+	}
+	event.QueryStringParameters["naming"] = "unified"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected error for unknown naming")
+	}
+}
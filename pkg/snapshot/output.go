@@ -0,0 +1,224 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+const (
+	// OutputTSV is the default output, a snapshot is written as `nanosec\tchannel\tmessage` lines
+	OutputTSV = "tsv"
+	// OutputJSON writes the whole response as a single JSON document
+	OutputJSON = "json"
+	// OutputCSV writes orderbook levels and prices as `timestamp,channel,side,price,size` rows
+	OutputCSV = "csv"
+	// OutputProtobuf writes the response as `SnapshotResponse` defined in proto/snapshot.proto
+	OutputProtobuf = "protobuf"
+	// OutputArrow writes orderbook levels as record batches in Arrow IPC stream format
+	OutputArrow = "arrow"
+	// OutputParquet writes orderbook levels as a Parquet file to `ExportBucket`, location of it is returned
+	OutputParquet = "parquet"
+	// OutputNDJSON writes a metadata line followed by a JSON object per entry
+	OutputNDJSON = "ndjson"
+	// OutputTable writes snapshots for humans to read, orderbook levels are aligned in columns of bids and asks
+	OutputTable = "table"
+)
+
+// ContentTypes is the map of outputs to the content type of the response.
+var ContentTypes = map[string]string{
+	OutputTSV:  "text/plain",
+	OutputJSON: "application/json",
+	OutputCSV:  "text/csv",
+	// there is no registered type for protobuf
+	OutputProtobuf: "application/x-protobuf",
+	OutputArrow:    "application/vnd.apache.arrow.stream",
+	OutputParquet:  "application/json",
+	OutputNDJSON:   "application/x-ndjson",
+	OutputTable:    "text/plain; charset=utf-8",
+}
+
+// jsonSnapshot is a snapshot at a target in JSON output.
+type jsonSnapshot struct {
+	Timestamp int64  `json:"timestamp"`
+	Exchange  string `json:"exchange"`
+	// Channels is the map of channels to messages in it, messages are embedded as they are if they are valid JSON
+	Channels map[string][]json.RawMessage `json:"channels"`
+	// AsOf is the map of channels to the timestamp of the last line updated them if requested
+	AsOf map[string]int64 `json:"asOf,omitempty"`
+}
+
+// newJSONSnapshot makes a snapshot in JSON output from `entries` at `nanosec`.
+func newJSONSnapshot(exchange string, nanosec int64, entries []entry) (snapshot jsonSnapshot, err error) {
+	snapshot = jsonSnapshot{
+		Timestamp: nanosec,
+		Exchange:  exchange,
+		Channels:  make(map[string][]json.RawMessage),
+	}
+	for _, e := range entries {
+		if len(e.message) == 0 {
+			// channel without data
+			if _, ok := snapshot.Channels[e.channel]; !ok {
+				snapshot.Channels[e.channel] = []json.RawMessage{}
+			}
+			continue
+		}
+		message, serr := jsonMessage(e.message)
+		if serr != nil {
+			err = serr
+			return
+		}
+		snapshot.Channels[e.channel] = append(snapshot.Channels[e.channel], message)
+		if e.asOf != 0 {
+			if snapshot.AsOf == nil {
+				snapshot.AsOf = make(map[string]int64)
+			}
+			snapshot.AsOf[e.channel] = e.asOf
+		}
+	}
+	return
+}
+
+// jsonMessage returns `message` to embed in JSON.
+func jsonMessage(message []byte) (json.RawMessage, error) {
+	if json.Valid(message) {
+		return json.RawMessage(message), nil
+	}
+	// messages not in JSON, such as raw format of some exchanges, are embedded as string
+	return json.Marshal(string(message))
+}
+
+// writeJSON writes `snapshots` as a JSON document, it is an object if there is only one snapshot
+// or an array of them otherwise.
+func writeJSON(buffer *bufio.Writer, snapshots []jsonSnapshot) error {
+	if len(snapshots) == 0 {
+		// nothing was found, make it empty so that it would be 404
+		return nil
+	}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if len(snapshots) == 1 {
+		return encoder.Encode(snapshots[0])
+	}
+	return encoder.Encode(snapshots)
+}
+
+// csvHeader is the first row of CSV output.
+var csvHeader = []string{"timestamp", "channel", "side", "price", "size"}
+
+// priced is a formatted message having price but not being an orderbook level, such as ticker.
+type priced struct {
+	Price *float64 `json:"price"`
+	Size  *float64 `json:"size"`
+}
+
+// csvHeader returns the first row of CSV output in the layout.
+func (l lineLayout) csvHeader() []string {
+	if l.omitTimestamp {
+		return csvHeader[1:]
+	}
+	return csvHeader
+}
+
+// writeCSVRows writes orderbook levels in `entries` as CSV rows in `layout`.
+// Other messages having price are written with empty side, and the rest is ignored.
+func writeCSVRows(writer *csv.Writer, nanosec int64, entries []entry, layout lineLayout) error {
+	timestamp := formatTimestamp(nanosec, layout.unit)
+	row := make([]string, len(csvHeader))
+	for _, e := range entries {
+		row[0] = timestamp
+		row[1] = e.channel
+		side, price, size, ok := parseLevel(e.message)
+		if ok {
+			if side == sideBid {
+				row[2] = "buy"
+			} else {
+				row[2] = "sell"
+			}
+			row[3] = strconv.FormatFloat(price, 'f', -1, 64)
+			row[4] = strconv.FormatFloat(size, 'f', -1, 64)
+		} else {
+			var p priced
+			if len(e.message) == 0 || e.message[0] != '{' {
+				continue
+			}
+			if err := json.Unmarshal(e.message, &p); err != nil || p.Price == nil {
+				continue
+			}
+			row[2] = ""
+			row[3] = strconv.FormatFloat(*p.Price, 'f', -1, 64)
+			row[4] = ""
+			if p.Size != nil {
+				row[4] = strconv.FormatFloat(*p.Size, 'f', -1, 64)
+			}
+		}
+		written := row
+		if layout.omitTimestamp {
+			written = row[1:]
+		}
+		if err := writer.Write(written); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ndjsonMetadata is the first line of NDJSON output.
+type ndjsonMetadata struct {
+	Exchange string  `json:"exchange"`
+	Targets  []int64 `json:"targets"`
+	// Channels is the list of channels resolved from patterns, it is the same as requested without patterns
+	Channels     []string `json:"channels"`
+	FilesScanned int      `json:"filesScanned"`
+	// Gaps is the list of dataset files which did not exist
+	Gaps []string `json:"gaps"`
+	// SkippedLines is the number of malformed lines skipped in lenient mode
+	SkippedLines int `json:"skippedLines,omitempty"`
+	// Truncated is the list of dataset files which were read until they ended in the middle
+	Truncated []string `json:"truncated,omitempty"`
+}
+
+// ndjsonEntry is a line of NDJSON output following metadata.
+type ndjsonEntry struct {
+	Timestamp int64           `json:"timestamp"`
+	Channel   string          `json:"channel"`
+	Message   json.RawMessage `json:"message"`
+	// AsOf is the timestamp of the last line updated the channel if requested
+	AsOf int64 `json:"asOf,omitempty"`
+}
+
+// writeNDJSONEntries writes `entries` at `nanosec` as lines of NDJSON output.
+func writeNDJSONEntries(writer io.Writer, nanosec int64, entries []entry) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	for _, e := range entries {
+		message, err := jsonMessage(e.message)
+		if err != nil {
+			return err
+		}
+		if len(e.message) == 0 {
+			// channel without data
+			message = json.RawMessage("null")
+		}
+		if err := encoder.Encode(ndjsonEntry{Timestamp: nanosec, Channel: e.channel, Message: message, AsOf: e.asOf}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeNDJSON writes `metadata` and `body` having entries, nothing is written if `body` is empty.
+func writeNDJSON(buffer *bufio.Writer, metadata ndjsonMetadata, body []byte) error {
+	if len(body) == 0 {
+		return nil
+	}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(metadata); err != nil {
+		return err
+	}
+	_, err := buffer.Write(body)
+	return err
+}
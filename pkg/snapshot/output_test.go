@@ -0,0 +1,66 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	snapshot, err := newJSONSnapshot("bitmex", 100, []entry{
+		{channel: "book", message: []byte(`{"price":1}`)},
+		{channel: "book", message: []byte(`{"price":2}`)},
+		{channel: "raw", message: []byte(`not json`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	writer := bufio.NewWriter(buf)
+	if err := writeJSON(writer, []jsonSnapshot{snapshot}); err != nil {
+		t.Fatal(err)
+	}
+	writer.Flush()
+	expected := `{"timestamp":100,"exchange":"bitmex","channels":{"book":[{"price":1},{"price":2}],"raw":["not json"]}}` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %s, got %s", expected, buf.String())
+	}
+}
+
+func TestWriteCSVRows(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writer := csv.NewWriter(buf)
+	err := writeCSVRows(writer, 100, []entry{
+		{channel: "book", message: []byte(`{"side":"Sell","price":1.5,"size":2}`)},
+		{channel: "ticker", message: []byte(`{"price":1.25}`)},
+		{channel: "status", message: []byte(`{"online":true}`)},
+	}, lineLayout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Flush()
+	expected := "100,book,sell,1.5,2\n100,ticker,,1.25,\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	body := new(bytes.Buffer)
+	if err := writeNDJSONEntries(body, 100, []entry{{channel: "book", message: []byte(`{"price":1}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	writer := bufio.NewWriter(buf)
+	metadata := ndjsonMetadata{Exchange: "bitmex", Targets: []int64{100}, Channels: []string{"book"}, FilesScanned: 2, Gaps: []string{}}
+	if err := writeNDJSON(writer, metadata, body.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	writer.Flush()
+	expected := `{"exchange":"bitmex","targets":[100],"channels":["book"],"filesScanned":2,"gaps":[]}` + "\n" +
+		`{"timestamp":100,"channel":"book","message":{"price":1}}` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %s, got %s", expected, buf.String())
+	}
+}
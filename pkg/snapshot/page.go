@@ -0,0 +1,158 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pageTTL is how long the rest of paged results can be taken after the first page is returned.
+const pageTTL = 10 * time.Minute
+
+// pageIDLength is the number of random bytes identifying a paged result.
+const pageIDLength = 16
+
+// pages is the store of paged results, shared among instances in S3 if `ResultCacheBucket` is configured.
+var pages resultStore = newMemoryResultStore(pageTTL)
+
+// pagedOutput returns true if results in `output` can be split into pages, which are of lines.
+func pagedOutput(output string) bool {
+	return output == OutputTSV || output == OutputNDJSON || output == OutputCSV || output == OutputTable
+}
+
+// pageToken is the position in a paged result the next page starts at.
+type pageToken struct {
+	id      string
+	offset  int64
+	expires int64
+}
+
+func (t pageToken) String() string {
+	return fmt.Sprintf("%s-%d-%d", t.id, t.offset, t.expires)
+}
+
+// parsePageToken parses the token returned with a page in the form of `id-offset-expires`.
+func parsePageToken(token string) (t pageToken, err error) {
+	fields := strings.Split(token, "-")
+	if len(fields) != 3 {
+		return t, errors.New("page token must be in the form of 'id-offset-expires'")
+	}
+	if id, serr := hex.DecodeString(fields[0]); serr != nil || len(id) != pageIDLength {
+		return t, errors.New("invalid id in page token")
+	}
+	t.id = fields[0]
+	if t.offset, err = strconv.ParseInt(fields[1], 10, 64); err != nil || t.offset <= 0 {
+		return t, errors.New("invalid offset in page token")
+	}
+	if t.expires, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return t, errors.New("invalid expiration in page token")
+	}
+	return
+}
+
+// pageEnd returns the end of the page of `result` starting at `offset` not longer than `size` bytes,
+// pages end at lines so that entries are not split. A line longer than `size` is a page by itself.
+func pageEnd(result []byte, offset int64, size int64) int64 {
+	if int64(len(result))-offset <= size {
+		return int64(len(result))
+	}
+	if i := bytes.LastIndexByte(result[offset:offset+size], '\n'); i >= 0 {
+		return offset + int64(i) + 1
+	}
+	if i := bytes.IndexByte(result[offset+size:], '\n'); i >= 0 {
+		return offset + size + int64(i) + 1
+	}
+	return int64(len(result))
+}
+
+// firstPage stores `result` larger than `size` bytes and returns the first page of it,
+// `report.NextPage` is set to the token of the rest.
+func firstPage(result []byte, report *Report, size int64, now time.Time) ([]byte, error) {
+	end := pageEnd(result, 0, size)
+	if end == int64(len(result)) {
+		return result, nil
+	}
+	id := make([]byte, pageIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	token := pageToken{id: hex.EncodeToString(id), offset: end, expires: now.Add(pageTTL).Unix()}
+	cached := cachedResult{result: result, scanned: report.Scanned, lastTimestamp: report.LastTimestamp, token: report.Token, fullSnapshot: report.FullSnapshot}
+	if err := pages.Put(token.id, cached); err != nil {
+		return nil, newSnapshotError(ErrStorage, err)
+	}
+	report.NextPage = token.String()
+	return result[:end], nil
+}
+
+// nextPage returns the page of the result stored by firstPage at `token`, which is not billed as nothing is scanned.
+// `report.NextPage` is set to the token of the rest if there is more.
+func nextPage(token string, size int64, now time.Time) (page []byte, report Report, err error) {
+	t, err := parsePageToken(token)
+	if err != nil {
+		return nil, report, newSnapshotError(ErrBadParameter, err)
+	}
+	if now.Unix() > t.expires {
+		return nil, report, newSnapshotError(ErrBadParameter, errors.New("page token has expired, request the snapshot again"))
+	}
+	cached, ok, err := pages.Get(t.id)
+	if err != nil {
+		return nil, report, newSnapshotError(ErrStorage, err)
+	}
+	if !ok || t.offset >= int64(len(cached.result)) {
+		return nil, report, newSnapshotError(ErrBadParameter, errors.New("page token has expired, request the snapshot again"))
+	}
+	report = Report{LastTimestamp: cached.lastTimestamp, Token: cached.token, FullSnapshot: cached.fullSnapshot}
+	end := pageEnd(cached.result, t.offset, size)
+	if end < int64(len(cached.result)) {
+		report.NextPage = pageToken{id: t.id, offset: end, expires: t.expires}.String()
+	}
+	return cached.result[t.offset:end], report, nil
+}
+
+// memoryResultStore is resultStore keeping results in memory until they expire.
+type memoryResultStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	results map[string]memoryResult
+}
+
+// memoryResult is a result in memoryResultStore with the time it expires at.
+type memoryResult struct {
+	cachedResult
+	expires time.Time
+}
+
+func newMemoryResultStore(ttl time.Duration) *memoryResultStore {
+	return &memoryResultStore{ttl: ttl, results: make(map[string]memoryResult)}
+}
+
+func (s *memoryResultStore) Get(key string) (cachedResult, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result, ok := s.results[key]
+	if !ok || time.Now().After(result.expires) {
+		return cachedResult{}, false, nil
+	}
+	return result.cachedResult, true, nil
+}
+
+func (s *memoryResultStore) Put(key string, result cachedResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// expired results are removed as new ones are put
+	for k, r := range s.results {
+		if now.After(r.expires) {
+			delete(s.results, k)
+		}
+	}
+	s.results[key] = memoryResult{cachedResult: result, expires: now.Add(s.ttl)}
+	return nil
+}
@@ -0,0 +1,87 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPageEnd(t *testing.T) {
+	result := []byte("line1\nline22\nline333\n")
+	for _, c := range []struct {
+		offset   int64
+		size     int64
+		expected int64
+	}{
+		{offset: 0, size: 100, expected: 21},
+		{offset: 0, size: 13, expected: 13},
+		{offset: 0, size: 12, expected: 6},
+		// a line longer than the page is not split
+		{offset: 6, size: 3, expected: 13},
+		{offset: 13, size: 8, expected: 21},
+	} {
+		if end := pageEnd(result, c.offset, c.size); end != c.expected {
+			t.Errorf("%d+%d: expected %d, got %d", c.offset, c.size, c.expected, end)
+		}
+	}
+}
+
+func TestParsePageToken(t *testing.T) {
+	token := pageToken{id: "00112233445566778899aabbccddeeff", offset: 10, expires: 1598941025}
+	parsed, err := parsePageToken(token.String())
+	if err != nil || parsed != token {
+		t.Errorf("expected %+v, got %+v %v", token, parsed, err)
+	}
+	for _, invalid := range []string{"", "abc-1-2", "00112233445566778899aabbccddeeff-0-1", "00112233445566778899aabbccddeeff-x-1"} {
+		if _, err := parsePageToken(invalid); err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+func TestTakePages(t *testing.T) {
+	defer registerFixture()()
+	defer func(dir string) { DatasetDirectory = dir }(DatasetDirectory)
+	DatasetDirectory = goldenDir
+	defer func(store resultStore) { pages = store }(pages)
+	pages = newMemoryResultStore(pageTTL)
+	param := SnapshotParameter{
+		Exchange:    fixtureExchange,
+		Nanosecs:    []int64{fixtureAt(80 * time.Second)},
+		Channels:    []string{"book", "ticker"},
+		Format:      fixtureExchange,
+		Output:      OutputTSV,
+		Compression: "gzip",
+		PageBytes:   100,
+	}
+	var paged []byte
+	for i := 0; ; i++ {
+		result, report, err := Take(context.Background(), param)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) == 0 || result[len(result)-1] != '\n' {
+			t.Fatalf("expected the page to end at a line, got %q", result)
+		}
+		paged = append(paged, result...)
+		if key, _ := resultCacheKey(param, time.Now()); report.ETag != pageETag(key, param.PageBytes, param.PageToken) {
+			t.Errorf("expected the tag of the result and the page, got %s", report.ETag)
+		}
+		if report.NextPage == "" {
+			break
+		}
+		if i == 0 && report.Scanned == 0 {
+			t.Error("expected the first page to be billed")
+		} else if i > 0 && report.Scanned != 0 {
+			t.Error("expected the next pages not to be billed")
+		}
+		param.PageToken = report.NextPage
+	}
+	if expected := goldenFile(t, "formatted_across_files"); !bytes.Equal(paged, expected) {
+		t.Errorf("expected pages to make the whole result, got:\n%s", paged)
+	}
+	if _, _, err := nextPage(param.PageToken, param.PageBytes, time.Now().Add(pageTTL+time.Minute)); err == nil {
+		t.Error("expected error for the expired token")
+	}
+}
@@ -0,0 +1,317 @@
+package snapshot
+
+import (
+	"sync"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// maxParallel is the maximum number of workers of parallel simulation.
+const maxParallel = 64
+
+// parallelBatch is the number of lines passed to a worker at once.
+const parallelBatch = 256
+
+// operations passed to workers of parallelSimulator
+const (
+	opStart = iota
+	opMessage
+	opState
+)
+
+type parallelOp struct {
+	kind    int
+	channel string
+	line    []byte
+}
+
+// parallelGroup is channels processed by the same simulator, a channel and its companions.
+type parallelGroup struct {
+	channels []string
+	sim      simulator.Simulator
+}
+
+// parallelWorker processes lines of its groups in a goroutine.
+type parallelWorker struct {
+	groups []*parallelGroup
+	// group of each channel of groups
+	groupOf map[string]*parallelGroup
+	batches chan []parallelOp
+	// snapshots requests the worker to take snapshots after lines sent so far
+	snapshots chan chan parallelResult
+	pending   []parallelOp
+}
+
+type parallelResult struct {
+	snapshots []simulator.Snapshot
+	err       error
+}
+
+// parallelSimulator is simulator running simulators of independent groups of channels in parallel.
+// Lines are passed to workers with copies of them, and processed while the next lines are read.
+// Errors of lines are returned from the call after they happened, at the latest from `TakeSnapshot`.
+type parallelSimulator struct {
+	simulator.Simulator
+	workers []*parallelWorker
+	// worker of each channel, nil if no simulator needs the channel
+	route map[string]*parallelWorker
+	mu    sync.Mutex
+	err   error
+	wg    sync.WaitGroup
+}
+
+// parallelGroups returns groups of `channels` which can be simulated independently.
+// Companions of a channel are in the same group as the channel.
+func parallelGroups(exchange string, channels []string) [][]string {
+	var groups [][]string
+	// group of each channel and companion seen
+	index := make(map[string]int)
+	requested := make(map[string]bool)
+	for _, channel := range channels {
+		if requested[channel] {
+			continue
+		}
+		requested[channel] = true
+		i, ok := index[channel]
+		if !ok {
+			for _, companion := range companionChannels(exchange, channel) {
+				if i, ok = index[companion]; ok {
+					break
+				}
+			}
+		}
+		if !ok {
+			i = len(groups)
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], channel)
+		index[channel] = i
+		for _, companion := range companionChannels(exchange, channel) {
+			index[companion] = i
+		}
+	}
+	return groups
+}
+
+// newParallelSimulator makes simulators for each group of `channels` and distributes them to `n` workers.
+func newParallelSimulator(exchange string, channels []string, n int) (*parallelSimulator, error) {
+	groups := parallelGroups(exchange, channels)
+	if n > len(groups) {
+		n = len(groups)
+	}
+	s := &parallelSimulator{route: make(map[string]*parallelWorker)}
+	for i := 0; i < n; i++ {
+		s.workers = append(s.workers, &parallelWorker{
+			groupOf:   make(map[string]*parallelGroup),
+			batches:   make(chan []parallelOp, 4),
+			snapshots: make(chan chan parallelResult),
+		})
+	}
+	for i, channels := range groups {
+		sim, err := getSimulator(exchange, channels)
+		if err != nil {
+			return nil, err
+		}
+		group := &parallelGroup{channels: channels, sim: sim}
+		worker := s.workers[i%n]
+		worker.groups = append(worker.groups, group)
+		for _, channel := range channels {
+			worker.groupOf[channel] = group
+			s.route[channel] = worker
+			for _, companion := range companionChannels(exchange, channel) {
+				worker.groupOf[companion] = group
+				s.route[companion] = worker
+			}
+		}
+	}
+	for _, worker := range s.workers {
+		s.wg.Add(1)
+		go s.run(worker)
+	}
+	return s, nil
+}
+
+// run processes lines passed to `w` until it is closed.
+func (s *parallelSimulator) run(w *parallelWorker) {
+	defer s.wg.Done()
+	// the first error in this worker, lines after it are not processed
+	var err error
+	processBatch := func(batch []parallelOp) {
+		if err != nil {
+			return
+		}
+		for _, op := range batch {
+			if err = w.process(op); err != nil {
+				s.fail(err)
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case batch, ok := <-w.batches:
+			if !ok {
+				return
+			}
+			processBatch(batch)
+		case result := <-w.snapshots:
+			// batches sent before the request are all in the buffer, as they are sent from the same goroutine
+			for len(w.batches) > 0 {
+				processBatch(<-w.batches)
+			}
+			if err != nil {
+				result <- parallelResult{err: err}
+				continue
+			}
+			var snapshots []simulator.Snapshot
+			for _, group := range w.groups {
+				groupSnapshots, serr := group.sim.TakeSnapshot()
+				if serr != nil {
+					err = serr
+					break
+				}
+				snapshots = append(snapshots, groupSnapshots...)
+			}
+			result <- parallelResult{snapshots: snapshots, err: err}
+		}
+	}
+}
+
+func (w *parallelWorker) process(op parallelOp) error {
+	if op.kind == opStart {
+		for _, group := range w.groups {
+			if err := group.sim.ProcessStart(op.line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	group, ok := w.groupOf[op.channel]
+	if !ok {
+		group = w.groupOf[channelTable(op.channel, w.groupOf)]
+		w.groupOf[op.channel] = group
+	}
+	if op.kind == opState {
+		return group.sim.ProcessState(op.channel, op.line)
+	}
+	return group.sim.ProcessMessageChannelKnown(op.channel, op.line)
+}
+
+// channelTable returns the channel in `known` whose table `channel` is of, such as orderBookL2 for orderBookL2_XBTUSD.
+func channelTable(channel string, known map[string]*parallelGroup) string {
+	for i := 0; i < len(channel); i++ {
+		if channel[i] == '_' {
+			if _, ok := known[channel[:i]]; ok {
+				return channel[:i]
+			}
+		}
+	}
+	return ""
+}
+
+func (s *parallelSimulator) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+}
+
+// failed returns the first error occurred in workers so far.
+func (s *parallelSimulator) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// send queues `op` to `w`, and passes queued lines to it if enough of them are queued.
+func (s *parallelSimulator) send(w *parallelWorker, op parallelOp) error {
+	w.pending = append(w.pending, op)
+	if len(w.pending) >= parallelBatch {
+		w.batches <- w.pending
+		w.pending = make([]parallelOp, 0, parallelBatch)
+	}
+	return s.failed()
+}
+
+// flush passes all queued lines to workers.
+func (s *parallelSimulator) flush() {
+	for _, w := range s.workers {
+		if len(w.pending) > 0 {
+			w.batches <- w.pending
+			w.pending = make([]parallelOp, 0, parallelBatch)
+		}
+	}
+}
+
+// workerOf returns the worker processing lines of `channel`, or nil if no simulator needs them.
+func (s *parallelSimulator) workerOf(channel string) *parallelWorker {
+	if w, ok := s.route[channel]; ok {
+		return w
+	}
+	var w *parallelWorker
+	for i := 0; i < len(channel); i++ {
+		if channel[i] == '_' {
+			if table, ok := s.route[channel[:i]]; ok {
+				w = table
+				break
+			}
+		}
+	}
+	// channel is retained, bytes it refers to might be reused
+	s.route[copyString(channel)] = w
+	return w
+}
+
+func (s *parallelSimulator) ProcessStart(line []byte) error {
+	copied := append([]byte(nil), line...)
+	for _, w := range s.workers {
+		if err := s.send(w, parallelOp{kind: opStart, line: copied}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *parallelSimulator) ProcessMessageChannelKnown(channel string, line []byte) error {
+	w := s.workerOf(channel)
+	if w == nil {
+		return s.failed()
+	}
+	return s.send(w, parallelOp{kind: opMessage, channel: copyString(channel), line: append([]byte(nil), line...)})
+}
+
+func (s *parallelSimulator) ProcessState(channel string, line []byte) error {
+	w := s.workerOf(channel)
+	if w == nil {
+		return s.failed()
+	}
+	return s.send(w, parallelOp{kind: opState, channel: copyString(channel), line: append([]byte(nil), line...)})
+}
+
+// TakeSnapshot waits for workers to process all lines passed so far and merges their snapshots in the order of groups in workers.
+func (s *parallelSimulator) TakeSnapshot() ([]simulator.Snapshot, error) {
+	s.flush()
+	results := make([]chan parallelResult, len(s.workers))
+	for i, w := range s.workers {
+		results[i] = make(chan parallelResult, 1)
+		w.snapshots <- results[i]
+	}
+	var snapshots []simulator.Snapshot
+	for _, result := range results {
+		r := <-result
+		if r.err != nil {
+			return nil, r.err
+		}
+		snapshots = append(snapshots, r.snapshots...)
+	}
+	return snapshots, nil
+}
+
+// Close stops workers, lines not processed yet are discarded.
+func (s *parallelSimulator) Close() {
+	for _, w := range s.workers {
+		close(w.batches)
+	}
+	s.wg.Wait()
+}
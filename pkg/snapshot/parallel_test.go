@@ -0,0 +1,102 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// countingSimulator counts messages of each channel it was given since the last start line.
+type countingSimulator struct {
+	simulator.Simulator
+	channels []string
+	counts   map[string]int
+}
+
+func (s *countingSimulator) ProcessStart(line []byte) error {
+	s.counts = make(map[string]int)
+	return nil
+}
+
+func (s *countingSimulator) ProcessMessageChannelKnown(channel string, line []byte) error {
+	if string(line) == "broken" {
+		return errors.New("broken message")
+	}
+	s.counts[channel]++
+	return nil
+}
+
+func (s *countingSimulator) ProcessState(channel string, line []byte) error {
+	return s.ProcessMessageChannelKnown(channel, line)
+}
+
+func (s *countingSimulator) TakeSnapshot() ([]simulator.Snapshot, error) {
+	var snapshots []simulator.Snapshot
+	for _, channel := range s.channels {
+		count := s.counts[channel]
+		// lines of instruments belong to the table
+		for name, c := range s.counts {
+			if strings.HasPrefix(name, channel+"_") {
+				count += c
+			}
+		}
+		snapshots = append(snapshots, simulator.Snapshot{Channel: channel, Snapshot: []byte(fmt.Sprint(count))})
+	}
+	return snapshots, nil
+}
+
+func TestParallelGroups(t *testing.T) {
+	groups := parallelGroups("bitflyer", []string{"lightning_board_BTC_JPY", "lightning_executions_BTC_JPY", "lightning_board_snapshot_BTC_JPY", "lightning_board_BTC_JPY"})
+	expected := [][]string{{"lightning_board_BTC_JPY", "lightning_board_snapshot_BTC_JPY"}, {"lightning_executions_BTC_JPY"}}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("expected %v, got %v", expected, groups)
+	}
+}
+
+func TestParallelSimulator(t *testing.T) {
+	defer func(get func(string, []string) (simulator.Simulator, error)) { getSimulator = get }(getSimulator)
+	getSimulator = func(exchange string, channels []string) (simulator.Simulator, error) {
+		return &countingSimulator{channels: channels, counts: make(map[string]int)}, nil
+	}
+	sim, err := newParallelSimulator("bitmex", []string{"orderBookL2", "trade", "instrument"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+	if err := sim.ProcessStart([]byte("start")); err != nil {
+		t.Fatal(err)
+	}
+	line := []byte("message")
+	for i := 0; i < 1000; i++ {
+		for _, channel := range []string{"orderBookL2_XBTUSD", "trade", "unknown"} {
+			if err := sim.ProcessMessageChannelKnown(channel, line); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// lines can be reused by the caller
+		line[0] = 'x'
+	}
+	snapshots, err := sim.TakeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, snapshot := range snapshots {
+		got[snapshot.Channel] = string(snapshot.Snapshot)
+	}
+	expected := map[string]string{"orderBookL2": "1000", "trade": "1000", "instrument": "0"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if err := sim.ProcessMessageChannelKnown("trade", []byte("broken")); err != nil {
+		// errors can be returned later
+		t.Logf("returned immediately: %v", err)
+	}
+	if _, err := sim.TakeSnapshot(); err == nil {
+		t.Error("expected the error of the worker")
+	}
+}
@@ -0,0 +1,735 @@
+package snapshot
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxTargets is the maximum number of timestamps snapshots can be taken at in a request.
+const maxTargets = 1440
+
+// maximum values of prefetch parameters
+const (
+	maxPrefetchFiles    = 16
+	maxReadAheadBlocks  = 256
+	maxFetchConcurrency = 32
+)
+
+// parseTimestamp parses `str` as either nanoseconds from the epoch or RFC3339 time with timezone.
+func parseTimestamp(str string) (int64, error) {
+	if nanosec, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return nanosec, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixNano(), nil
+}
+
+// intParameter returns the positive integer of query parameter `name` not more than `max`, or `def` if it is not specified.
+func intParameter(event events.APIGatewayProxyRequest, name string, def int, max int) (int, error) {
+	str, ok := event.QueryStringParameters[name]
+	if !ok {
+		return def, nil
+	}
+	value, err := strconv.Atoi(str)
+	if err != nil || value <= 0 || value > max {
+		return 0, fmt.Errorf("'%s' must be positive integer not more than %d", name, max)
+	}
+	return value, nil
+}
+
+// ParseParameter makes SnapshotParameter from the request of the API in the form of API Gateway event.
+func ParseParameter(event events.APIGatewayProxyRequest) (param SnapshotParameter, err error) {
+	var ok bool
+	param.Exchange, ok = event.PathParameters["exchange"]
+	if !ok {
+		err = errors.New("'exchange' must be specified")
+		return
+	}
+	nanosecStr, ok := event.PathParameters["nanosec"]
+	if !ok {
+		err = errors.New("'nanosec' must be specified")
+		return
+	}
+	var nanosec int64
+	var serr error
+	if nanosecStr == "latest" {
+		// resolved to the newest dataset after parameters are made
+		param.Latest = true
+		for _, name := range []string{"nanosecs", "endNanosec", "diffFrom", "replayUntil"} {
+			if _, ok := event.QueryStringParameters[name]; ok {
+				err = fmt.Errorf("'%s' can not be specified with the latest target", name)
+				return
+			}
+		}
+		if event.Body != "" {
+			err = errors.New("state can not be given with the latest target")
+			return
+		}
+	} else {
+		nanosec, serr = parseTimestamp(nanosecStr)
+		if serr != nil {
+			err = errors.New("'nanosec' must be nanoseconds, RFC3339 time or 'latest'")
+			return
+		}
+	}
+	param.Nanosecs = []int64{nanosec}
+	// additional targets to take snapshot at in the same request
+	for _, str := range event.MultiValueQueryStringParameters["nanosecs"] {
+		nanosec, serr := parseTimestamp(str)
+		if serr != nil {
+			err = errors.New("'nanosecs' must be nanoseconds or RFC3339 time")
+			return
+		}
+		param.Nanosecs = append(param.Nanosecs, nanosec)
+	}
+	// range mode: take snapshots at every interval from nanosec until endNanosec
+	endNanosecStr, hasEnd := event.QueryStringParameters["endNanosec"]
+	intervalNanosecStr, hasInterval := event.QueryStringParameters["intervalNanosec"]
+	if hasEnd != hasInterval {
+		err = errors.New("'endNanosec' and 'intervalNanosec' must be specified together")
+		return
+	}
+	if hasEnd {
+		endNanosec, serr := parseTimestamp(endNanosecStr)
+		if serr != nil {
+			err = errors.New("'endNanosec' must be nanoseconds or RFC3339 time")
+			return
+		}
+		intervalNanosec, serr := strconv.ParseInt(intervalNanosecStr, 10, 64)
+		if serr != nil {
+			err = errors.New("'intervalNanosec' must be of integer type")
+			return
+		}
+		if intervalNanosec <= 0 {
+			err = errors.New("'intervalNanosec' must be positive")
+			return
+		}
+		if endNanosec < nanosec {
+			err = errors.New("'endNanosec' must not be before 'nanosec'")
+			return
+		}
+		if (endNanosec-nanosec)/intervalNanosec >= maxTargets {
+			err = fmt.Errorf("too many snapshots in the range: at most %d snapshots can be taken", maxTargets)
+			return
+		}
+		for t := nanosec + intervalNanosec; t <= endNanosec; t += intervalNanosec {
+			param.Nanosecs = append(param.Nanosecs, t)
+		}
+	}
+	// delta mode: return changes from the snapshot the client has, identified by the token returned with it
+	if since, ok := event.QueryStringParameters["since"]; ok {
+		if _, ok := event.QueryStringParameters["diffFrom"]; ok {
+			err = errors.New("'since' and 'diffFrom' can not be specified at the same time")
+			return
+		}
+		if len(param.Nanosecs) != 1 {
+			err = errors.New("'since' can not be specified with multiple targets")
+			return
+		}
+		sinceNanosec, hash, serr := parseSnapshotToken(since)
+		if serr != nil {
+			err = fmt.Errorf("invalid 'since': %v", serr)
+			return
+		}
+		if sinceNanosec >= nanosec {
+			err = errors.New("the snapshot of 'since' must be before 'nanosec'")
+			return
+		}
+		param.Nanosecs = []int64{sinceNanosec, nanosec}
+		param.Diff = true
+		param.SinceHash = hash
+	}
+	// diff mode: return the difference of snapshots from diffFrom to nanosec
+	if diffFromStr, ok := event.QueryStringParameters["diffFrom"]; ok {
+		if len(param.Nanosecs) != 1 {
+			err = errors.New("'diffFrom' can not be specified with multiple targets")
+			return
+		}
+		diffFrom, serr := parseTimestamp(diffFromStr)
+		if serr != nil {
+			err = errors.New("'diffFrom' must be nanoseconds or RFC3339 time")
+			return
+		}
+		if diffFrom >= nanosec {
+			err = errors.New("'diffFrom' must be before 'nanosec'")
+			return
+		}
+		param.Nanosecs = []int64{diffFrom, nanosec}
+		param.Diff = true
+	}
+	if len(param.Nanosecs) > maxTargets {
+		err = fmt.Errorf("too many snapshots: at most %d snapshots can be taken", maxTargets)
+		return
+	}
+	sort.Slice(param.Nanosecs, func(i, j int) bool { return param.Nanosecs[i] < param.Nanosecs[j] })
+	// remove duplicates
+	deduped := param.Nanosecs[:1]
+	for _, nanosec := range param.Nanosecs[1:] {
+		if nanosec != deduped[len(deduped)-1] {
+			deduped = append(deduped, nanosec)
+		}
+	}
+	param.Nanosecs = deduped
+	param.Channels, ok = event.MultiValueQueryStringParameters["channels"]
+	if !ok {
+		// channels appeared in dataset are taken snapshot of
+		param.Channels = []string{allChannels}
+	}
+	param.Naming, ok = event.QueryStringParameters["naming"]
+	if !ok {
+		param.Naming = NamingNative
+	}
+	if param.Naming != NamingNative && param.Naming != NamingNormalized {
+		err = errors.New("'naming' must be either 'native' or 'normalized'")
+		return
+	}
+	if param.Naming == NamingNormalized {
+		param.NormalizedChannels = param.Channels
+		if param.Channels, err = nativeChannels(param.Exchange, param.Channels); err != nil {
+			return
+		}
+	}
+	if isBinance(param.Exchange) {
+		if err = validateBinanceChannels(param.Channels); err != nil {
+			return
+		}
+	}
+	param.Format, ok = event.QueryStringParameters["format"]
+	if !ok {
+		// default format is raw
+		param.Format = "raw"
+	}
+	// multiple formats are either repeated or separated by comma
+	if formats := event.MultiValueQueryStringParameters["format"]; len(formats) > 1 {
+		param.Formats = formats
+	} else if strings.Contains(param.Format, ",") {
+		param.Formats = strings.Split(param.Format, ",")
+	}
+	if len(param.Formats) > 1 {
+		param.Format = param.Formats[0]
+	}
+	// state exported by the previous request can be given as body
+	if event.Body != "" {
+		encoded := event.Body
+		if event.IsBase64Encoded {
+			decoded, serr := base64.StdEncoding.DecodeString(event.Body)
+			if serr != nil {
+				err = errors.New("body is not properly encoded")
+				return
+			}
+			encoded = string(decoded)
+		}
+		param.State, param.StateNanosec, serr = decodeState(encoded)
+		if serr != nil {
+			err = fmt.Errorf("invalid state: %v", serr)
+			return
+		}
+		if param.Nanosecs[0] < param.StateNanosec {
+			err = errors.New("'nanosec' must not be before the timestamp of the state")
+			return
+		}
+	}
+	param.ExportState = event.QueryStringParameters["exportState"] == "true"
+	param.Resumable = event.QueryStringParameters["resumable"] == "true"
+	if token, ok := event.QueryStringParameters["resume"]; ok {
+		if serr := parseResumeToken(token); serr != nil {
+			err = serr
+			return
+		}
+		// the resumed scan can stop again
+		param.ResumeToken = token
+		param.Resumable = true
+	}
+	param.IsolateErrors = event.QueryStringParameters["isolateErrors"] == "true"
+	param.Partial = event.QueryStringParameters["partial"] == "true"
+	param.SafeParsing = event.QueryStringParameters["safeParsing"] == "true"
+	param.Lenient = event.QueryStringParameters["lenient"] == "true"
+	param.ScanReport = event.QueryStringParameters["report"] == "true"
+	// the breakdown of time is returned in the report
+	param.Debug = event.QueryStringParameters["debug"] == "true"
+	param.ScanReport = param.ScanReport || param.Debug
+	param.DryRun = event.QueryStringParameters["dryRun"] == "true"
+	// clients polling the same snapshot tell the result they have, the header is used as browsers do
+	param.IfNoneMatch = event.QueryStringParameters["ifNoneMatch"]
+	if param.IfNoneMatch == "" {
+		param.IfNoneMatch = headerValue(event, "If-None-Match")
+	}
+	param.Verify = event.QueryStringParameters["verify"] == "true"
+	param.AsOf = event.QueryStringParameters["asOf"] == "true"
+	param.Stats = event.QueryStringParameters["stats"] == "true"
+	param.Audit = event.QueryStringParameters["audit"] == "true"
+	if param.Audit {
+		if !AuditEnabled {
+			err = errors.New("'audit' is not available")
+			return
+		}
+		if rawFormatIn(param) {
+			err = errors.New("'audit' can not be used with raw format")
+			return
+		}
+	}
+	param.CrossValidate = event.QueryStringParameters["crossValidate"] == "true"
+	if param.CrossValidate && rawFormatIn(param) {
+		err = errors.New("'crossValidate' can not be used with raw format")
+		return
+	}
+	// lines exactly at targets are included by default
+	switch event.QueryStringParameters["boundary"] {
+	case "", "inclusive":
+	case "exclusive":
+		param.Exclusive = true
+	default:
+		err = errors.New("'boundary' must be either 'inclusive' or 'exclusive'")
+		return
+	}
+	param.MissingChannels, ok = event.QueryStringParameters["missingChannels"]
+	if !ok {
+		param.MissingChannels = MissingChannelsIgnore
+	}
+	if param.MissingChannels != MissingChannelsIgnore && param.MissingChannels != MissingChannelsEmpty && param.MissingChannels != MissingChannelsError {
+		err = errors.New("'missingChannels' must be one of 'ignore', 'empty' and 'error'")
+		return
+	}
+	param.Reconnects, ok = event.QueryStringParameters["reconnects"]
+	if !ok {
+		param.Reconnects = ReconnectsIgnore
+	}
+	if param.Reconnects != ReconnectsIgnore && param.Reconnects != ReconnectsFlag && param.Reconnects != ReconnectsError {
+		err = errors.New("'reconnects' must be one of 'ignore', 'flag' and 'error'")
+		return
+	}
+	// channels are sorted by name unless the order is specified
+	switch event.QueryStringParameters["channelOrder"] {
+	case "", "name":
+	case "request":
+		param.ChannelOrder = param.Channels
+	default:
+		err = errors.New("'channelOrder' must be either 'name' or 'request'")
+		return
+	}
+	// in case the simulator needs channels not known to be needed
+	param.NoPrefilter = event.QueryStringParameters["prefilter"] == "false"
+	// in case the dataset has states differing from what the simulator built
+	param.AllStates = event.QueryStringParameters["dedupeStates"] == "false"
+	// replay mode: return messages after the snapshot until replayUntil
+	if replayUntilStr, ok := event.QueryStringParameters["replayUntil"]; ok {
+		if len(param.Nanosecs) != 1 {
+			err = errors.New("'replayUntil' can not be specified with multiple targets")
+			return
+		}
+		param.ReplayUntil, serr = parseTimestamp(replayUntilStr)
+		if serr != nil {
+			err = errors.New("'replayUntil' must be nanoseconds or RFC3339 time")
+			return
+		}
+		if param.ReplayUntil <= nanosec {
+			err = errors.New("'replayUntil' must be after 'nanosec'")
+			return
+		}
+	}
+	param.PostFilter = event.MultiValueQueryStringParameters["postFilter"]
+	param.Symbols = event.MultiValueQueryStringParameters["symbols"]
+	param.FanOut = event.QueryStringParameters["fanOut"] == "true"
+	param.MetricsBps = defaultMetricsBps
+	if bpsStr, ok := event.QueryStringParameters["metricsBps"]; ok {
+		param.MetricsBps, serr = strconv.ParseFloat(bpsStr, 64)
+		if serr != nil || param.MetricsBps <= 0 {
+			err = errors.New("'metricsBps' must be positive number")
+			return
+		}
+	}
+	if depthStr, ok := event.QueryStringParameters["depth"]; ok {
+		param.Depth, serr = strconv.Atoi(depthStr)
+		if serr != nil || param.Depth <= 0 {
+			err = errors.New("'depth' must be positive integer")
+			return
+		}
+		if rawFormatIn(param) {
+			// levels can not be told from messages in raw format
+			err = errors.New("'depth' can not be used with raw format")
+			return
+		}
+	}
+	if bucketStr, ok := event.QueryStringParameters["bucket"]; ok {
+		param.Bucket, serr = strconv.ParseFloat(bucketStr, 64)
+		if serr != nil || param.Bucket <= 0 {
+			err = errors.New("'bucket' must be positive number")
+			return
+		}
+		if rawFormatIn(param) {
+			err = errors.New("'bucket' can not be used with raw format")
+			return
+		}
+		if i := strings.IndexByte(bucketStr, '.'); i >= 0 {
+			param.BucketDecimals = len(bucketStr) - i - 1
+		}
+	}
+	// levels and fields are filtered to make responses smaller
+	if sideStr, ok := event.QueryStringParameters["side"]; ok {
+		switch sideStr {
+		case "bid":
+			param.Side = sideBid
+		case "ask":
+			param.Side = sideAsk
+		default:
+			err = errors.New("'side' must be either 'bid' or 'ask'")
+			return
+		}
+	}
+	if withinStr, ok := event.QueryStringParameters["withinPercent"]; ok {
+		param.WithinPercent, serr = strconv.ParseFloat(withinStr, 64)
+		if serr != nil || param.WithinPercent <= 0 || param.WithinPercent > 100 {
+			err = errors.New("'withinPercent' must be positive number not more than 100")
+			return
+		}
+	}
+	param.Fields = event.MultiValueQueryStringParameters["fields"]
+	if (param.Side != 0 || param.WithinPercent > 0 || len(param.Fields) > 0) && rawFormatIn(param) {
+		// levels and fields can not be told from messages in raw format
+		err = errors.New("'side', 'withinPercent' and 'fields' can not be used with raw format")
+		return
+	}
+	// other exchanges to take snapshots of at the same time, in the form of `exchange:channel,channel`
+	if others, ok := event.MultiValueQueryStringParameters["exchanges"]; ok {
+		if param.State != nil || param.ExportState {
+			err = errors.New("state can not be used with multiple exchanges")
+			return
+		}
+		param.Exchanges = append(param.Exchanges, ExchangeChannels{Exchange: param.Exchange, Channels: param.Channels, NormalizedChannels: param.NormalizedChannels})
+		for _, other := range others {
+			fields := strings.SplitN(other, ":", 2)
+			if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+				err = errors.New("'exchanges' must be in the form of 'exchange:channel,channel'")
+				return
+			}
+			channels := strings.Split(fields[1], ",")
+			var normalized []string
+			if param.Naming == NamingNormalized {
+				normalized = channels
+				if channels, err = nativeChannels(fields[0], channels); err != nil {
+					return
+				}
+			}
+			if isBinance(fields[0]) {
+				if err = validateBinanceChannels(channels); err != nil {
+					return
+				}
+			}
+			param.Exchanges = append(param.Exchanges, ExchangeChannels{Exchange: fields[0], Channels: channels, NormalizedChannels: normalized})
+		}
+	}
+	if param.Latest && len(param.Exchanges) > 0 {
+		// the newest dataset differs among exchanges
+		err = errors.New("the latest target can not be used with 'exchanges'")
+		return
+	}
+	if param.DryRun && len(param.Exchanges) > 0 {
+		err = errors.New("'dryRun' can not be specified with 'exchanges'")
+		return
+	}
+	param.Nearest = event.QueryStringParameters["nearest"] == "true"
+	if param.Nearest && (len(param.Nanosecs) > 1 || len(param.Exchanges) > 0 || param.DryRun) {
+		err = errors.New("'nearest' can only be used with a single target, and not with 'exchanges' and 'dryRun'")
+		return
+	}
+	param.Output, ok = event.QueryStringParameters["output"]
+	if !ok {
+		param.Output = OutputTSV
+	}
+	if _, ok := ContentTypes[param.Output]; !ok {
+		err = errors.New("'output' must be one of 'tsv', 'json', 'csv', 'protobuf', 'arrow', 'parquet', 'ndjson' and 'table'")
+		return
+	}
+	if (param.Output == OutputCSV || param.Output == OutputArrow || param.Output == OutputParquet) && rawFormatIn(param) {
+		err = errors.New("csv, arrow and parquet output can not be used with raw format")
+		return
+	}
+	// clients not asking for a version get the latest one, those parsing by position should ask for it
+	param.SchemaVersion, serr = intParameter(event, "schemaVersion", CurrentSchemaVersion, CurrentSchemaVersion)
+	if serr != nil {
+		err = serr
+		return
+	}
+	if param.Output == OutputParquet {
+		if ExportBucket == "" {
+			err = errors.New("parquet output is not available")
+			return
+		}
+		param.ExportKey = event.QueryStringParameters["exportKey"]
+		if param.ExportKey == "" || strings.HasPrefix(param.ExportKey, "/") {
+			err = errors.New("'exportKey' must be specified as a relative key with parquet output")
+			return
+		}
+	}
+	if destination, ok := event.QueryStringParameters["destination"]; ok {
+		bucket, _, serr := parseDestination(destination)
+		if serr != nil {
+			err = serr
+			return
+		}
+		allowed := false
+		for _, b := range AllowedDestinationBuckets {
+			if b != "" && b == bucket {
+				allowed = true
+			}
+		}
+		if !allowed {
+			err = errors.New("'destination' is not allowed")
+			return
+		}
+		if param.Output == OutputParquet || len(param.Exchanges) > 0 {
+			err = errors.New("'destination' can not be used with parquet output and 'exchanges'")
+			return
+		}
+		if len(param.Formats) > 1 && !strings.Contains(destination, formatPlaceholder) {
+			// objects of formats would overwrite each other
+			err = errors.New("'destination' must have '{format}' in the key with multiple formats")
+			return
+		}
+		if param.FanOut && !strings.Contains(destination, symbolPlaceholder) {
+			// objects of symbols would overwrite each other
+			err = errors.New("'destination' must have '{symbol}' in the key with 'fanOut'")
+			return
+		}
+		param.Destination = destination
+		param.DestinationCompression = event.QueryStringParameters["destinationCompression"]
+		if param.DestinationCompression != "" && param.DestinationCompression != "gzip" {
+			err = errors.New("'destinationCompression' must be 'gzip'")
+			return
+		}
+	}
+	if param.Output != OutputTSV && (param.Diff || param.ReplayUntil != 0 || param.ExportState || len(param.Exchanges) > 0) {
+		err = errors.New("'diffFrom', 'since', 'replayUntil', 'exportState' and 'exchanges' can only be used with tsv output")
+		return
+	}
+	if param.Resumable && (param.Output != OutputTSV || param.State != nil || param.Diff || param.ReplayUntil != 0 || len(param.Exchanges) > 0 || len(param.Formats) > 1) {
+		err = errors.New("'resumable' and 'resume' can only be used with tsv output, and not with state, 'diffFrom', 'since', 'replayUntil', 'exchanges' and multiple formats")
+		return
+	}
+	if param.Stats && (param.Resumable || param.State != nil) {
+		// lines before the scan are not counted
+		err = errors.New("'stats' can not be used with state, 'resumable' and 'resume'")
+		return
+	}
+	if param.AsOf && (param.Diff || (param.Output != OutputTSV && param.Output != OutputJSON && param.Output != OutputNDJSON)) {
+		err = errors.New("'asOf' can only be used with tsv, json and ndjson output and not with 'diffFrom'")
+		return
+	}
+	// large results are split into pages, the rest is taken with the token returned with each page
+	param.PageBytes = int64(PageKB) * 1024
+	if pageStr, ok := event.QueryStringParameters["pageBytes"]; ok {
+		param.PageBytes, serr = strconv.ParseInt(pageStr, 10, 64)
+		if serr != nil || param.PageBytes <= 0 {
+			err = errors.New("'pageBytes' must be positive integer")
+			return
+		}
+		if !pagedOutput(param.Output) || param.Destination != "" || len(param.Formats) > 1 || param.FanOut {
+			err = errors.New("'pageBytes' can only be used with tsv, ndjson, csv and table output without 'destination', multiple formats and 'fanOut'")
+			return
+		}
+	} else if !pagedOutput(param.Output) || param.Destination != "" || len(param.Formats) > 1 || param.FanOut {
+		// results of the default page size are returned as they are if they can not be split
+		param.PageBytes = 0
+	}
+	if token, ok := event.QueryStringParameters["page"]; ok {
+		if _, serr := parsePageToken(token); serr != nil {
+			err = fmt.Errorf("invalid 'page': %v", serr)
+			return
+		}
+		if param.PageBytes == 0 {
+			err = errors.New("'pageBytes' must be specified with 'page'")
+			return
+		}
+		param.PageToken = token
+	}
+	// responses are compressed as asked by the parameter, or as the client accepts otherwise
+	if encoding, ok := event.QueryStringParameters["encoding"]; ok {
+		if encoding != EncodingGzip && encoding != EncodingDeflate && encoding != EncodingIdentity {
+			err = errors.New("'encoding' must be one of 'gzip', 'deflate' and 'identity'")
+			return
+		}
+		if encoding != EncodingIdentity {
+			param.Encoding = encoding
+		}
+	} else {
+		param.Encoding = negotiateEncoding(headerValue(event, "Accept-Encoding"))
+	}
+	// loaders can read timestamps without converting them, or take the target from the request instead of every line
+	param.TimestampUnit, ok = event.QueryStringParameters["timestampUnit"]
+	if !ok {
+		param.TimestampUnit = TimestampNanosecond
+	}
+	if param.TimestampUnit != TimestampNanosecond && param.TimestampUnit != TimestampMillisecond && param.TimestampUnit != TimestampRFC3339 {
+		err = errors.New("'timestampUnit' must be one of 'ns', 'ms' and 'rfc3339'")
+		return
+	}
+	switch event.QueryStringParameters["timestampColumn"] {
+	case "", "true":
+	case "false":
+		param.OmitTimestamp = true
+	default:
+		err = errors.New("'timestampColumn' must be either 'true' or 'false'")
+		return
+	}
+	if (param.TimestampUnit != TimestampNanosecond || param.OmitTimestamp) && param.Output != OutputTSV && param.Output != OutputCSV {
+		err = errors.New("'timestampUnit' and 'timestampColumn' can only be used with tsv and csv output")
+		return
+	}
+	if param.OmitTimestamp && (param.ReplayUntil != 0 || (len(param.Nanosecs) > 1 && !param.Diff) || len(param.Exchanges) > 0) {
+		// lines could not be told which snapshot they are of
+		err = errors.New("'timestampColumn' can not be 'false' with multiple targets, 'replayUntil' and 'exchanges'")
+		return
+	}
+	param.Parallel, serr = intParameter(event, "parallel", 0, maxParallel)
+	if serr != nil {
+		err = serr
+		return
+	}
+	if param.Parallel > 1 && (hasPattern(param.Channels) || param.IsolateErrors) {
+		err = errors.New("'parallel' can not be used with channel patterns or 'isolateErrors'")
+		return
+	}
+	// prefetch can be tuned per request, but not to use unlimited memory
+	param.PrefetchFiles, serr = intParameter(event, "prefetchFiles", PrefetchFiles, maxPrefetchFiles)
+	if serr != nil {
+		err = serr
+		return
+	}
+	param.ReadAheadBlocks, serr = intParameter(event, "readAheadBlocks", ReadAheadBlocks, maxReadAheadBlocks)
+	if serr != nil {
+		err = serr
+		return
+	}
+	param.FetchConcurrency, serr = intParameter(event, "fetchConcurrency", FetchConcurrency, maxFetchConcurrency)
+	if serr != nil {
+		err = serr
+		return
+	}
+	param.MaxLookbackMinutes = int64(DefaultMaxLookbackMinutes)
+	if lookbackStr, ok := event.QueryStringParameters["maxLookbackMinutes"]; ok {
+		param.MaxLookbackMinutes, serr = strconv.ParseInt(lookbackStr, 10, 64)
+		if serr != nil || param.MaxLookbackMinutes <= 0 {
+			err = errors.New("'maxLookbackMinutes' must be positive integer")
+			return
+		}
+	}
+	if tradesStr, ok := event.QueryStringParameters["recentTrades"]; ok {
+		param.RecentTrades, serr = strconv.Atoi(tradesStr)
+		if serr != nil || param.RecentTrades <= 0 {
+			err = errors.New("'recentTrades' must be positive integer")
+			return
+		}
+	}
+	if secondsStr, ok := event.QueryStringParameters["recentTradesSeconds"]; ok {
+		seconds, serr := strconv.ParseInt(secondsStr, 10, 64)
+		if serr != nil || seconds <= 0 {
+			err = errors.New("'recentTradesSeconds' must be positive integer")
+			return
+		}
+		param.RecentTradesNanosec = seconds * 1000000000
+	}
+	// requests scanning back many hours can be capped
+	if maxScanStr, ok := event.QueryStringParameters["maxScanBytes"]; ok {
+		param.MaxScanBytes, serr = strconv.ParseInt(maxScanStr, 10, 64)
+		if serr != nil || param.MaxScanBytes <= 0 {
+			err = errors.New("'maxScanBytes' must be positive integer")
+			return
+		}
+	}
+	// dataset can be read from other locations such as staging or archive
+	if bucket, ok := event.QueryStringParameters["datasetBucket"]; ok {
+		allowed := false
+		for _, b := range AllowedDatasetBuckets {
+			if b != "" && b == bucket {
+				allowed = true
+			}
+		}
+		if !allowed || DatasetDirectory != "" || GCSBucket != "" {
+			err = errors.New("'datasetBucket' is not allowed")
+			return
+		}
+		param.DatasetBucket = bucket
+	}
+	// buckets of other accounts are read with their roles
+	if role, ok := event.QueryStringParameters["datasetRoleArn"]; ok {
+		allowed := false
+		for _, r := range AllowedDatasetRoles {
+			if r != "" && r == role {
+				allowed = true
+			}
+		}
+		if !allowed || param.DatasetBucket == "" {
+			err = errors.New("'datasetRoleArn' is not allowed")
+			return
+		}
+		param.DatasetRoleARN = role
+	}
+	// mirrored buckets can be encrypted with keys managed by customers
+	param.DatasetKMSKeyARN = event.QueryStringParameters["datasetKmsKeyArn"]
+	if param.DatasetKMSKeyARN != "" && !isKMSKeyARN(param.DatasetKMSKeyARN) {
+		err = errors.New("'datasetKmsKeyArn' must be the ARN of a KMS key")
+		return
+	}
+	param.DatasetClientSideEncryption = event.QueryStringParameters["datasetClientSideEncryption"] == "true"
+	if encryptionOf(param).encrypted() && param.DatasetBucket == "" {
+		err = errors.New("'datasetKmsKeyArn' and 'datasetClientSideEncryption' can only be used with 'datasetBucket'")
+		return
+	}
+	param.DatasetPrefix = event.QueryStringParameters["datasetPrefix"]
+	if strings.HasPrefix(param.DatasetPrefix, "/") || strings.Contains(param.DatasetPrefix, "..") {
+		err = errors.New("'datasetPrefix' must be a relative prefix")
+		return
+	}
+	param.Compression, ok = event.QueryStringParameters["compression"]
+	if !ok {
+		param.Compression = "gzip"
+	}
+	if _, ok := extensions[param.Compression]; !ok {
+		err = errors.New("'compression' must be either 'gzip' or 'zstd'")
+		return
+	}
+	param.Granularity, ok = event.QueryStringParameters["granularity"]
+	if !ok {
+		param.Granularity = DatasetGranularity
+	}
+	if param.Granularity == "" {
+		param.Granularity = GranularityMinute
+	}
+	if param.Granularity != GranularityMinute && param.Granularity != GranularityHour && param.Granularity != GranularityMixed {
+		err = errors.New("'granularity' must be one of 'minute', 'hour' and 'mixed'")
+		return
+	}
+	if param.Latest && param.Granularity != GranularityMinute {
+		// the newest dataset is only searched among minute files
+		err = errors.New("the latest target can only be used with minute granularity")
+		return
+	}
+	param.StrictChannels = event.QueryStringParameters["strictChannels"] == "true"
+	if param.StrictChannels {
+		if len(param.Exchanges) > 0 {
+			err = errors.New("'strictChannels' can not be used with 'exchanges'")
+			return
+		}
+		if err = validateChannels(param); err != nil {
+			return
+		}
+	}
+	if err = validateFormats(param); err != nil {
+		return
+	}
+	if err = validateFanOut(param); err != nil {
+		return
+	}
+	err = validateParameter(param)
+	return
+}
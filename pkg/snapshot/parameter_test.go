@@ -0,0 +1,193 @@
+package snapshot
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func makeLambdaEvent(exchange string, channels []string, nanosec string, format string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		PathParameters: map[string]string{
+			"exchange": exchange,
+			"nanosec":  nanosec,
+		},
+		QueryStringParameters: map[string]string{
+			"format": format,
+		},
+		MultiValueQueryStringParameters: map[string][]string{
+			"channels": channels,
+		},
+		Headers: map[string]string{"Authorization": "Bearer demo"},
+	}
+}
+
+func TestMakeParameterRange(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.QueryStringParameters["endNanosec"] = "1598941205000000000"
+	event.QueryStringParameters["intervalNanosec"] = "60000000000"
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int64{1598941025000000000, 1598941085000000000, 1598941145000000000, 1598941205000000000}
+	if len(param.Nanosecs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, param.Nanosecs)
+	}
+	for i := range expected {
+		if param.Nanosecs[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, param.Nanosecs)
+		}
+	}
+}
+
+func TestMakeParameterRFC3339(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "2020-09-01T15:17:05+09:00", "json")
+	event.MultiValueQueryStringParameters["nanosecs"] = []string{"2020-09-01T06:18:05.5Z"}
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(param.Nanosecs) != 2 || param.Nanosecs[0] != 1598941025000000000 || param.Nanosecs[1] != 1598941085500000000 {
+		t.Fatalf("unexpected targets: %v", param.Nanosecs)
+	}
+	event.PathParameters["nanosec"] = "2020-09-01T06:17:05"
+	if _, err := ParseParameter(event); err == nil {
+		t.Fatal("expected time without timezone to be rejected")
+	}
+}
+
+func TestMakeParameterPrefetch(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.QueryStringParameters["prefetchFiles"] = "4"
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.PrefetchFiles != 4 || param.ReadAheadBlocks != ReadAheadBlocks {
+		t.Fatalf("unexpected prefetch: %d files, %d blocks", param.PrefetchFiles, param.ReadAheadBlocks)
+	}
+	event.QueryStringParameters["prefetchFiles"] = "1000"
+	if _, err := ParseParameter(event); err == nil {
+		t.Fatal("expected too large prefetch to be rejected")
+	}
+}
+
+func TestMakeParameterContentFilters(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.QueryStringParameters["side"] = "ask"
+	event.QueryStringParameters["withinPercent"] = "0.5"
+	event.MultiValueQueryStringParameters["fields"] = []string{"lastPrice"}
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.Side != sideAsk || param.WithinPercent != 0.5 || len(param.Fields) != 1 {
+		t.Fatalf("unexpected filters: %d %v %v", param.Side, param.WithinPercent, param.Fields)
+	}
+	event.QueryStringParameters["withinPercent"] = "200"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected withinPercent over 100 to be rejected")
+	}
+	delete(event.QueryStringParameters, "withinPercent")
+	event.QueryStringParameters["format"] = "raw"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected side to be rejected with raw format")
+	}
+}
+
+func TestMakeParameterIfNoneMatch(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.Headers = map[string]string{"if-none-match": `"abc"`}
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.IfNoneMatch != `"abc"` || !param.MadeAtOnce() {
+		t.Errorf("expected the tag of the header, got %s", param.IfNoneMatch)
+	}
+	event.QueryStringParameters["ifNoneMatch"] = `"def"`
+	if param, err = ParseParameter(event); err != nil || param.IfNoneMatch != `"def"` {
+		t.Errorf("expected the tag of the parameter, got %s %v", param.IfNoneMatch, err)
+	}
+}
+
+func TestMakeParameterGranularity(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.Granularity != GranularityMinute {
+		t.Errorf("expected minute granularity by default, got %s", param.Granularity)
+	}
+	event.QueryStringParameters["granularity"] = "hour"
+	if param, err = ParseParameter(event); err != nil || param.Granularity != GranularityHour {
+		t.Errorf("expected hour granularity, got %s %v", param.Granularity, err)
+	}
+	event.QueryStringParameters["granularity"] = "day"
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected unknown granularity to be rejected")
+	}
+}
+
+func TestMakeParameterDatasetLocation(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.QueryStringParameters["datasetPrefix"] = "archive/"
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	param.StartMinute = 26649017
+	if keys := datasetKeys(param); keys[0] != "archive/bitmex_26649017.gz" {
+		t.Fatalf("expected prefix to be prepended: %v", keys)
+	}
+	if checkpointStoreFor(param) != nil {
+		t.Fatal("expected checkpoints to be disabled for other locations")
+	}
+	event.QueryStringParameters["datasetBucket"] = "not-allowed"
+	if _, err := ParseParameter(event); err == nil {
+		t.Fatal("expected bucket not in the allowed list to be rejected")
+	}
+}
+
+func TestDefaultStartMinute(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 26649017 is the minute of the target
+	if minute := defaultStartMinute(param); minute != 26649010 {
+		t.Fatalf("expected to start at the beginning of 10 minutes, got %d", minute)
+	}
+	event.QueryStringParameters["maxLookbackMinutes"] = "3"
+	param, err = ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minute := defaultStartMinute(param); minute != 26649014 {
+		t.Fatalf("expected lookback to be limited, got %d", minute)
+	}
+}
+
+func TestMakeParameterSchemaVersion(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.SchemaVersion != CurrentSchemaVersion || ResponseSchemaVersion(param) != strconv.Itoa(CurrentSchemaVersion) {
+		t.Fatalf("expected the latest version if not requested, got %d", param.SchemaVersion)
+	}
+	for _, version := range []string{"0", strconv.Itoa(CurrentSchemaVersion + 1), "v1"} {
+		event.QueryStringParameters["schemaVersion"] = version
+		if _, err := ParseParameter(event); err == nil {
+			t.Errorf("expected version %s to be rejected", version)
+		}
+	}
+	if version := ResponseSchemaVersion(SnapshotParameter{}); version != strconv.Itoa(CurrentSchemaVersion) {
+		t.Errorf("expected parameters without the version to be of the latest one, got %s", version)
+	}
+}
@@ -0,0 +1,188 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// pipelineDepth is the number of files which can be waiting to be simulated.
+	pipelineDepth = 2
+	// pipelineBlockSize is the default size of a block of decompressed data passed to the simulation.
+	pipelineBlockSize = 1024 * 1024
+	// pipelineBlocks is the number of decompressed blocks which can be buffered for a file.
+	pipelineBlocks = 16
+)
+
+// pipelinedFile is a dataset file being downloaded and decompressed ahead of the simulation.
+type pipelinedFile struct {
+	name string
+	// reader is nil if the file did not exist
+	reader *blockReader
+}
+
+// blockReader reads decompressed blocks sent from the pipeline.
+type blockReader struct {
+	blocks chan []byte
+	// block is the whole of the block `current` is the rest of, returned to the pool after it is read
+	block   []byte
+	current []byte
+	// err is the error occurred while decompressing, only valid after `blocks` is closed
+	err error
+}
+
+func (r *blockReader) Read(p []byte) (n int, err error) {
+	for len(r.current) == 0 {
+		if r.block != nil {
+			// the content was copied out
+			putBlock(r.block)
+			r.block = nil
+		}
+		block, ok := <-r.blocks
+		if !ok {
+			if r.err != nil {
+				return 0, r.err
+			}
+			return 0, io.EOF
+		}
+		r.block = block
+		r.current = block
+	}
+	n = copy(p, r.current)
+	r.current = r.current[n:]
+	return
+}
+
+// Close does nothing, decompression is aborted when the whole pipeline is stopped.
+func (r *blockReader) Close() error {
+	return nil
+}
+
+// pipeline fetches and decompresses files from `source` in a goroutine so that the next file is prepared
+// while the current file is being simulated.
+// `depth` files are prepared ahead with `blocks` decompressed blocks buffered for each, defaults are used if they are not positive.
+// Closing `done` or `ctx` being done stops the pipeline, the returned channel is closed after it stopped.
+func pipeline(ctx context.Context, source DatasetSource, done <-chan struct{}, depth int, blocks int) <-chan pipelinedFile {
+	if depth <= 0 {
+		depth = pipelineDepth
+	}
+	if blocks <= 0 {
+		blocks = pipelineBlocks
+	}
+	files := make(chan pipelinedFile, depth)
+	go func() {
+		defer close(files)
+		for {
+			_, fetchSpan := StartSpan(ctx, "fetch")
+			body, ok := source.Next()
+			if !ok {
+				fetchSpan.End()
+				return
+			}
+			file := pipelinedFile{name: source.Name()}
+			fetchSpan.SetAttributes(attribute.String("file", file.name), attribute.Bool("missing", body == nil))
+			fetchSpan.End()
+			if r, ok := source.(refetcher); ok && body != nil {
+				// transient failures while reading are recovered by fetching the rest again
+				name := file.name
+				body = &retryingReader{ctx: ctx, name: name, body: body, refetch: func(offset int64) (io.ReadCloser, error) {
+					return r.Refetch(name, offset)
+				}}
+			}
+			if body != nil {
+				file.reader = &blockReader{blocks: make(chan []byte, blocks)}
+			}
+			select {
+			case files <- file:
+			case <-done:
+				if body != nil {
+					body.Close()
+				}
+				return
+			case <-ctx.Done():
+				if body != nil {
+					body.Close()
+				}
+				return
+			}
+			if body == nil {
+				continue
+			}
+			_, decompressSpan := StartSpan(ctx, "decompress", attribute.String("file", file.name))
+			err := decompressBlocks(ctx, body, file.reader.blocks, done)
+			EndSpan(decompressSpan, err)
+			file.reader.err = err
+			close(file.reader.blocks)
+			if err != nil && (err == errPipelineStopped || err == ctx.Err()) {
+				return
+			}
+		}
+	}()
+	return files
+}
+
+var errPipelineStopped = errors.New("pipeline stopped")
+
+// decompressBlocks decompresses `body` and sends decompressed data to `blocks`.
+func decompressBlocks(ctx context.Context, body io.ReadCloser, blocks chan<- []byte, done <-chan struct{}) (err error) {
+	defer func() {
+		serr := body.Close()
+		if serr != nil {
+			if err != nil {
+				err = fmt.Errorf("%v, original error was: %v", serr, err)
+			} else {
+				err = serr
+			}
+		}
+	}()
+	// errors reading body are of the storage rather than the dataset
+	greader, err := newDecompressor(storageReader{body})
+	if err != nil {
+		return
+	}
+	// to ensure closing readers
+	defer func() {
+		serr := greader.Close()
+		// the decompressor returns the error it had again on closing
+		if serr != nil && serr != err {
+			if err != nil {
+				err = fmt.Errorf("%v, original error was: %v", serr, err)
+			} else {
+				err = serr
+			}
+		}
+	}()
+	for {
+		block := getBlock(DecompressBlockKB * 1024)
+		// io.ReadFull is not used as it can not tell a short last block from a truncated file
+		n := 0
+		var serr error
+		for n < len(block) && serr == nil {
+			var m int
+			m, serr = greader.Read(block[n:])
+			n += m
+		}
+		if n > 0 {
+			select {
+			case blocks <- block[:n]:
+			case <-done:
+				return errPipelineStopped
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if n == 0 {
+			putBlock(block)
+		}
+		if serr == io.EOF {
+			return nil
+		}
+		if serr != nil {
+			return serr
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+func TestPipeline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// larger than a block to make sure blocks are concatenated in order
+	content := strings.Repeat("msg\t1\tchannel\t{}\n", pipelineBlockSize/8)
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	writer.Write([]byte(content))
+	writer.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.gz"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	files := pipeline(context.Background(), NewDirSource(dir, []string{"missing.gz", "a.gz", "a.gz"}), done, 0, 0)
+	missing := <-files
+	if missing.name != "missing.gz" || missing.reader != nil {
+		t.Fatalf("expected missing file, got %v", missing)
+	}
+	// files after one read to the end are also prepared
+	for i := 0; i < 2; i++ {
+		file, ok := <-files
+		if !ok {
+			t.Fatalf("expected file %d", i)
+		}
+		decompressed, err := ioutil.ReadAll(file.reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decompressed) != content {
+			t.Fatal("decompressed content differs")
+		}
+	}
+	if _, ok := <-files; ok {
+		t.Fatal("expected no more files")
+	}
+}
+
+func TestPipelineBlockSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(block int, gzipBlock int) { DecompressBlockKB, GzipBlockKB = block, gzipBlock }(DecompressBlockKB, GzipBlockKB)
+	DecompressBlockKB = 1
+	GzipBlockKB = 1
+	content := strings.Repeat("msg\t1\tchannel\t{}\n", 1000)
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	writer.Write([]byte(content))
+	writer.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.gz"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	file := <-pipeline(context.Background(), NewDirSource(dir, []string{"a.gz"}), done, 0, 0)
+	decompressed := new(bytes.Buffer)
+	for block := range file.reader.blocks {
+		if len(block) > 1024 {
+			t.Fatalf("expected blocks of 1KB at most, got %d bytes", len(block))
+		}
+		decompressed.Write(block)
+	}
+	if file.reader.err != nil {
+		t.Fatal(file.reader.err)
+	}
+	if decompressed.String() != content {
+		t.Fatal("decompressed content differs")
+	}
+}
+
+func TestFeedTruncatedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gzipped := func(content string) []byte {
+		buf := new(bytes.Buffer)
+		writer := gzip.NewWriter(buf)
+		writer.Write([]byte(content))
+		writer.Close()
+		return buf.Bytes()
+	}
+	first := gzipped("start\t100\twss://example.com\nmsg\t200\tchannelA\t{\"a\":1}\nmsg\t300\tchannelB\t{\"b\":2}\n")
+	// cut in the middle of the stream, the gzip trailer is lost
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.gz"), first[:len(first)-12], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.gz"), gzipped("start\t400\twss://example.com\nmsg\t500\tchannelC\t{\"c\":3}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec := &recordingSimulator{}
+	var sim simulator.Simulator = rec
+	f := &Feeder{Sim: &sim, SetNewSim: func(simp *simulator.Simulator) error { *simp = rec; return nil }, Targets: []int64{1000}, OnTarget: func(int64) error { return nil }}
+	done := make(chan struct{})
+	defer close(done)
+	for file := range pipeline(context.Background(), NewDirSource(dir, []string{"a.gz", "b.gz"}), done, 0, 0) {
+		if _, _, err := Feed(context.Background(), file.reader, f); err != nil {
+			t.Fatalf("%s: %v", file.name, err)
+		}
+	}
+	if f.truncatedFiles != 1 {
+		t.Fatalf("expected a truncated file to be recorded, got %d", f.truncatedFiles)
+	}
+	if len(rec.channels) == 0 || rec.channels[len(rec.channels)-1] != "channelC" {
+		t.Fatalf("expected the next file to be fed, got %v", rec.channels)
+	}
+}
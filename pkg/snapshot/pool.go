@@ -0,0 +1,144 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/klauspost/pgzip"
+)
+
+const (
+	// outputBufferSize is the capacity of the buffer snapshots are written to in memory.
+	outputBufferSize = 10 * 1024 * 1024
+	// maxPooledOutputSize is the capacity of output buffers beyond which they are not pooled,
+	// not to keep memory of a rare huge snapshot in warm processes.
+	maxPooledOutputSize = 64 * 1024 * 1024
+	// maxPooledLineSize is the capacity of line buffers beyond which they are not pooled.
+	maxPooledLineSize = 16 * 1024 * 1024
+)
+
+// poolBuffers is true if buffers are reused across snapshots, disabled to compare allocations in benchmarks.
+var poolBuffers = true
+
+// pools of buffers reused by snapshots in warm processes
+var (
+	outputPool = sync.Pool{New: func() interface{} { return bytes.NewBuffer(make([]byte, 0, outputBufferSize)) }}
+	linePool   = sync.Pool{New: func() interface{} { return []byte(nil) }}
+	blockPool  sync.Pool
+	readerPool sync.Pool
+	gzipPool   sync.Pool
+)
+
+// getOutputBuffer returns the empty buffer to write snapshots to.
+func getOutputBuffer() *bytes.Buffer {
+	if !poolBuffers {
+		return bytes.NewBuffer(make([]byte, 0, outputBufferSize))
+	}
+	return outputPool.Get().(*bytes.Buffer)
+}
+
+// putOutputBuffer returns `buffer` to the pool, bytes of it must not be used after it.
+func putOutputBuffer(buffer *bytes.Buffer) {
+	if !poolBuffers || buffer.Cap() > maxPooledOutputSize {
+		return
+	}
+	buffer.Reset()
+	outputPool.Put(buffer)
+}
+
+// getLineBuffer returns the buffer for long lines of the feeder.
+func getLineBuffer() []byte {
+	if !poolBuffers {
+		return nil
+	}
+	return linePool.Get().([]byte)[:0]
+}
+
+// putLineBuffer returns the buffer for long lines to the pool.
+func putLineBuffer(buf []byte) {
+	if !poolBuffers || buf == nil || cap(buf) > maxPooledLineSize {
+		return
+	}
+	linePool.Put(buf[:0])
+}
+
+// getBlock returns the block of `size` bytes to decompress dataset into.
+func getBlock(size int) []byte {
+	if poolBuffers {
+		// blocks of other sizes are of the configuration before a change in tests
+		if block, ok := blockPool.Get().([]byte); ok && cap(block) == size {
+			return block[:size]
+		}
+	}
+	return make([]byte, size)
+}
+
+// putBlock returns `block` whose content was copied out to the pool.
+func putBlock(block []byte) {
+	if !poolBuffers || cap(block) == 0 {
+		return
+	}
+	blockPool.Put(block[:cap(block)])
+}
+
+// getReader returns the reader of `r` buffered with `size` bytes.
+func getReader(r io.Reader, size int) *bufio.Reader {
+	if poolBuffers {
+		if reader, ok := readerPool.Get().(*bufio.Reader); ok && reader.Size() == size {
+			reader.Reset(r)
+			return reader
+		}
+	}
+	return bufio.NewReaderSize(r, size)
+}
+
+// putReader returns `reader` to the pool, lines read from it must not be used after it.
+func putReader(reader *bufio.Reader) {
+	if !poolBuffers {
+		return
+	}
+	// not to retain the underlying reader
+	reader.Reset(nil)
+	readerPool.Put(reader)
+}
+
+// gzipReader is the reader of gzip returned to the pool when closed, with blocks it decompresses into.
+type gzipReader struct {
+	*pgzip.Reader
+	blockSize int
+	blocks    int
+	closed    bool
+}
+
+// getGzipReader returns the reader decompressing gzip `r` in `blocks` blocks of `blockSize` in parallel.
+func getGzipReader(r io.Reader, blockSize int, blocks int) (io.ReadCloser, error) {
+	if poolBuffers {
+		if reader, ok := gzipPool.Get().(*gzipReader); ok && reader.blockSize == blockSize && reader.blocks == blocks {
+			reader.closed = false
+			if err := reader.Reset(r); err != nil {
+				reader.Close()
+				return nil, err
+			}
+			return reader, nil
+		}
+	}
+	z, err := pgzip.NewReaderN(r, blockSize, blocks)
+	if err != nil {
+		return nil, err
+	}
+	return &gzipReader{Reader: z, blockSize: blockSize, blocks: blocks}, nil
+}
+
+func (r *gzipReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	err := r.Reader.Close()
+	if poolBuffers {
+		gzipPool.Put(r)
+	}
+	return err
+}
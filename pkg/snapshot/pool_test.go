@@ -0,0 +1,75 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPooledSnapshots(t *testing.T) {
+	defer registerFixture()()
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{fixtureAt(80 * time.Second)},
+		Channels: []string{"book", "ticker"},
+		Format:   "raw",
+		Output:   OutputTSV,
+	}
+	expected := goldenFile(t, "raw_across_files")
+	var results [][]byte
+	for i := 0; i < 3; i++ {
+		ret, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, ret)
+	}
+	// results are not overwritten by later snapshots reusing buffers
+	for i, ret := range results {
+		if !bytes.Equal(ret, expected) {
+			t.Errorf("result %d differs:\n%s\nexpected:\n%s", i, ret, expected)
+		}
+	}
+}
+
+func TestBlockPool(t *testing.T) {
+	block := getBlock(1024)
+	putBlock(block[:10])
+	if reused := getBlock(1024); len(reused) != 1024 {
+		t.Errorf("expected block of 1024 bytes, got %d", len(reused))
+	}
+	putBlock(make([]byte, 512))
+	// blocks of other sizes are not reused
+	if block := getBlock(1024); len(block) != 1024 {
+		t.Errorf("expected block of 1024 bytes, got %d", len(block))
+	}
+}
+
+// BenchmarkSnapshotPool measures allocations of repeated snapshots with and without reusing buffers.
+func BenchmarkSnapshotPool(b *testing.B) {
+	defer registerFixture()()
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{fixtureAt(80 * time.Second)},
+		Channels: []string{"book", "ticker"},
+		Format:   "raw",
+		Output:   OutputTSV,
+	}
+	defer func(original bool) { poolBuffers = original }(poolBuffers)
+	for _, pooled := range []bool{false, true} {
+		name := "unpooled"
+		if pooled {
+			name = "pooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			poolBuffers = pooled
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys())); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
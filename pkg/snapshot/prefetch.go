@@ -0,0 +1,175 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// anchorSamples is the number of recent distances to anchors kept for each exchange.
+const anchorSamples = 64
+
+// minAnchorSamples is the number of distances which have to be observed before prefetch of the exchange is planned.
+const minAnchorSamples = 8
+
+// anchorPercentile is the percentile of distances prefetch is planned for, so that most requests do not wait for fetches.
+const anchorPercentile = 0.9
+
+// minPrefetchObjects is the minimum number of objects planned to be fetched ahead.
+const minPrefetchObjects = 2
+
+// anchorTracker tracks how far before the first target each exchange had the anchor, the start line or the initial state
+// lines of a file the book was built from. It is tracked in the process, and forgotten when it exits.
+type anchorTracker struct {
+	mu sync.Mutex
+	// distances are recent distances of exchanges in nanoseconds, the oldest first
+	distances map[string][]int64
+}
+
+func newAnchorTracker() *anchorTracker {
+	return &anchorTracker{distances: make(map[string][]int64)}
+}
+
+// anchors is the tracker of requests taken in this process.
+var anchors = newAnchorTracker()
+
+// observe records that the book of `exchange` was built from the anchor `distance` nanoseconds before the first target.
+func (t *anchorTracker) observe(exchange string, distance int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	distances := t.distances[exchange]
+	if len(distances) >= anchorSamples {
+		distances = append(distances[:0], distances[len(distances)-anchorSamples+1:]...)
+	}
+	t.distances[exchange] = append(distances, distance)
+}
+
+// distance returns the distance to the anchor requests of `exchange` typically have, `ok` is false if too few were observed.
+func (t *anchorTracker) distance(exchange string) (distance int64, ok bool) {
+	t.mu.Lock()
+	sorted := append([]int64(nil), t.distances[exchange]...)
+	t.mu.Unlock()
+	if len(sorted) < minAnchorSamples {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(float64(len(sorted)-1)*anchorPercentile)], true
+}
+
+// planPrefetch returns the number of objects to fetch ahead of the one being read among `files` for `param`,
+// or 0 if all of them are fetched at once. Objects from the anchor to the first target have to be read before
+// anything else, so exchanges having anchors far before targets are fetched further ahead than those having them often.
+func planPrefetch(param SnapshotParameter, files int) int {
+	distance, ok := anchors.distance(param.Exchange)
+	if !ok {
+		return 0
+	}
+	// the file of the anchor and files until the target
+	planned := int((distance+int64(time.Minute)-1)/int64(time.Minute)) + 1
+	if planned < minPrefetchObjects {
+		planned = minPrefetchObjects
+	}
+	if planned > MaxPrefetchObjects {
+		planned = MaxPrefetchObjects
+	}
+	if planned >= files {
+		return 0
+	}
+	return planned
+}
+
+// windowedSource is DatasetSource opening sources of `window` keys at a time, for sources which fetch
+// every key given at once such as S3GetConcurrent. The next window is opened as soon as the current one is read from,
+// so that objects of it are fetched while the current one is read.
+type windowedSource struct {
+	keys   []string
+	window int
+	open   func(keys []string) (DatasetSource, error)
+	// opened is the number of keys given to sources opened
+	opened  int
+	current DatasetSource
+	next    DatasetSource
+	// refetch is the first source opened if it can fetch files again, it is not replaced as it is used while files are read
+	refetch refetcher
+	// err is the error opening the next window, returned when the window is read, and errKey is the first key of it
+	err    error
+	errKey string
+	name   string
+}
+
+func newWindowedSource(keys []string, window int, open func(keys []string) (DatasetSource, error)) *windowedSource {
+	return &windowedSource{keys: keys, window: window, open: open}
+}
+
+// openNext opens the source of the next window if it is not opened yet and any key is left.
+func (s *windowedSource) openNext() {
+	if s.next != nil || s.err != nil || s.opened >= len(s.keys) {
+		return
+	}
+	end := s.opened + s.window
+	if end > len(s.keys) {
+		end = len(s.keys)
+	}
+	s.next, s.err = s.open(s.keys[s.opened:end])
+	if s.err != nil {
+		s.errKey = s.keys[s.opened]
+	} else if r, ok := s.next.(refetcher); ok && s.refetch == nil {
+		s.refetch = r
+	}
+	s.opened = end
+}
+
+func (s *windowedSource) Next() (io.ReadCloser, bool) {
+	for {
+		if s.current == nil {
+			s.openNext()
+			if s.err != nil {
+				// nothing after the window is read
+				err := s.err
+				s.err = nil
+				s.opened = len(s.keys)
+				s.name = s.errKey
+				return failedReader{err: err}, true
+			}
+			if s.next == nil {
+				return nil, false
+			}
+			s.current, s.next = s.next, nil
+			// objects of the next window are fetched while this one is read
+			s.openNext()
+		}
+		if body, ok := s.current.Next(); ok {
+			s.name = s.current.Name()
+			return body, true
+		}
+		s.current.Close()
+		s.current = nil
+	}
+}
+
+// Refetch fetches the file again with the first source opened, sources of windows fetch any file of the location.
+func (s *windowedSource) Refetch(name string, offset int64) (io.ReadCloser, error) {
+	if s.refetch == nil {
+		return nil, fmt.Errorf("%s can not be fetched again", name)
+	}
+	return s.refetch.Refetch(name, offset)
+}
+
+func (s *windowedSource) Name() string {
+	return s.name
+}
+
+func (s *windowedSource) Close() error {
+	var err error
+	for _, source := range []DatasetSource{s.current, s.next} {
+		if source == nil {
+			continue
+		}
+		if serr := source.Close(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
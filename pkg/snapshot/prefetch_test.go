@@ -0,0 +1,132 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAnchorTracker(t *testing.T) {
+	tracker := newAnchorTracker()
+	for i := 0; i < minAnchorSamples-1; i++ {
+		tracker.observe("bitmex", int64(time.Minute))
+	}
+	if _, ok := tracker.distance("bitmex"); ok {
+		t.Fatal("expected too few distances not to be used")
+	}
+	// old distances are forgotten
+	for i := 0; i < anchorSamples; i++ {
+		tracker.observe("bitmex", int64(i)*int64(time.Second))
+	}
+	if len(tracker.distances["bitmex"]) != anchorSamples {
+		t.Errorf("expected %d distances, got %d", anchorSamples, len(tracker.distances["bitmex"]))
+	}
+	distance, ok := tracker.distance("bitmex")
+	if !ok || distance != int64(56*time.Second) {
+		t.Errorf("unexpected distance %v", time.Duration(distance))
+	}
+}
+
+func TestPlanPrefetch(t *testing.T) {
+	defer func(tracker *anchorTracker) { anchors = tracker }(anchors)
+	anchors = newAnchorTracker()
+	param := SnapshotParameter{Exchange: "bitmex"}
+	if planned := planPrefetch(param, 60); planned != 0 {
+		t.Errorf("expected every object to be fetched without history, got %d", planned)
+	}
+	for i := 0; i < minAnchorSamples; i++ {
+		anchors.observe("bitmex", int64(10*time.Second))
+		anchors.observe("bitflyer", int64(9*time.Minute+30*time.Second))
+		anchors.observe("liquid", int64(6*time.Hour))
+	}
+	for exchange, expected := range map[string]int{"bitmex": minPrefetchObjects, "bitflyer": 11, "liquid": MaxPrefetchObjects} {
+		if planned := planPrefetch(SnapshotParameter{Exchange: exchange}, 60); planned != expected {
+			t.Errorf("%s: expected %d objects ahead, got %d", exchange, expected, planned)
+		}
+	}
+	if planned := planPrefetch(SnapshotParameter{Exchange: "bitflyer"}, 11); planned != 0 {
+		t.Errorf("expected the request having fewer objects to fetch every object, got %d", planned)
+	}
+}
+
+func TestWindowedSource(t *testing.T) {
+	keys := fixtureKeys()
+	var windows [][]string
+	source := newWindowedSource(keys, 2, func(keys []string) (DatasetSource, error) {
+		windows = append(windows, keys)
+		return NewDirSource(goldenDir, keys), nil
+	})
+	defer source.Close()
+	for i, key := range keys {
+		body, ok := source.Next()
+		if !ok {
+			t.Fatalf("expected %s", key)
+		}
+		body.Close()
+		if source.Name() != key {
+			t.Errorf("expected %s, got %s", key, source.Name())
+		}
+		// the next window is opened as soon as the current one is read
+		if i == 0 && len(windows) != 2 {
+			t.Errorf("expected the second window to be opened, got %v", windows)
+		}
+	}
+	if _, ok := source.Next(); ok {
+		t.Error("expected no more files")
+	}
+	if len(windows) != 2 || len(windows[1]) != len(keys)-2 {
+		t.Errorf("unexpected windows %v", windows)
+	}
+	// files of any window are fetched again by the source of the first one
+	whole, err := ioutil.ReadFile(filepath.Join(goldenDir, keys[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := source.Refetch(keys[2], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil || !bytes.Equal(rest, whole[10:]) {
+		t.Errorf("expected the rest of %s from 10, got %d bytes: %v", keys[2], len(rest), err)
+	}
+	failing := newWindowedSource(keys, 2, func(keys []string) (DatasetSource, error) {
+		return nil, errors.New("access denied")
+	})
+	body, ok := failing.Next()
+	if !ok {
+		t.Fatal("expected the error to be returned as a file")
+	}
+	if _, err := ioutil.ReadAll(body); !errors.Is(err, ErrStorage) || failing.Name() != keys[0] {
+		t.Errorf("expected the storage error of %s, got %v", failing.Name(), err)
+	}
+	if _, ok := failing.Next(); ok {
+		t.Error("expected nothing after the error")
+	}
+}
+
+func TestObserveAnchor(t *testing.T) {
+	defer registerFixture()()
+	defer func(tracker *anchorTracker) { anchors = tracker }(anchors)
+	anchors = newAnchorTracker()
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{fixtureAt(80 * time.Second), fixtureAt(150 * time.Second)},
+		Channels: []string{"book", "ticker"},
+		Format:   "raw",
+		Output:   OutputTSV,
+	}
+	if _, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys())); err != nil {
+		t.Fatal(err)
+	}
+	// only the first target, built from the start line of the first file
+	distances := anchors.distances[fixtureExchange]
+	if len(distances) != 1 || distances[0] != int64(79*time.Second) {
+		t.Errorf("unexpected distances %v", distances)
+	}
+}
@@ -0,0 +1,43 @@
+package snapshot
+
+import (
+	"log/slog"
+	"time"
+)
+
+// ProgressIntervalSeconds is the interval in seconds progress of scans is logged at, so that scans of many files
+// are not a black box until they finish.
+var ProgressIntervalSeconds = envInt("PROGRESS_INTERVAL_SECONDS", 10)
+
+// progressClock returns the current time for progress of scans, replaced in tests.
+var progressClock = time.Now
+
+// progressReporter logs progress of a scan when files are done after the interval since the last report.
+type progressReporter struct {
+	log      *slog.Logger
+	interval time.Duration
+	// planned is the number of files planned to be read, 0 if it is not known such as of sources following dataset
+	planned int
+	start   time.Time
+	last    time.Time
+}
+
+func newProgressReporter(log *slog.Logger, planned int) *progressReporter {
+	now := progressClock()
+	return &progressReporter{log: log, interval: time.Duration(ProgressIntervalSeconds) * time.Second, planned: planned, start: now, last: now}
+}
+
+// fileDone reports progress after `done` files are read or skipped, with bytes `scanned` in total
+// and `timestamp` of the last line applied.
+func (p *progressReporter) fileDone(done int, scanned int64, timestamp int64) {
+	now := progressClock()
+	if now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last = now
+	attrs := []any{"files_done", done, "scanned", scanned, "timestamp", timestamp, "elapsed", now.Sub(p.start)}
+	if p.planned > 0 {
+		attrs = append(attrs, "files_planned", p.planned)
+	}
+	p.log.Info("scan progress", attrs...)
+}
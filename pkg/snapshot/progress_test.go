@@ -0,0 +1,41 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestProgressReporter(t *testing.T) {
+	defer func(clock func() time.Time) { progressClock = clock }(progressClock)
+	now := time.Unix(0, fixtureAt(0))
+	progressClock = func() time.Time { return now }
+	buf := new(bytes.Buffer)
+	p := newProgressReporter(NewLogger(buf, "info"), 30)
+	now = now.Add(time.Second)
+	p.fileDone(1, 100, fixtureAt(time.Minute))
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing to be reported before the interval, got %s", buf)
+	}
+	now = now.Add(time.Duration(ProgressIntervalSeconds) * time.Second)
+	p.fileDone(2, 200, fixtureAt(2*time.Minute))
+	var logged struct {
+		Msg          string `json:"msg"`
+		FilesDone    int    `json:"files_done"`
+		FilesPlanned int    `json:"files_planned"`
+		Scanned      int64  `json:"scanned"`
+		Timestamp    int64  `json:"timestamp"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &logged); err != nil {
+		t.Fatalf("expected a progress log: %v: %s", err, buf)
+	}
+	if logged.Msg != "scan progress" || logged.FilesDone != 2 || logged.FilesPlanned != 30 || logged.Scanned != 200 || logged.Timestamp != fixtureAt(2*time.Minute) {
+		t.Errorf("unexpected progress %+v", logged)
+	}
+	buf.Reset()
+	p.fileDone(3, 300, fixtureAt(3*time.Minute))
+	if buf.Len() != 0 {
+		t.Errorf("expected the interval to start again from the last report, got %s", buf)
+	}
+}
@@ -0,0 +1,117 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsAddress is the address to serve Prometheus metrics at `/metrics` on, disabled if empty.
+// It is only useful when running as a long-lived service.
+var MetricsAddress = os.Getenv("METRICS_ADDR")
+
+// metrics of snapshots, labeled by exchange
+var (
+	snapshotRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "snapshot_requests_total",
+		Help: "Number of snapshots taken.",
+	}, []string{"exchange"})
+	snapshotErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "snapshot_errors_total",
+		Help: "Number of snapshots failed by the kind of the error.",
+	}, []string{"exchange", "kind"})
+	snapshotScannedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "snapshot_scanned_bytes_total",
+		Help: "Bytes of decompressed dataset scanned.",
+	}, []string{"exchange"})
+	snapshotFilesRead = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "snapshot_files_read_total",
+		Help: "Number of dataset files read.",
+	}, []string{"exchange"})
+	snapshotProcessSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "snapshot_simulator_seconds",
+		Help:    "Time the simulator took to process dataset for a snapshot.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"exchange"})
+	snapshotDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "snapshot_duration_seconds",
+		Help:    "Time taken to make a snapshot including reading dataset.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"exchange"})
+	snapshotSizeBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "snapshot_size_bytes",
+		Help:    "Size of the snapshot returned.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"exchange"})
+)
+
+// metrics of requests authorized by API keys in server mode, labeled by the name of the key
+var (
+	keyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "snapshot_key_requests_total",
+		Help: "Number of requests served with the API key.",
+	}, []string{"key"})
+	keyRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "snapshot_key_rejected_total",
+		Help: "Number of requests of the API key rejected by the reason.",
+	}, []string{"key", "reason"})
+	keyScannedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "snapshot_key_scanned_bytes_total",
+		Help: "Bytes of decompressed dataset scanned by requests of the API key.",
+	}, []string{"key"})
+)
+
+// errorKind returns the label of the kind of `err`.
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrBadParameter):
+		return "bad_parameter"
+	case errors.Is(err, ErrDatasetGap):
+		return "dataset_gap"
+	case errors.Is(err, ErrSimulator):
+		return "simulator"
+	case errors.Is(err, ErrStorage):
+		return "storage"
+	case errors.Is(err, ErrScanLimit):
+		return "scan_limit"
+	case errors.Is(err, ErrInsufficientHistory):
+		return "insufficient_history"
+	case errors.Is(err, ErrBookReset):
+		return "book_reset"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "other"
+	}
+}
+
+// observeSnapshot records metrics of a snapshot of `exchange` which took `elapsed` and resulted in `size` bytes or `err`.
+func observeSnapshot(exchange string, report Report, size int, elapsed time.Duration, err error) {
+	snapshotRequests.WithLabelValues(exchange).Inc()
+	snapshotScannedBytes.WithLabelValues(exchange).Add(float64(report.Scanned))
+	snapshotFilesRead.WithLabelValues(exchange).Add(float64(report.FilesRead))
+	snapshotProcessSeconds.WithLabelValues(exchange).Observe(report.ProcessTime.Seconds())
+	snapshotDurationSeconds.WithLabelValues(exchange).Observe(elapsed.Seconds())
+	if err != nil {
+		snapshotErrors.WithLabelValues(exchange, errorKind(err)).Inc()
+		return
+	}
+	snapshotSizeBytes.WithLabelValues(exchange).Observe(float64(size))
+}
+
+// serveMetrics serves Prometheus metrics on `addr` in background.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			Logger.Error("could not serve metrics", "address", addr, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,39 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestErrorKind(t *testing.T) {
+	cases := map[error]string{
+		newSnapshotError(ErrStorage, fmt.Errorf("reset")):                 "storage",
+		fmt.Errorf("wrapped: %w", newSnapshotError(ErrBadParameter, nil)): "bad_parameter",
+		context.Canceled:      "canceled",
+		fmt.Errorf("unknown"): "other",
+	}
+	for err, expected := range cases {
+		if kind := errorKind(err); kind != expected {
+			t.Errorf("%v: expected %s, got %s", err, expected, kind)
+		}
+	}
+}
+
+func TestObserveSnapshot(t *testing.T) {
+	report := Report{Scanned: 1000, FilesRead: 2, ProcessTime: time.Second}
+	observeSnapshot("test-observe", report, 10, 2*time.Second, nil)
+	observeSnapshot("test-observe", report, 0, time.Second, newSnapshotError(ErrSimulator, fmt.Errorf("broken")))
+	if v := testutil.ToFloat64(snapshotScannedBytes.WithLabelValues("test-observe")); v != 2000 {
+		t.Fatalf("expected 2000 bytes scanned, got %v", v)
+	}
+	if v := testutil.ToFloat64(snapshotErrors.WithLabelValues("test-observe", "simulator")); v != 1 {
+		t.Fatalf("expected an error to be counted, got %v", v)
+	}
+	if v := testutil.ToFloat64(snapshotRequests.WithLabelValues("test-observe")); v != 2 {
+		t.Fatalf("expected 2 requests, got %v", v)
+	}
+}
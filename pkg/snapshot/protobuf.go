@@ -0,0 +1,77 @@
+package snapshot
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// field numbers in proto/snapshot.proto
+const (
+	pbResponseExchange  = 1
+	pbResponseSnapshots = 2
+
+	pbSnapshotTimestamp = 1
+	pbSnapshotChannels  = 2
+
+	pbChannelChannel  = 1
+	pbChannelLevels   = 2
+	pbChannelMessages = 3
+
+	pbLevelSide  = 1
+	pbLevelPrice = 2
+	pbLevelSize  = 3
+
+	pbSideBid = 1
+	pbSideAsk = 2
+)
+
+// appendProtobufHeader appends the fields of `SnapshotResponse` preceding snapshots.
+func appendProtobufHeader(b []byte, exchange string) []byte {
+	b = protowire.AppendTag(b, pbResponseExchange, protowire.BytesType)
+	return protowire.AppendString(b, exchange)
+}
+
+// appendProtobufSnapshot appends `entries` at `nanosec` as an element of `SnapshotResponse.snapshots`.
+// Since repeated fields can be concatenated, snapshots are appended one by one as they are taken.
+func appendProtobufSnapshot(b []byte, nanosec int64, entries []entry) []byte {
+	snapshot := protowire.AppendTag(nil, pbSnapshotTimestamp, protowire.VarintType)
+	snapshot = protowire.AppendVarint(snapshot, uint64(nanosec))
+	// group entries by channel keeping the order of appearance
+	var channels []string
+	byChannel := make(map[string][]entry)
+	for _, e := range entries {
+		if _, ok := byChannel[e.channel]; !ok {
+			channels = append(channels, e.channel)
+		}
+		byChannel[e.channel] = append(byChannel[e.channel], e)
+	}
+	for _, channel := range channels {
+		cs := protowire.AppendTag(nil, pbChannelChannel, protowire.BytesType)
+		cs = protowire.AppendString(cs, channel)
+		for _, e := range byChannel[channel] {
+			side, price, size, ok := parseLevel(e.message)
+			if !ok {
+				cs = protowire.AppendTag(cs, pbChannelMessages, protowire.BytesType)
+				cs = protowire.AppendBytes(cs, e.message)
+				continue
+			}
+			l := protowire.AppendTag(nil, pbLevelSide, protowire.VarintType)
+			if side == sideBid {
+				l = protowire.AppendVarint(l, pbSideBid)
+			} else {
+				l = protowire.AppendVarint(l, pbSideAsk)
+			}
+			l = protowire.AppendTag(l, pbLevelPrice, protowire.Fixed64Type)
+			l = protowire.AppendFixed64(l, math.Float64bits(price))
+			l = protowire.AppendTag(l, pbLevelSize, protowire.Fixed64Type)
+			l = protowire.AppendFixed64(l, math.Float64bits(size))
+			cs = protowire.AppendTag(cs, pbChannelLevels, protowire.BytesType)
+			cs = protowire.AppendBytes(cs, l)
+		}
+		snapshot = protowire.AppendTag(snapshot, pbSnapshotChannels, protowire.BytesType)
+		snapshot = protowire.AppendBytes(snapshot, cs)
+	}
+	b = protowire.AppendTag(b, pbResponseSnapshots, protowire.BytesType)
+	return protowire.AppendBytes(b, snapshot)
+}
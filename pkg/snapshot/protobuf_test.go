@@ -0,0 +1,63 @@
+package snapshot
+
+import (
+	"math"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// consumeFields decodes a message into the map of field numbers to their values.
+func consumeFields(t *testing.T, b []byte) map[protowire.Number][][]byte {
+	fields := make(map[protowire.Number][][]byte)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		m := protowire.ConsumeFieldValue(num, typ, b)
+		if m < 0 {
+			t.Fatal(protowire.ParseError(m))
+		}
+		value := b[:m]
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(value)
+		}
+		fields[num] = append(fields[num], value)
+		b = b[m:]
+	}
+	return fields
+}
+
+func TestAppendProtobufSnapshot(t *testing.T) {
+	b := appendProtobufHeader(nil, "bitmex")
+	b = appendProtobufSnapshot(b, 100, []entry{
+		{channel: "book", message: []byte(`{"side":"Buy","price":1.5,"size":2}`)},
+		{channel: "book", message: []byte(`{"side":"Sell","price":2.5,"size":3}`)},
+	})
+	b = appendProtobufSnapshot(b, 200, []entry{{channel: "status", message: []byte(`{"online":true}`)}})
+	response := consumeFields(t, b)
+	if string(response[pbResponseExchange][0]) != "bitmex" || len(response[pbResponseSnapshots]) != 2 {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	snapshot := consumeFields(t, response[pbResponseSnapshots][0])
+	if ts, _ := protowire.ConsumeVarint(snapshot[pbSnapshotTimestamp][0]); ts != 100 {
+		t.Fatalf("expected timestamp 100, got %d", ts)
+	}
+	channel := consumeFields(t, snapshot[pbSnapshotChannels][0])
+	if string(channel[pbChannelChannel][0]) != "book" || len(channel[pbChannelLevels]) != 2 {
+		t.Fatalf("unexpected channel snapshot: %v", channel)
+	}
+	ask := consumeFields(t, channel[pbChannelLevels][1])
+	side, _ := protowire.ConsumeVarint(ask[pbLevelSide][0])
+	price, _ := protowire.ConsumeFixed64(ask[pbLevelPrice][0])
+	if side != pbSideAsk || math.Float64frombits(price) != 2.5 {
+		t.Fatalf("unexpected level: side %d, price %f", side, math.Float64frombits(price))
+	}
+	snapshot = consumeFields(t, response[pbResponseSnapshots][1])
+	channel = consumeFields(t, snapshot[pbSnapshotChannels][0])
+	if string(channel[pbChannelMessages][0]) != `{"online":true}` {
+		t.Fatalf("unexpected messages: %v", channel)
+	}
+}
@@ -0,0 +1,175 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// rangeChunkSize is the size in bytes of a chunk of the last dataset file fetched at once.
+// The last file is usually not read through as the scan stops at the latest target,
+// so it is fetched in chunks not to download the rest of it.
+const rangeChunkSize = 1024 * 1024
+
+// rangeFetcher fetches `length` bytes of an object from `offset`, less bytes are returned at the end of the object.
+// `size` is the size of the whole object.
+type rangeFetcher func(ctx context.Context, offset int64, length int64) (body io.ReadCloser, size int64, err error)
+
+// chunkedReader reads an object by fetching chunks of it one after another as they are read.
+// Closing it cancels the chunk being fetched, and chunks after it are never fetched.
+type chunkedReader struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	name      string
+	fetch     rangeFetcher
+	chunkSize int64
+	// body is the chunk being read, nil if the next chunk is not fetched yet
+	body io.ReadCloser
+	// offset is the number of bytes read, `chunkRead` is of them in the current chunk
+	offset    int64
+	chunkRead int64
+	size      int64
+	failures  int
+}
+
+// openChunked fetches the first chunk of the object `name` so that objects which do not exist are known before reading.
+func openChunked(ctx context.Context, name string, chunkSize int64, fetch rangeFetcher) (*chunkedReader, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &chunkedReader{ctx: ctx, cancel: cancel, name: name, fetch: fetch, chunkSize: chunkSize}
+	if err := r.next(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return r, nil
+}
+
+// next fetches the chunk from `r.offset`, retrying on transient errors.
+func (r *chunkedReader) next() (err error) {
+	for attempt := 0; ; attempt++ {
+		r.body, r.size, err = r.fetch(r.ctx, r.offset, r.chunkSize)
+		if err == nil {
+			r.chunkRead = 0
+			return
+		}
+		if attempt >= maxRetries || !isTransient(err) || r.ctx.Err() != nil {
+			return
+		}
+		LoggerFrom(r.ctx).Warn("retrying to fetch chunk", "object", r.name, "offset", r.offset, "attempt", attempt, "error", err)
+		if err = backoff(r.ctx, attempt); err != nil {
+			return
+		}
+	}
+}
+
+func (r *chunkedReader) Read(p []byte) (n int, err error) {
+	for {
+		if r.body == nil {
+			if r.offset >= r.size {
+				return 0, io.EOF
+			}
+			if err = r.ctx.Err(); err != nil {
+				// closed
+				return 0, err
+			}
+			if err = r.next(); err != nil {
+				return 0, err
+			}
+		}
+		n, err = r.body.Read(p)
+		r.offset += int64(n)
+		r.chunkRead += int64(n)
+		if n > 0 {
+			r.failures = 0
+		}
+		if err == io.EOF {
+			r.body.Close()
+			r.body = nil
+			if r.chunkRead == 0 && r.offset < r.size {
+				// the storage returned nothing of the rest of the object
+				return n, io.ErrUnexpectedEOF
+			}
+			err = nil
+		}
+		if err != nil && n == 0 && r.failures < maxRetries && isTransient(err) && r.ctx.Err() == nil {
+			// fetch the rest of the chunk again
+			LoggerFrom(r.ctx).Warn("retrying to read chunk", "object", r.name, "offset", r.offset, "attempt", r.failures, "error", err)
+			r.body.Close()
+			r.body = nil
+			if err = backoff(r.ctx, r.failures); err != nil {
+				return
+			}
+			r.failures++
+			continue
+		}
+		if n > 0 || err != nil {
+			return
+		}
+	}
+}
+
+func (r *chunkedReader) Close() error {
+	r.cancel()
+	if r.body == nil {
+		return nil
+	}
+	body := r.body
+	r.body = nil
+	return body.Close()
+}
+
+// s3RangeFetcher returns the fetcher of ranges of the object `key` in `bucket`, which has to be encrypted as `encryption`.
+func s3RangeFetcher(client objectGetter, encryption datasetEncryption, bucket string, key string) rangeFetcher {
+	return func(ctx context.Context, offset int64, length int64) (io.ReadCloser, int64, error) {
+		obj, err := checkedGetObject(ctx, client, encryption, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidRange" && offset == 0 {
+				// no range of empty objects is satisfiable
+				return ioutil.NopCloser(bytes.NewReader(nil)), 0, nil
+			}
+			return nil, 0, err
+		}
+		size, err := contentRangeSize(aws.StringValue(obj.ContentRange))
+		if err != nil {
+			obj.Body.Close()
+			return nil, 0, err
+		}
+		return obj.Body, size, nil
+	}
+}
+
+// contentRangeSize returns the size of the whole object in the value of Content-Range header such as `bytes 0-99/1234`.
+func contentRangeSize(contentRange string) (int64, error) {
+	slash := strings.LastIndexByte(contentRange, '/')
+	if !strings.HasPrefix(contentRange, "bytes ") || slash < 0 {
+		return 0, fmt.Errorf("unexpected content range: '%s'", contentRange)
+	}
+	size, err := strconv.ParseInt(contentRange[slash+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected content range: '%s'", contentRange)
+	}
+	return size, nil
+}
+
+// gcsRangeFetcher returns the fetcher of ranges of `obj`.
+func gcsRangeFetcher(obj *storage.ObjectHandle) rangeFetcher {
+	return func(ctx context.Context, offset int64, length int64) (io.ReadCloser, int64, error) {
+		reader, err := obj.NewRangeReader(ctx, offset, length)
+		if err != nil {
+			return nil, 0, err
+		}
+		return reader, reader.Attrs.Size, nil
+	}
+}
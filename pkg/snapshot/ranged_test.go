@@ -0,0 +1,114 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"syscall"
+	"testing"
+)
+
+// fakeObject serves ranges of `content`, `fails` fetches are failed with a transient error first.
+type fakeObject struct {
+	content []byte
+	fetched int64
+	fetches int
+	fails   int
+}
+
+func (o *fakeObject) fetch(ctx context.Context, offset int64, length int64) (io.ReadCloser, int64, error) {
+	o.fetches++
+	if o.fails > 0 {
+		o.fails--
+		return nil, 0, syscall.ECONNRESET
+	}
+	end := offset + length
+	if end > int64(len(o.content)) {
+		end = int64(len(o.content))
+	}
+	o.fetched += end - offset
+	return ioutil.NopCloser(bytes.NewReader(o.content[offset:end])), int64(len(o.content)), nil
+}
+
+func TestChunkedReader(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	for _, chunkSize := range []int64{1, 3, 10, 20, 64} {
+		object := &fakeObject{content: content}
+		reader, err := openChunked(context.Background(), "object", chunkSize, object.fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(read, content) {
+			t.Errorf("chunk size %d: expected %s, got %s", chunkSize, content, read)
+		}
+		expected := (int64(len(content)) + chunkSize - 1) / chunkSize
+		if int64(object.fetches) != expected {
+			t.Errorf("chunk size %d: expected %d fetches, got %d", chunkSize, expected, object.fetches)
+		}
+	}
+	// objects of zero bytes are read as empty
+	reader, err := openChunked(context.Background(), "empty", 4, (&fakeObject{}).fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, err := ioutil.ReadAll(reader); err != nil || len(read) != 0 {
+		t.Errorf("expected empty object, got %q %v", read, err)
+	}
+}
+
+func TestChunkedReaderClose(t *testing.T) {
+	object := &fakeObject{content: bytes.Repeat([]byte("x"), 100)}
+	reader, err := openChunked(context.Background(), "object", 10, object.fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(reader, make([]byte, 15)); err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the third chunk and after are never transferred
+	if object.fetched != 20 {
+		t.Errorf("expected 20 bytes fetched, got %d", object.fetched)
+	}
+	if _, err := reader.Read(make([]byte, 1)); err == nil {
+		t.Error("expected closed reader not to fetch more")
+	}
+}
+
+func TestChunkedReaderRetry(t *testing.T) {
+	object := &fakeObject{content: []byte("0123456789"), fails: 2}
+	reader, err := openChunked(context.Background(), "object", 4, object.fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(read) != "0123456789" {
+		t.Errorf("unexpected content after retries: %s", read)
+	}
+	object = &fakeObject{content: []byte("0123456789"), fails: maxRetries + 1}
+	if _, err := openChunked(context.Background(), "object", 4, object.fetch); err == nil {
+		t.Error("expected error after retries")
+	}
+}
+
+func TestContentRangeSize(t *testing.T) {
+	if size, err := contentRangeSize("bytes 0-99/1234"); err != nil || size != 1234 {
+		t.Errorf("expected 1234, got %d %v", size, err)
+	}
+	for _, invalid := range []string{"", "bytes 0-99/*", "items 0-1/2"} {
+		if _, err := contentRangeSize(invalid); err == nil {
+			t.Errorf("expected '%s' to be invalid", invalid)
+		}
+	}
+}
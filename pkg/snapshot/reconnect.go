@@ -0,0 +1,51 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// reconnectChannel is the channel of the entry telling the connection started again before the target.
+const reconnectChannel = "$reconnect"
+
+// ways to handle start lines in the scan after the simulator had state, which discard all of it
+const (
+	// ReconnectsIgnore takes snapshots of the state built since the last start line
+	ReconnectsIgnore = "ignore"
+	// ReconnectsFlag adds an entry in `reconnectChannel` to snapshots taken after start lines
+	ReconnectsFlag = "flag"
+	// ReconnectsError fails if the connection started again before the target
+	ReconnectsError = "error"
+)
+
+// reconnectReport is the message of the entry in `reconnectChannel`.
+type reconnectReport struct {
+	// Reconnects are timestamps of start lines which discarded the state, in the order of time
+	Reconnects []int64 `json:"reconnects"`
+	// SinceReconnect is nanoseconds from the last start line to the target
+	SinceReconnect int64 `json:"sinceReconnect"`
+}
+
+// reconnectEntries returns the entry telling start lines at `reconnects` discarded the state before `nanosec`,
+// or nothing if there is no such start line.
+func reconnectEntries(nanosec int64, reconnects []int64) ([]entry, error) {
+	if len(reconnects) == 0 {
+		return nil, nil
+	}
+	message, err := json.Marshal(reconnectReport{Reconnects: reconnects, SinceReconnect: nanosec - reconnects[len(reconnects)-1]})
+	if err != nil {
+		return nil, err
+	}
+	return []entry{{channel: reconnectChannel, message: message}}, nil
+}
+
+// reconnectError returns the error telling the book was reset at the last of `reconnects` before `nanosec`,
+// or nil if there is no such start line.
+func reconnectError(nanosec int64, reconnects []int64) error {
+	if len(reconnects) == 0 {
+		return nil
+	}
+	last := reconnects[len(reconnects)-1]
+	return newSnapshotError(ErrBookReset, fmt.Errorf("connection started again at %d, %v before %d", last, time.Duration(nanosec-last), nanosec))
+}
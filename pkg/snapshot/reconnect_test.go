@@ -0,0 +1,58 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconnectsFlag(t *testing.T) {
+	defer registerFixture()()
+	param := SnapshotParameter{
+		Exchange:   fixtureExchange,
+		Nanosecs:   []int64{fixtureAt(80 * time.Second), fixtureAt(150 * time.Second)},
+		Channels:   []string{"book", "ticker"},
+		Format:     "raw",
+		Output:     OutputTSV,
+		Reconnects: ReconnectsFlag,
+	}
+	ret, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flagged []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(ret), "\n"), "\n") {
+		columns := strings.SplitN(line, "\t", 3)
+		if len(columns) == 3 && columns[1] == reconnectChannel {
+			flagged = append(flagged, columns[0]+" "+columns[2])
+		}
+	}
+	// the first start line is not a reconnect
+	expected := fmt.Sprintf(`[%d {"reconnects":[%d],"sinceReconnect":%d}]`, fixtureAt(150*time.Second), fixtureAt(90*time.Second), int64(time.Minute))
+	if fmt.Sprint(flagged) != expected {
+		t.Errorf("expected %s, got %v", expected, flagged)
+	}
+}
+
+func TestReconnectsError(t *testing.T) {
+	defer registerFixture()()
+	param := SnapshotParameter{
+		Exchange:   fixtureExchange,
+		Nanosecs:   []int64{fixtureAt(80 * time.Second)},
+		Channels:   []string{"book", "ticker"},
+		Format:     "raw",
+		Output:     OutputTSV,
+		Reconnects: ReconnectsError,
+	}
+	if _, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys())); err != nil {
+		t.Fatalf("expected no error before the reconnect, got %v", err)
+	}
+	param.Nanosecs = []int64{fixtureAt(150 * time.Second)}
+	_, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if !errors.Is(err, ErrBookReset) {
+		t.Errorf("expected the book reset error, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/exchangedataset/streamcommons/formatter"
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// SimulatorFactory makes the simulator of `channels` of an exchange.
+type SimulatorFactory func(channels []string) (simulator.Simulator, error)
+
+var (
+	simulatorsMu sync.RWMutex
+	// simulators are factories of simulators registered by exchanges
+	simulators = make(map[string]SimulatorFactory)
+)
+
+// RegisterSimulator makes simulators of `exchange` be made by `factory` instead of streamcommons.
+// It is for exchanges whose dataset is in the same line format but not known to streamcommons,
+// and should be called before any snapshot is taken, such as in `init`. It panics if `exchange` is already registered.
+func RegisterSimulator(exchange string, factory SimulatorFactory) {
+	simulatorsMu.Lock()
+	defer simulatorsMu.Unlock()
+	if factory == nil {
+		panic("snapshot: simulator factory is nil for " + exchange)
+	}
+	if _, ok := simulators[exchange]; ok {
+		panic("snapshot: simulator is registered twice for " + exchange)
+	}
+	simulators[exchange] = factory
+}
+
+// registeredSimulator returns the simulator of `channels` of `exchange` made by the registered factory,
+// or of streamcommons if nothing is registered for it. Derivatives and ticker channels of streamcommons exchanges
+// are taken snapshot of by derivativesSimulator and tickerSimulator.
+func registeredSimulator(exchange string, channels []string) (simulator.Simulator, error) {
+	simulatorsMu.RLock()
+	factory, ok := simulators[exchange]
+	simulatorsMu.RUnlock()
+	if !ok {
+		derivatives, others := splitDerivativesChannels(exchange, channels)
+		tickers, others := splitTickerChannels(exchange, others)
+		if len(derivatives) == 0 && len(tickers) == 0 {
+			return simulator.GetSimulator(exchange, channels)
+		}
+		var sim simulator.Simulator
+		if len(others) > 0 {
+			var err error
+			if sim, err = simulator.GetSimulator(exchange, others); err != nil {
+				return nil, err
+			}
+		}
+		if len(tickers) > 0 {
+			sim = newTickerSimulator(tickers, sim)
+		}
+		if len(derivatives) == 0 {
+			return sim, nil
+		}
+		return newDerivativesSimulator(exchange, derivatives, sim), nil
+	}
+	sim, err := factory(channels)
+	if err != nil {
+		return nil, fmt.Errorf("simulator of %s: %v", exchange, err)
+	}
+	return sim, nil
+}
+
+// FormatterFactory makes the formatter of `channels` of `exchange`.
+type FormatterFactory func(exchange string, channels []string) (formatter.Formatter, error)
+
+// formatChainSeparator separates formats of stages in `format` parameter, such as `json+internal`.
+const formatChainSeparator = "+"
+
+var (
+	formattersMu sync.RWMutex
+	// formatters are factories of formatters registered by formats
+	formatters = make(map[string]FormatterFactory)
+)
+
+// RegisterFormatter makes `format` parameter of `format` be formatted by formatters made by `factory`.
+// A registered format can also be a stage after another format, such as `json+internal`,
+// where formatters of later stages are given channels and messages formatted by the previous stage.
+// It should be called before any snapshot is taken, such as in `init`. It panics if `format` is already registered.
+func RegisterFormatter(format string, factory FormatterFactory) {
+	formattersMu.Lock()
+	defer formattersMu.Unlock()
+	if factory == nil {
+		panic("snapshot: formatter factory is nil for " + format)
+	}
+	if format == "" || format == "raw" || strings.Contains(format, formatChainSeparator) {
+		panic("snapshot: invalid format to register: " + format)
+	}
+	if _, ok := formatters[format]; ok {
+		panic("snapshot: formatter is registered twice for " + format)
+	}
+	formatters[format] = factory
+}
+
+// formatStage returns the formatter of a stage of `format`, registered or of streamcommons.
+func formatStage(exchange string, channels []string, format string) (formatter.Formatter, error) {
+	formattersMu.RLock()
+	factory, ok := formatters[format]
+	formattersMu.RUnlock()
+	if !ok {
+		derivatives, others := splitDerivativesChannels(exchange, channels)
+		if len(derivatives) == 0 {
+			return streamcommonsFormatter(exchange, channels, format)
+		}
+		form := &derivativesFormatter{exchange: exchange}
+		if len(others) > 0 {
+			var err error
+			if form.Formatter, err = streamcommonsFormatter(exchange, others, format); err != nil {
+				return nil, err
+			}
+		}
+		return form, nil
+	}
+	form, err := factory(exchange, channels)
+	if err != nil {
+		return nil, fmt.Errorf("formatter of %s: %v", format, err)
+	}
+	return form, nil
+}
+
+// newFormatter returns the formatter of `format` for `channels` of `exchange`.
+// Stages in `format` are chained, a stage of `raw` first passes messages as they are to the next stage.
+func newFormatter(exchange string, channels []string, format string) (formatter.Formatter, error) {
+	stages := strings.Split(format, formatChainSeparator)
+	if len(stages) == 1 {
+		return formatStage(exchange, channels, format)
+	}
+	chain := &chainFormatter{}
+	for i, stage := range stages {
+		if stage == "raw" && i == 0 {
+			chain.stages = append(chain.stages, rawFormatter{})
+			continue
+		}
+		form, err := formatStage(exchange, channels, stage)
+		if err != nil {
+			return nil, err
+		}
+		chain.stages = append(chain.stages, form)
+	}
+	return chain, nil
+}
+
+// rawFormatter returns messages as they are.
+type rawFormatter struct{}
+
+func (rawFormatter) FormatMessage(channel string, line []byte) ([]formatter.Result, error) {
+	return []formatter.Result{{Channel: channel, Message: line}}, nil
+}
+
+func (rawFormatter) IsSupported(channel string) bool {
+	return true
+}
+
+// chainFormatter formats messages with the first stage and each result again with the next stage.
+type chainFormatter struct {
+	stages []formatter.Formatter
+}
+
+func (f *chainFormatter) FormatMessage(channel string, line []byte) ([]formatter.Result, error) {
+	results := []formatter.Result{{Channel: channel, Message: line}}
+	for _, stage := range f.stages {
+		next := make([]formatter.Result, 0, len(results))
+		for _, result := range results {
+			formatted, err := stage.FormatMessage(result.Channel, result.Message)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, formatted...)
+		}
+		results = next
+	}
+	return results, nil
+}
+
+// IsSupported is of the first stage, later stages are given what it produced.
+func (f *chainFormatter) IsSupported(channel string) bool {
+	return f.stages[0].IsSupported(channel)
+}
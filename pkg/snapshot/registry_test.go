@@ -0,0 +1,100 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/exchangedataset/streamcommons/formatter"
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+func TestRegisterSimulator(t *testing.T) {
+	RegisterSimulator("niche", func(channels []string) (simulator.Simulator, error) {
+		return &countingSimulator{channels: channels, counts: make(map[string]int)}, nil
+	})
+	defer func() {
+		simulatorsMu.Lock()
+		delete(simulators, "niche")
+		simulatorsMu.Unlock()
+	}()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected registering twice to panic")
+			}
+		}()
+		RegisterSimulator("niche", func(channels []string) (simulator.Simulator, error) { return nil, nil })
+	}()
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	writer.Write([]byte("start\t100\twss://niche.example.com\nmsg\t200\ttrade\t{}\nmsg\t300\ttrade\t{}\nmsg\t2000\ttrade\t{}\n"))
+	writer.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, "niche_0.gz"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	param := SnapshotParameter{Exchange: "niche", Nanosecs: []int64{1000}, Channels: []string{"trade"}, Format: "raw", Output: OutputTSV}
+	ret, _, err := Snapshot(context.Background(), param, NewDirSource(dir, []string{"niche_0.gz"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(ret), "trade\t2") {
+		t.Errorf("expected 2 messages of trade from the registered simulator, got %q", ret)
+	}
+	// exchanges not registered are of streamcommons
+	if _, err := registeredSimulator("unknown", []string{"trade"}); err == nil {
+		t.Error("expected unknown exchange to fail")
+	}
+}
+
+// wrappingFormatter wraps messages in `{"<name>":...}`.
+type wrappingFormatter struct {
+	name string
+}
+
+func (f wrappingFormatter) FormatMessage(channel string, line []byte) ([]formatter.Result, error) {
+	return []formatter.Result{{Channel: channel, Message: []byte(`{"` + f.name + `":` + string(line) + `}`)}}, nil
+}
+
+func (f wrappingFormatter) IsSupported(channel string) bool {
+	return true
+}
+
+func TestRegisterFormatter(t *testing.T) {
+	for _, name := range []string{"inner", "outer"} {
+		name := name
+		RegisterFormatter(name, func(exchange string, channels []string) (formatter.Formatter, error) {
+			return wrappingFormatter{name}, nil
+		})
+	}
+	defer func() {
+		formattersMu.Lock()
+		delete(formatters, "inner")
+		delete(formatters, "outer")
+		formattersMu.Unlock()
+	}()
+	form, err := newFormatter("bitmex", []string{"trade"}, "raw+inner+outer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := form.FormatMessage("trade", []byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || string(results[0].Message) != `{"outer":{"inner":1}}` {
+		t.Errorf("unexpected results: %v", results)
+	}
+	if _, err := newFormatter("bitmex", []string{"trade"}, "inner+unknown"); err == nil {
+		t.Error("expected unknown stage to fail")
+	}
+}
@@ -0,0 +1,126 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// RequestIDHeader is the header of request IDs given by clients or proxies, and returned in responses.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength is the longest request ID accepted from clients.
+const maxRequestIDLength = 128
+
+// RequestID returns `given` if it can be used as a request ID, or a new request ID otherwise.
+// Given IDs must be printable ASCII without spaces so that they can be put in logs and headers as they are.
+func RequestID(given string) string {
+	valid := given != "" && len(given) <= maxRequestIDLength
+	for i := 0; valid && i < len(given); i++ {
+		valid = given[i] > ' ' && given[i] < 0x7f
+	}
+	if valid {
+		return given
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// unique enough in logs of the process
+		return hex.EncodeToString([]byte(time.Now().Format(time.RFC3339Nano)))
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestRecord is what a request asked for and how it went, logged once at the end of each request.
+type RequestRecord struct {
+	ID       string
+	Exchange string
+	Nanosec  string
+	Query    map[string][]string
+	// Status is the status code of the response
+	Status  int
+	Scanned int64
+	// Size is the bytes of the result returned or written
+	Size int64
+	// Error is the error of the snapshot, empty if it succeeded
+	Error string
+	start time.Time
+}
+
+// NewRequestRecord returns the record of the request `event` identified by `id`.
+func NewRequestRecord(id string, event events.APIGatewayProxyRequest) *RequestRecord {
+	query := event.MultiValueQueryStringParameters
+	if query == nil {
+		query = make(map[string][]string)
+		for name, value := range event.QueryStringParameters {
+			query[name] = []string{value}
+		}
+	}
+	return &RequestRecord{
+		ID:       id,
+		Exchange: event.PathParameters["exchange"],
+		Nanosec:  event.PathParameters["nanosec"],
+		Query:    query,
+		start:    time.Now(),
+	}
+}
+
+type requestRecordKey struct{}
+
+// WithRequestRecord returns the context carrying `record` of the request, which is filled as the request is served.
+func WithRequestRecord(ctx context.Context, record *RequestRecord) context.Context {
+	return context.WithValue(ctx, requestRecordKey{}, record)
+}
+
+// RequestRecordFrom returns the record of the request `ctx` is of, or nil if it does not have one.
+func RequestRecordFrom(ctx context.Context) *RequestRecord {
+	record, _ := ctx.Value(requestRecordKey{}).(*RequestRecord)
+	return record
+}
+
+// Observe records the result of the snapshot which scanned as in `report`, it does nothing on nil record.
+func (r *RequestRecord) Observe(report Report, err error) {
+	if r == nil {
+		return
+	}
+	r.Scanned = report.Scanned
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// outcome returns how the request went, `ok`, `not_found`, `rejected` for errors of the request or `failed`.
+func (r *RequestRecord) outcome() string {
+	switch {
+	case r.Status >= 500 || (r.Status < 400 && r.Error != ""):
+		// errors while streaming come after the status
+		return "failed"
+	case r.Status == 404:
+		return "not_found"
+	case r.Status >= 400:
+		return "rejected"
+	default:
+		return "ok"
+	}
+}
+
+// Log writes the record to the log, it does nothing on nil record.
+func (r *RequestRecord) Log() {
+	if r == nil {
+		return
+	}
+	Logger.Info("request",
+		"request_id", r.ID,
+		"path_exchange", r.Exchange,
+		"path_nanosec", r.Nanosec,
+		"query", r.Query,
+		"status", r.Status,
+		"outcome", r.outcome(),
+		"scanned", r.Scanned,
+		"size", r.Size,
+		"error", r.Error,
+		"elapsed", time.Now().Sub(r.start),
+	)
+}
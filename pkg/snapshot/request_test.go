@@ -0,0 +1,57 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	if id := RequestID("abc-123"); id != "abc-123" {
+		t.Errorf("valid ID should be kept, got %s", id)
+	}
+	for _, given := range []string{"", "has space", "new\nline", strings.Repeat("x", maxRequestIDLength+1)} {
+		id := RequestID(given)
+		if id == given || len(id) != 32 {
+			t.Errorf("%q: expected a new ID, got %q", given, id)
+		}
+	}
+	if RequestID("") == RequestID("") {
+		t.Error("new IDs should differ")
+	}
+}
+
+func TestRequestRecord(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.MultiValueQueryStringParameters = nil
+	record := NewRequestRecord("id", event)
+	if record.Exchange != "bitmex" || record.Nanosec != "1598941025000000000" || record.Query["format"][0] != "json" {
+		t.Errorf("unexpected record: %+v", record)
+	}
+	record.Observe(Report{Scanned: 100}, nil)
+	for _, c := range []struct {
+		status   int
+		err      string
+		expected string
+	}{
+		{200, "", "ok"},
+		{200, "failed while streaming", "failed"},
+		{404, "", "not_found"},
+		{400, "bad", "rejected"},
+		{502, "storage", "failed"},
+	} {
+		record.Status, record.Error = c.status, c.err
+		if outcome := record.outcome(); outcome != c.expected {
+			t.Errorf("%d %q: expected %s, got %s", c.status, c.err, c.expected, outcome)
+		}
+	}
+	// records are optional
+	var none *RequestRecord
+	none.Observe(Report{}, errors.New("ignored"))
+	none.Log()
+	ctx := WithRequestRecord(context.Background(), record)
+	if RequestRecordFrom(ctx) != record || RequestRecordFrom(context.Background()) != nil {
+		t.Error("record should be carried by the context")
+	}
+}
@@ -0,0 +1,150 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// resultCacheMinAge is how old the latest target must be for the result to be cached,
+// dataset of recent time could be still being written.
+const resultCacheMinAge = time.Hour
+
+// cachedResult is a finished snapshot response stored in resultStore.
+type cachedResult struct {
+	result        []byte
+	scanned       int64
+	lastTimestamp int64
+	token         string
+	fullSnapshot  bool
+}
+
+// resultStore stores finished snapshot results to serve the same requests without scanning dataset.
+type resultStore interface {
+	// Get returns the result stored for `key`, `ok` is false if there is not.
+	Get(key string) (result cachedResult, ok bool, err error)
+	Put(key string, result cachedResult) error
+}
+
+// results is the store of snapshot results, results are not cached if nil.
+var results resultStore
+
+// resultCacheKey returns the key identifying the result of `param`, `ok` is false if the result should not be cached.
+func resultCacheKey(param SnapshotParameter, now time.Time) (key string, ok bool) {
+	if param.Output == OutputParquet || param.Destination != "" {
+		// result is written somewhere else
+		return "", false
+	}
+	if len(param.Formats) > 1 || param.FanOut {
+		// the boundary of parts is not cached
+		return "", false
+	}
+	if param.Audit {
+		// orderbooks of REST APIs change
+		return "", false
+	}
+	if param.ScanReport {
+		// files and times of the scan are not cached, the report is of the scan made for the request
+		return "", false
+	}
+	if param.Nanosecs[len(param.Nanosecs)-1] > now.Add(-resultCacheMinAge).UnixNano() {
+		return "", false
+	}
+	// every parameter changing the result must be included
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s\n%v\n%v\n%s\n%s\n%s\n", param.Exchange, param.Nanosecs, param.Channels, param.Format, param.Output, param.Compression)
+	fmt.Fprintf(hash, "%v\n%v\n%d\n%v\n%v\n%v\n", param.PostFilter, param.Symbols, param.Depth, param.Bucket, param.MetricsBps, param.Exchanges)
+	// prices of buckets are formatted with the decimals of the bucket given, 0.5 and 0.50 are the same bucket
+	fmt.Fprintf(hash, "%d\n", param.BucketDecimals)
+	fmt.Fprintf(hash, "%v\n%v\n%d\n%v\n%v\n", param.Diff, param.ExportState, param.ReplayUntil, param.IsolateErrors, param.Partial)
+	fmt.Fprintf(hash, "%s\n%s\n%d\n", param.DatasetBucket, param.DatasetPrefix, param.StateNanosec)
+	fmt.Fprintf(hash, "%s\n%s\n%v\n", param.DatasetRoleARN, param.DatasetKMSKeyARN, param.DatasetClientSideEncryption)
+	fmt.Fprintf(hash, "%d\n%v\n%v\n%v\n%s\n", param.MaxLookbackMinutes, param.Verify, param.ChannelOrder, param.AsOf, param.MissingChannels)
+	fmt.Fprintf(hash, "%v\n", param.Exclusive)
+	fmt.Fprintf(hash, "%s\n%v\n", param.Naming, param.NormalizedChannels)
+	fmt.Fprintf(hash, "%d\n%v\n%v\n", param.Side, param.WithinPercent, param.Fields)
+	fmt.Fprintf(hash, "%s\n%s\n", param.Granularity, param.SinceHash)
+	fmt.Fprintf(hash, "%s\n%v\n", param.TimestampUnit, param.OmitTimestamp)
+	fmt.Fprintf(hash, "%d\n%d\n", param.RecentTrades, param.RecentTradesNanosec)
+	fmt.Fprintf(hash, "%d\n", param.SchemaVersion)
+	fmt.Fprintf(hash, "%v\n%s\n%v\n", param.Stats, param.Reconnects, param.CrossValidate)
+	// malformed lines skipped and initial states applied again leave the result different
+	fmt.Fprintf(hash, "%v\n%v\n", param.Lenient, param.AllStates)
+	hash.Write(param.State)
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// s3ResultStore is resultStore saving results as S3 objects under `prefix`.
+type s3ResultStore struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+func newS3ResultStore(bucket string, prefix string) (*s3ResultStore, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return &s3ResultStore{client: s3.New(sess), bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *s3ResultStore) key(key string) string {
+	return fmt.Sprintf("%s/%s", s.prefix, key)
+}
+
+func (s *s3ResultStore) Get(key string) (result cachedResult, ok bool, err error) {
+	obj, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			err = nil
+		}
+		return
+	}
+	defer obj.Body.Close()
+	result.result, err = ioutil.ReadAll(obj.Body)
+	if err != nil {
+		return
+	}
+	// metadata is needed to make the same response
+	result.scanned, err = strconv.ParseInt(aws.StringValue(obj.Metadata["Scanned"]), 10, 64)
+	if err != nil {
+		return
+	}
+	result.lastTimestamp, err = strconv.ParseInt(aws.StringValue(obj.Metadata["Last-Timestamp"]), 10, 64)
+	if err != nil {
+		return
+	}
+	// results cached before tokens do not have them
+	result.token = aws.StringValue(obj.Metadata["Token"])
+	result.fullSnapshot = aws.StringValue(obj.Metadata["Full-Snapshot"]) == "true"
+	ok = true
+	return
+}
+
+func (s *s3ResultStore) Put(key string, result cachedResult) error {
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+		Body:   bytes.NewReader(result.result),
+		Metadata: map[string]*string{
+			"Scanned":        aws.String(strconv.FormatInt(result.scanned, 10)),
+			"Last-Timestamp": aws.String(strconv.FormatInt(result.lastTimestamp, 10)),
+			"Token":          aws.String(result.token),
+			"Full-Snapshot":  aws.String(strconv.FormatBool(result.fullSnapshot)),
+		},
+	})
+	return err
+}
@@ -0,0 +1,120 @@
+package snapshot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResultCacheKey(t *testing.T) {
+	now := time.Unix(0, 1598941025000000000).Add(2 * time.Hour)
+	param := SnapshotParameter{Exchange: "bitmex", Nanosecs: []int64{1598941025000000000}, Channels: []string{"orderBookL2"}, Format: "json"}
+	key, ok := resultCacheKey(param, now)
+	if !ok {
+		t.Fatal("expected to be cacheable")
+	}
+	other := param
+	other.Depth = 10
+	if otherKey, _ := resultCacheKey(other, now); otherKey == key {
+		t.Fatal("expected different parameter to have different key")
+	}
+	other = param
+	other.MissingChannels = MissingChannelsEmpty
+	if otherKey, _ := resultCacheKey(other, now); otherKey == key {
+		t.Fatal("expected output options to change the key")
+	}
+	other = param
+	other.Lenient = true
+	if otherKey, _ := resultCacheKey(other, now); otherKey == key {
+		t.Fatal("expected lenient mode to change the key")
+	}
+	other = param
+	other.AllStates = true
+	if otherKey, _ := resultCacheKey(other, now); otherKey == key {
+		t.Fatal("expected initial states applied again to change the key")
+	}
+	other = param
+	// prefetch does not change the result
+	other.PrefetchFiles = 4
+	if otherKey, _ := resultCacheKey(other, now); otherKey != key {
+		t.Fatal("expected the same key")
+	}
+	other = param
+	other.ScanReport = true
+	if _, ok := resultCacheKey(other, now); ok {
+		t.Fatal("expected the result with the report not to be cached")
+	}
+	if _, ok := resultCacheKey(param, time.Unix(0, param.Nanosecs[0])); ok {
+		t.Fatal("expected recent result not to be cached")
+	}
+}
+
+func TestResultCacheKeyFields(t *testing.T) {
+	now := time.Unix(0, 1598941025000000000).Add(2 * time.Hour)
+	param := SnapshotParameter{Exchange: "bitmex", Nanosecs: []int64{1598941025000000000}, Channels: []string{"orderBookL2"}, Format: "json"}
+	key, _ := resultCacheKey(param, now)
+	// every field changing the result
+	changes := map[string]func(*SnapshotParameter){
+		"Exchange":                    func(p *SnapshotParameter) { p.Exchange = "bitflyer" },
+		"Nanosecs":                    func(p *SnapshotParameter) { p.Nanosecs = []int64{1598941025000000001} },
+		"Channels":                    func(p *SnapshotParameter) { p.Channels = []string{"trade"} },
+		"Format":                      func(p *SnapshotParameter) { p.Format = "raw" },
+		"Output":                      func(p *SnapshotParameter) { p.Output = OutputTSV },
+		"Compression":                 func(p *SnapshotParameter) { p.Compression = "gzip" },
+		"PostFilter":                  func(p *SnapshotParameter) { p.PostFilter = []string{"orderBookL2_XBTUSD"} },
+		"Symbols":                     func(p *SnapshotParameter) { p.Symbols = []string{"XBTUSD"} },
+		"Depth":                       func(p *SnapshotParameter) { p.Depth = 10 },
+		"Bucket":                      func(p *SnapshotParameter) { p.Bucket = 0.5 },
+		"BucketDecimals":              func(p *SnapshotParameter) { p.BucketDecimals = 2 },
+		"MetricsBps":                  func(p *SnapshotParameter) { p.MetricsBps = 10 },
+		"Exchanges":                   func(p *SnapshotParameter) { p.Exchanges = []ExchangeChannels{{Exchange: "bitflyer"}} },
+		"Diff":                        func(p *SnapshotParameter) { p.Diff = true },
+		"ExportState":                 func(p *SnapshotParameter) { p.ExportState = true },
+		"ReplayUntil":                 func(p *SnapshotParameter) { p.ReplayUntil = 1598941026000000000 },
+		"IsolateErrors":               func(p *SnapshotParameter) { p.IsolateErrors = true },
+		"Partial":                     func(p *SnapshotParameter) { p.Partial = true },
+		"DatasetBucket":               func(p *SnapshotParameter) { p.DatasetBucket = "archive" },
+		"DatasetPrefix":               func(p *SnapshotParameter) { p.DatasetPrefix = "archive/" },
+		"StateNanosec":                func(p *SnapshotParameter) { p.StateNanosec = 1598941024000000000 },
+		"DatasetRoleARN":              func(p *SnapshotParameter) { p.DatasetRoleARN = "arn:aws:iam::123456789012:role/dataset" },
+		"DatasetKMSKeyARN":            func(p *SnapshotParameter) { p.DatasetKMSKeyARN = "arn:aws:kms:us-east-1:123456789012:key/dataset" },
+		"DatasetClientSideEncryption": func(p *SnapshotParameter) { p.DatasetClientSideEncryption = true },
+		"MaxLookbackMinutes":          func(p *SnapshotParameter) { p.MaxLookbackMinutes = 30 },
+		"Verify":                      func(p *SnapshotParameter) { p.Verify = true },
+		"ChannelOrder":                func(p *SnapshotParameter) { p.ChannelOrder = []string{"orderBookL2"} },
+		"AsOf":                        func(p *SnapshotParameter) { p.AsOf = true },
+		"MissingChannels":             func(p *SnapshotParameter) { p.MissingChannels = MissingChannelsEmpty },
+		"Exclusive":                   func(p *SnapshotParameter) { p.Exclusive = true },
+		"Naming":                      func(p *SnapshotParameter) { p.Naming = NamingNormalized },
+		"NormalizedChannels":          func(p *SnapshotParameter) { p.NormalizedChannels = []string{"book:BTC/USD"} },
+		"Side":                        func(p *SnapshotParameter) { p.Side = sideBid },
+		"WithinPercent":               func(p *SnapshotParameter) { p.WithinPercent = 1 },
+		"Fields":                      func(p *SnapshotParameter) { p.Fields = []string{"price"} },
+		"Granularity":                 func(p *SnapshotParameter) { p.Granularity = GranularityHour },
+		"SinceHash":                   func(p *SnapshotParameter) { p.SinceHash = "0123456789abcdef" },
+		"TimestampUnit":               func(p *SnapshotParameter) { p.TimestampUnit = "ms" },
+		"OmitTimestamp":               func(p *SnapshotParameter) { p.OmitTimestamp = true },
+		"RecentTrades":                func(p *SnapshotParameter) { p.RecentTrades = 10 },
+		"RecentTradesNanosec":         func(p *SnapshotParameter) { p.RecentTradesNanosec = 60000000000 },
+		"SchemaVersion":               func(p *SnapshotParameter) { p.SchemaVersion = 2 },
+		"Stats":                       func(p *SnapshotParameter) { p.Stats = true },
+		"Reconnects":                  func(p *SnapshotParameter) { p.Reconnects = ReconnectsError },
+		"CrossValidate":               func(p *SnapshotParameter) { p.CrossValidate = true },
+		"Lenient":                     func(p *SnapshotParameter) { p.Lenient = true },
+		"AllStates":                   func(p *SnapshotParameter) { p.AllStates = true },
+		"State":                       func(p *SnapshotParameter) { p.State = []byte("state") },
+	}
+	keys := map[string]string{key: "the parameter"}
+	for field, change := range changes {
+		other := param
+		change(&other)
+		otherKey, ok := resultCacheKey(other, now)
+		if !ok {
+			t.Errorf("%s: expected to be cacheable", field)
+			continue
+		}
+		if same, ok := keys[otherKey]; ok {
+			t.Errorf("%s: expected the key to differ from %s", field, same)
+		}
+		keys[otherKey] = field
+	}
+}
@@ -0,0 +1,98 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// resumeTTL is how long scans stopped before the deadline can be resumed.
+const resumeTTL = time.Hour
+
+// resumeMargin is how long before the deadline of the request resumable scans stop at,
+// enough to save the state and return snapshots already taken.
+const resumeMargin = 30 * time.Second
+
+// resumeIDLength is the number of random bytes of resume tokens.
+const resumeIDLength = 16
+
+// resumes is the store of states of stopped scans, shared among instances in S3 if `ResultCacheBucket` is configured.
+var resumes resultStore = newMemoryResultStore(resumeTTL)
+
+// resumeRecord is what a resume token refers to, the state of the simulator at where the scan stopped
+// and targets not reached yet.
+type resumeRecord struct {
+	Nanosecs     []int64 `json:"nanosecs"`
+	StateNanosec int64   `json:"stateNanosec"`
+	State        []byte  `json:"state"`
+}
+
+// parseResumeToken returns an error if `token` is not a token saveResume returns.
+func parseResumeToken(token string) error {
+	if id, err := hex.DecodeString(token); err != nil || len(id) != resumeIDLength {
+		return errors.New("invalid resume token")
+	}
+	return nil
+}
+
+// resumeAt returns the time the scan of `param` stops at to be resumed, zero if it is not resumable
+// or the request has no deadline.
+func resumeAt(ctx context.Context, param SnapshotParameter) time.Time {
+	if !param.Resumable {
+		return time.Time{}
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return time.Time{}
+	}
+	return deadline.Add(-resumeMargin)
+}
+
+// saveResume saves the state of `sim` having lines until `nanosec` applied with `targets` not reached yet,
+// and returns the token to resume the scan with.
+func saveResume(sim *startRecorder, nanosec int64, targets []int64) (string, error) {
+	snapshots, err := sim.TakeSnapshot()
+	if err != nil {
+		return "", err
+	}
+	state, err := encodeCheckpoint(nanosec, sim.startLine, snapshots)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(resumeRecord{Nanosecs: targets, StateNanosec: nanosec, State: state})
+	if err != nil {
+		return "", err
+	}
+	id := make([]byte, resumeIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(id)
+	if err := resumes.Put(token, cachedResult{result: encoded}); err != nil {
+		return "", newSnapshotError(ErrStorage, err)
+	}
+	return token, nil
+}
+
+// applyResume replaces targets and the state of `param` with those saved for `param.ResumeToken`,
+// so that the scan continues from where it stopped.
+func applyResume(param *SnapshotParameter) error {
+	cached, ok, err := resumes.Get(param.ResumeToken)
+	if err != nil {
+		return newSnapshotError(ErrStorage, err)
+	}
+	if !ok {
+		return newSnapshotError(ErrBadParameter, errors.New("resume token has expired, request the snapshot again"))
+	}
+	var record resumeRecord
+	if err := json.Unmarshal(cached.result, &record); err != nil {
+		return newSnapshotError(ErrStorage, err)
+	}
+	param.Nanosecs = record.Nanosecs
+	param.State = record.State
+	param.StateNanosec = record.StateNanosec
+	return nil
+}
@@ -0,0 +1,55 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResume(t *testing.T) {
+	defer registerFixture()()
+	defer func(dir string) { DatasetDirectory = dir }(DatasetDirectory)
+	DatasetDirectory = goldenDir
+	param := SnapshotParameter{
+		Exchange:    fixtureExchange,
+		Nanosecs:    []int64{fixtureAt(50 * time.Second), fixtureAt(150 * time.Second)},
+		Channels:    []string{"book", "ticker"},
+		Format:      "raw",
+		Output:      OutputTSV,
+		Compression: "gzip",
+	}
+	expected, _, err := Take(context.Background(), param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the deadline is within the margin, the scan stops as soon as the simulator has the state to save
+	ctx, cancel := context.WithTimeout(context.Background(), resumeMargin/2)
+	defer cancel()
+	param.Resumable = true
+	stopped, report, err := Take(ctx, param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ResumeToken == "" || report.Partial != PartialResumable || report.FilesRead != 1 {
+		t.Fatalf("expected the scan to stop after the first file, got %+v", report)
+	}
+	if len(stopped) != 0 {
+		t.Errorf("expected no target to be reached in the first file, got:\n%s", stopped)
+	}
+	param.ResumeToken = report.ResumeToken
+	resumed, report, err := Take(context.Background(), param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ResumeToken != "" || report.Partial != "" {
+		t.Errorf("expected the resumed scan to finish, got %+v", report)
+	}
+	if !bytes.Equal(resumed, expected) {
+		t.Errorf("expected the same snapshots as of a scan not stopped:\n%s\nexpected:\n%s", resumed, expected)
+	}
+	param.ResumeToken = "00112233445566778899aabbccddeeff"
+	if _, _, err := Take(context.Background(), param); err == nil {
+		t.Error("expected unknown token to be rejected")
+	}
+}
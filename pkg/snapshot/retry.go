@@ -0,0 +1,105 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// maxRetries is the number of times a failed read is retried before giving up.
+	maxRetries = 3
+	// retryBackoff is the wait before the first retry, doubled for each retry.
+	retryBackoff = 200 * time.Millisecond
+)
+
+// refetcher is implemented by DatasetSource which can fetch a file again from the middle of it.
+type refetcher interface {
+	// Refetch returns the body of the file `name` from `offset` in bytes.
+	Refetch(name string, offset int64) (io.ReadCloser, error)
+}
+
+// transientCodes are the error codes of AWS which are worth retrying.
+var transientCodes = map[string]bool{
+	"RequestTimeout":      true,
+	"RequestError":        true,
+	"SlowDown":            true,
+	"Throttling":          true,
+	"ThrottlingException": true,
+	"InternalError":       true,
+	"ServiceUnavailable":  true,
+}
+
+// isTransient returns true if `err` is likely to succeed when retried, such as connection resets and throttling.
+func isTransient(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return transientCodes[aerr.Code()]
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == 429 || gerr.Code >= 500
+	}
+	return false
+}
+
+// backoff waits before the `attempt`-th retry, it returns the error of `ctx` if it is done while waiting.
+func backoff(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(retryBackoff << uint(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryingReader reads body of a file and fetches it again from where it failed on transient errors.
+type retryingReader struct {
+	ctx     context.Context
+	name    string
+	body    io.ReadCloser
+	refetch func(offset int64) (io.ReadCloser, error)
+	// offset is the number of bytes successfully read
+	offset int64
+}
+
+func (r *retryingReader) Read(p []byte) (n int, err error) {
+	for attempt := 0; ; attempt++ {
+		n, err = r.body.Read(p)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF || n > 0 || attempt >= maxRetries || !isTransient(err) {
+			// return what was read, the error would occur again on the next read if it was not transient
+			return
+		}
+		Logger.Warn("retrying to read", "file", r.name, "offset", r.offset, "error", err)
+		if cerr := backoff(r.ctx, attempt); cerr != nil {
+			return 0, cerr
+		}
+		body, serr := r.refetch(r.offset)
+		if serr != nil {
+			return 0, fmt.Errorf("%v, original error was: %v", serr, err)
+		}
+		r.body.Close()
+		r.body = body
+	}
+}
+
+func (r *retryingReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,73 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// flakyReader returns `data` and fails with `err` instead of EOF.
+type flakyReader struct {
+	data io.Reader
+	err  error
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+func (r *flakyReader) Close() error {
+	return nil
+}
+
+func TestRetryingReader(t *testing.T) {
+	content := "0123456789"
+	refetched := []int64{}
+	reader := &retryingReader{
+		ctx:  context.Background(),
+		name: "test",
+		body: &flakyReader{data: strings.NewReader(content[:4]), err: syscall.ECONNRESET},
+		refetch: func(offset int64) (io.ReadCloser, error) {
+			refetched = append(refetched, offset)
+			return ioutil.NopCloser(strings.NewReader(content[offset:])), nil
+		},
+	}
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(read) != content || len(refetched) != 1 || refetched[0] != 4 {
+		t.Fatalf("expected to be refetched from 4: %s, %v", read, refetched)
+	}
+	// permanent errors are not retried
+	permanent := errors.New("permanent")
+	reader = &retryingReader{
+		ctx:     context.Background(),
+		name:    "test",
+		body:    &flakyReader{data: strings.NewReader(""), err: permanent},
+		refetch: func(offset int64) (io.ReadCloser, error) { t.Fatal("unexpected refetch"); return nil, nil },
+	}
+	if _, err := ioutil.ReadAll(reader); err != permanent {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	// requests cancelled while waiting to retry are not refetched
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	reader = &retryingReader{
+		ctx:     ctx,
+		name:    "test",
+		body:    &flakyReader{data: strings.NewReader(""), err: syscall.ECONNRESET},
+		refetch: func(offset int64) (io.ReadCloser, error) { t.Fatal("unexpected refetch"); return nil, nil },
+	}
+	if _, err := ioutil.ReadAll(reader); err != context.Canceled {
+		t.Fatalf("expected the request to be cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package snapshot
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// AllowedDatasetRoles is the list of ARNs of IAM roles which can be specified as `datasetRoleArn`, separated by comma.
+var AllowedDatasetRoles = strings.Split(os.Getenv("ALLOWED_DATASET_ROLES"), ",")
+
+// DatasetBucketRoles is the map of dataset buckets to ARNs of IAM roles assumed to read them unless the request specifies one,
+// such as buckets owned by other accounts. It is given as `bucket=arn` separated by comma.
+var DatasetBucketRoles = parseBucketRoles(os.Getenv("DATASET_BUCKET_ROLES"))
+
+// DatasetRoleExternalID is the external ID given when dataset roles are assumed, not given if empty.
+var DatasetRoleExternalID = os.Getenv("DATASET_ROLE_EXTERNAL_ID")
+
+// datasetRoleSessionName is the name of sessions of dataset roles, which is shown in CloudTrail of the account of the role.
+const datasetRoleSessionName = "stream-snapshot"
+
+// datasetRoleDuration is the duration of credentials of dataset roles, which are of a single request.
+const datasetRoleDuration = 15 * time.Minute
+
+// parseBucketRoles parses pairs of buckets and roles in `str`, malformed pairs are ignored.
+func parseBucketRoles(str string) map[string]string {
+	roles := make(map[string]string)
+	for _, pair := range strings.Split(str, ",") {
+		eq := strings.IndexByte(pair, '=')
+		if eq <= 0 || eq == len(pair)-1 {
+			if pair != "" {
+				Logger.Warn("ignoring invalid dataset bucket role", "value", pair)
+			}
+			continue
+		}
+		roles[strings.TrimSpace(pair[:eq])] = strings.TrimSpace(pair[eq+1:])
+	}
+	return roles
+}
+
+// datasetRoleOf returns the ARN of the role to read dataset of `param` with, or empty string if the role of the process is used.
+func datasetRoleOf(param SnapshotParameter) string {
+	if param.DatasetRoleARN != "" {
+		return param.DatasetRoleARN
+	}
+	if param.DatasetBucket == "" {
+		return ""
+	}
+	return DatasetBucketRoles[param.DatasetBucket]
+}
+
+// datasetSession returns the session reading dataset with credentials of `role`, or of the process if it is empty.
+// Credentials of the role are obtained for each session, so that they are not shared with other requests.
+func datasetSession(role string) (*session.Session, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	if role == "" {
+		return sess, nil
+	}
+	creds := stscreds.NewCredentials(sess, role, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = datasetRoleSessionName
+		p.Duration = datasetRoleDuration
+		if DatasetRoleExternalID != "" {
+			p.ExternalID = aws.String(DatasetRoleExternalID)
+		}
+	})
+	return sess.Copy(&aws.Config{Credentials: creds}), nil
+}
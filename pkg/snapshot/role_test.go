@@ -0,0 +1,54 @@
+package snapshot
+
+import (
+	"fmt"
+	"testing"
+)
+
+const testDatasetRole = "arn:aws:iam::123456789012:role/dataset-reader"
+
+func TestParseBucketRoles(t *testing.T) {
+	roles := parseBucketRoles("customer-a=" + testDatasetRole + ", customer-b =arn:aws:iam::210987654321:role/reader,broken,=arn,")
+	expected := fmt.Sprint(map[string]string{"customer-a": testDatasetRole, "customer-b": "arn:aws:iam::210987654321:role/reader"})
+	if fmt.Sprint(roles) != expected {
+		t.Errorf("expected %s, got %v", expected, roles)
+	}
+}
+
+func TestDatasetRoleOf(t *testing.T) {
+	defer func(roles map[string]string) { DatasetBucketRoles = roles }(DatasetBucketRoles)
+	DatasetBucketRoles = map[string]string{"customer-a": testDatasetRole}
+	if role := datasetRoleOf(SnapshotParameter{}); role != "" {
+		t.Errorf("expected the default bucket to be read by the process, got '%s'", role)
+	}
+	if role := datasetRoleOf(SnapshotParameter{DatasetBucket: "customer-a"}); role != testDatasetRole {
+		t.Errorf("expected the role configured for the bucket, got '%s'", role)
+	}
+	other := "arn:aws:iam::123456789012:role/other"
+	if role := datasetRoleOf(SnapshotParameter{DatasetBucket: "customer-a", DatasetRoleARN: other}); role != other {
+		t.Errorf("expected the role of the request, got '%s'", role)
+	}
+}
+
+func TestMakeParameterDatasetRole(t *testing.T) {
+	defer func(buckets []string, roles []string) { AllowedDatasetBuckets, AllowedDatasetRoles = buckets, roles }(AllowedDatasetBuckets, AllowedDatasetRoles)
+	AllowedDatasetBuckets = []string{"customer-a"}
+	AllowedDatasetRoles = []string{testDatasetRole}
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.QueryStringParameters["datasetRoleArn"] = testDatasetRole
+	if _, err := ParseParameter(event); err == nil {
+		t.Fatal("expected the role to be rejected without the dataset bucket")
+	}
+	event.QueryStringParameters["datasetBucket"] = "customer-a"
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.DatasetRoleARN != testDatasetRole {
+		t.Errorf("expected the role, got '%s'", param.DatasetRoleARN)
+	}
+	event.QueryStringParameters["datasetRoleArn"] = "arn:aws:iam::123456789012:role/admin"
+	if _, err := ParseParameter(event); err == nil {
+		t.Fatal("expected the role not in the allowed list to be rejected")
+	}
+}
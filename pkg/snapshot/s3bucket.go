@@ -0,0 +1,175 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// s3BucketConcurrency is the default number of objects s3BucketSource downloads concurrently.
+const s3BucketConcurrency = 5
+
+type s3BucketResult struct {
+	body *spillBuffer
+	// reader is the body of the last object which is fetched in chunks
+	reader io.ReadCloser
+	err    error
+}
+
+// s3BucketSource is DatasetSource reading objects from a S3 bucket other than the default one of streamcommons.
+// Objects are prefetched concurrently in the same way gcsSource does, except the last one fetched in chunks as it is read.
+type s3BucketSource struct {
+	keys    []string
+	i       int
+	results []chan s3BucketResult
+	cancel  context.CancelFunc
+}
+
+// newS3BucketSource makes s3BucketSource downloading `concurrency` objects concurrently,
+// or `s3BucketConcurrency` if not positive. Objects are read with credentials of `role` if not empty,
+// and have to be encrypted as `encryption`.
+func newS3BucketSource(ctx context.Context, bucket string, keys []string, concurrency int, role string, encryption datasetEncryption) (*s3BucketSource, error) {
+	if concurrency <= 0 {
+		concurrency = s3BucketConcurrency
+	}
+	sess, err := datasetSession(role)
+	if err != nil {
+		return nil, err
+	}
+	client, err := encryption.newObjectGetter(sess)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s := &s3BucketSource{
+		keys:    keys,
+		i:       -1,
+		results: make([]chan s3BucketResult, len(keys)),
+		cancel:  cancel,
+	}
+	sem := make(chan struct{}, concurrency)
+	for i, key := range keys {
+		// buffered so that goroutines can exit even if no one receives the result
+		result := make(chan s3BucketResult, 1)
+		s.results[i] = result
+		last := i == len(keys)-1
+		go func(key string) {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				result <- s3BucketResult{err: ctx.Err()}
+				return
+			}
+			defer func() { <-sem }()
+			// objects encrypted on the client side can only be decrypted as a whole
+			if last && !encryption.clientSide {
+				reader, err := openChunked(ctx, key, rangeChunkSize, s3RangeFetcher(client, encryption, bucket, key))
+				if err != nil {
+					result <- s3BucketResult{err: err}
+					return
+				}
+				result <- s3BucketResult{reader: reader}
+				return
+			}
+			body, err := downloadS3(ctx, client, encryption, bucket, key)
+			result <- s3BucketResult{body: body, err: err}
+		}(key)
+	}
+	return s, nil
+}
+
+// downloadS3 downloads the whole object, the rest of it is fetched again with range request on transient errors.
+// Objects encrypted on the client side are downloaded again from the beginning instead.
+// Objects beyond the memory ceiling are spilled to disk.
+func downloadS3(ctx context.Context, client objectGetter, encryption datasetEncryption, bucket string, key string) (*spillBuffer, error) {
+	buf := newSpillBuffer(ceiling, 0)
+	for attempt := 0; ; attempt++ {
+		input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+		if buf.Len() > 0 {
+			if encryption.clientSide {
+				buf.Close()
+				buf = newSpillBuffer(ceiling, 0)
+			} else {
+				input.Range = aws.String(fmt.Sprintf("bytes=%d-", buf.Len()))
+			}
+		}
+		obj, err := checkedGetObject(ctx, client, encryption, input)
+		if err == nil {
+			_, err = io.Copy(buf, obj.Body)
+			obj.Body.Close()
+			if err == nil {
+				return buf, nil
+			}
+		}
+		if attempt >= maxRetries || !isTransient(err) || ctx.Err() != nil {
+			buf.Close()
+			return nil, err
+		}
+		LoggerFrom(ctx).Warn("retrying to download", "object", key, "offset", buf.Len(), "attempt", attempt, "error", err)
+		if err := backoff(ctx, attempt); err != nil {
+			buf.Close()
+			return nil, err
+		}
+	}
+}
+
+func (s *s3BucketSource) Next() (io.ReadCloser, bool) {
+	if s.i+1 >= len(s.keys) {
+		return nil, false
+	}
+	s.i++
+	result := <-s.results[s.i]
+	if errors.Is(result.err, errNotEncrypted) {
+		// the object exists, but must not be read
+		return failedReader{err: result.err}, true
+	}
+	if result.err != nil {
+		if aerr, ok := result.err.(awserr.Error); !ok || aerr.Code() != s3.ErrCodeNoSuchKey {
+			Logger.Warn("could not download", "object", s.Name(), "error", result.err)
+		}
+		// treat it as if the file did not exist
+		return nil, true
+	}
+	if result.reader != nil {
+		return result.reader, true
+	}
+	body, err := result.body.Reader()
+	if err != nil {
+		Logger.Warn("could not read downloaded object", "object", s.Name(), "error", err)
+		result.body.Close()
+		return nil, true
+	}
+	return body, true
+}
+
+func (s *s3BucketSource) Name() string {
+	if s.i < 0 || s.i >= len(s.keys) {
+		return ""
+	}
+	return s.keys[s.i]
+}
+
+func (s *s3BucketSource) Close() error {
+	s.cancel()
+	if s.i+1 < len(s.results) {
+		// objects not returned hold connections or files spilled to until they are closed
+		go func(results []chan s3BucketResult) {
+			for _, result := range results {
+				r := <-result
+				if r.reader != nil {
+					r.reader.Close()
+				}
+				if r.body != nil {
+					r.body.Close()
+				}
+			}
+		}(s.results[s.i+1:])
+		s.results = s.results[:s.i+1]
+	}
+	return nil
+}
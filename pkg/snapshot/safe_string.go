@@ -0,0 +1,10 @@
+//go:build safestring
+// +build safestring
+
+package snapshot
+
+// bytesToString converts byte slice into string by copying it.
+// This is used instead of the unsafe conversion when built with `-tags safestring`.
+func bytesToString(b []byte) string {
+	return string(b)
+}
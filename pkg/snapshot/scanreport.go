@@ -0,0 +1,100 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FileScan is a dataset file read in a scan.
+type FileScan struct {
+	Name string `json:"name"`
+	// Scanned is the number of bytes of the decompressed file read
+	Scanned int64 `json:"scanned"`
+	// ParseTime and SimulatorTime are the time in milliseconds lines took to be read and parsed,
+	// and the simulator took to process them, measured in debug mode
+	ParseTime     float64 `json:"parseTime,omitempty"`
+	SimulatorTime float64 `json:"simulatorTime,omitempty"`
+}
+
+// ScanPosition is the position in a dataset file.
+type ScanPosition struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+}
+
+// ScanReport is the report of how the snapshot was made returned to clients in `X-Snapshot-Report` header.
+type ScanReport struct {
+	// Scanned is the total bytes scanned, which is billed
+	Scanned int64      `json:"scanned"`
+	Files   []FileScan `json:"files"`
+	// Missing is the list of dataset files which did not exist
+	Missing []string `json:"missing"`
+	// StoppedAt is where the scan stopped as all targets were reached, null if it read through dataset
+	StoppedAt *ScanPosition `json:"stoppedAt"`
+	// SimulatorTime is the time in milliseconds the simulator took to process lines
+	SimulatorTime float64 `json:"simulatorTime"`
+	// WallTime is the time in milliseconds from the beginning of the request
+	WallTime     float64 `json:"wallTime"`
+	SkippedLines int     `json:"skippedLines"`
+	// SkippedStates is the number of state lines not applied as the simulator already had the state
+	SkippedStates int      `json:"skippedStates"`
+	Truncated     []string `json:"truncated"`
+	Partial       string   `json:"partial"`
+	// Channels is the report of requested channels and post filters, null if snapshots were not taken
+	Channels *ChannelReport `json:"channels"`
+	// ChannelTimes is the breakdown of `simulatorTime` by channels in debug mode
+	ChannelTimes []ChannelTiming `json:"channelTimes,omitempty"`
+	// Omitted is true if lists of files and channels were left out to keep the header small
+	Omitted bool `json:"omitted,omitempty"`
+}
+
+// MaxReportHeaderBytes is the maximum size of the report in `X-Snapshot-Report` header,
+// as API Gateway and proxies refuse responses having large headers.
+const MaxReportHeaderBytes = 8192
+
+// HeaderValue returns the JSON of the report for `X-Snapshot-Report` header. Reports larger than MaxReportHeaderBytes
+// have only counters, scans reading many files or channels would make the header too large.
+func (r ScanReport) HeaderValue() (string, error) {
+	encoded, err := json.Marshal(r)
+	if err != nil || len(encoded) <= MaxReportHeaderBytes {
+		return string(encoded), err
+	}
+	r.Files = []FileScan{}
+	r.Missing = []string{}
+	r.Truncated = []string{}
+	r.Channels = nil
+	r.ChannelTimes = nil
+	r.Omitted = true
+	encoded, err = json.Marshal(r)
+	return string(encoded), err
+}
+
+// NewScanReport makes the report for clients from `report` of a request took `elapsed`.
+func NewScanReport(report Report, elapsed time.Duration) ScanReport {
+	files := report.Files
+	if files == nil {
+		files = []FileScan{}
+	}
+	missing := report.MissingFiles
+	if missing == nil {
+		missing = []string{}
+	}
+	truncated := report.TruncatedFiles
+	if truncated == nil {
+		truncated = []string{}
+	}
+	return ScanReport{
+		Scanned:       report.Scanned,
+		Files:         files,
+		Missing:       missing,
+		StoppedAt:     report.StoppedAt,
+		SimulatorTime: milliseconds(report.ProcessTime),
+		WallTime:      milliseconds(elapsed),
+		SkippedLines:  report.SkippedLines,
+		SkippedStates: report.SkippedStates,
+		Truncated:     truncated,
+		Partial:       report.Partial,
+		Channels:      report.Channels,
+		ChannelTimes:  report.ChannelTimes,
+	}
+}
@@ -0,0 +1,50 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewScanReport(t *testing.T) {
+	report := Report{
+		Scanned:      300,
+		Files:        []FileScan{{Name: "bitmex_1.gz", Scanned: 100}, {Name: "bitmex_3.gz", Scanned: 200}},
+		MissingFiles: []string{"bitmex_2.gz"},
+		StoppedAt:    &ScanPosition{File: "bitmex_3.gz", Offset: 200},
+		ProcessTime:  2 * time.Millisecond,
+	}
+	encoded, err := json.Marshal(NewScanReport(report, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"scanned":300,"files":[{"name":"bitmex_1.gz","scanned":100},{"name":"bitmex_3.gz","scanned":200}],"missing":["bitmex_2.gz"],"stoppedAt":{"file":"bitmex_3.gz","offset":200},"simulatorTime":2,"wallTime":10,"skippedLines":0,"skippedStates":0,"truncated":[],"partial":"","channels":null}`
+	if string(encoded) != expected {
+		t.Fatalf("expected %s, got %s", expected, encoded)
+	}
+}
+
+func TestScanReportHeaderValue(t *testing.T) {
+	report := Report{Scanned: 300, Files: []FileScan{{Name: "bitmex_1.gz", Scanned: 300}}}
+	value, err := NewScanReport(report, 10*time.Millisecond).HeaderValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(value, "bitmex_1.gz") || strings.Contains(value, "omitted") {
+		t.Errorf("expected the whole report, got %s", value)
+	}
+	// a day of minute files
+	report.Files = nil
+	for i := 0; i < 1440; i++ {
+		report.Files = append(report.Files, FileScan{Name: fmt.Sprintf("bitmex_%d.gz", 26636817+i), Scanned: 1})
+	}
+	value, err = NewScanReport(report, 10*time.Millisecond).HeaderValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(value) > MaxReportHeaderBytes || !strings.Contains(value, `"scanned":300,"files":[]`) || !strings.Contains(value, `"omitted":true`) {
+		t.Errorf("expected only counters, got %s", value)
+	}
+}
@@ -0,0 +1,22 @@
+package snapshot
+
+import "strconv"
+
+// SchemaVersionHeader is the header responses tell the version of the layout of their output in.
+const SchemaVersionHeader = "X-Snapshot-Schema-Version"
+
+// CurrentSchemaVersion is the latest version of the layout of outputs. It is incremented when columns or lines
+// are added to outputs, which are written only for requests of `schemaVersion` of it or later, so that clients
+// parsing outputs by position keep working until they ask for the new version.
+//
+// Version 1 is the layout of outputs before versions were introduced.
+const CurrentSchemaVersion = 1
+
+// ResponseSchemaVersion returns the value of SchemaVersionHeader of the response for `param`,
+// parameters made without the version, such as by the CLI, are of the latest one.
+func ResponseSchemaVersion(param SnapshotParameter) string {
+	if param.SchemaVersion == 0 {
+		return strconv.Itoa(CurrentSchemaVersion)
+	}
+	return strconv.Itoa(param.SchemaVersion)
+}
@@ -0,0 +1,286 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// zstdSkippableMagic is the magic number of the skippable frame having the seek table of seekable zstd files.
+	zstdSkippableMagic = 0x184D2A5E
+	// zstdSeekableMagic is the magic number at the end of the seek table.
+	zstdSeekableMagic = 0x8F92EAB1
+	// zstdSeekFooterSize is the size of the footer of the seek table.
+	zstdSeekFooterSize = 9
+)
+
+// seekSource is DatasetSource returning files from the compressed frames planned by their manifests.
+type seekSource struct {
+	rest DatasetSource
+	// offsets is the map of files to the byte offset of the compressed frame they are read from
+	offsets map[string]int64
+}
+
+// refetchingSeekSource is seekSource of the source which can fetch files again.
+type refetchingSeekSource struct {
+	*seekSource
+}
+
+// newSeekSource returns the source reading files of `rest` from `offsets`, which can fetch files again if `rest` can.
+func newSeekSource(rest DatasetSource, offsets map[string]int64) DatasetSource {
+	s := &seekSource{rest: rest, offsets: offsets}
+	if _, ok := rest.(refetcher); ok {
+		return refetchingSeekSource{s}
+	}
+	return s
+}
+
+func (s *seekSource) Next() (io.ReadCloser, bool) {
+	body, ok := s.rest.Next()
+	if !ok || body == nil {
+		return body, ok
+	}
+	if offset := s.offsets[s.rest.Name()]; offset > 0 {
+		return &seekingReader{ReadCloser: body, offset: offset}, true
+	}
+	return body, true
+}
+
+func (s *seekSource) Name() string {
+	return s.rest.Name()
+}
+
+// Refetch fetches the file again from `offset` after the frame it is read from.
+func (s refetchingSeekSource) Refetch(name string, offset int64) (io.ReadCloser, error) {
+	return s.rest.(refetcher).Refetch(name, s.offsets[name]+offset)
+}
+
+func (s *seekSource) CacheStats() (hits int, misses int) {
+	if c, ok := s.rest.(cacheStatter); ok {
+		return c.CacheStats()
+	}
+	return 0, 0
+}
+
+func (s *seekSource) Close() error {
+	return s.rest.Close()
+}
+
+// seekingReader reads a file from `offset`, seeking to it if the body can seek or reading through the bytes before it otherwise,
+// which are not decompressed either way.
+type seekingReader struct {
+	io.ReadCloser
+	offset int64
+}
+
+func (r *seekingReader) Read(p []byte) (int, error) {
+	if r.offset > 0 {
+		offset := r.offset
+		r.offset = 0
+		if seeker, ok := r.ReadCloser.(io.Seeker); ok {
+			if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+				return 0, err
+			}
+		} else if _, err := io.CopyN(ioutil.Discard, r.ReadCloser, offset); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	return r.ReadCloser.Read(p)
+}
+
+// framer makes manifestFrame of frames given in the order of the file.
+type framer struct {
+	frames       []manifestFrame
+	decompressed int64
+	// newline is true if the frames before ended at the end of a line
+	newline bool
+}
+
+// add adds the frame at `offset` of the compressed file which is decompressed to `content`.
+func (f *framer) add(offset int64, content []byte) {
+	frame := manifestFrame{Offset: offset, Decompressed: f.decompressed, Line: -1}
+	start := 0
+	if !f.newline {
+		// the rest of the line in the previous frame
+		start = bytes.IndexByte(content, '\n') + 1
+	}
+	if start > 0 || f.newline {
+		if timestamp, ok := lineTimestamp(content[start:]); ok {
+			frame.Line = f.decompressed + int64(start)
+			frame.Timestamp = timestamp
+		}
+	}
+	f.frames = append(f.frames, frame)
+	f.decompressed += int64(len(content))
+	if len(content) > 0 {
+		f.newline = content[len(content)-1] == '\n'
+	}
+}
+
+// lineTimestamp returns the timestamp of the line at the beginning of `b`, false if it is not in `b`.
+func lineTimestamp(b []byte) (int64, bool) {
+	tab := bytes.IndexByte(b, '\t')
+	if tab < 0 {
+		return 0, false
+	}
+	rest := b[tab+1:]
+	end := bytes.IndexAny(rest, "\t\n")
+	if end < 0 {
+		return 0, false
+	}
+	timestamp, err := strconv.ParseInt(string(rest[:end]), 10, 64)
+	return timestamp, err == nil
+}
+
+// indexFrames returns frames of the compressed dataset file `data`, nil if it is not seekable.
+// Multistream gzip files are seekable at members, zstd files are if they have the seek table at the end.
+func indexFrames(data []byte) ([]manifestFrame, error) {
+	f := &framer{newline: true}
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		// gzip does not read beyond a member from io.ByteReader
+		reader := bytes.NewReader(data)
+		var zr *gzip.Reader
+		for reader.Len() > 0 {
+			offset := int64(len(data) - reader.Len())
+			var err error
+			if zr == nil {
+				zr, err = gzip.NewReader(reader)
+			} else {
+				err = zr.Reset(reader)
+			}
+			if err != nil {
+				return nil, err
+			}
+			zr.Multistream(false)
+			content, err := ioutil.ReadAll(zr)
+			if err != nil {
+				return nil, err
+			}
+			f.add(offset, content)
+		}
+	case bytes.HasPrefix(data, zstdMagic):
+		sizes, ok := zstdSeekTable(data)
+		if !ok {
+			return nil, nil
+		}
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		offset := int64(0)
+		for _, size := range sizes {
+			if offset+size > int64(len(data)) {
+				return nil, errors.New("seek table does not match the file")
+			}
+			content, err := decoder.DecodeAll(data[offset:offset+size], nil)
+			if err != nil {
+				return nil, err
+			}
+			f.add(offset, content)
+			offset += size
+		}
+	default:
+		return nil, errors.New("unknown compression")
+	}
+	if len(f.frames) < 2 {
+		// the whole file is a frame
+		return nil, nil
+	}
+	return f.frames, nil
+}
+
+// zstdSeekTable returns compressed sizes of frames in the seek table at the end of the seekable zstd file `data`.
+func zstdSeekTable(data []byte) ([]int64, bool) {
+	if len(data) < zstdSeekFooterSize+8 {
+		return nil, false
+	}
+	footer := data[len(data)-zstdSeekFooterSize:]
+	if binary.LittleEndian.Uint32(footer[5:]) != zstdSeekableMagic {
+		return nil, false
+	}
+	frames := int(binary.LittleEndian.Uint32(footer))
+	entrySize := 8
+	if footer[4]&0x80 != 0 {
+		// entries have checksums
+		entrySize = 12
+	}
+	tableSize := frames*entrySize + zstdSeekFooterSize
+	start := len(data) - tableSize - 8
+	if frames <= 0 || start < 0 {
+		return nil, false
+	}
+	if binary.LittleEndian.Uint32(data[start:]) != zstdSkippableMagic || int(binary.LittleEndian.Uint32(data[start+4:])) != tableSize {
+		return nil, false
+	}
+	sizes := make([]int64, frames)
+	for i := range sizes {
+		sizes[i] = int64(binary.LittleEndian.Uint32(data[start+8+i*entrySize:]))
+	}
+	return sizes, true
+}
+
+// buildManifest returns the manifest of the compressed dataset file `data`, with frames if it is seekable.
+func buildManifest(data []byte) (m channelManifest, err error) {
+	reader, err := newDecompressor(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	builder := newManifestBuilder()
+	breader := bufio.NewReaderSize(reader, ReadBufferKB*1024)
+	var f Feeder
+	offset := int64(0)
+	for {
+		var line []byte
+		line, err = f.readLine(breader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return
+		}
+		if parsed, perr := parseLine(line); perr == nil {
+			builder.add(parsed, offset)
+		}
+		offset += int64(len(line))
+	}
+	m = builder.manifest()
+	m.Frames, err = indexFrames(data)
+	return
+}
+
+// IndexDataset writes manifests of dataset files `keys` in `dir` to `manifestDir`,
+// so that snapshots with `MANIFEST_DIR` skip files and seek seekable files by them.
+func IndexDataset(dir string, keys []string, manifestDir string) error {
+	store := dirManifestStore(manifestDir)
+	for _, key := range keys {
+		data, err := ioutil.ReadFile(filepath.Join(dir, key))
+		if err != nil {
+			return err
+		}
+		m, err := buildManifest(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		if err := store.Put(key, m); err != nil {
+			return err
+		}
+		Logger.Info("indexed dataset file", "file", key, "channels", len(m.Channels), "starts", len(m.Starts), "frames", len(m.Frames))
+	}
+	return nil
+}
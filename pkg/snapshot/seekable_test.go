@@ -0,0 +1,153 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// multistreamGzip compresses `content` split at `splits` into gzip members.
+func multistreamGzip(t *testing.T, content string, splits ...int) []byte {
+	buf := new(bytes.Buffer)
+	prev := 0
+	for _, end := range append(splits, len(content)) {
+		writer := gzip.NewWriter(buf)
+		if _, err := writer.Write([]byte(content[prev:end])); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		prev = end
+	}
+	return buf.Bytes()
+}
+
+func TestIndexFramesGzip(t *testing.T) {
+	content := "start\t100\twss://example.com\n" +
+		"msg\t200\tchannelA\t{\"a\":1}\n" +
+		"msg\t300\tchannelA\t{\"a\":2}\n"
+	first := strings.Index(content, "msg")
+	// the second member begins in the middle of a line
+	frames, err := indexFrames(multistreamGzip(t, content, first, first+5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("expected 3 frames, got %+v", frames)
+	}
+	second := int64(strings.LastIndex(content, "msg"))
+	expected := []struct{ decompressed, line, timestamp int64 }{{0, 0, 100}, {int64(first), int64(first), 200}, {int64(first + 5), second, 300}}
+	for i, e := range expected {
+		if frames[i].Decompressed != e.decompressed || frames[i].Line != e.line || frames[i].Timestamp != e.timestamp {
+			t.Errorf("frame %d: expected %+v, got %+v", i, e, frames[i])
+		}
+	}
+	if frames[0].Offset != 0 || frames[1].Offset <= frames[0].Offset || frames[2].Offset <= frames[1].Offset {
+		t.Errorf("unexpected offsets of frames %+v", frames)
+	}
+	// a single member is not seekable
+	if frames, err = indexFrames(multistreamGzip(t, content)); err != nil || frames != nil {
+		t.Errorf("expected no frames, got %+v %v", frames, err)
+	}
+}
+
+func TestIndexFramesZstd(t *testing.T) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+	parts := []string{"start\t100\twss://example.com\n", "msg\t200\tchannelA\t{\"a\":1}\n"}
+	var data, table []byte
+	for _, part := range parts {
+		frame := encoder.EncodeAll([]byte(part), nil)
+		data = append(data, frame...)
+		table = binary.LittleEndian.AppendUint32(table, uint32(len(frame)))
+		table = binary.LittleEndian.AppendUint32(table, uint32(len(part)))
+	}
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(parts)))
+	table = append(table, 0)
+	table = binary.LittleEndian.AppendUint32(table, zstdSeekableMagic)
+	data = binary.LittleEndian.AppendUint32(data, zstdSkippableMagic)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(table)))
+	data = append(data, table...)
+	frames, err := indexFrames(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || frames[1].Decompressed != int64(len(parts[0])) || frames[1].Timestamp != 200 {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+	// the seek table is skipped in decompression
+	m, err := buildManifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(m.Channels) != "[channelA]" || len(m.Starts) != 1 {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+}
+
+func TestSeekableOnFixture(t *testing.T) {
+	defer registerFixture()()
+	dir, err := ioutil.TempDir("", "stream-snapshot-seekable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys := fixtureKeys()
+	for i, key := range keys {
+		content := strings.Join(fixtureFiles[i], "\n") + "\n"
+		var data []byte
+		if i == 1 {
+			// the reconnection is in the second member
+			data = multistreamGzip(t, content, len(fixtureFiles[1][0])+1)
+		} else if data, err = ioutil.ReadFile(filepath.Join(goldenDir, key)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, key), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := IndexDataset(dir, keys, dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func(dataset, manifest string) { DatasetDirectory, ManifestDirectory = dataset, manifest }(DatasetDirectory, ManifestDirectory)
+	DatasetDirectory, ManifestDirectory = dir, dir
+	param := SnapshotParameter{Exchange: fixtureExchange, Nanosecs: []int64{fixtureAt(130 * time.Second)}, Channels: []string{"book", "ticker"}, Format: "raw", Output: OutputTSV, Compression: "gzip"}
+	source, err := OpenSource(context.Background(), &param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	ret, report, err := Snapshot(context.Background(), param, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile(filepath.Join(goldenDir, "raw_reconnected.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ret, expected) {
+		t.Errorf("expected the same snapshot as without seeking:\n%s\nexpected:\n%s", ret, expected)
+	}
+	// the first member is not decompressed
+	size := 0
+	for _, line := range fixtureFiles[1][1:] {
+		size += len(line) + 1
+	}
+	if len(report.Files) == 0 || report.Files[0].Name != keys[1] || report.Files[0].Scanned != int64(size) {
+		t.Errorf("expected %d bytes of %s to be scanned, got %+v", size, keys[1], report.Files)
+	}
+}
@@ -0,0 +1,160 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// consistencyChannel is the channel of entries reporting whether updates of channels were consecutive.
+const consistencyChannel = "$consistency"
+
+// sequenceExtractor extracts the range of update IDs in a message of `channel`.
+// `key` identifies the sequence, which can be shared by channels of snapshots and updates.
+// `reset` is true if the message is a snapshot having the ID of the last update applied to it.
+type sequenceExtractor func(channel string, message []byte) (key string, first int64, last int64, reset bool, ok bool)
+
+// sequenceExtractors has extractors of exchanges whose messages have update IDs.
+var sequenceExtractors = map[string]sequenceExtractor{
+	binanceSpot:    binanceSequence,
+	binanceFutures: binanceFuturesSequence,
+}
+
+// binanceUpdate is the part of depth messages of Binance having update IDs.
+type binanceUpdate struct {
+	// Data is the message of stream if it is of combined streams
+	Data         *binanceUpdate `json:"data"`
+	FirstID      *int64         `json:"U"`
+	FinalID      *int64         `json:"u"`
+	LastUpdateID *int64         `json:"lastUpdateId"`
+}
+
+func binanceSequence(channel string, message []byte) (key string, first int64, last int64, reset bool, ok bool) {
+	at := strings.IndexByte(channel, '@')
+	if at < 0 || !strings.Contains(channel[at:], "depth") {
+		return
+	}
+	// snapshots from REST and updates are of the same sequence for a symbol
+	key = channel[:at]
+	var update binanceUpdate
+	if err := json.Unmarshal(message, &update); err != nil {
+		return
+	}
+	if update.Data != nil {
+		update = *update.Data
+	}
+	if update.LastUpdateID != nil {
+		return key, *update.LastUpdateID, *update.LastUpdateID, true, true
+	}
+	if update.FirstID == nil || update.FinalID == nil {
+		return
+	}
+	return key, *update.FirstID, *update.FinalID, false, true
+}
+
+// sequence is the state of update IDs of a sequence.
+type sequence struct {
+	// channel is the channel of updates reported
+	channel string
+	// last is the ID of the last update applied, 0 if none is applied
+	last int64
+	// gaps are ranges of update IDs which were missing
+	gaps [][2]int64
+}
+
+// sequenceChecker detects updates dropped in sequences of update IDs, shared among simulators in a request.
+type sequenceChecker struct {
+	extract   sequenceExtractor
+	sequences map[string]*sequence
+}
+
+// newSequenceChecker returns sequenceChecker for `exchange`, or nil if its messages do not have update IDs.
+func newSequenceChecker(exchange string) *sequenceChecker {
+	extract, ok := sequenceExtractors[exchange]
+	if !ok {
+		return nil
+	}
+	return &sequenceChecker{extract: extract, sequences: make(map[string]*sequence)}
+}
+
+// reset forgets sequences as the connection was made again.
+func (c *sequenceChecker) reset() {
+	c.sequences = make(map[string]*sequence)
+}
+
+func (c *sequenceChecker) check(channel string, message []byte) {
+	key, first, last, reset, ok := c.extract(channel, message)
+	if !ok {
+		return
+	}
+	seq, ok := c.sequences[key]
+	if !ok {
+		seq = &sequence{}
+		c.sequences[copyString(key)] = seq
+	}
+	if reset {
+		seq.last = last
+		return
+	}
+	if seq.channel == "" {
+		seq.channel = copyString(channel)
+	}
+	if seq.last != 0 && last <= seq.last {
+		// updates already in the snapshot
+		return
+	}
+	if seq.last != 0 && first > seq.last+1 {
+		seq.gaps = append(seq.gaps, [2]int64{seq.last + 1, first - 1})
+	}
+	seq.last = last
+}
+
+// sequenceCheckingSimulator is simulator checking update IDs of messages before processing them.
+type sequenceCheckingSimulator struct {
+	simulator.Simulator
+	checker *sequenceChecker
+}
+
+func (s *sequenceCheckingSimulator) ProcessMessageChannelKnown(channel string, line []byte) error {
+	s.checker.check(channel, line)
+	return s.Simulator.ProcessMessageChannelKnown(channel, line)
+}
+
+func (s *sequenceCheckingSimulator) ProcessState(channel string, line []byte) error {
+	s.checker.check(channel, line)
+	return s.Simulator.ProcessState(channel, line)
+}
+
+// channelConsistency is the message of entries in `consistencyChannel`.
+type channelConsistency struct {
+	Channel    string     `json:"channel"`
+	Consistent bool       `json:"consistent"`
+	Gaps       [][2]int64 `json:"gaps"`
+}
+
+// consistencyEntries returns entries reporting gaps of sequences having updates in the order of channel name.
+func (c *sequenceChecker) consistencyEntries() ([]entry, error) {
+	reports := make([]channelConsistency, 0, len(c.sequences))
+	for _, seq := range c.sequences {
+		if seq.channel == "" {
+			continue
+		}
+		gaps := seq.gaps
+		if gaps == nil {
+			gaps = [][2]int64{}
+		}
+		reports = append(reports, channelConsistency{Channel: seq.channel, Consistent: len(seq.gaps) == 0, Gaps: gaps})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Channel < reports[j].Channel })
+	entries := make([]entry, len(reports))
+	for i, report := range reports {
+		message, err := json.Marshal(report)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = entry{channel: consistencyChannel, message: message}
+	}
+	return entries, nil
+}
@@ -0,0 +1,51 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSequenceChecker(t *testing.T) {
+	checker := newSequenceChecker("binance")
+	checker.check("btcusdt@rest_depth", []byte(`{"lastUpdateId":100,"bids":[],"asks":[]}`))
+	// updates already in the snapshot are ignored
+	checker.check("btcusdt@depth@100ms", []byte(`{"stream":"btcusdt@depth@100ms","data":{"e":"depthUpdate","U":90,"u":95}}`))
+	checker.check("btcusdt@depth@100ms", []byte(`{"stream":"btcusdt@depth@100ms","data":{"e":"depthUpdate","U":98,"u":105}}`))
+	checker.check("btcusdt@depth@100ms", []byte(`{"stream":"btcusdt@depth@100ms","data":{"e":"depthUpdate","U":106,"u":110}}`))
+	checker.check("ethusdt@depth@100ms", []byte(`{"stream":"ethusdt@depth@100ms","data":{"e":"depthUpdate","U":1,"u":5}}`))
+	checker.check("ethusdt@depth@100ms", []byte(`{"stream":"ethusdt@depth@100ms","data":{"e":"depthUpdate","U":9,"u":12}}`))
+	entries, err := checker.consistencyEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	var reports []channelConsistency
+	for _, e := range entries {
+		if e.channel != consistencyChannel {
+			t.Fatalf("unexpected channel %s", e.channel)
+		}
+		var report channelConsistency
+		if err := json.Unmarshal(e.message, &report); err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, report)
+	}
+	if reports[0].Channel != "btcusdt@depth@100ms" || !reports[0].Consistent {
+		t.Errorf("expected btcusdt to be consistent: %+v", reports[0])
+	}
+	if reports[1].Consistent || len(reports[1].Gaps) != 1 || reports[1].Gaps[0] != [2]int64{6, 8} {
+		t.Errorf("expected ethusdt to have gap: %+v", reports[1])
+	}
+	checker.reset()
+	if entries, _ := checker.consistencyEntries(); len(entries) != 0 {
+		t.Fatal("expected sequences to be forgotten")
+	}
+}
+
+func TestSequenceCheckerUnsupported(t *testing.T) {
+	if newSequenceChecker("bitmex") != nil {
+		t.Fatal("expected no checker for exchange without update IDs")
+	}
+}
@@ -0,0 +1,335 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// trailers of streamed responses, they are only known after snapshots are written
+const (
+	trailerTimestamp = "X-Snapshot-Timestamp"
+	trailerPartial   = "X-Snapshot-Partial-Reason"
+	trailerError     = "X-Snapshot-Error"
+	trailerToken     = "X-Snapshot-Token"
+	trailerChannels  = "X-Snapshot-Channels"
+)
+
+// ErrShuttingDown is the cause of cancellation of requests aborted because the server is shutting down.
+// Checkpoints taken until then are saved, so that requests retried on other servers can start from them.
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// NewHTTPHandler returns the handler serving the same API as Lambda at `GET /snapshot/{exchange}/{nanosec}`
+// reading dataset from the location configured. Requests of snapshots are authorized by API keys in `APIKeysFile`
+// if it is loaded by Setup, and not authorized otherwise. Each of them is identified by `X-Request-Id` and logged once.
+// Snapshots are pushed every `interval` over WebSocket at `GET /subscribe/{exchange}`, authorized in the same way.
+// Probes are served at `/healthz` and `/readyz`, and profiles at `/debug/pprof/` if `Pprof` is true.
+func NewHTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	var snapshots http.Handler = http.HandlerFunc(serveSnapshot)
+	if apiKeys != nil {
+		snapshots = apiKeys.authorize(snapshots)
+	}
+	mux.Handle("GET /snapshot/{exchange}/{nanosec}", identify(snapshots))
+	var subscriptions http.Handler = http.HandlerFunc(serveSubscription)
+	if apiKeys != nil {
+		subscriptions = apiKeys.authorize(subscriptions)
+	}
+	mux.Handle("GET /subscribe/{exchange}", identify(subscriptions))
+	mux.HandleFunc("GET /healthz", serveHealth)
+	mux.HandleFunc("GET /readyz", serveReady)
+	if Pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+// statusCode returns the status code of the response for the error of snapshot, 5xx if it is not of the request.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrBadParameter), errors.Is(err, ErrScanLimit):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrInsufficientHistory), errors.Is(err, ErrBookReset):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrStorage):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrOverloaded):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// streamWriter writes the response with status 200 at the first write, so that the status can be
+// changed until anything is written. Each write is flushed to the client.
+type streamWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	// encoding is the content encoding of what is written, not encoded if empty
+	encoding string
+	started  bool
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if !s.started {
+		s.w.Header().Set("Content-Type", s.contentType)
+		if s.encoding != "" {
+			s.w.Header().Set("Content-Encoding", s.encoding)
+			s.w.Header().Set("Vary", "Accept-Encoding")
+		}
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	n, err := s.w.Write(p)
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// statusRecorder remembers the status and the size of the response, writes are flushed as those of the underlying writer.
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// identify gives an ID to the request and tells it in the response, the ID given by the client is used if it is valid.
+func identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := RequestID(r.Header.Get(RequestIDHeader))
+		r.Header.Set(RequestIDHeader, id)
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := Logger.With("request_id", r.Header.Get(RequestIDHeader))
+	st := time.Now()
+	// the same parameters as API Gateway gives
+	query := r.URL.Query()
+	event := events.APIGatewayProxyRequest{
+		PathParameters:                  map[string]string{"exchange": r.PathValue("exchange"), "nanosec": r.PathValue("nanosec")},
+		QueryStringParameters:           make(map[string]string),
+		MultiValueQueryStringParameters: query,
+		Headers:                         map[string]string{"If-None-Match": r.Header.Get("If-None-Match"), "Accept-Encoding": r.Header.Get("Accept-Encoding")},
+	}
+	for name, values := range query {
+		event.QueryStringParameters[name] = values[len(values)-1]
+	}
+	record := NewRequestRecord(r.Header.Get(RequestIDHeader), event)
+	recorder := &statusRecorder{ResponseWriter: w}
+	w = recorder
+	defer func() {
+		record.Status = recorder.status
+		record.Size = recorder.written
+		record.Log()
+	}()
+	if r.Body != nil {
+		// state exported by the previous request
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(MaxRequestBodyKB)*1024))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		event.Body = string(body)
+	}
+	param, err := ParseParameter(event)
+	if err != nil {
+		record.Error = err.Error()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(param.Exchanges) == 0 {
+		log = log.With("exchange", param.Exchange)
+	}
+	limitScan(ctx, &param)
+	ctx = WithLogger(ctx, log)
+	if param.Latest {
+		if err := ResolveLatest(ctx, &param, time.Now()); err != nil {
+			if errors.Is(err, ErrDatasetGap) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			writeError(ctx, w, err)
+			return
+		}
+	}
+	if param.DryRun {
+		result, err := DryRun(ctx, param)
+		if err != nil {
+			writeError(ctx, w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(result)
+		return
+	}
+	if param.Nearest {
+		result, err := Nearest(ctx, param)
+		if err != nil {
+			writeError(ctx, w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(result)
+		return
+	}
+	if param.MadeAtOnce() {
+		// results are made at once
+		result, report, err := Take(ctx, param)
+		addUsage(ctx, report.Scanned)
+		record.Observe(report, err)
+		if err != nil {
+			writeError(ctx, w, err)
+			return
+		}
+		if report.ETag != "" {
+			w.Header().Set("ETag", report.ETag)
+		}
+		if report.NotModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if len(result) == 0 && report.ResumeToken == "" {
+			// the stopped scan could have reached no target yet, which is not the end
+			http.Error(w, "no snapshot", http.StatusNotFound)
+			return
+		}
+		if param.Encoding != "" {
+			if result, err = EncodeResult(result, param.Encoding); err != nil {
+				writeError(ctx, w, err)
+				return
+			}
+			w.Header().Set("Content-Encoding", param.Encoding)
+			w.Header().Set("Vary", "Accept-Encoding")
+		}
+		w.Header().Set("Content-Type", ResponseContentType(param, report))
+		w.Header().Set(SchemaVersionHeader, ResponseSchemaVersion(param))
+		w.Header().Set(trailerTimestamp, strconv.FormatInt(report.LastTimestamp, 10))
+		if report.Token != "" {
+			w.Header().Set(trailerToken, report.Token)
+		}
+		if report.NextPage != "" {
+			w.Header().Set("X-Snapshot-Next-Page", report.NextPage)
+		}
+		if report.ResumeToken != "" {
+			w.Header().Set("X-Snapshot-Resume-Token", report.ResumeToken)
+		}
+		if report.Channels.HasProblems() {
+			w.Header().Set(trailerChannels, report.Channels.Header())
+		}
+		if param.SinceHash != "" {
+			w.Header().Set("X-Snapshot-Delta", strconv.FormatBool(!report.FullSnapshot))
+		}
+		w.Write(result)
+		return
+	}
+	source, err := OpenSource(ctx, &param)
+	if err != nil {
+		writeError(ctx, w, asSnapshotError(err, ErrStorage))
+		return
+	}
+	defer source.Close()
+	w.Header().Set("Trailer", trailerTimestamp+", "+trailerPartial+", "+trailerError+", "+trailerToken+", "+trailerChannels)
+	w.Header().Set(SchemaVersionHeader, ResponseSchemaVersion(param))
+	stream := &streamWriter{w: w, contentType: ContentTypes[param.Output], encoding: param.Encoding}
+	// snapshots are compressed as they are written
+	encoded := NewEncodingWriter(stream, param.Encoding)
+	report, err := SnapshotTo(ctx, param, source, encoded)
+	if serr := encoded.Close(); err == nil {
+		err = serr
+	}
+	addUsage(ctx, report.Scanned)
+	record.Observe(report, err)
+	if err != nil {
+		if !stream.started {
+			writeError(ctx, w, err)
+			return
+		}
+		// the status is already sent, clients tell the failure by the trailer
+		log.Warn("snapshot failed while streaming", "error", err)
+		w.Header().Set(trailerError, err.Error())
+		return
+	}
+	if !stream.started {
+		http.Error(w, "no snapshot", http.StatusNotFound)
+		return
+	}
+	w.Header().Set(trailerTimestamp, strconv.FormatInt(report.LastTimestamp, 10))
+	if report.Partial != "" {
+		w.Header().Set(trailerPartial, report.Partial)
+	}
+	if report.Token != "" {
+		w.Header().Set(trailerToken, report.Token)
+	}
+	if report.Channels.HasProblems() {
+		w.Header().Set(trailerChannels, report.Channels.Header())
+	}
+	log.Info("snapshot end", "scanned", report.Scanned, "elapsed", time.Now().Sub(st))
+}
+
+// writeError writes the response of `err`, errors not of the request are logged and not shown to clients.
+func writeError(ctx context.Context, w http.ResponseWriter, err error) {
+	if context.Cause(ctx) == ErrShuttingDown {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if ctx.Err() != nil {
+		// client has gone away
+		return
+	}
+	code := statusCode(err)
+	if code == http.StatusServiceUnavailable {
+		// clients can retry later or on other servers
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if code >= 500 {
+		LoggerFrom(ctx).Error("snapshot failed", "error", err)
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	http.Error(w, err.Error(), code)
+}
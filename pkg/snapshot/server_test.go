@@ -0,0 +1,89 @@
+package snapshot
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "bitmex_26649017.gz"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	DatasetDirectory = dir
+	defer func() { DatasetDirectory = "" }()
+	server := httptest.NewServer(NewHTTPHandler())
+	defer server.Close()
+	for path, expected := range map[string]int{
+		"/snapshot/bitmex/1598941025000000000?channels=orderBookL2&dryRun=true": http.StatusOK,
+		"/snapshot/bitmex/1598941025000000000":                                  http.StatusBadRequest,
+		"/snapshot/bitmex/not-a-time?channels=orderBookL2":                      http.StatusBadRequest,
+		"/unknown": http.StatusNotFound,
+	} {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != expected {
+			t.Errorf("%s: expected %d, got %d", path, expected, res.StatusCode)
+		}
+		if path != "/unknown" && res.Header.Get(RequestIDHeader) == "" {
+			t.Errorf("%s: expected the request ID in the response", path)
+		}
+	}
+	// the ID of the client is returned as it is
+	req, err := http.NewRequest("GET", server.URL+"/snapshot/bitmex/1598941025000000000?channels=orderBookL2&dryRun=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(RequestIDHeader, "client-id")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if id := res.Header.Get(RequestIDHeader); id != "client-id" {
+		t.Errorf("expected the ID of the client, got %s", id)
+	}
+}
+
+func TestHTTPHandlerBodyLimit(t *testing.T) {
+	defer func(limit int) { MaxRequestBodyKB = limit }(MaxRequestBodyKB)
+	MaxRequestBodyKB = 1
+	server := httptest.NewServer(NewHTTPHandler())
+	defer server.Close()
+	req, err := http.NewRequest("GET", server.URL+"/snapshot/bitmex/1598941025000000000?channels=orderBookL2&dryRun=true", bytes.NewReader(make([]byte, 2048)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", res.StatusCode)
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	if code := statusCode(newSnapshotError(ErrBadParameter, os.ErrInvalid)); code != http.StatusBadRequest {
+		t.Errorf("bad parameter: expected 400, got %d", code)
+	}
+	if code := statusCode(newSnapshotError(ErrStorage, os.ErrNotExist)); code != http.StatusBadGateway {
+		t.Errorf("storage: expected 502, got %d", code)
+	}
+	if code := statusCode(os.ErrClosed); code != http.StatusInternalServerError {
+		t.Errorf("other: expected 500, got %d", code)
+	}
+}
@@ -0,0 +1,1543 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/exchangedataset/streamcommons/formatter"
+	"github.com/exchangedataset/streamcommons/simulator"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// reasons why snapshots are partial
+const (
+	// PartialMissingFile means some dataset files did not exist
+	PartialMissingFile = "missing_file"
+	// PartialScanFailed means reading dataset failed before targets, snapshots are as of where it failed
+	PartialScanFailed = "scan_failed"
+	// PartialQuotaExceeded means scanning stopped as it reached `maxScanBytes`, snapshots are as of where it stopped
+	PartialQuotaExceeded = "quota_exceeded"
+	// PartialResumable means scanning stopped before the deadline of the request, the rest of targets are taken
+	// by resuming the scan with `Report.ResumeToken`
+	PartialResumable = "resumable"
+)
+
+// SnapshotParameter is the parameter for snapshot.
+// It is made from requests by ParseParameter, or can be filled directly when this package is embedded.
+type SnapshotParameter struct {
+	// Exchange is the exchange to take snapshots of
+	Exchange string
+	// Nanosecs is the list of target timestamps, sorted in ascending order without duplicates
+	Nanosecs []int64
+	// Exclusive is true if lines exactly at targets are not applied to snapshots at them
+	Exclusive bool
+	// Latest is true if the target is the end of the newest dataset file, `Nanosecs` are resolved by ResolveLatest
+	Latest bool
+	// Channels is the list of channels or patterns of them to take snapshots of
+	Channels []string
+	// Format is the format messages are written in, `raw` for messages as they are in dataset
+	Format string
+	// Formats are the formats snapshots are written in from a single scan, `Format` is the first of them.
+	// It is empty unless multiple formats are requested.
+	Formats []string
+	// Compression is the compression of dataset files to read, one of the keys of `extensions`
+	Compression string
+	// StartMinute is the minute of the first dataset file to read, set by OpenSource
+	StartMinute int64
+	// Granularity is the granularity of dataset files, one of `Granularity*`, minute files if empty
+	Granularity string
+	// State is the state exported by the previous request to continue from, nil if not specified
+	State []byte
+	// StateNanosec is the timestamp `State` was exported at
+	StateNanosec int64
+	// ExportState is true if the state at the last target should be exported
+	ExportState bool
+	// Resumable is true if the scan should stop before the deadline of the request to be resumed,
+	// instead of failing when it reaches the deadline
+	Resumable bool
+	// ResumeToken is the token returned with the result of the stopped scan to resume it with
+	ResumeToken string
+	// Diff is true if the difference between snapshots at two targets should be returned instead of snapshots
+	Diff bool
+	// SinceHash is the hash of entries of the snapshot at the first target the client has,
+	// the full snapshot at the second target is returned instead of the difference if it does not match
+	SinceHash string
+	// ReplayUntil is the timestamp until which messages after the target are returned, 0 if not replaying
+	ReplayUntil int64
+	// PostFilter is the list of channels to return after formatting, everything is returned if empty
+	PostFilter []string
+	// Symbols is the list of instruments to take snapshot of, every instrument if empty
+	Symbols []string
+	// FanOut is true if snapshots of each of `Symbols` are written separately from a single scan
+	FanOut bool
+	// Depth is the number of the best orderbook levels of each side to return, every level if 0
+	Depth int
+	// Bucket is the width of price buckets to aggregate orderbook levels into, not aggregated if 0
+	Bucket float64
+	// Side is the side of orderbook levels to return, either `sideBid` or `sideAsk`, both sides if 0
+	Side int
+	// WithinPercent is the range around mid price in percent to return orderbook levels within, every level if 0
+	WithinPercent float64
+	// Fields are the fields of messages other than orderbook levels to return, every field if empty
+	Fields []string
+	// BucketDecimals is the number of digits after the decimal point in `bucket`
+	BucketDecimals int
+	// Destination is the location in the form of `s3://bucket/key` to write the snapshot to instead of returning it, if not empty
+	Destination string
+	// DestinationCompression is the compression of the object written to `Destination`, either `gzip` or empty
+	DestinationCompression string
+	// ExportKey is the key of the object in `ExportBucket` to write the result to in parquet output
+	ExportKey string
+	// IsolateErrors is true if channels failed to be processed are quarantined instead of failing the request
+	IsolateErrors bool
+	// Naming is the naming of channels in the request and the output, either `NamingNative` or `NamingNormalized`
+	Naming string
+	// NormalizedChannels is the requested channels in the normalized naming, `Channels` are native channels of them
+	NormalizedChannels []string
+	// Parallel is the number of workers simulating groups of channels in parallel, simulated in the feeding goroutine if not more than 1
+	Parallel int
+	// PrefetchFiles is the number of files downloaded and decompressed ahead, default if not positive
+	PrefetchFiles int
+	// ReadAheadBlocks is the number of decompressed blocks buffered for each file, default if not positive
+	ReadAheadBlocks int
+	// FetchConcurrency is the number of objects downloaded concurrently if the source supports it
+	FetchConcurrency int
+	// DatasetBucket is the S3 bucket to read dataset files from instead of the default one, if not empty
+	DatasetBucket string
+	// DatasetRoleARN is the ARN of the IAM role assumed to read `DatasetBucket`, the role configured for it is used if empty
+	DatasetRoleARN string
+	// DatasetKMSKeyARN is the ARN of the KMS key objects in `DatasetBucket` have to be encrypted with by SSE-KMS,
+	// objects are not checked if empty
+	DatasetKMSKeyARN string
+	// DatasetClientSideEncryption is true if objects in `DatasetBucket` are encrypted on the client side with keys of KMS
+	DatasetClientSideEncryption bool
+	// DatasetPrefix is prepended to the names of dataset files
+	DatasetPrefix string
+	// Lenient is true if malformed lines in dataset are skipped
+	Lenient bool
+	// ScanReport is true if the report of the scan is returned with the snapshot
+	ScanReport bool
+	// Debug is true if the breakdown of time spent in the scan by files and channels is measured for the report
+	Debug bool
+	// MaxScanBytes is the maximum bytes of decompressed dataset scanned, unlimited if 0
+	MaxScanBytes int64
+	// RecentTrades is the number of the last messages of trade channels written after snapshots, not written if 0
+	// unless `RecentTradesNanosec` is given
+	RecentTrades int
+	// RecentTradesNanosec is the nanoseconds before targets messages of trade channels written after snapshots are within,
+	// not limited by time if 0. Only messages read in the scan are written.
+	RecentTradesNanosec int64
+	// DryRun is true if only the estimated cost is returned without scanning
+	DryRun bool
+	// Nearest is true if the nearest snapshot materialized at or before the target is looked up in the catalog without scanning
+	Nearest bool
+	// IfNoneMatch is the entity tags of results the client has, the result is not returned if it has one of them
+	IfNoneMatch string
+	// MaxLookbackMinutes is the maximum minutes of dataset read before the first target, unlimited if 0
+	MaxLookbackMinutes int64
+	// Audit is true if orderbooks are compared with REST APIs of the exchange for recent targets
+	Audit bool
+	// Verify is true if update IDs in messages are checked to report dropped updates
+	Verify bool
+	// CrossValidate is true if formatted snapshots are compared with raw snapshots to report discrepancies
+	CrossValidate bool
+	// ChannelOrder is the order of channels in snapshots, channels are sorted by name if empty
+	ChannelOrder []string
+	// AsOf is true if snapshots have the timestamp of the last line updated each channel
+	AsOf bool
+	// Stats is true if snapshots have an entry summarizing lines applied in the scan until them
+	Stats bool
+	// MissingChannels is how requested channels without data are handled, one of `missingChannels*`
+	MissingChannels string
+	// Reconnects is how start lines discarding the state before targets are handled, one of `Reconnects*`
+	Reconnects string
+	// SafeParsing is true if messages given to the simulator are copied so that they can be retained
+	SafeParsing bool
+	// NoPrefilter is true if lines of all channels are given to the simulator
+	NoPrefilter bool
+	// AllStates is true if the initial state of every file is applied even if the simulator already has it
+	AllStates bool
+	// Partial is true if snapshots are returned even if dataset is missing or failed to be read
+	Partial bool
+	// Output is the layout of the response, one of the outputs in `ContentTypes`
+	Output string
+	// SchemaVersion is the version of the layout of `Output` clients parse, see CurrentSchemaVersion
+	SchemaVersion int
+	// PageBytes is the maximum bytes of a result returned at once, the rest is returned in the next pages.
+	// Results are not split if 0.
+	PageBytes int64
+	// PageToken is the token of the page of the result made by the previous request to return, instead of taking snapshots
+	PageToken string
+	// TimestampUnit is the unit of timestamps in tsv and csv output, one of `Timestamp*`, nanoseconds if empty
+	TimestampUnit string
+	// OmitTimestamp is true if the column of the target is not written in lines of snapshots in tsv and csv output
+	OmitTimestamp bool
+	// StrictChannels is true if requests of channels which the simulator or the formatter does not support fail
+	StrictChannels bool
+	// Encoding is the content encoding the response is compressed in, either `EncodingGzip` or `EncodingDeflate`,
+	// not compressed if empty
+	Encoding string
+	// MetricsBps is the range around mid price in basis points for sizes in metrics channels
+	MetricsBps float64
+	// Exchanges is the list of exchanges to take snapshots of at the same time, including `Exchange`.
+	// It is empty unless multiple exchanges are requested.
+	Exchanges []ExchangeChannels
+	// record is where snapshots taken at targets are recorded to write them in other formats, if not nil
+	record *snapshotRecord
+	// replay is the snapshots recorded by the previous pass, replayed instead of dataset if not nil
+	replay *snapshotRecord
+	// manifests is how dataset files are read as planned by their manifests, nil if they are not used
+	manifests *manifestPlan
+	// onSnapshot is called with the target after the snapshot at it is written and flushed, if not nil
+	onSnapshot func(nanosec int64) error
+	// prefetchObjects is the number of objects fetched ahead from the default S3 location as planned by OpenSource,
+	// all of them are fetched at once if 0
+	prefetchObjects int
+	// plannedFiles is the number of dataset files and checkpoints planned to be read by OpenSource, for progress of the scan
+	plannedFiles int
+}
+
+// contextCheckInterval is the number of lines fed to the simulator between checks of the context.
+const contextCheckInterval = 1024
+
+// Feeder holds what is needed to feed dataset files to the simulator, shared among files.
+type Feeder struct {
+	// Sim is the simulator lines are applied to, replaced by SetNewSim at each start line
+	Sim *simulator.Simulator
+	// SetNewSim sets the new simulator to the pointer given as the connection was made again
+	SetNewSim func(*simulator.Simulator) error
+	// Targets is the list of targets not reached yet
+	Targets []int64
+	// OnTarget is called with the target timestamp each time the simulator reaches the state right at the target,
+	// in the order of `Targets`
+	OnTarget func(int64) error
+	// lines with timestamp not after skipUntil are skipped as they are already applied to the simulator
+	skipUntil int64
+	// msg lines after the last target until replayUntil are passed to onReplay instead of the simulator
+	replayUntil int64
+	// exclusive is true if lines at a target are applied after the snapshot at it is taken
+	exclusive bool
+	onReplay  func(timestamp int64, channel string, line []byte) error
+	// LastTimestamp is the timestamp of the last line applied to the simulator before the target
+	LastTimestamp int64
+	// lenient is true if malformed lines are skipped instead of failing
+	lenient bool
+	// skippedLines is the number of malformed lines skipped
+	skippedLines int
+	// truncatedFiles is the number of files fed which were truncated
+	truncatedFiles int
+	// gaps is the number of times lines were lost between lines fed, by missing or truncated files
+	gaps int
+	// processTime is the total time the simulator took to process lines
+	processTime time.Duration
+	// channelUpdated is the map of channels to the timestamp of the last line applied of them, not tracked if nil
+	channelUpdated map[string]int64
+	// maxScan is the maximum bytes to scan in total, unlimited if 0
+	maxScan int64
+	// scannedBefore is the bytes scanned before the current file
+	scannedBefore int64
+	// copyMessages is true if messages are copied out of the read buffer before given to the simulator
+	copyMessages bool
+	// prefilter skips msg lines of channels the simulator does not need if not nil
+	prefilter *channelPrefilter
+	// lineBuf is the buffer for lines longer than the buffer of the reader
+	lineBuf []byte
+	// channelNames interns channel names so that they are not allocated for every line
+	channelNames map[string]string
+	// dedupeStates is true if the initial state of a file is skipped when lines have been fed continuously until it
+	dedupeStates bool
+	// haveBase is true if the simulator has been given a start line or a state to build on
+	haveBase bool
+	// continuous is true if messages have been fed since the base without a gap,
+	// so that the simulator already has the initial state dumped at the beginning of the next file
+	continuous bool
+	// skippedStates is the number of state lines skipped as the simulator already had them
+	skippedStates int
+	// discard is the bytes at the beginning of the next file which are read without being parsed
+	discard int64
+	// manifest records channels and start lines of the file being fed if not nil
+	manifest *manifestBuilder
+	// trades keeps recent messages of trade channels applied if not nil
+	trades *tradeHistory
+	// timing measures the breakdown of time spent by files and channels if not nil
+	timing *scanTiming
+	// stats counts lines applied to the simulator if not nil
+	stats *scanStats
+	// reconnects are timestamps of start lines applied after the simulator had state, which discarded it
+	reconnects []int64
+	// anchor is the timestamp of the last start line or initial state of a file, which the book can be built from
+	anchor int64
+}
+
+// FeedToSimulator feeds lines to the simulator until a line after the last target in `f.Targets` is found,
+// or after `f.replayUntil` when replaying.
+// `stop` is true if all targets are reached and nothing more has to be read.
+// It returns the error of `ctx` if it is done while feeding.
+// Lines are parsed in the buffer of `reader` without allocation, so messages given to the simulator
+// are only valid during the call unless `f.copyMessages` is set, while channels and start lines can be retained.
+func FeedToSimulator(ctx context.Context, reader *bufio.Reader, f *Feeder) (scanned int, stop bool, err error) {
+	tprocess := int64(0)
+	defer func() {
+		f.processTime += time.Duration(tprocess)
+	}()
+	// state lines at the beginning of the file or right after a start line are the initial state,
+	// they are applied even if they are after the target, as the state is as of before the first message.
+	// state lines in the middle of the file are as of its timestamp, so they trigger targets as messages do.
+	initial := true
+	for lines := 0; ; lines++ {
+		if lines%contextCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+		}
+		var parseStart time.Time
+		if f.timing != nil {
+			parseStart = time.Now()
+		}
+		var line []byte
+		line, err = f.readLine(reader)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+				break
+			}
+			return
+		}
+		scanned += len(line)
+		if f.maxScan > 0 && f.scannedBefore+int64(scanned) > f.maxScan {
+			// the line exceeding the limit is not applied
+			scanned -= len(line)
+			err = newSnapshotError(ErrScanLimit, fmt.Errorf("more than %d bytes would be scanned", f.maxScan))
+			return
+		}
+		var parsed datasetLine
+		parsed, err = parseLine(line)
+		if f.timing != nil {
+			f.timing.parse += time.Now().Sub(parseStart)
+		}
+		if err != nil {
+			if f.skipMalformed(line) {
+				err = nil
+				continue
+			}
+			return
+		}
+		if f.manifest != nil {
+			f.manifest.add(parsed, int64(scanned-len(line)))
+		}
+		timestamp := parsed.timestamp
+		if timestamp <= f.skipUntil {
+			continue
+		}
+		isMsg := bytes.Equal(parsed.typ, typeMsg)
+		isState := bytes.Equal(parsed.typ, typeState)
+		isStart := bytes.Equal(parsed.typ, typeStart)
+		// true if lines are not applied to the simulator but replayed
+		replaying := false
+		if !isState || !initial {
+			for len(f.Targets) > 0 && f.afterTarget(timestamp) {
+				// the simulator has the state at this target, this line should be applied after it
+				err = f.OnTarget(f.Targets[0])
+				if err != nil {
+					return
+				}
+				f.Targets = f.Targets[1:]
+			}
+			if len(f.Targets) == 0 {
+				if f.onReplay == nil || timestamp > f.replayUntil {
+					// lines after the last target time is not needed to construct a snapshot,
+					// the initial state is already applied if the target is before the first message
+					stop = true
+					return
+				}
+				replaying = true
+			}
+		} else if len(f.Targets) == 0 {
+			// state lines are not replayed
+			replaying = true
+		}
+		if !replaying && (isStart || (isState && initial)) {
+			f.anchor = timestamp
+		}
+		if !isState && !isStart {
+			initial = false
+			if isMsg && f.haveBase {
+				f.continuous = true
+			}
+		}
+		if isMsg || isState {
+			channel := f.channelName(parsed.channel)
+			if isMsg && f.prefilter != nil && !f.prefilter.Match(channel) {
+				// not to be parsed by the simulator, state lines are always applied as they are few
+				continue
+			}
+			if isState && initial && !replaying && f.dedupeStates && f.continuous {
+				// the state dumped at the beginning of the file is what the simulator has built from the previous file
+				f.skippedStates++
+				if len(f.Targets) > 0 && !f.afterTarget(timestamp) {
+					f.LastTimestamp = timestamp
+				}
+				if f.channelUpdated != nil {
+					f.channelUpdated[channel] = f.LastTimestamp
+				}
+				continue
+			}
+			message := parsed.message
+			if f.copyMessages {
+				// simulator can retain the message
+				message = append([]byte(nil), message...)
+			}
+			if replaying {
+				if isMsg {
+					err = f.onReplay(timestamp, channel, message)
+					if err != nil {
+						err = asSnapshotError(err, ErrSimulator)
+						return
+					}
+				}
+				continue
+			}
+			st := time.Now()
+			if isMsg {
+				err = (*f.Sim).ProcessMessageChannelKnown(channel, message)
+			} else {
+				err = (*f.Sim).ProcessState(channel, message)
+			}
+			elapsed := time.Now().Sub(st)
+			tprocess += elapsed.Nanoseconds()
+			if f.timing != nil {
+				f.timing.add(channel, message, elapsed)
+			}
+			if err != nil {
+				err = newSnapshotError(ErrSimulator, err)
+				return
+			}
+			if isState {
+				f.haveBase = true
+			} else {
+				if f.trades != nil {
+					f.trades.add(timestamp, channel, message)
+				}
+				if f.stats != nil {
+					f.stats.messages[channel]++
+				}
+			}
+			// state lines of the initial state after the target are also applied, but the state is not as of them
+			if len(f.Targets) > 0 && !f.afterTarget(timestamp) {
+				f.LastTimestamp = timestamp
+			}
+			if f.channelUpdated != nil {
+				f.channelUpdated[channel] = f.LastTimestamp
+			}
+			continue
+		} else if isStart && !replaying {
+			initial = true
+			if f.haveBase || f.LastTimestamp != 0 {
+				f.reconnects = append(f.reconnects, timestamp)
+			}
+			// start line is retained to be replayed to new simulators
+			url := make([]byte, len(parsed.message))
+			copy(url, parsed.message)
+			err = f.SetNewSim(f.Sim)
+			if err != nil {
+				err = newSnapshotError(ErrSimulator, err)
+				return
+			}
+			st := time.Now()
+			err = (*f.Sim).ProcessStart(url)
+			tprocess += time.Now().Sub(st).Nanoseconds()
+			if err != nil {
+				err = newSnapshotError(ErrSimulator, err)
+				return
+			}
+			if f.stats != nil {
+				f.stats.starts++
+			}
+			f.LastTimestamp = timestamp
+			// the state of the new connection follows, which has to be applied
+			f.haveBase = true
+			f.continuous = false
+			continue
+		}
+		// ignore other lines
+	}
+	LoggerFrom(ctx).Debug("fed to simulator", "scanned", scanned, "process_time", time.Duration(tprocess))
+	return
+}
+
+// breakContinuity makes the initial state of the next file applied,
+// as lines between it and the lines fed so far are lost.
+func (f *Feeder) breakContinuity() {
+	f.gaps++
+	f.haveBase = false
+	f.continuous = false
+}
+
+// afterTarget returns true if a line at `timestamp` has to be applied after the snapshot at the next target.
+func (f *Feeder) afterTarget(timestamp int64) bool {
+	if f.exclusive {
+		return timestamp >= f.Targets[0]
+	}
+	return timestamp > f.Targets[0]
+}
+
+// types of lines in dataset files
+var (
+	typeMsg   = []byte("msg")
+	typeState = []byte("state")
+	typeStart = []byte("start")
+)
+
+// readLine returns the next line including the newline at the end.
+// Returned slice is only valid until the next call, `io.EOF` is returned if there is no more line.
+func (f *Feeder) readLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadSlice('\n')
+	if err == nil {
+		return line, nil
+	}
+	if err == bufio.ErrBufferFull {
+		// line is longer than the buffer, read the rest into the buffer of the feeder
+		f.lineBuf = append(f.lineBuf[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = reader.ReadSlice('\n')
+			f.lineBuf = append(f.lineBuf, line...)
+		}
+		line = f.lineBuf
+	}
+	if err == io.EOF && len(line) > 0 {
+		// the last line is not terminated
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+// skipMalformed returns true if malformed `line` should be skipped in lenient mode, counting skipped lines.
+func (f *Feeder) skipMalformed(line []byte) bool {
+	if !f.lenient {
+		return false
+	}
+	if f.skippedLines < maxMalformedLogs {
+		Logger.Warn("skipping malformed line", "line", string(line))
+	}
+	f.skippedLines++
+	return true
+}
+
+// maxMalformedLogs is the number of malformed lines printed in a request.
+const maxMalformedLogs = 10
+
+// channelName returns the channel as string which can be retained, without allocation if it has appeared before.
+func (f *Feeder) channelName(b []byte) string {
+	if channel, ok := f.channelNames[string(b)]; ok {
+		return channel
+	}
+	if f.channelNames == nil {
+		f.channelNames = make(map[string]string)
+	}
+	channel := string(b)
+	f.channelNames[channel] = channel
+	return channel
+}
+
+// feedBufferSize is the default size of the buffer decompressed dataset is read through.
+// Larger buffers than it did not make scans faster in BenchmarkFeedBufferSize.
+const feedBufferSize = 256 * 1024
+
+// Feed feeds decompressed dataset file from `reader` to the simulator.
+func Feed(ctx context.Context, reader io.ReadCloser, f *Feeder) (scanned int, stop bool, err error) {
+	defer func() {
+		serr := reader.Close()
+		if serr != nil {
+			if err != nil {
+				err = fmt.Errorf("%v, original error was: %v", serr, err)
+			} else {
+				err = serr
+			}
+			return
+		}
+	}()
+	breader := getReader(reader, ReadBufferKB*1024)
+	defer putReader(breader)
+	discarded := 0
+	if f.discard > 0 {
+		// lines before it are superseded by the start line at it
+		discarded, err = breader.Discard(int(f.discard))
+		f.discard = 0
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		f.scannedBefore += int64(discarded)
+	}
+	if err == nil {
+		scanned, stop, err = FeedToSimulator(ctx, breader, f)
+	}
+	scanned += discarded
+	if isTruncated(err) {
+		// lines until the truncated tail are applied, the incomplete last line is discarded
+		f.truncatedFiles++
+		f.breakContinuity()
+		err = nil
+	}
+	return
+}
+
+// isTruncated returns true if `err` tells the dataset file ended in the middle of the compressed stream or a line.
+// Connections closed by the storage are not truncation of the file itself.
+func isTruncated(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, ErrStorage)
+}
+
+// entry is a line of snapshot, message is formatted if formatter is specified.
+type entry struct {
+	channel string
+	message []byte
+	// asOf is the timestamp of the last line updated the channel the entry is made from, 0 if it is not tracked
+	asOf int64
+}
+
+// takeSnapshot takes snapshot of the simulator and formats it with `form` if it is not nil.
+// Entries have timestamps in `updated` of channels they are made from if it is not nil.
+func takeSnapshot(sim simulator.Simulator, form formatter.Formatter, updated map[string]int64) (entries []entry, err error) {
+	snapshots, err := sim.TakeSnapshot()
+	if err != nil {
+		return
+	}
+	entries = make([]entry, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		if form != nil {
+			// if formatter is specified, write formatted
+			formatted, serr := form.FormatMessage(snapshot.Channel, snapshot.Snapshot)
+			if serr != nil {
+				return nil, serr
+			}
+			for _, f := range formatted {
+				entries = append(entries, entry{channel: f.Channel, message: f.Message, asOf: updated[snapshot.Channel]})
+			}
+		} else {
+			entries = append(entries, entry{channel: snapshot.Channel, message: snapshot.Snapshot, asOf: updated[snapshot.Channel]})
+		}
+	}
+	return
+}
+
+// muxOf returns muxSimulator wrapped in `sim`.
+func muxOf(sim simulator.Simulator) *muxSimulator {
+	sim = sim.(*startRecorder).Simulator
+	if isolating, ok := sim.(*isolatingSimulator); ok {
+		sim = isolating.Simulator
+	}
+	if checking, ok := sim.(*sequenceCheckingSimulator); ok {
+		sim = checking.Simulator
+	}
+	if filtering, ok := sim.(*symbolFilteringSimulator); ok {
+		sim = filtering.Simulator
+	}
+	return sim.(*muxSimulator)
+}
+
+// sortEntries sorts `entries` by channel in `order`, keeping the order of entries in the same channel.
+// Channels in `order` can be prefixes of channels of entries such as `orderBookL2` of `orderBookL2_XBTUSD`,
+// channels not in `order` follow them in the order of name.
+func sortEntries(entries []entry, order []string) {
+	rank := func(channel string) int {
+		for i, c := range order {
+			if channel == c || (strings.HasPrefix(channel, c) && channel[len(c)] == '_') {
+				return i
+			}
+		}
+		return len(order)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		ri, rj := rank(entries[i].channel), rank(entries[j].channel)
+		if ri != rj {
+			return ri < rj
+		}
+		return entries[i].channel < entries[j].channel
+	})
+}
+
+// filterEntries returns entries of channels `match` returns true for.
+func filterEntries(entries []entry, match func(channel string) bool) []entry {
+	filtered := make([]entry, 0, len(entries))
+	for _, e := range entries {
+		if match(e.channel) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// writeEntries writes `entries` at `nanosec` as TSV lines in `layout`.
+func writeEntries(buffer *bufio.Writer, nanosec int64, entries []entry, layout lineLayout) (err error) {
+	prefix := layout.prefix(nanosec, "\t")
+	for _, e := range entries {
+		if _, err = buffer.WriteString(prefix); err != nil {
+			return
+		}
+		if _, err = buffer.WriteString(e.channel); err != nil {
+			return
+		}
+		if _, err = buffer.WriteRune('\t'); err != nil {
+			return
+		}
+		if layout.asOf {
+			if _, err = buffer.WriteString(formatTimestamp(e.asOf, layout.unit)); err != nil {
+				return
+			}
+			if _, err = buffer.WriteRune('\t'); err != nil {
+				return
+			}
+		}
+		if _, err = buffer.Write(e.message); err != nil {
+			return
+		}
+		if _, err = buffer.WriteRune('\n'); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// mergeTargets merges two sorted lists of targets into a sorted list without duplicates.
+func mergeTargets(a []int64, b []int64) []int64 {
+	merged := make([]int64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		var next int64
+		if j >= len(b) || (i < len(a) && a[i] <= b[j]) {
+			next = a[i]
+			i++
+		} else {
+			next = b[j]
+			j++
+		}
+		if len(merged) == 0 || merged[len(merged)-1] != next {
+			merged = append(merged, next)
+		}
+	}
+	return merged
+}
+
+// checkpointTargets returns the timestamps checkpoints are taken at, right before every minute after the first file
+// until the latest target of `param`, and the map of them to their minutes.
+func checkpointTargets(param SnapshotParameter) (targets []int64, minutes map[int64]int64) {
+	lastMinute := param.Nanosecs[len(param.Nanosecs)-1] / 60 / 1000000000
+	startMinute := param.StartMinute
+	if startMinute == 0 {
+		// the first file to read was not planned
+		startMinute = defaultStartMinute(param)
+	}
+	if lastMinute <= startMinute {
+		return nil, nil
+	}
+	targets = make([]int64, 0, lastMinute-startMinute)
+	minutes = make(map[int64]int64)
+	for minute := startMinute + 1; minute <= lastMinute; minute++ {
+		// state right before the minute begins
+		nanosec := minute*60*1000000000 - 1
+		minutes[nanosec] = minute
+		targets = append(targets, nanosec)
+	}
+	return
+}
+
+// isTarget returns true if `nanosec` is in the sorted list of targets.
+func isTarget(targets []int64, nanosec int64) bool {
+	i := sort.Search(len(targets), func(i int) bool { return targets[i] >= nanosec })
+	return i < len(targets) && targets[i] == nanosec
+}
+
+// Report is the report of how snapshots were taken, returned with them.
+type Report struct {
+	// Scanned is the number of bytes of decompressed dataset read
+	Scanned int64
+	// LastTimestamp is the timestamp of the last line applied to the simulator before the last target,
+	// which can be earlier than the target if data were sparse, or 0 if no line with timestamp was applied
+	LastTimestamp int64
+	// Partial is the reason why snapshots could be incomplete in partial mode, or empty if they are complete
+	Partial string
+	// SkippedLines is the number of malformed lines skipped in lenient mode
+	SkippedLines int
+	// SkippedStates is the number of state lines not applied as the simulator already had the state
+	SkippedStates int
+	// TruncatedFiles is the list of dataset files which ended in the middle, they are read until there
+	TruncatedFiles []string
+	// FilesRead is the number of dataset files read
+	FilesRead int
+	// ProcessTime is the time the simulator took to process lines
+	ProcessTime time.Duration
+	// CacheHits and cacheMisses are the numbers of dataset files which were and were not in the cache
+	CacheHits   int
+	CacheMisses int
+	// Files is the list of dataset files read with bytes scanned in each of them
+	Files []FileScan
+	// MissingFiles is the list of dataset files which did not exist
+	MissingFiles []string
+	// StoppedAt is where the scan stopped as the last target was reached, nil if it read through dataset
+	StoppedAt *ScanPosition
+	// ContentType is the content type of the result if it differs from that of the output, such as of multiple formats
+	ContentType string
+	// ETag is the entity tag of the result made at once, empty if it is empty
+	ETag string
+	// NotModified is true if the result was not returned as it matched `IfNoneMatch`
+	NotModified bool
+	// Token identifies the snapshot at the last target, to be given as `since` to take changes from it
+	Token string
+	// FullSnapshot is true if the snapshot was returned instead of changes as the client did not have the snapshot of `SinceHash`
+	FullSnapshot bool
+	// Channels is the report of requested channels and post filters which could not make output
+	Channels *ChannelReport
+	// NextPage is the token of the next page of the result, to be given as `page` to take it, empty if it is the last page
+	NextPage string
+	// ChannelTimes is the time simulators took for each channel, measured in debug mode
+	ChannelTimes []ChannelTiming
+	// ResumeToken is the token to resume the scan stopped before the deadline with, to be given as `resume`
+	// to take snapshots at the rest of targets, empty if the scan was not stopped
+	ResumeToken string
+}
+
+// getSimulator returns the simulator of `channels` of `exchange`, it is replaced in tests.
+var getSimulator = registeredSimulator
+
+// Snapshot reconstructs snapshots at each of `param.Nanosecs` in a single pass over files from `source` and returns them.
+// `err` is `*SnapshotError` telling the kind of the error, or the error of `ctx` if it is done before finishing.
+func Snapshot(ctx context.Context, param SnapshotParameter, source DatasetSource) (ret []byte, report Report, err error) {
+	st := time.Now()
+	ctx, span := StartSpan(ctx, "snapshot", attribute.String("exchange", param.Exchange), attribute.Int("targets", len(param.Nanosecs)))
+	defer func() {
+		span.SetAttributes(attribute.Int64("scanned", report.Scanned), attribute.Int("files_read", report.FilesRead), attribute.Int("size", len(ret)))
+		EndSpan(span, err)
+	}()
+	// snapshots beyond the memory ceiling are spilled to disk while dataset is scanned
+	buffer := newPooledSpillBuffer(ceiling)
+	defer buffer.Close()
+	report, err = SnapshotTo(ctx, param, source, buffer)
+	if c, ok := source.(cacheStatter); ok {
+		report.CacheHits, report.CacheMisses = c.CacheStats()
+	}
+	elapsed := time.Now().Sub(st)
+	observeSnapshot(param.Exchange, report, int(buffer.Len()), elapsed, err)
+	emitEMF(param.Exchange, report, elapsed, err)
+	if err != nil {
+		return
+	}
+	if buffer.Spilled() {
+		LoggerFrom(ctx).Info("snapshot was spilled to disk", "size", buffer.Len())
+	}
+	ret, err = buffer.Bytes()
+	return
+}
+
+// SnapshotTo is the same as Snapshot, but writes snapshots to `w` as soon as each of them is taken.
+// Nothing is written to `w` if the error is `ErrBadParameter`.
+func SnapshotTo(ctx context.Context, param SnapshotParameter, source DatasetSource, w io.Writer) (report Report, err error) {
+	st := time.Now()
+	log := LoggerFrom(ctx)
+	release, serr := budget.acquire(ctx, bufferMemory(param))
+	if serr != nil {
+		err = serr
+		return
+	}
+	defer release()
+	if waited := time.Now().Sub(st); waited > time.Second {
+		log.Info("waited for other snapshots", "elapsed", waited)
+	}
+	channels, serr := newChannelMatcher(param.Channels)
+	if serr != nil {
+		err = newSnapshotError(ErrBadParameter, serr)
+		return
+	}
+	postFilter, serr := newChannelMatcher(param.PostFilter)
+	if serr != nil {
+		err = newSnapshotError(ErrBadParameter, serr)
+		return
+	}
+	// post filters which matched nothing are reported, as typos in them silently make results empty
+	postFilterMatched, serr := newPostFilterTracker(param.PostFilter)
+	if serr != nil {
+		err = newSnapshotError(ErrBadParameter, serr)
+		return
+	}
+	symbols := newSymbolFilter(param.Exchange, param.Symbols)
+	// whether to output entry of the channel
+	outputFilter := func(channel string) bool {
+		return (postFilter.Empty() || postFilterMatched.Match(channel)) && symbols.Match(channel)
+	}
+	if param.Naming == NamingNormalized {
+		requested := make(map[string]bool)
+		for _, channel := range param.NormalizedChannels {
+			kind, base, quote, _ := parseNormalizedChannel(channel)
+			requested[kind+":"+base+"/"+quote] = true
+		}
+		outputFilter = func(channel string) bool {
+			normalized, ok := normalizedChannel(param.Exchange, channel)
+			if !ok {
+				normalized = channel
+			} else if !requested[normalized] {
+				// tables have instruments not requested
+				return false
+			}
+			return (postFilter.Empty() || postFilterMatched.Match(normalized)) && symbols.Match(channel)
+		}
+	}
+	// channels matching patterns are known only after they appear in dataset
+	patterns := hasPattern(param.Channels)
+	// channels failed to be processed, shared among simulators made in this request
+	quarantined := make(map[string]string)
+	var checker *sequenceChecker
+	if param.Verify {
+		checker = newSequenceChecker(param.Exchange)
+	}
+	// simulators of parallel simulation made in this request, workers are stopped at the end
+	var parallels []*parallelSimulator
+	defer func() {
+		for _, parallel := range parallels {
+			parallel.Close()
+		}
+	}()
+	// check if it has the right simulator for this request
+	setNewSim := func(simp *simulator.Simulator) error {
+		var sim simulator.Simulator
+		if param.replay != nil {
+			*simp = &startRecorder{Simulator: &replayedSimulator{record: param.replay}}
+			return nil
+		}
+		if patterns {
+			sim = newMuxSimulator(param.Exchange, channels)
+		} else if param.Parallel > 1 {
+			parallel, serr := newParallelSimulator(param.Exchange, param.Channels, param.Parallel)
+			if serr != nil {
+				return serr
+			}
+			parallels = append(parallels, parallel)
+			sim = parallel
+		} else {
+			var serr error
+			sim, serr = getSimulator(param.Exchange, param.Channels)
+			if serr != nil {
+				return serr
+			}
+		}
+		if symbols != nil {
+			sim = &symbolFilteringSimulator{Simulator: sim, filter: symbols}
+		}
+		if checker != nil {
+			// sequences start again in the new connection
+			checker.reset()
+			sim = &sequenceCheckingSimulator{Simulator: sim, checker: checker}
+		}
+		if param.IsolateErrors {
+			sim = &isolatingSimulator{Simulator: sim, quarantined: quarantined}
+		}
+		*simp = &startRecorder{Simulator: sim}
+		return nil
+	}
+	sim := new(simulator.Simulator)
+	serr = setNewSim(sim)
+	if serr != nil {
+		err = newSnapshotError(ErrBadParameter, serr)
+		return
+	}
+	var form formatter.Formatter
+	getFormatter := func() (formatter.Formatter, error) {
+		return form, nil
+	}
+	if param.Format != "raw" {
+		if patterns {
+			// formatter for channels matched so far
+			var formChannels int
+			getFormatter = func() (formatter.Formatter, error) {
+				matched := muxOf(*sim).Channels()
+				if len(matched) == 0 {
+					return nil, nil
+				}
+				if form == nil || formChannels != len(matched) {
+					newForm, serr := newFormatter(param.Exchange, matched, param.Format)
+					if serr != nil {
+						return nil, serr
+					}
+					form = newForm
+					formChannels = len(matched)
+				}
+				return form, nil
+			}
+		} else {
+			// check if it has the right formatter for this exhcange and format
+			form, serr = newFormatter(param.Exchange, param.Channels, param.Format)
+			if serr != nil {
+				err = newSnapshotError(ErrBadParameter, serr)
+				return
+			}
+		}
+	}
+	if param.replay == nil {
+		report.Channels = checkChannels(param.Exchange, param.Channels, param.Format)
+	}
+	buffer := bufio.NewWriter(w)
+	// targets which are not reached yet
+	targets := param.Nanosecs
+	// checkpoints are taken at the beginning of every minute after the first file
+	var checkpointAt map[int64]int64
+	store := checkpointStoreFor(param)
+	if store != nil {
+		var checkpointNanosecs []int64
+		checkpointNanosecs, checkpointAt = checkpointTargets(param)
+		targets = mergeTargets(targets, checkpointNanosecs)
+	}
+	// snapshot at the first target in diff mode
+	var diffBase []entry
+	// snapshots to write at the end in JSON output
+	var jsonSnapshots []jsonSnapshot
+	csvWriter := csv.NewWriter(buffer)
+	// header is written before the first row
+	csvStarted := false
+	// header of protobuf output is written before the first snapshot
+	protobufStarted := false
+	arrowWriter := newArrowWriter(buffer)
+	// entries in NDJSON output are written after metadata known at the end
+	ndjsonBody := new(bytes.Buffer)
+	if param.Output == OutputParquet {
+		arrowWriter = newParquetWriter(buffer)
+	}
+	// channels appeared in dataset, interned by the feeder
+	channelNames := make(map[string]string)
+	// timestamps of the last lines applied of channels, updated by the feeder
+	var channelUpdated map[string]int64
+	if param.AsOf || param.Stats {
+		channelUpdated = make(map[string]int64)
+	}
+	// entries have timestamps of channels only if requested
+	var asOf map[string]int64
+	if param.AsOf {
+		asOf = channelUpdated
+	}
+	var stats *scanStats
+	if param.Stats {
+		stats = newScanStats()
+	}
+	// recent trades are kept through the scan, they are recorded with snapshots in replayed passes
+	var trades *tradeHistory
+	if param.replay == nil {
+		trades = newTradeHistory(param)
+	}
+	// the feeder is made after snapshots are defined to be written at targets
+	var f *Feeder
+	var saving sync.WaitGroup
+	defer saving.Wait()
+	onTarget := func(nanosec int64) error {
+		if minute, ok := checkpointAt[nanosec]; ok {
+			startLine := (*sim).(*startRecorder).startLine
+			// simulator does not have the complete state if start line was not read, lines were lost in the scan
+			// or channels were quarantined, checkpoints are complete as results cached are
+			complete := f.skippedLines == 0 && f.truncatedFiles == 0 && f.gaps == 0 && len(quarantined) == 0
+			if startLine != nil && complete {
+				snapshots, serr := (*sim).TakeSnapshot()
+				if serr != nil {
+					return serr
+				}
+				data, serr := encodeCheckpoint(nanosec, startLine, snapshots)
+				if serr != nil {
+					return serr
+				}
+				saving.Add(1)
+				go func() {
+					defer saving.Done()
+					// failing to save checkpoint does not affect the result
+					if serr := store.Save(param.Exchange, param.Channels, minute, data); serr != nil {
+						log.Warn("could not save checkpoint", "minute", minute, "error", serr)
+					}
+				}()
+			}
+		}
+		if !isTarget(param.Nanosecs, nanosec) {
+			return nil
+		}
+		if nanosec == param.Nanosecs[0] && param.State == nil && param.replay == nil && f.anchor != 0 {
+			// prefetch of later requests is planned from it
+			anchors.observe(param.Exchange, nanosec-f.anchor)
+		}
+		if param.MaxLookbackMinutes > 0 && (*sim).(*startRecorder).startLine == nil {
+			// files before the window were not read, so the state is incomplete
+			return newSnapshotError(ErrInsufficientHistory, fmt.Errorf("no start line within %d minutes before %d", param.MaxLookbackMinutes, nanosec))
+		}
+		if param.Reconnects == ReconnectsError {
+			// the book might not have been complete again yet
+			if serr := reconnectError(nanosec, f.reconnects); serr != nil {
+				return serr
+			}
+		}
+		form, serr := getFormatter()
+		if serr != nil {
+			return serr
+		}
+		var validator *validatingFormatter
+		if param.CrossValidate && form != nil {
+			validator = &validatingFormatter{Formatter: form}
+			form = validator
+		}
+		_, span := StartSpan(ctx, "take_snapshot", attribute.Int64("nanosec", nanosec))
+		taken := *sim
+		if trades != nil {
+			taken = &tradeHistorySnapshots{Simulator: taken, history: trades, nanosec: nanosec}
+		}
+		if param.record != nil {
+			taken = &recordingSnapshots{Simulator: taken, record: param.record}
+		}
+		entries, serr := takeSnapshot(taken, form, asOf)
+		EndSpan(span, serr)
+		if serr != nil {
+			return serr
+		}
+		if !postFilter.Empty() {
+			// metrics channels are only returned if selected
+			entries, serr = appendMetrics(entries, param.MetricsBps, postFilterMatched.Match)
+			if serr != nil {
+				return serr
+			}
+		}
+		entries = filterEntries(entries, outputFilter)
+		if param.Side != 0 || param.WithinPercent > 0 {
+			entries = filterLevels(entries, param.Side, param.WithinPercent)
+		}
+		if param.Bucket > 0 {
+			entries, serr = aggregateLevels(entries, param.Bucket, param.BucketDecimals)
+			if serr != nil {
+				return serr
+			}
+		}
+		if param.Depth > 0 {
+			entries = limitDepth(entries, param.Depth)
+		}
+		if len(param.Fields) > 0 {
+			entries, serr = filterFields(entries, param.Fields)
+			if serr != nil {
+				return serr
+			}
+		}
+		if param.MissingChannels == MissingChannelsError {
+			if unseen := unseenChannels(param.Exchange, param.Channels, channelNames); len(unseen) > 0 {
+				return newSnapshotError(ErrBadParameter, fmt.Errorf("channels did not appear in dataset: %s", strings.Join(unseen, ", ")))
+			}
+		} else if param.MissingChannels == MissingChannelsEmpty {
+			// tell channels were requested but nothing is in the snapshot
+			for _, channel := range channelsWithoutEntries(param.Channels, entries) {
+				entries = append(entries, entry{channel: channel})
+			}
+		}
+		// simulator returns snapshots in arbitrary order
+		sortEntries(entries, param.ChannelOrder)
+		if param.Audit {
+			reports, serr := auditEntries(ctx, param.Exchange, nanosec, entries)
+			if serr != nil {
+				return serr
+			}
+			entries = append(entries, reports...)
+		}
+		if param.Naming == NamingNormalized {
+			entries = normalizeEntries(param.Exchange, entries)
+		}
+		if len(quarantined) > 0 {
+			// report channels missing in the snapshot
+			reports, serr := errorEntries(quarantined)
+			if serr != nil {
+				return serr
+			}
+			entries = append(entries, reports...)
+		}
+		if checker != nil {
+			// tell whether books could be wrong as updates were dropped
+			reports, serr := checker.consistencyEntries()
+			if serr != nil {
+				return serr
+			}
+			entries = append(entries, reports...)
+		}
+		if validator != nil {
+			// formatter bugs dropping channels would not be noticed otherwise
+			if count := validator.inconsistent(); count > 0 {
+				log.Warn("formatted snapshot is not consistent with raw snapshot", "nanosec", nanosec, "channels", count)
+			}
+			reports, serr := validator.validationEntries()
+			if serr != nil {
+				return serr
+			}
+			entries = append(entries, reports...)
+		}
+		if param.Reconnects == ReconnectsFlag {
+			reports, serr := reconnectEntries(nanosec, f.reconnects)
+			if serr != nil {
+				return serr
+			}
+			entries = append(entries, reports...)
+		}
+		if stats != nil {
+			summary, serr := stats.statsEntry(nanosec, entries, channelUpdated)
+			if serr != nil {
+				return serr
+			}
+			entries = append(entries, summary)
+		}
+		if nanosec == param.Nanosecs[len(param.Nanosecs)-1] {
+			report.Token = snapshotToken(nanosec, entries)
+		}
+		if param.Diff && !report.FullSnapshot {
+			if nanosec == param.Nanosecs[0] {
+				if param.SinceHash != "" && entriesHash(entries) != param.SinceHash {
+					// changes are from a snapshot different from what the client has, the full snapshot is written instead
+					report.FullSnapshot = true
+					return nil
+				}
+				// compare with the snapshot at the second target
+				diffBase = entries
+				return nil
+			}
+			return writeDiff(buffer, nanosec, diffBase, entries, layoutOf(param))
+		}
+		if param.Output == OutputJSON {
+			// written as a document at the end
+			snapshot, serr := newJSONSnapshot(param.Exchange, nanosec, entries)
+			if serr != nil {
+				return serr
+			}
+			jsonSnapshots = append(jsonSnapshots, snapshot)
+			return nil
+		}
+		if param.Output == OutputNDJSON {
+			return writeNDJSONEntries(ndjsonBody, nanosec, entries)
+		}
+		if param.Output == OutputArrow || param.Output == OutputParquet {
+			return arrowWriter.Write(nanosec, entries)
+		}
+		if param.Output == OutputProtobuf {
+			var b []byte
+			if !protobufStarted {
+				b = appendProtobufHeader(b, param.Exchange)
+				protobufStarted = true
+			}
+			_, serr := buffer.Write(appendProtobufSnapshot(b, nanosec, entries))
+			return serr
+		}
+		if param.Output == OutputTable {
+			return writeTable(buffer, param.Exchange, nanosec, entries)
+		}
+		if param.Output == OutputCSV {
+			if !csvStarted {
+				if serr := csvWriter.Write(layoutOf(param).csvHeader()); serr != nil {
+					return serr
+				}
+				csvStarted = true
+			}
+			if serr := writeCSVRows(csvWriter, nanosec, entries, layoutOf(param)); serr != nil {
+				return serr
+			}
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+		if serr := writeEntries(buffer, nanosec, entries, layoutOf(param)); serr != nil {
+			return serr
+		}
+		if param.ExportState && nanosec == param.Nanosecs[len(param.Nanosecs)-1] {
+			if param.Exclusive {
+				// lines at the target are not in the state, they have to be applied when it is restored
+				return writeState(buffer, nanosec-1, (*sim).(*startRecorder))
+			}
+			return writeState(buffer, nanosec, (*sim).(*startRecorder))
+		}
+		return nil
+	}
+	if param.onSnapshot != nil {
+		// each snapshot is handed over as soon as it is written
+		write := onTarget
+		onTarget = func(nanosec int64) error {
+			if serr := write(nanosec); serr != nil || !isTarget(param.Nanosecs, nanosec) {
+				return serr
+			}
+			csvWriter.Flush()
+			if serr := buffer.Flush(); serr != nil {
+				return serr
+			}
+			return param.onSnapshot(nanosec)
+		}
+	}
+	// error occurred while writing snapshots, which is not of reading dataset
+	var targetErr error
+	f = &Feeder{
+		Sim:       sim,
+		SetNewSim: setNewSim,
+		Targets:   targets,
+		OnTarget: func(nanosec int64) error {
+			if serr := onTarget(nanosec); serr != nil {
+				targetErr = asSnapshotError(serr, ErrSimulator)
+				return targetErr
+			}
+			return nil
+		},
+		skipUntil: param.StateNanosec,
+	}
+	f.copyMessages = param.SafeParsing || SafeParsing
+	f.lineBuf = getLineBuffer()
+	defer func() { putLineBuffer(f.lineBuf) }()
+	f.lenient = param.Lenient
+	f.maxScan = param.MaxScanBytes
+	f.exclusive = param.Exclusive
+	f.channelUpdated = channelUpdated
+	f.channelNames = channelNames
+	f.dedupeStates = !param.AllStates
+	f.trades = trades
+	f.stats = stats
+	if param.Debug {
+		f.timing = newScanTiming()
+	}
+	if !param.NoPrefilter {
+		f.prefilter = newChannelPrefilter(param.Exchange, param.Channels, channels)
+	}
+	if param.ReplayUntil != 0 {
+		// messages after the target are written as they are read
+		f.replayUntil = param.ReplayUntil
+		f.onReplay = func(timestamp int64, channel string, line []byte) error {
+			if !channels.Match(channel) {
+				return nil
+			}
+			// remove newline at the end
+			line = line[:len(line)-1]
+			var entries []entry
+			form, serr := getFormatter()
+			if serr != nil {
+				return serr
+			}
+			if form != nil {
+				formatted, serr := form.FormatMessage(channel, line)
+				if serr != nil {
+					return serr
+				}
+				for _, r := range formatted {
+					entries = append(entries, entry{channel: r.Channel, message: r.Message})
+				}
+			} else {
+				entries = []entry{{channel: channel, message: line}}
+			}
+			entries = filterEntries(entries, outputFilter)
+			if param.Naming == NamingNormalized {
+				entries = normalizeEntries(param.Exchange, entries)
+			}
+			// replayed lines are of their own timestamps, which are always written
+			return writeEntries(buffer, timestamp, entries, lineLayout{unit: param.TimestampUnit})
+		}
+	}
+	if param.State != nil {
+		// continue from the state exported by the previous request
+		serr = restoreState(ctx, param.State, param.StateNanosec, sim, setNewSim)
+		if serr != nil {
+			err = newSnapshotError(ErrBadParameter, fmt.Errorf("invalid state: %v", serr))
+			return
+		}
+	}
+	// the next files are downloaded and decompressed while a file is being simulated
+	done := make(chan struct{})
+	var stopOnce sync.Once
+	stopPipeline := func() { stopOnce.Do(func() { close(done) }) }
+	files := pipeline(ctx, source, done, param.PrefetchFiles, param.ReadAheadBlocks)
+	defer func() {
+		stopPipeline()
+		// wait for the pipeline to stop so that source is not used after returning
+		for range files {
+		}
+	}()
+	// targets up to the end of files of sources following dataset being written are reached after the files,
+	// as lines after them are not written yet
+	following, _ := source.(followingSource)
+	reachEnd := func(name string) (stop bool, err error) {
+		if following == nil {
+			return false, nil
+		}
+		end := following.End(name)
+		for len(f.Targets) > 0 && f.Targets[0] <= end {
+			if err = f.OnTarget(f.Targets[0]); err != nil {
+				return
+			}
+			f.Targets = f.Targets[1:]
+		}
+		return len(f.Targets) == 0, nil
+	}
+	progress := newProgressReporter(log, param.plannedFiles)
+	stopAt := resumeAt(ctx, param)
+	filesScanned := 0
+	fileIndex := -1
+	for file := range files {
+		fileIndex++
+		var rest []int64
+		if !stopAt.IsZero() && time.Now().After(stopAt) && (*sim).(*startRecorder).startLine != nil {
+			for _, nanosec := range f.Targets {
+				if isTarget(param.Nanosecs, nanosec) {
+					rest = append(rest, nanosec)
+				}
+			}
+		}
+		if len(rest) > 0 {
+			// the rest is taken by the next request from the state, rather than failing at the deadline
+			stopPipeline()
+			if report.ResumeToken, err = saveResume((*sim).(*startRecorder), f.LastTimestamp, rest); err != nil {
+				return
+			}
+			log.Info("scan stopped before the deadline to be resumed", "file", file.name, "file_index", fileIndex, "scanned", report.Scanned)
+			report.Partial = PartialResumable
+			f.Targets = nil
+			break
+		}
+		if file.reader == nil {
+			log.Info("skipping file which did not exist", "file", file.name, "file_index", fileIndex)
+			report.MissingFiles = append(report.MissingFiles, file.name)
+			f.breakContinuity()
+			if param.Partial {
+				report.Partial = PartialMissingFile
+			}
+			progress.fileDone(fileIndex+1, report.Scanned, f.LastTimestamp)
+			if stop, serr := reachEnd(file.name); serr != nil || stop {
+				err = serr
+				if stop {
+					stopPipeline()
+				}
+				return
+			}
+			continue
+		}
+		filesScanned++
+		log.Debug("reading file", "file", file.name, "file_index", fileIndex, "elapsed", time.Now().Sub(st))
+		truncatedBefore := f.truncatedFiles
+		processedBefore := f.processTime
+		if f.timing != nil {
+			f.timing.parse = 0
+		}
+		f.scannedBefore = report.Scanned
+		f.discard = param.manifests.offset(file.name)
+		f.manifest = nil
+		if f.discard == 0 && param.manifests.indexes(file.name) {
+			f.manifest = newManifestBuilder()
+		}
+		feedCtx, span := StartSpan(ctx, "feed", attribute.String("file", file.name), attribute.Int("file_index", fileIndex))
+		scanned, stop, serr := Feed(feedCtx, file.reader, f)
+		span.SetAttributes(attribute.Int("scanned", scanned), attribute.Int64("simulator_time_ns", int64(f.processTime-processedBefore)))
+		EndSpan(span, serr)
+		report.Scanned += int64(scanned)
+		fileScan := FileScan{Name: file.name, Scanned: int64(scanned)}
+		if f.timing != nil {
+			fileScan.ParseTime = milliseconds(f.timing.parse)
+			fileScan.SimulatorTime = milliseconds(f.processTime - processedBefore)
+		}
+		report.Files = append(report.Files, fileScan)
+		progress.fileDone(fileIndex+1, report.Scanned, f.LastTimestamp)
+		if f.truncatedFiles != truncatedBefore {
+			log.Warn("file was truncated, continuing with the next file", "file", file.name, "file_index", fileIndex, "scanned", scanned)
+			report.TruncatedFiles = append(report.TruncatedFiles, file.name)
+		} else if f.manifest != nil && serr == nil && !stop {
+			// the file was read through, later requests can skip it by the manifest
+			saving.Add(1)
+			go func(name string, builder *manifestBuilder) {
+				defer saving.Done()
+				param.manifests.save(ctx, name, builder)
+			}(file.name, f.manifest)
+		}
+		if ctx.Err() != nil {
+			// aborted, there is no point to return partial result
+			err = ctx.Err()
+			return
+		}
+		if serr != nil && param.Partial && errors.Is(serr, ErrScanLimit) {
+			// return snapshots as of the limit
+			log.Info("scan reached the limit, returning partial result", "file", file.name, "file_index", fileIndex, "scanned", report.Scanned)
+			report.Partial = PartialQuotaExceeded
+			report.StoppedAt = &ScanPosition{File: file.name, Offset: int64(scanned)}
+			break
+		}
+		if serr != nil {
+			if !param.Partial || serr == targetErr {
+				// errors not from the simulator are of reading dataset
+				snapErr := asSnapshotError(serr, ErrDatasetGap)
+				snapErr.File = file.name
+				snapErr.FileIndex = fileIndex
+				snapErr.Offset = int64(scanned)
+				err = snapErr
+				return
+			}
+			// return snapshots the simulator has at the moment
+			log.Warn("scan of file failed, returning partial result", "file", file.name, "file_index", fileIndex, "error", serr)
+			report.Partial = PartialScanFailed
+			break
+		}
+		if !stop {
+			if stop, serr = reachEnd(file.name); serr != nil {
+				err = serr
+				return
+			}
+		}
+		if stop {
+			// it is enough to make snapshot, the rest of the file being fetched is not transferred
+			stopPipeline()
+			report.StoppedAt = &ScanPosition{File: file.name, Offset: int64(scanned)}
+			break
+		}
+	}
+	// dataset ended before the rest of targets, the simulator has the state at those targets
+	for _, nanosec := range f.Targets {
+		if serr := onTarget(nanosec); serr != nil {
+			err = asSnapshotError(serr, ErrSimulator)
+			return
+		}
+	}
+	if report.Channels != nil {
+		report.Channels.UnmatchedPostFilter = postFilterMatched.Unmatched()
+		if patterns {
+			report.Channels.Resolved = muxOf(*sim).Channels()
+		}
+	}
+	report.LastTimestamp = f.LastTimestamp
+	report.SkippedLines = f.skippedLines
+	report.SkippedStates = f.skippedStates
+	report.FilesRead = filesScanned
+	report.ProcessTime = f.processTime
+	if f.timing != nil {
+		report.ChannelTimes = f.timing.channelTimings()
+	}
+	if param.Output == OutputJSON {
+		if err = writeJSON(buffer, jsonSnapshots); err != nil {
+			return
+		}
+	}
+	if err = arrowWriter.Close(); err != nil {
+		return
+	}
+	if param.Output == OutputNDJSON {
+		metadata := ndjsonMetadata{
+			Exchange:     param.Exchange,
+			Targets:      param.Nanosecs,
+			Channels:     param.Channels,
+			FilesScanned: filesScanned,
+			Gaps:         report.MissingFiles,
+			SkippedLines: f.skippedLines,
+			Truncated:    report.TruncatedFiles,
+		}
+		if patterns {
+			metadata.Channels = muxOf(*sim).Channels()
+		}
+		if metadata.Gaps == nil {
+			metadata.Gaps = []string{}
+		}
+		if err = writeNDJSON(buffer, metadata, ndjsonBody.Bytes()); err != nil {
+			return
+		}
+	}
+	err = buffer.Flush()
+	return
+}
@@ -0,0 +1,484 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// recordingSimulator retains every string and byte slice given to it so tests can check
+// whether they were modified after the call returned.
+type recordingSimulator struct {
+	simulator.Simulator
+	channels []string
+	lines    []string
+	retained [][]byte
+}
+
+func (s *recordingSimulator) ProcessStart(line []byte) error {
+	s.retained = append(s.retained, line)
+	return nil
+}
+
+func (s *recordingSimulator) ProcessMessageChannelKnown(channel string, line []byte) error {
+	s.channels = append(s.channels, channel)
+	s.lines = append(s.lines, string(line))
+	s.retained = append(s.retained, line)
+	return nil
+}
+
+func (s *recordingSimulator) ProcessState(channel string, line []byte) error {
+	return s.ProcessMessageChannelKnown(channel, line)
+}
+
+func (s *recordingSimulator) TakeSnapshot() ([]simulator.Snapshot, error) {
+	return nil, nil
+}
+
+const testDataset = "start\t100\twss://example.com\n" +
+	"msg\t200\tchannelA\t{\"a\":1}\n" +
+	"state\t250\tchannelB\t{\"b\":2}\n" +
+	"msg\t300\tchannelC\t{\"c\":3}\n" +
+	"msg\t500\tchannelD\t{\"d\":4}\n"
+
+// testReadSize is the size of the buffer feedTestDataset reads testDataset through.
+var testReadSize = 16
+
+// feedTestDataset feeds testDataset and returns the number of messages the simulator had processed
+// at each target reached.
+func feedTestDataset(t *testing.T, targets []int64) (rec *recordingSimulator, processedAt []int, lastTimestamp int64, stop bool) {
+	rec = &recordingSimulator{}
+	var sim simulator.Simulator = rec
+	setNewSim := func(simp *simulator.Simulator) error {
+		*simp = rec
+		return nil
+	}
+	onTarget := func(nanosec int64) error {
+		processedAt = append(processedAt, len(rec.channels))
+		return nil
+	}
+	f := &Feeder{Sim: &sim, SetNewSim: setNewSim, Targets: targets, OnTarget: onTarget}
+	var err error
+	_, stop, err = FeedToSimulator(context.Background(), bufio.NewReaderSize(strings.NewReader(testDataset), testReadSize), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets)-len(f.Targets) != len(processedAt) {
+		t.Fatalf("reached %d targets but onTarget was called %d times", len(targets)-len(f.Targets), len(processedAt))
+	}
+	lastTimestamp = f.LastTimestamp
+	return
+}
+
+func TestFeedToSimulatorMultipleTargets(t *testing.T) {
+	_, processedAt, _, stop := feedTestDataset(t, []int64{150, 250, 400})
+	if !stop {
+		t.Fatal("expected to stop before the end of dataset")
+	}
+	expected := []int{0, 2, 3}
+	if len(processedAt) != len(expected) {
+		t.Fatalf("expected %d targets to be reached, got %d", len(expected), len(processedAt))
+	}
+	for i := range expected {
+		if processedAt[i] != expected[i] {
+			t.Errorf("target %d: expected %d messages processed, got %d", i, expected[i], processedAt[i])
+		}
+	}
+}
+
+func TestFeedToSimulatorLastTimestamp(t *testing.T) {
+	_, _, lastTimestamp, stop := feedTestDataset(t, []int64{400})
+	if !stop {
+		t.Fatal("expected to stop before the end of dataset")
+	}
+	if lastTimestamp != 300 {
+		t.Fatalf("lastTimestamp: expected 300, got %d", lastTimestamp)
+	}
+}
+
+// TestFeedToSimulatorRetainedStrings ensures no string derived from the read buffer is
+// passed to the simulator in a way that gets overwritten when the buffer is reused.
+func TestFeedToSimulatorRetainedStrings(t *testing.T) {
+	defer func(size int) { testReadSize = size }(testReadSize)
+	// lines are longer than the buffer of 16 bytes and fit in the buffer of 32 bytes,
+	// the buffer is reused for later lines either way
+	for _, testReadSize = range []int{16, 32} {
+		rec, _, _, _ := feedTestDataset(t, []int64{1000})
+		expectedChannels := []string{"channelA", "channelB", "channelC", "channelD"}
+		if len(rec.channels) != len(expectedChannels) {
+			t.Fatalf("%d: expected %d channels, got %v", testReadSize, len(expectedChannels), rec.channels)
+		}
+		for i, ch := range expectedChannels {
+			if rec.channels[i] != ch {
+				t.Errorf("%d: retained channel %d was modified: expected %q, got %q", testReadSize, i, ch, rec.channels[i])
+			}
+		}
+		for i, line := range rec.lines {
+			if !strings.HasPrefix(line, "{") {
+				t.Errorf("%d: line %d is not the message: %q", testReadSize, i, line)
+			}
+		}
+		if string(rec.retained[0]) != "wss://example.com\n" {
+			t.Errorf("%d: retained start line was modified: %q", testReadSize, rec.retained[0])
+		}
+	}
+}
+
+func TestFeedToSimulatorSkipUntil(t *testing.T) {
+	rec := &recordingSimulator{}
+	var sim simulator.Simulator = rec
+	setNewSim := func(simp *simulator.Simulator) error {
+		*simp = rec
+		return nil
+	}
+	f := &Feeder{Sim: &sim, SetNewSim: setNewSim, Targets: []int64{1000}, OnTarget: func(int64) error { return nil }, skipUntil: 250}
+	_, _, err := FeedToSimulator(context.Background(), bufio.NewReader(strings.NewReader(testDataset)), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.channels) != 2 || rec.channels[0] != "channelC" {
+		t.Fatalf("expected lines until 250 to be skipped, got %v", rec.channels)
+	}
+}
+
+func TestFeedToSimulatorReplay(t *testing.T) {
+	rec := &recordingSimulator{}
+	var sim simulator.Simulator = rec
+	setNewSim := func(simp *simulator.Simulator) error {
+		*simp = rec
+		return nil
+	}
+	var replayed []string
+	f := &Feeder{
+		Sim:         &sim,
+		SetNewSim:   setNewSim,
+		Targets:     []int64{200},
+		OnTarget:    func(int64) error { return nil },
+		replayUntil: 300,
+		onReplay: func(timestamp int64, channel string, line []byte) error {
+			replayed = append(replayed, channel)
+			return nil
+		},
+	}
+	_, stop, err := FeedToSimulator(context.Background(), bufio.NewReader(strings.NewReader(testDataset)), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stop {
+		t.Fatal("expected to stop after replayUntil")
+	}
+	// state line in the middle of the file is as of its timestamp, so it is after the target
+	if len(rec.channels) != 1 || rec.channels[0] != "channelA" {
+		t.Fatalf("expected only lines until the target to be applied, got %v", rec.channels)
+	}
+	if len(replayed) != 1 || replayed[0] != "channelC" {
+		t.Fatalf("expected msg lines until 300 to be replayed, got %v", replayed)
+	}
+}
+
+func TestFeedCanceled(t *testing.T) {
+	rec := &recordingSimulator{}
+	var sim simulator.Simulator = rec
+	f := &Feeder{Sim: &sim, Targets: []int64{1000}, OnTarget: func(int64) error { return nil }}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := FeedToSimulator(ctx, bufio.NewReader(strings.NewReader(testDataset)), f)
+	if err != context.Canceled {
+		t.Fatalf("expected to be canceled, got %v", err)
+	}
+	if len(rec.lines) != 0 {
+		t.Fatalf("expected nothing to be fed: %v", rec.lines)
+	}
+}
+
+// benchmarkDataset makes a dataset file of `lines` msg lines over a few channels.
+func benchmarkDataset(lines int) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString("start\t100\twss://example.com\n")
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(buf, "msg\t%d\tchannel%d\t{\"price\":%d,\"size\":1,\"side\":\"Buy\"}\n", 200+i, i%4, i)
+	}
+	return buf.Bytes()
+}
+
+// nopSimulator does nothing with lines.
+type nopSimulator struct {
+	simulator.Simulator
+}
+
+func (s *nopSimulator) ProcessStart(line []byte) error                               { return nil }
+func (s *nopSimulator) ProcessMessageChannelKnown(channel string, line []byte) error { return nil }
+func (s *nopSimulator) ProcessState(channel string, line []byte) error               { return nil }
+
+func benchmarkFeedToSimulator(b *testing.B, copyMessages bool) {
+	dataset := benchmarkDataset(100000)
+	b.SetBytes(int64(len(dataset)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var sim simulator.Simulator = &nopSimulator{}
+		f := &Feeder{
+			Sim:          &sim,
+			SetNewSim:    func(*simulator.Simulator) error { return nil },
+			Targets:      []int64{1 << 62},
+			OnTarget:     func(int64) error { return nil },
+			copyMessages: copyMessages,
+		}
+		if _, _, err := FeedToSimulator(context.Background(), bufio.NewReader(bytes.NewReader(dataset)), f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFeedToSimulator(b *testing.B) {
+	benchmarkFeedToSimulator(b, false)
+}
+
+// BenchmarkFeedToSimulatorSafe measures the cost of copying messages in safe parsing mode.
+func BenchmarkFeedToSimulatorSafe(b *testing.B) {
+	benchmarkFeedToSimulator(b, true)
+}
+
+// BenchmarkFeedBufferSize measures Feed with buffers of each size, reading dataset in decompressed blocks as the pipeline passes.
+func BenchmarkFeedBufferSize(b *testing.B) {
+	dataset := benchmarkDataset(100000)
+	// long lines such as states of the whole orderbook are copied many times through small buffers
+	state := strings.Repeat("x", 512*1024)
+	for i := 0; i < 4; i++ {
+		dataset = append(dataset, fmt.Sprintf("state\t%d\tchannel%d\t{\"state\":\"%s\"}\n", 100200000+i, i, state)...)
+	}
+	defer func(original int) { ReadBufferKB = original }(ReadBufferKB)
+	for _, kb := range []int{4, 16, 64, 256, 1024} {
+		b.Run(fmt.Sprintf("%dKB", kb), func(b *testing.B) {
+			ReadBufferKB = kb
+			b.SetBytes(int64(len(dataset)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var sim simulator.Simulator = &nopSimulator{}
+				f := &Feeder{
+					Sim:       &sim,
+					SetNewSim: func(*simulator.Simulator) error { return nil },
+					Targets:   []int64{1 << 62},
+					OnTarget:  func(int64) error { return nil },
+				}
+				blocks := &blockReader{blocks: make(chan []byte, len(dataset)/pipelineBlockSize+1)}
+				for offset := 0; offset < len(dataset); offset += pipelineBlockSize {
+					end := offset + pipelineBlockSize
+					if end > len(dataset) {
+						end = len(dataset)
+					}
+					blocks.blocks <- dataset[offset:end]
+				}
+				close(blocks.blocks)
+				if _, _, err := Feed(context.Background(), blocks, f); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReadBytesLines is the baseline reading fields with ReadBytes as FeedToSimulator used to do.
+func BenchmarkReadBytesLines(b *testing.B) {
+	dataset := benchmarkDataset(100000)
+	b.SetBytes(int64(len(dataset)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader := bufio.NewReader(bytes.NewReader(dataset))
+		for {
+			typeBytes, err := reader.ReadBytes('\t')
+			if err != nil {
+				break
+			}
+			if _, err := reader.ReadBytes('\t'); err != nil {
+				b.Fatal(err)
+			}
+			if string(typeBytes) == "msg\t" {
+				if _, err := reader.ReadBytes('\t'); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if _, err := reader.ReadBytes('\n'); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestFeedToSimulatorCopyMessages(t *testing.T) {
+	rec := &recordingSimulator{}
+	var sim simulator.Simulator = rec
+	f := &Feeder{Sim: &sim, SetNewSim: func(simp *simulator.Simulator) error { *simp = rec; return nil }, Targets: []int64{1000}, OnTarget: func(int64) error { return nil }, copyMessages: true}
+	// small buffer makes lines overwritten after they are read
+	_, _, err := FeedToSimulator(context.Background(), bufio.NewReaderSize(strings.NewReader(testDataset), 16), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, retained := range rec.retained[1:] {
+		if string(retained) != rec.lines[i] {
+			t.Errorf("retained message %d was modified: %q", i, retained)
+		}
+	}
+}
+
+func TestFeedToSimulatorInitialState(t *testing.T) {
+	dataset := "start\t100\twss://example.com\n" +
+		"state\t110\tchannelA\t{\"a\":1}\n" +
+		"state\t120\tchannelB\t{\"b\":2}\n" +
+		"msg\t200\tchannelA\t{\"a\":3}\n"
+	rec := &recordingSimulator{}
+	var sim simulator.Simulator = rec
+	processedAt := -1
+	f := &Feeder{
+		Sim:       &sim,
+		SetNewSim: func(simp *simulator.Simulator) error { *simp = rec; return nil },
+		Targets:   []int64{105},
+		OnTarget:  func(int64) error { processedAt = len(rec.channels); return nil },
+	}
+	_, stop, err := FeedToSimulator(context.Background(), bufio.NewReader(strings.NewReader(dataset)), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stop || processedAt != 2 {
+		t.Fatalf("expected snapshot to be made of the initial state and stop, processed %d", processedAt)
+	}
+	if f.LastTimestamp != 100 {
+		t.Fatalf("expected the state to be as of the start line, got %d", f.LastTimestamp)
+	}
+}
+
+func TestFeedToSimulatorLenient(t *testing.T) {
+	dataset := "start\t100\twss://example.com\n" +
+		"msg\t200\tchannelA\t{\"a\":1}\n" +
+		"msg\tgarbage\tchannelB\t{\"b\":2}\n" +
+		"msg\t300\n" +
+		"truncated\n" +
+		"msg\t400\tchannelC\t{\"c\":3}\n"
+	newFeeder := func(rec *recordingSimulator, lenient bool) *Feeder {
+		var sim simulator.Simulator = rec
+		return &Feeder{Sim: &sim, SetNewSim: func(simp *simulator.Simulator) error { *simp = rec; return nil }, Targets: []int64{1000}, OnTarget: func(int64) error { return nil }, lenient: lenient}
+	}
+	if _, _, err := FeedToSimulator(context.Background(), bufio.NewReader(strings.NewReader(dataset)), newFeeder(&recordingSimulator{}, false)); err == nil {
+		t.Fatal("expected malformed line to fail without lenient mode")
+	}
+	rec := &recordingSimulator{}
+	f := newFeeder(rec, true)
+	if _, _, err := FeedToSimulator(context.Background(), bufio.NewReader(strings.NewReader(dataset)), f); err != nil {
+		t.Fatal(err)
+	}
+	if f.skippedLines != 3 {
+		t.Fatalf("expected 3 lines to be skipped, got %d", f.skippedLines)
+	}
+	if len(rec.channels) != 2 || rec.channels[1] != "channelC" {
+		t.Fatalf("expected well-formed lines to be applied, got %v", rec.channels)
+	}
+}
+
+func TestFeedToSimulatorScanLimit(t *testing.T) {
+	rec := &recordingSimulator{}
+	var sim simulator.Simulator = rec
+	// limit falls in the middle of the third line
+	f := &Feeder{Sim: &sim, SetNewSim: func(simp *simulator.Simulator) error { *simp = rec; return nil }, Targets: []int64{1000}, OnTarget: func(int64) error { return nil }, maxScan: 70, scannedBefore: 10}
+	scanned, _, err := FeedToSimulator(context.Background(), bufio.NewReader(strings.NewReader(testDataset)), f)
+	if !errors.Is(err, ErrScanLimit) {
+		t.Fatalf("expected scan limit to be exceeded, got %v", err)
+	}
+	if int64(scanned)+f.scannedBefore > f.maxScan {
+		t.Fatalf("scanned %d bytes beyond the limit", scanned)
+	}
+	if len(rec.channels) != 1 {
+		t.Fatalf("expected only lines within the limit to be applied, got %v", rec.channels)
+	}
+}
+
+func TestFeedToSimulatorChannelUpdated(t *testing.T) {
+	rec := &recordingSimulator{}
+	var sim simulator.Simulator = rec
+	f := &Feeder{Sim: &sim, SetNewSim: func(simp *simulator.Simulator) error { *simp = rec; return nil }, Targets: []int64{400}, OnTarget: func(int64) error { return nil }, channelUpdated: make(map[string]int64)}
+	if _, _, err := FeedToSimulator(context.Background(), bufio.NewReader(strings.NewReader(testDataset)), f); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int64{"channelA": 200, "channelB": 250, "channelC": 300}
+	if len(f.channelUpdated) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, f.channelUpdated)
+	}
+	for channel, timestamp := range expected {
+		if f.channelUpdated[channel] != timestamp {
+			t.Errorf("%s: expected %d, got %d", channel, timestamp, f.channelUpdated[channel])
+		}
+	}
+}
+
+func TestFeedToSimulatorDedupeStates(t *testing.T) {
+	first := "start\t100\twss://example.com\n" +
+		"state\t110\tchannelA\t{\"a\":1}\n" +
+		"msg\t200\tchannelA\t{\"a\":2}\n"
+	// the state dumped at the beginning of the next file is what the simulator has
+	second := "state\t300\tchannelA\t{\"a\":2}\n" +
+		"msg\t400\tchannelA\t{\"a\":3}\n" +
+		"start\t500\twss://example.com\n" +
+		"state\t510\tchannelA\t{\"a\":4}\n"
+	feed := func(dedupe bool, gap bool) (*recordingSimulator, *Feeder) {
+		rec := &recordingSimulator{}
+		var sim simulator.Simulator = rec
+		f := &Feeder{Sim: &sim, SetNewSim: func(simp *simulator.Simulator) error { *simp = rec; return nil }, Targets: []int64{1000}, OnTarget: func(int64) error { return nil }, dedupeStates: dedupe}
+		for i, dataset := range []string{first, second} {
+			if i > 0 && gap {
+				f.breakContinuity()
+			}
+			if _, _, err := FeedToSimulator(context.Background(), bufio.NewReader(strings.NewReader(dataset)), f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, f
+	}
+	rec, f := feed(true, false)
+	// the state after the start line is of the new connection
+	expected := []string{"{\"a\":1}\n", "{\"a\":2}\n", "{\"a\":3}\n", "{\"a\":4}\n"}
+	if strings.Join(rec.lines, "") != strings.Join(expected, "") {
+		t.Errorf("expected %q, got %q", expected, rec.lines)
+	}
+	if f.skippedStates != 1 {
+		t.Errorf("expected 1 state line to be skipped, got %d", f.skippedStates)
+	}
+	if f.LastTimestamp != 510 {
+		t.Errorf("expected the last timestamp 510, got %d", f.LastTimestamp)
+	}
+	for _, gap := range []bool{false, true} {
+		rec, f = feed(!gap, gap)
+		if len(rec.lines) != 5 || f.skippedStates != 0 {
+			t.Errorf("gap %v: expected every state line to be applied, got %q", gap, rec.lines)
+		}
+	}
+}
+
+func TestFeedToSimulatorExclusive(t *testing.T) {
+	processed := func(exclusive bool) []int {
+		rec := &recordingSimulator{}
+		var sim simulator.Simulator = rec
+		var processedAt []int
+		f := &Feeder{Sim: &sim, SetNewSim: func(simp *simulator.Simulator) error { *simp = rec; return nil }, Targets: []int64{200, 300}, exclusive: exclusive}
+		f.OnTarget = func(int64) error {
+			processedAt = append(processedAt, len(rec.channels))
+			return nil
+		}
+		if _, _, err := FeedToSimulator(context.Background(), bufio.NewReader(strings.NewReader(testDataset)), f); err != nil {
+			t.Fatal(err)
+		}
+		return processedAt
+	}
+	// lines exactly at targets are applied before snapshots only if inclusive
+	if at := processed(false); len(at) != 2 || at[0] != 1 || at[1] != 3 {
+		t.Errorf("inclusive: unexpected messages processed %v", at)
+	}
+	if at := processed(true); len(at) != 2 || at[0] != 0 || at[1] != 2 {
+		t.Errorf("exclusive: unexpected messages processed %v", at)
+	}
+}
@@ -0,0 +1,173 @@
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// spillReserveSize is the bytes spillBuffer reserves from the ceiling at once, not to lock on every write.
+const spillReserveSize = 1024 * 1024
+
+// memoryCeiling limits the bytes of outputs and prefetched dataset files held in memory in the process.
+// Buffers which could not reserve memory under the ceiling write the rest to disk instead.
+type memoryCeiling struct {
+	mu sync.Mutex
+	// max is the bytes which can be held in memory, not limited if not positive
+	max  int64
+	used int64
+}
+
+func newMemoryCeiling(max int64) *memoryCeiling {
+	return &memoryCeiling{max: max}
+}
+
+// ceiling is the memory ceiling shared by all snapshots in this process.
+var ceiling = newMemoryCeiling(int64(MemoryCeilingMB) * 1024 * 1024)
+
+// reserve returns true if `n` bytes can be held in memory, they must be freed by `free` after use.
+func (c *memoryCeiling) reserve(n int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.max > 0 && c.used+n > c.max {
+		return false
+	}
+	c.used += n
+	return true
+}
+
+// free returns `n` bytes reserved to the ceiling.
+func (c *memoryCeiling) free(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.used -= n
+}
+
+// spillBuffer is the buffer held in memory as long as it fits under the ceiling,
+// and spilled to a temporary file in `SpillDirectory` beyond it.
+// It must be closed to free the memory and remove the file.
+type spillBuffer struct {
+	ceiling  *memoryCeiling
+	mem      *bytes.Buffer
+	reserved int64
+	// pooled is true if `mem` is from the pool of output buffers
+	pooled bool
+	// file is where the rest of the buffer is written after it exceeded the ceiling, nil if it has not
+	file *os.File
+	size int64
+}
+
+// newSpillBuffer makes the buffer holding up to `capacity` bytes in memory before it grows.
+func newSpillBuffer(c *memoryCeiling, capacity int) *spillBuffer {
+	return &spillBuffer{ceiling: c, mem: bytes.NewBuffer(make([]byte, 0, capacity))}
+}
+
+// newPooledSpillBuffer makes the buffer holding bytes in memory in the buffer from the pool of output buffers.
+func newPooledSpillBuffer(c *memoryCeiling) *spillBuffer {
+	return &spillBuffer{ceiling: c, mem: getOutputBuffer(), pooled: true}
+}
+
+func (b *spillBuffer) Write(p []byte) (n int, err error) {
+	if b.file == nil {
+		needed := int64(b.mem.Len()+len(p)) - b.reserved
+		if needed <= 0 {
+			return b.write(b.mem, p)
+		}
+		if needed < spillReserveSize {
+			needed = spillReserveSize
+		}
+		if b.ceiling.reserve(needed) {
+			b.reserved += needed
+			return b.write(b.mem, p)
+		}
+		if b.file, err = ioutil.TempFile(SpillDirectory, ".spill-"); err != nil {
+			return 0, asSnapshotError(err, ErrStorage)
+		}
+		Logger.Debug("spilling buffer to disk", "file", b.file.Name(), "in_memory", b.mem.Len())
+	}
+	return b.write(b.file, p)
+}
+
+func (b *spillBuffer) write(w io.Writer, p []byte) (n int, err error) {
+	n, err = w.Write(p)
+	b.size += int64(n)
+	return
+}
+
+// Len returns the bytes written to the buffer.
+func (b *spillBuffer) Len() int64 {
+	return b.size
+}
+
+// Spilled returns true if the buffer is partly written to disk.
+func (b *spillBuffer) Spilled() bool {
+	return b.file != nil
+}
+
+// Reader returns the reader of the whole buffer, closing it closes the buffer.
+func (b *spillBuffer) Reader() (io.ReadCloser, error) {
+	if b.file == nil {
+		return &spillReader{Reader: bytes.NewReader(b.mem.Bytes()), buffer: b}, nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, asSnapshotError(err, ErrStorage)
+	}
+	return &spillReader{Reader: io.MultiReader(bytes.NewReader(b.mem.Bytes()), b.file), buffer: b}, nil
+}
+
+// Bytes returns the whole buffer in memory, the buffer can not be read again after it.
+// Spilled buffer is read into the slice of the exact size, so that the buffer does not grow beyond it.
+func (b *spillBuffer) Bytes() (result []byte, err error) {
+	if b.file == nil && b.pooled {
+		// the buffer is reused after closed
+		return append([]byte(nil), b.mem.Bytes()...), nil
+	}
+	if b.file == nil {
+		result = b.mem.Bytes()
+		// the memory is now of the caller
+		b.ceiling.free(b.reserved)
+		b.reserved = 0
+		return
+	}
+	result = make([]byte, b.size)
+	reader, err := b.Reader()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.ReadFull(reader, result); err != nil {
+		return nil, asSnapshotError(err, ErrStorage)
+	}
+	return
+}
+
+// Close frees the memory of the buffer and removes the file spilled to.
+func (b *spillBuffer) Close() error {
+	b.ceiling.free(b.reserved)
+	b.reserved = 0
+	if b.pooled {
+		putOutputBuffer(b.mem)
+		b.pooled = false
+	}
+	b.mem = new(bytes.Buffer)
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	if serr := os.Remove(b.file.Name()); serr != nil && err == nil {
+		err = serr
+	}
+	b.file = nil
+	return err
+}
+
+// spillReader reads spillBuffer and closes it on Close.
+type spillReader struct {
+	io.Reader
+	buffer *spillBuffer
+}
+
+func (r *spillReader) Close() error {
+	return r.buffer.Close()
+}
@@ -0,0 +1,93 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(original string) { SpillDirectory = original }(SpillDirectory)
+	SpillDirectory = dir
+	content := bytes.Repeat([]byte("0123456789"), spillReserveSize/5)
+	c := newMemoryCeiling(spillReserveSize)
+	buffer := newSpillBuffer(c, 0)
+	for i := 0; i < len(content); i += 1000 {
+		end := i + 1000
+		if end > len(content) {
+			end = len(content)
+		}
+		if _, err := buffer.Write(content[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !buffer.Spilled() || buffer.Len() != int64(len(content)) {
+		t.Fatalf("expected %d bytes to be spilled, got %d spilled=%t", len(content), buffer.Len(), buffer.Spilled())
+	}
+	// the ceiling is full, another buffer is spilled at once
+	other := newSpillBuffer(c, 0)
+	other.Write([]byte("x"))
+	if !other.Spilled() {
+		t.Error("expected buffer beyond the ceiling to be spilled")
+	}
+	other.Close()
+	reader, err := buffer.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Error("read content differs")
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("expected spilled files to be removed, got %v", files)
+	}
+	if c.used != 0 {
+		t.Errorf("expected memory to be freed, %d bytes are still used", c.used)
+	}
+}
+
+func TestSpillBufferUnlimited(t *testing.T) {
+	buffer := newSpillBuffer(newMemoryCeiling(0), 0)
+	defer buffer.Close()
+	buffer.Write(bytes.Repeat([]byte("x"), 3*spillReserveSize))
+	if buffer.Spilled() {
+		t.Error("expected buffer not to be spilled without the ceiling")
+	}
+}
+
+func TestSnapshotSpilled(t *testing.T) {
+	defer registerFixture()()
+	defer func(original *memoryCeiling) { ceiling = original }(ceiling)
+	// nothing fits in memory
+	ceiling = newMemoryCeiling(1)
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{fixtureAt(80 * time.Second)},
+		Channels: []string{"book", "ticker"},
+		Format:   "raw",
+		Output:   OutputTSV,
+	}
+	ret, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := goldenFile(t, "raw_across_files"); !bytes.Equal(ret, expected) {
+		t.Errorf("spilled snapshot differs:\n%s\nexpected:\n%s", ret, expected)
+	}
+}
@@ -0,0 +1,83 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// statsChannel is the channel of the entry summarizing lines of the scan until a target.
+const statsChannel = "$stats"
+
+// scanStats counts lines applied to the simulator in the scan.
+type scanStats struct {
+	// starts is the number of start lines, each of which resets books
+	starts int
+	// messages is the number of msg lines applied of each channel
+	messages map[string]int
+}
+
+func newScanStats() *scanStats {
+	return &scanStats{messages: make(map[string]int)}
+}
+
+// channelStats is the summary of a channel in the message of the entry in `statsChannel`.
+type channelStats struct {
+	Channel  string `json:"channel"`
+	Messages int    `json:"messages"`
+	// Levels is the number of entries of the channel in the snapshot, which are levels of books if it is formatted
+	Levels int `json:"levels"`
+	// LastUpdate is the timestamp of the last line applied of the channel, 0 if none is
+	LastUpdate int64 `json:"lastUpdate"`
+	// SinceUpdate is nanoseconds from the last line applied of the channel to the target, 0 if none is
+	SinceUpdate int64 `json:"sinceUpdate"`
+}
+
+// scanSummary is the message of the entry in `statsChannel`.
+type scanSummary struct {
+	Starts   int            `json:"starts"`
+	Channels []channelStats `json:"channels"`
+}
+
+// statsEntry returns the entry summarizing the scan until `nanosec` for channels appeared in lines applied or in `entries`,
+// in the order of channel name. `updated` has timestamps of the last lines applied of channels.
+func (s *scanStats) statsEntry(nanosec int64, entries []entry, updated map[string]int64) (entry, error) {
+	channels := make(map[string]*channelStats)
+	get := func(channel string) *channelStats {
+		stats, ok := channels[channel]
+		if !ok {
+			stats = &channelStats{Channel: channel}
+			channels[channel] = stats
+		}
+		return stats
+	}
+	for channel, messages := range s.messages {
+		get(channel).Messages = messages
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.channel, "$") {
+			// entries of other reports
+			continue
+		}
+		get(e.channel).Levels++
+	}
+	for channel, timestamp := range updated {
+		if timestamp == 0 {
+			// only the initial state after the target was applied
+			continue
+		}
+		stats := get(channel)
+		stats.LastUpdate = timestamp
+		stats.SinceUpdate = nanosec - timestamp
+	}
+	summary := scanSummary{Starts: s.starts, Channels: make([]channelStats, 0, len(channels))}
+	for _, stats := range channels {
+		summary.Channels = append(summary.Channels, *stats)
+	}
+	sort.Slice(summary.Channels, func(i, j int) bool { return summary.Channels[i].Channel < summary.Channels[j].Channel })
+	message, err := json.Marshal(summary)
+	if err != nil {
+		return entry{}, err
+	}
+	return entry{channel: statsChannel, message: message}, nil
+}
@@ -0,0 +1,59 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsEntry(t *testing.T) {
+	defer registerFixture()()
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{fixtureAt(80 * time.Second), fixtureAt(150 * time.Second)},
+		Channels: []string{"book", "ticker"},
+		Format:   fixtureExchange,
+		Output:   OutputTSV,
+		Stats:    true,
+	}
+	ret, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summaries []scanSummary
+	for _, line := range strings.Split(strings.TrimSuffix(string(ret), "\n"), "\n") {
+		columns := strings.SplitN(line, "\t", 3)
+		if len(columns) != 3 {
+			t.Fatalf("unexpected line %q", line)
+		}
+		if columns[1] != statsChannel {
+			continue
+		}
+		var summary scanSummary
+		if err := json.Unmarshal([]byte(columns[2]), &summary); err != nil {
+			t.Fatal(err)
+		}
+		summaries = append(summaries, summary)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("expected a summary for each target, got %d:\n%s", len(summaries), ret)
+	}
+	// book has 7 levels at the first target, and its connection started again before the second one
+	expected := []string{
+		fmt.Sprintf("1 [{book 4 7 %d %d} {ticker 3 1 %d %d}]", fixtureAt(65*time.Second), int64(15*time.Second), fixtureAt(70*time.Second), int64(10*time.Second)),
+		fmt.Sprintf("2 [{book 6 3 %d %d} {ticker 4 1 %d %d}]", fixtureAt(125*time.Second), int64(25*time.Second), fixtureAt(126*time.Second), int64(24*time.Second)),
+	}
+	for i, summary := range summaries {
+		if actual := fmt.Sprint(summary.Starts, " ", summary.Channels); actual != expected[i] {
+			t.Errorf("target %d: expected %s, got %s", i, expected[i], actual)
+		}
+	}
+	// timestamps of channels are not written unless requested
+	if bytes.Count(ret, []byte("\t")) != bytes.Count(ret, []byte("\n"))*2 {
+		t.Errorf("expected lines of three columns:\n%s", ret)
+	}
+}
@@ -0,0 +1,216 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"golang.org/x/net/websocket"
+)
+
+// minSubscriptionInterval is the shortest interval snapshots can be pushed at.
+const minSubscriptionInterval = time.Second
+
+// subscriptionClock returns the current time for subscriptions, replaced in tests.
+var subscriptionClock = time.Now
+
+// subscriptionPoll is how often dataset files not written yet are looked for.
+var subscriptionPoll = 5 * time.Second
+
+// subscriptionGrace is how long dataset files are waited for after their minute ends,
+// files not written by then are taken as missing.
+var subscriptionGrace = 2 * time.Minute
+
+// followingSource is DatasetSource following dataset files as they are written.
+// Lines after the end of a file are not known until the next file is written,
+// so targets up to the end are reached after the file.
+type followingSource interface {
+	// End returns the last timestamp the file `name` returned by Next covers, 0 if it is not known.
+	End(name string) int64
+}
+
+// liveSource is DatasetSource returning minute files from `minute` as they are written.
+// Next blocks until the next file is written or it is taken as missing, and returns no more file once `ctx` is done.
+type liveSource struct {
+	ctx    context.Context
+	param  SnapshotParameter
+	minute int64
+	// current is the source the file last returned was opened by
+	current DatasetSource
+	name    string
+	mu      sync.Mutex
+	// ends is the map of files returned to the last timestamp they cover
+	ends map[string]int64
+}
+
+func newLiveSource(ctx context.Context, param SnapshotParameter, minute int64) *liveSource {
+	return &liveSource{ctx: ctx, param: param, minute: minute, ends: make(map[string]int64)}
+}
+
+func (s *liveSource) Next() (io.ReadCloser, bool) {
+	key := minuteKey(s.param, s.minute)
+	end := (s.minute+1)*60*1000000000 - 1
+	for {
+		if s.ctx.Err() != nil {
+			return nil, false
+		}
+		body, err := s.open(key)
+		if err != nil {
+			LoggerFrom(s.ctx).Warn("could not open file", "file", key, "error", err)
+		}
+		if body != nil || subscriptionClock().Add(-subscriptionGrace).UnixNano() > end {
+			s.minute++
+			s.name = key
+			s.mu.Lock()
+			s.ends[key] = end
+			s.mu.Unlock()
+			return body, true
+		}
+		select {
+		case <-s.ctx.Done():
+		case <-time.After(subscriptionPoll):
+		}
+	}
+}
+
+// open opens the file `key`, returning nil body if it is not written yet.
+func (s *liveSource) open(key string) (io.ReadCloser, error) {
+	if s.current != nil {
+		s.current.Close()
+		s.current = nil
+	}
+	source, err := openKeys(s.ctx, s.param, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	body, _ := source.Next()
+	if body == nil {
+		source.Close()
+		return nil, nil
+	}
+	s.current = source
+	return body, nil
+}
+
+func (s *liveSource) Name() string {
+	return s.name
+}
+
+func (s *liveSource) End(name string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ends[name]
+}
+
+func (s *liveSource) Close() error {
+	if s.current != nil {
+		return s.current.Close()
+	}
+	return nil
+}
+
+// subscriptionParameter returns the parameter of the subscription request `r` pushing snapshots every `interval`,
+// from the end of the newest dataset file until at most `maxTargets` snapshots are pushed.
+func subscriptionParameter(ctx context.Context, r *http.Request) (param SnapshotParameter, err error) {
+	query := r.URL.Query()
+	interval := time.Minute
+	if intervalStr := query.Get("interval"); intervalStr != "" {
+		if interval, err = time.ParseDuration(intervalStr); err != nil {
+			err = newSnapshotError(ErrBadParameter, errors.New("'interval' must be duration such as '10s'"))
+			return
+		}
+		if interval < minSubscriptionInterval {
+			err = newSnapshotError(ErrBadParameter, fmt.Errorf("'interval' must be at least %s", minSubscriptionInterval))
+			return
+		}
+		query.Del("interval")
+	}
+	// the same parameters as API Gateway gives, at the end of the newest file
+	event := events.APIGatewayProxyRequest{
+		PathParameters:                  map[string]string{"exchange": r.PathValue("exchange"), "nanosec": "latest"},
+		QueryStringParameters:           make(map[string]string),
+		MultiValueQueryStringParameters: query,
+	}
+	for name, values := range query {
+		event.QueryStringParameters[name] = values[len(values)-1]
+	}
+	if param, err = ParseParameter(event); err != nil {
+		err = newSnapshotError(ErrBadParameter, err)
+		return
+	}
+	switch {
+	case param.Output != OutputTSV && param.Output != OutputCSV && param.Output != OutputTable:
+		err = errors.New("'output' must be tsv, csv or table to subscribe")
+	case len(param.Exchanges) > 0 || param.Diff || param.ReplayUntil != 0 || param.ExportState || param.State != nil || param.DryRun:
+		err = errors.New("only snapshots can be subscribed to")
+	}
+	if err != nil {
+		err = newSnapshotError(ErrBadParameter, err)
+		return
+	}
+	// messages are not compressed
+	param.Encoding = ""
+	if err = ResolveLatest(ctx, &param, subscriptionClock()); err != nil {
+		return
+	}
+	first := param.Nanosecs[0]
+	param.Nanosecs = make([]int64, maxTargets)
+	for i := range param.Nanosecs {
+		param.Nanosecs[i] = first + int64(i)*int64(interval)
+	}
+	return
+}
+
+// serveSubscription pushes snapshots as text messages over WebSocket every interval until the client closes it.
+// A simulator is kept through the subscription and only lines since the last snapshot are applied to it.
+func serveSubscription(w http.ResponseWriter, r *http.Request) {
+	log := Logger.With("request_id", r.Header.Get(RequestIDHeader), "exchange", r.PathValue("exchange"))
+	ctx := WithLogger(r.Context(), log)
+	param, err := subscriptionParameter(ctx, r)
+	if err != nil {
+		if errors.Is(err, ErrDatasetGap) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeError(ctx, w, err)
+		return
+	}
+	websocket.Server{Handler: func(conn *websocket.Conn) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			// messages from the client are not read, it is closed when reading fails
+			io.Copy(ioutil.Discard, conn)
+			cancel()
+		}()
+		param.StartMinute = defaultStartMinute(param)
+		checkpoint := loadCheckpoint(ctx, &param)
+		var source DatasetSource = newLiveSource(ctx, param, param.StartMinute)
+		if checkpoint != nil {
+			source = &prependSource{name: "checkpoint", first: checkpoint, rest: source}
+		}
+		defer source.Close()
+		message := new(strings.Builder)
+		param.onSnapshot = func(nanosec int64) error {
+			defer message.Reset()
+			return websocket.Message.Send(conn, message.String())
+		}
+		log.Info("subscription start", "first", param.Nanosecs[0])
+		report, err := SnapshotTo(ctx, param, source, message)
+		addUsage(ctx, report.Scanned)
+		if err != nil && ctx.Err() == nil {
+			log.Warn("subscription failed", "error", err)
+			conn.WriteClose(1011)
+			return
+		}
+		log.Info("subscription end", "scanned", report.Scanned)
+		conn.WriteClose(1000)
+	}}.ServeHTTP(w, r)
+}
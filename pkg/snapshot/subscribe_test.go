@@ -0,0 +1,48 @@
+package snapshot
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestSubscription(t *testing.T) {
+	defer registerFixture()()
+	defer func(dataset string) { DatasetDirectory = dataset }(DatasetDirectory)
+	DatasetDirectory = goldenDir
+	defer func(clock func() time.Time) { subscriptionClock = clock }(subscriptionClock)
+	// the last fixture file is the newest, the next one is not written yet
+	subscriptionClock = func() time.Time { return time.Unix(0, fixtureAt(210*time.Second)) }
+	server := httptest.NewServer(NewHTTPHandler())
+	defer server.Close()
+	res, err := http.Get(server.URL + "/subscribe/" + fixtureExchange + "?channels=book&channels=ticker&format=raw&interval=10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected too short interval to be rejected, got %d", res.StatusCode)
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/subscribe/" + fixtureExchange + "?channels=book&channels=ticker&format=raw&interval=30s"
+	conn, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var message string
+	if err := websocket.Message.Receive(conn, &message); err != nil {
+		t.Fatal(err)
+	}
+	// the first snapshot is at the end of the newest file
+	prefix := fmt.Sprintf("%d\t", fixtureAt(3*time.Minute)-1)
+	lines := strings.Split(strings.TrimSuffix(message, "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], prefix+"book\t") || !strings.HasPrefix(lines[1], prefix+"ticker\t") {
+		t.Errorf("unexpected snapshot:\n%s", message)
+	}
+}
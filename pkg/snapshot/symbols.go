@@ -0,0 +1,114 @@
+package snapshot
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// channelSymbol returns the symbol of the instrument `channel` is for, or empty string if it is not known from
+// the name of the channel, such as `orderBookL2` of bitmex which has messages for all instruments.
+func channelSymbol(exchange string, channel string) string {
+	switch exchange {
+	case binanceSpot, binanceFutures:
+		// btcusdt@depth@100ms
+		if i := strings.IndexByte(channel, '@'); i >= 0 {
+			return channel[:i]
+		}
+		return ""
+	case "bitfinex":
+		// book_tBTCUSD
+		if i := strings.LastIndexByte(channel, '_'); i >= 0 && strings.HasPrefix(channel[i+1:], "t") {
+			return channel[i+2:]
+		}
+		return ""
+	case "bitflyer":
+		// lightning_board_snapshot_BTC_JPY
+		for _, prefix := range []string{"lightning_board_snapshot_", "lightning_board_", "lightning_executions_", "lightning_ticker_"} {
+			if strings.HasPrefix(channel, prefix) {
+				return channel[len(prefix):]
+			}
+		}
+		return ""
+	case "liquid":
+		// price_ladders_cash_btcjpy_buy, executions_cash_btcjpy
+		for _, prefix := range []string{"price_ladders_cash_", "executions_cash_"} {
+			if strings.HasPrefix(channel, prefix) {
+				return strings.TrimSuffix(strings.TrimSuffix(channel[len(prefix):], "_buy"), "_sell")
+			}
+		}
+		return ""
+	default:
+		// channels formatted per instrument, such as orderBookL2_XBTUSD
+		if i := strings.LastIndexAny(channel, "_:"); i >= 0 {
+			return channel[i+1:]
+		}
+		return ""
+	}
+}
+
+// normalizeSymbol removes separators and case from symbol, so that BTC_JPY and btcjpy are the same.
+func normalizeSymbol(symbol string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, symbol)
+}
+
+// symbolFilter filters channels by the symbol of the instrument.
+// Channels of which symbol is not known from its name always pass the filter.
+type symbolFilter struct {
+	exchange string
+	symbols  map[string]bool
+	cache    map[string]bool
+}
+
+// newSymbolFilter returns the filter for `symbols`, or nil if `symbols` is empty.
+func newSymbolFilter(exchange string, symbols []string) *symbolFilter {
+	if len(symbols) == 0 {
+		return nil
+	}
+	f := &symbolFilter{exchange: exchange, symbols: make(map[string]bool), cache: make(map[string]bool)}
+	for _, symbol := range symbols {
+		f.symbols[normalizeSymbol(symbol)] = true
+	}
+	return f
+}
+
+// Match returns true if `channel` is for one of the symbols. It always returns true if the filter is nil.
+func (f *symbolFilter) Match(channel string) bool {
+	if f == nil {
+		return true
+	}
+	if match, ok := f.cache[channel]; ok {
+		return match
+	}
+	symbol := channelSymbol(f.exchange, channel)
+	match := symbol == "" || f.symbols[normalizeSymbol(symbol)]
+	// channel is retained, bytes it refers to might be reused
+	f.cache[copyString(channel)] = match
+	return match
+}
+
+// symbolFilteringSimulator is simulator ignoring channels not for the symbols.
+type symbolFilteringSimulator struct {
+	simulator.Simulator
+	filter *symbolFilter
+}
+
+func (s *symbolFilteringSimulator) ProcessMessageChannelKnown(channel string, line []byte) error {
+	if !s.filter.Match(channel) {
+		return nil
+	}
+	return s.Simulator.ProcessMessageChannelKnown(channel, line)
+}
+
+func (s *symbolFilteringSimulator) ProcessState(channel string, line []byte) error {
+	if !s.filter.Match(channel) {
+		return nil
+	}
+	return s.Simulator.ProcessState(channel, line)
+}
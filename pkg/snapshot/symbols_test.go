@@ -0,0 +1,30 @@
+package snapshot
+
+import "testing"
+
+func TestSymbolFilter(t *testing.T) {
+	expected := []struct {
+		exchange string
+		channel  string
+		match    bool
+	}{
+		{"bitflyer", "lightning_board_BTC_JPY", true},
+		{"bitflyer", "lightning_board_snapshot_ETH_JPY", false},
+		{"bitfinex", "book_tBTCJPY", true},
+		{"binance", "btcjpy@depth@100ms", true},
+		{"binance", "btcusdt@depth@100ms", false},
+		{"liquid", "price_ladders_cash_btcjpy_buy", true},
+		// symbol is not known from the name
+		{"bitmex", "orderBookL2", true},
+	}
+	for _, e := range expected {
+		f := newSymbolFilter(e.exchange, []string{"BTC/JPY"})
+		if f.Match(e.channel) != e.match {
+			t.Errorf("%s %s: expected match to be %v", e.exchange, e.channel, e.match)
+		}
+	}
+	var f *symbolFilter
+	if !f.Match("anything") {
+		t.Error("nil filter must match everything")
+	}
+}
@@ -0,0 +1,118 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// tableRow is a formatted message having price but not being an orderbook level, such as trade or ticker.
+type tableRow struct {
+	Side  string   `json:"side"`
+	Price *float64 `json:"price"`
+	Size  *float64 `json:"size"`
+}
+
+// formatNumber returns `value` in the shortest form, empty if it is nil.
+func formatNumber(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
+
+// writeTable writes `entries` at `nanosec` for humans to read.
+// Orderbook levels of each channel are written as columns of bids and asks with the best prices at the top,
+// other messages having price as rows of side, price and size, and the rest as they are.
+// Messages of trade channels are always rows as they also have sides.
+func writeTable(w io.Writer, exchange string, nanosec int64, entries []entry) error {
+	at := time.Unix(0, nanosec).UTC().Format(time.RFC3339Nano)
+	if _, err := fmt.Fprintf(w, "%s at %s (%d)\n", exchange, at, nanosec); err != nil {
+		return err
+	}
+	for start := 0; start < len(entries); {
+		// entries of the same channel are next to each other
+		end := start + 1
+		for end < len(entries) && entries[end].channel == entries[start].channel {
+			end++
+		}
+		if err := writeTableChannel(w, entries[start].channel, entries[start:end]); err != nil {
+			return err
+		}
+		start = end
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeTableChannel writes `entries` of `channel` as writeTable does.
+func writeTableChannel(w io.Writer, channel string, entries []entry) error {
+	var bids, asks [][2]float64
+	var rows []tableRow
+	var others [][]byte
+	// names of trade channels have it in both native and normalized naming, such as `trade_XBTUSD` and `trades:BTC/USD`
+	trades := strings.Contains(strings.ToLower(channel), "trade")
+	for _, e := range entries {
+		if len(e.message) == 0 {
+			// channel without data
+			continue
+		}
+		if side, price, size, ok := parseLevel(e.message); ok && !trades {
+			if side == sideBid {
+				bids = append(bids, [2]float64{price, size})
+			} else {
+				asks = append(asks, [2]float64{price, size})
+			}
+			continue
+		}
+		var row tableRow
+		if e.message[0] == '{' && json.Unmarshal(e.message, &row) == nil && row.Price != nil {
+			rows = append(rows, row)
+			continue
+		}
+		others = append(others, e.message)
+	}
+	if _, err := fmt.Fprintf(w, "\n%s\n", channel); err != nil {
+		return err
+	}
+	if len(bids) == 0 && len(asks) == 0 && len(rows) == 0 && len(others) == 0 {
+		_, err := io.WriteString(w, "  (no data)\n")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	if len(bids) > 0 || len(asks) > 0 {
+		sort.Slice(bids, func(i, j int) bool { return bids[i][0] > bids[j][0] })
+		sort.Slice(asks, func(i, j int) bool { return asks[i][0] < asks[j][0] })
+		fmt.Fprint(tw, "\tBID SIZE\tBID PRICE\tASK PRICE\tASK SIZE\t\n")
+		for i := 0; i < len(bids) || i < len(asks); i++ {
+			var bid, ask [2]string
+			if i < len(bids) {
+				bid = [2]string{formatNumber(&bids[i][0]), formatNumber(&bids[i][1])}
+			}
+			if i < len(asks) {
+				ask = [2]string{formatNumber(&asks[i][0]), formatNumber(&asks[i][1])}
+			}
+			fmt.Fprintf(tw, "\t%s\t%s\t%s\t%s\t\n", bid[1], bid[0], ask[0], ask[1])
+		}
+	}
+	if len(rows) > 0 {
+		fmt.Fprint(tw, "\tSIDE\tPRICE\tSIZE\t\n")
+		for _, row := range rows {
+			fmt.Fprintf(tw, "\t%s\t%s\t%s\t\n", row.Side, formatNumber(row.Price), formatNumber(row.Size))
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, message := range others {
+		if _, err := fmt.Fprintf(w, "  %s\n", message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
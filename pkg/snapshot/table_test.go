@@ -0,0 +1,64 @@
+package snapshot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTable(t *testing.T) {
+	entries := []entry{
+		{channel: "book", message: []byte(`{"side":"buy","price":99.5,"size":12.25}`)},
+		{channel: "book", message: []byte(`{"side":"buy","price":100,"size":1}`)},
+		{channel: "book", message: []byte(`{"side":"sell","price":101,"size":4}`)},
+		{channel: "empty"},
+		{channel: "ticker", message: []byte(`{"last":101}`)},
+		{channel: "trade", message: []byte(`{"side":"buy","price":100,"size":0.5}`)},
+	}
+	builder := new(strings.Builder)
+	if err := writeTable(builder, "fixture", 1598941025555000000, entries); err != nil {
+		t.Fatal(err)
+	}
+	expected := `fixture at 2020-09-01T06:17:05.555Z (1598941025555000000)
+
+book
+    BID SIZE  BID PRICE  ASK PRICE  ASK SIZE
+           1        100        101         4
+       12.25       99.5                     
+
+empty
+  (no data)
+
+ticker
+  {"last":101}
+
+trade
+    SIDE  PRICE  SIZE
+     buy    100   0.5
+
+`
+	if builder.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, builder.String())
+	}
+}
+
+func TestSnapshotTable(t *testing.T) {
+	defer registerFixture()()
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{fixtureAt(35 * time.Second)},
+		Channels: []string{"book", "ticker"},
+		Format:   fixtureExchange,
+		Output:   OutputTable,
+	}
+	ret, _, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"BID SIZE  BID PRICE  ASK PRICE  ASK SIZE", "99.5        101         4", `{"last":101}`} {
+		if !strings.Contains(string(ret), expected) {
+			t.Errorf("expected %q in the table:\n%s", expected, ret)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Setup initializes stores and telemetry configured by environment variables.
+// It has to be called once before snapshots are taken.
+func Setup(ctx context.Context) error {
+	if CheckpointBucket != "" {
+		store, err := newS3CheckpointStore(CheckpointBucket)
+		if err != nil {
+			return err
+		}
+		checkpoints = store
+	}
+	if WarmChannels != "" {
+		channels, err := parseWarmChannels(WarmChannels)
+		if err != nil {
+			return fmt.Errorf("invalid WARM_CHANNELS: %v", err)
+		}
+		// checkpoints of warm simulators are kept in memory, others are saved as configured
+		warm = newWarmStore(channels, checkpoints)
+		checkpoints = warm
+	}
+	if ManifestBucket != "" {
+		store, err := newS3ManifestStore(ManifestBucket)
+		if err != nil {
+			return err
+		}
+		manifests = store
+	}
+	if ResultCacheBucket != "" {
+		store, err := newS3ResultStore(ResultCacheBucket, "result")
+		if err != nil {
+			return err
+		}
+		results = store
+		// the next page could be requested to other instances, objects should expire by the lifecycle of the bucket
+		pageStore, err := newS3ResultStore(ResultCacheBucket, "page")
+		if err != nil {
+			return err
+		}
+		pages = pageStore
+		resumeStore, err := newS3ResultStore(ResultCacheBucket, "resume")
+		if err != nil {
+			return err
+		}
+		resumes = resumeStore
+	}
+	if CatalogBucket != "" {
+		store, err := newS3CatalogStore(CatalogBucket)
+		if err != nil {
+			return err
+		}
+		catalog = store
+	}
+	if APIKeysFile != "" {
+		limiter, err := loadAPIKeys(APIKeysFile)
+		if err != nil {
+			return err
+		}
+		apiKeys = limiter
+	}
+	if MetricsAddress != "" {
+		serveMetrics(MetricsAddress)
+	}
+	return setupTracing(ctx)
+}
+
+// MadeAtOnce returns true if the result for `param` is made at once by Take instead of written as snapshots are taken.
+// Results are also made at once to be compared with `IfNoneMatch` before sent, to tell whether changes are returned for `SinceHash`,
+// to be split into pages, to be taken of groups of targets concurrently, and to have the state of stopped scans applied.
+func (param SnapshotParameter) MadeAtOnce() bool {
+	return param.Output == OutputParquet || param.Destination != "" || len(param.Exchanges) > 0 || len(param.Formats) > 1 || param.FanOut ||
+		param.IfNoneMatch != "" || param.SinceHash != "" || param.PageBytes > 0 || param.PageToken != "" || targetGroups(param) != nil ||
+		param.Resumable
+}
+
+// Take makes the result for `param` from the location configured, which could have been cached.
+// Results of parquet output are exported and the location of them is returned instead, as are results written to `destination`.
+// `result` is nil with `report.NotModified` if it matches `param.IfNoneMatch`, `report.ETag` is of the result either way.
+// Results larger than `param.PageBytes` are returned in pages, `report.NextPage` is given as `param.PageToken` to take the next one.
+// Pages are tagged by the result they are of and their position in it instead of their content.
+func Take(ctx context.Context, param SnapshotParameter) (result []byte, report Report, err error) {
+	log := LoggerFrom(ctx)
+	st := time.Now()
+	defer func() {
+		// results are cached in full before it
+		if err != nil || len(result) == 0 {
+			return
+		}
+		report.ETag = ETag(result)
+		if param.PageBytes > 0 {
+			if key, ok := resultCacheKey(param, time.Now()); ok {
+				// pages of different results could have the same content
+				report.ETag = pageETag(key, param.PageBytes, param.PageToken)
+			}
+		}
+		if param.IfNoneMatch != "" && matchesETag(param.IfNoneMatch, report.ETag) {
+			// the client already has the result
+			result = nil
+			report.NotModified = true
+		}
+	}()
+	if param.PageToken != "" {
+		// the rest of the result made by the previous request
+		return nextPage(param.PageToken, param.PageBytes, time.Now())
+	}
+	defer func() {
+		// results are cached in full before they are split
+		if err != nil || param.PageBytes == 0 || int64(len(result)) <= param.PageBytes {
+			return
+		}
+		result, err = firstPage(result, &report, param.PageBytes, time.Now())
+	}()
+	if param.ResumeToken != "" {
+		// continue from the state the stopped scan saved
+		if err = applyResume(&param); err != nil {
+			return
+		}
+	}
+	// the same request could have been already made
+	var cacheKey string
+	cacheable := false
+	if results != nil {
+		cacheKey, cacheable = resultCacheKey(param, time.Now())
+	}
+	if cacheable {
+		cached, ok, serr := results.Get(cacheKey)
+		if serr != nil {
+			log.Warn("could not get cached result", "error", serr)
+		} else if ok {
+			log.Info("serving cached result", "elapsed", time.Now().Sub(st))
+			// billed as if it was scanned to be fair to consumers of uncached results
+			report = Report{Scanned: cached.scanned, LastTimestamp: cached.lastTimestamp, Token: cached.token, FullSnapshot: cached.fullSnapshot}
+			return cached.result, report, nil
+		}
+	}
+	defer func() {
+		// only complete results are cached
+		if !cacheable || err != nil || report.Partial != "" || report.SkippedLines > 0 || len(report.TruncatedFiles) > 0 {
+			return
+		}
+		cached := cachedResult{result: result, scanned: report.Scanned, lastTimestamp: report.LastTimestamp, token: report.Token, fullSnapshot: report.FullSnapshot}
+		if serr := results.Put(cacheKey, cached); serr != nil {
+			log.Warn("could not cache result", "error", serr)
+			return
+		}
+		if entry, ok := cacheCatalogEntry(param); ok {
+			recordMaterialized(ctx, entry)
+		}
+	}()
+	// list dataset to read to reconstruct snapshot
+	// and make response string
+	if len(param.Exchanges) > 0 {
+		log.Debug("snapshot start", "elapsed", time.Now().Sub(st))
+		return snapshotExchanges(ctx, param)
+	}
+	if groups := targetGroups(param); groups != nil {
+		log.Debug("snapshot start", "elapsed", time.Now().Sub(st), "groups", len(groups))
+		return snapshotTargets(ctx, param, groups)
+	}
+	source, err := OpenSource(ctx, &param)
+	if err != nil {
+		return
+	}
+	defer func() {
+		serr := source.Close()
+		if serr != nil {
+			if err != nil {
+				err = fmt.Errorf("snapshot: source close: %v, originally: %v", serr, err)
+			} else {
+				err = serr
+			}
+		}
+	}()
+	log.Debug("snapshot start", "elapsed", time.Now().Sub(st))
+	if param.Destination != "" {
+		return snapshotToDestination(ctx, param, source)
+	}
+	if param.FanOut {
+		return snapshotSymbolParts(ctx, param, source)
+	}
+	if len(param.Formats) > 1 {
+		return snapshotParts(ctx, param, source)
+	}
+	// write snapshot
+	result, report, err = Snapshot(ctx, param, source)
+	if err == nil && param.Output == OutputParquet && len(result) > 0 {
+		// result is too large to be returned, location of it is returned instead
+		result, err = exportResult(ExportBucket, param.ExportKey, result)
+		if err != nil {
+			err = newSnapshotError(ErrStorage, err)
+		}
+	}
+	return
+}
@@ -0,0 +1,124 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+)
+
+// targetGroups returns targets of `param` split into groups in disjoint time windows, which are taken snapshot of
+// independently of each other. A target starts a new group if dataset files it is taken snapshot from are all after
+// the previous target, as reading files between them in a single pass would be slower than starting from them again.
+// It returns nil if targets are taken in a single pass, as the request needs snapshots at them in the same pass
+// or its output can not be concatenated.
+func targetGroups(param SnapshotParameter) [][]int64 {
+	if TargetConcurrency <= 1 || len(param.Nanosecs) < 2 || len(param.Exchanges) > 0 || len(param.Formats) > 1 || param.FanOut ||
+		(param.Output != OutputTSV && param.Output != OutputNDJSON) ||
+		param.Diff || param.State != nil || param.ExportState || param.ReplayUntil != 0 || param.DryRun ||
+		param.Destination != "" || param.MaxScanBytes > 0 || param.Resumable || param.Stats || (param.Reconnects != "" && param.Reconnects != ReconnectsIgnore) ||
+		param.record != nil || param.replay != nil || param.onSnapshot != nil {
+		return nil
+	}
+	groups := [][]int64{{param.Nanosecs[0]}}
+	for _, nanosec := range param.Nanosecs[1:] {
+		last := groups[len(groups)-1]
+		single := param
+		single.Nanosecs = []int64{nanosec}
+		if defaultStartMinute(single) > last[len(last)-1]/60/1000000000 {
+			groups = append(groups, []int64{nanosec})
+		} else {
+			groups[len(groups)-1] = append(last, nanosec)
+		}
+	}
+	if len(groups) == 1 {
+		return nil
+	}
+	return groups
+}
+
+// targetResult is the result of snapshot for a group of targets.
+type targetResult struct {
+	ret    []byte
+	report Report
+	err    error
+}
+
+// snapshotTargets takes snapshots at targets in each of `groups` concurrently, at most `TargetConcurrency` groups at a time,
+// and returns them in the order of targets as if they were taken in a single pass.
+// In the report, `lastTimestamp` and the token are of the last group, and `partial` is the first reason any of them is partial.
+func snapshotTargets(ctx context.Context, param SnapshotParameter, groups [][]int64) (ret []byte, report Report, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]targetResult, len(groups))
+	workers := make(chan struct{}, TargetConcurrency)
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		groupParam := param
+		groupParam.Nanosecs = group
+		wg.Add(1)
+		go func(i int, groupParam SnapshotParameter) {
+			defer wg.Done()
+			select {
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				results[i].err = ctx.Err()
+				return
+			}
+			defer func() { <-workers }()
+			result := &results[i]
+			ctx := WithLogger(ctx, LoggerFrom(ctx).With("first_target", groupParam.Nanosecs[0]))
+			source, serr := OpenSource(ctx, &groupParam)
+			if serr != nil {
+				result.err = serr
+				cancel()
+				return
+			}
+			result.ret, result.report, result.err = Snapshot(ctx, groupParam, source)
+			if serr := source.Close(); serr != nil && result.err == nil {
+				result.err = serr
+			}
+			if result.err != nil {
+				// the whole result fails anyway
+				cancel()
+			}
+		}(i, groupParam)
+	}
+	wg.Wait()
+	buffer := new(bytes.Buffer)
+	for _, result := range results {
+		report.Scanned += result.report.Scanned
+		report.SkippedLines += result.report.SkippedLines
+		report.SkippedStates += result.report.SkippedStates
+		report.FilesRead += result.report.FilesRead
+		report.ProcessTime += result.report.ProcessTime
+		report.CacheHits += result.report.CacheHits
+		report.CacheMisses += result.report.CacheMisses
+		report.Files = append(report.Files, result.report.Files...)
+		report.MissingFiles = append(report.MissingFiles, result.report.MissingFiles...)
+		report.TruncatedFiles = append(report.TruncatedFiles, result.report.TruncatedFiles...)
+		report.ChannelTimes = mergeChannelTimes(report.ChannelTimes, result.report.ChannelTimes)
+	}
+	// the error of the group which failed first, not of ones canceled by it
+	for _, result := range results {
+		if result.err != nil && (err == nil || errors.Is(err, context.Canceled)) {
+			err = result.err
+		}
+	}
+	if err != nil {
+		return
+	}
+	for _, result := range results {
+		if report.Partial == "" {
+			report.Partial = result.report.Partial
+		}
+		buffer.Write(result.ret)
+	}
+	last := results[len(results)-1].report
+	report.LastTimestamp = last.LastTimestamp
+	report.Token = last.Token
+	report.StoppedAt = last.StoppedAt
+	report.Channels = last.Channels
+	ret = buffer.Bytes()
+	return
+}
@@ -0,0 +1,67 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTargetGroups(t *testing.T) {
+	param := SnapshotParameter{
+		Exchange:           fixtureExchange,
+		Nanosecs:           []int64{fixtureAt(10 * time.Second), fixtureAt(50 * time.Second), fixtureAt(150 * time.Second), fixtureAt(10 * time.Minute)},
+		Output:             OutputTSV,
+		MaxLookbackMinutes: 1,
+	}
+	expected := fmt.Sprint([][]int64{param.Nanosecs[:2], param.Nanosecs[2:3], param.Nanosecs[3:]})
+	if groups := targetGroups(param); fmt.Sprint(groups) != expected {
+		t.Errorf("expected %s, got %v", expected, groups)
+	}
+	// files from the beginning of 10 minutes are read without the lookback
+	param.MaxLookbackMinutes = 0
+	expected = fmt.Sprint([][]int64{param.Nanosecs[:3], param.Nanosecs[3:]})
+	if groups := targetGroups(param); fmt.Sprint(groups) != expected {
+		t.Errorf("expected %s, got %v", expected, groups)
+	}
+	param.Diff = true
+	if groups := targetGroups(param); groups != nil {
+		t.Errorf("expected targets of diff to be in a single pass, got %v", groups)
+	}
+}
+
+func TestSnapshotTargets(t *testing.T) {
+	defer registerFixture()()
+	defer func(dir string, concurrency int) { DatasetDirectory, TargetConcurrency = dir, concurrency }(DatasetDirectory, TargetConcurrency)
+	DatasetDirectory = goldenDir
+	param := SnapshotParameter{
+		Exchange:           fixtureExchange,
+		Nanosecs:           []int64{fixtureAt(50 * time.Second), fixtureAt(150 * time.Second)},
+		Channels:           []string{"book", "ticker"},
+		Format:             "raw",
+		Output:             OutputTSV,
+		Compression:        "gzip",
+		MaxLookbackMinutes: 1,
+	}
+	TargetConcurrency = 1
+	single, singleReport, err := Take(context.Background(), param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TargetConcurrency = 4
+	if !param.MadeAtOnce() {
+		t.Error("expected targets in disjoint windows to be made at once")
+	}
+	concurrent, report, err := Take(context.Background(), param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the connection started again before the second target, the state at it is the same
+	if !bytes.Equal(concurrent, single) {
+		t.Errorf("expected the same snapshots as in a single pass:\n%s\nexpected:\n%s", concurrent, single)
+	}
+	if report.LastTimestamp != singleReport.LastTimestamp {
+		t.Errorf("expected the last timestamp %d, got %d", singleReport.LastTimestamp, report.LastTimestamp)
+	}
+}
@@ -0,0 +1,163 @@
+package snapshot
+
+import (
+	"strings"
+
+	"github.com/exchangedataset/streamcommons/formatter"
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// isTickerChannel returns true if `channel` of `exchange` is of tickers, such as `lightning_ticker_BTC_JPY`,
+// `btcusdt@bookTicker` or `quote_XBTUSD`, whose state is the latest message rather than what messages build up.
+// Simulators of streamcommons have nothing to take snapshot of them, they are taken snapshot of by this package.
+func isTickerChannel(exchange string, channel string) bool {
+	if isDerivativesChannel(exchange, channel) {
+		return false
+	}
+	if exchange == "bitmex" {
+		return channel == "quote" || strings.HasPrefix(channel, "quote_")
+	}
+	return strings.Contains(strings.ToLower(channel), "ticker")
+}
+
+// splitTickerChannels returns channels of tickers in `channels` and others.
+func splitTickerChannels(exchange string, channels []string) (tickers []string, others []string) {
+	for _, channel := range channels {
+		if isTickerChannel(exchange, channel) {
+			tickers = append(tickers, channel)
+		} else {
+			others = append(others, channel)
+		}
+	}
+	return
+}
+
+// tickerSimulator is simulator of ticker channels, which keeps the latest message of each channel.
+// Lines of other channels are passed to the embedded simulator, which is nil if no other channel is requested.
+type tickerSimulator struct {
+	simulator.Simulator
+	// channels are requested ticker channels
+	channels []string
+	// received are channels of instruments messages are received in for each requested channel, in the order of appearance
+	received map[string][]string
+	latest   map[string][]byte
+}
+
+func newTickerSimulator(channels []string, others simulator.Simulator) *tickerSimulator {
+	return &tickerSimulator{Simulator: others, channels: channels, received: make(map[string][]string), latest: make(map[string][]byte)}
+}
+
+// requested returns the requested channel lines of `channel` are for, such as `quote` for `quote_XBTUSD`,
+// or empty string if it is not of requested ticker channels.
+func (s *tickerSimulator) requested(channel string) string {
+	for _, requested := range s.channels {
+		if channel == requested || strings.HasPrefix(channel, requested+"_") {
+			return requested
+		}
+	}
+	return ""
+}
+
+func (s *tickerSimulator) ProcessStart(line []byte) error {
+	// tickers are sent again in the new connection
+	s.received = make(map[string][]string)
+	s.latest = make(map[string][]byte)
+	if s.Simulator != nil {
+		return s.Simulator.ProcessStart(line)
+	}
+	return nil
+}
+
+func (s *tickerSimulator) ProcessMessageChannelKnown(channel string, line []byte) error {
+	requested := s.requested(channel)
+	if requested == "" {
+		if s.Simulator != nil {
+			return s.Simulator.ProcessMessageChannelKnown(channel, line)
+		}
+		return nil
+	}
+	if _, ok := s.latest[channel]; !ok {
+		// channel is retained, bytes it refers to might be reused
+		channel = copyString(channel)
+		s.received[requested] = append(s.received[requested], channel)
+	}
+	// the buffer of the line is reused, and snapshots taken before must not change
+	s.latest[channel] = append([]byte(nil), line...)
+	return nil
+}
+
+func (s *tickerSimulator) ProcessState(channel string, line []byte) error {
+	if s.requested(channel) == "" {
+		if s.Simulator != nil {
+			return s.Simulator.ProcessState(channel, line)
+		}
+		return nil
+	}
+	return s.ProcessMessageChannelKnown(channel, line)
+}
+
+// TakeSnapshot returns snapshots of the embedded simulator followed by the latest messages of ticker channels
+// in the order of request, channels of instruments of a requested channel are in the order of appearance.
+func (s *tickerSimulator) TakeSnapshot() ([]simulator.Snapshot, error) {
+	var snapshots []simulator.Snapshot
+	if s.Simulator != nil {
+		others, err := s.Simulator.TakeSnapshot()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = others
+	}
+	for _, requested := range s.channels {
+		for _, channel := range s.received[requested] {
+			snapshots = append(snapshots, simulator.Snapshot{Channel: channel, Snapshot: s.latest[channel]})
+		}
+	}
+	return snapshots, nil
+}
+
+// tickerFormatter formats snapshots of ticker channels, which are messages as they are, with the embedded formatter
+// if it supports them and passes them as they are otherwise. The embedded formatter is nil if it is not made.
+type tickerFormatter struct {
+	formatter.Formatter
+	exchange string
+}
+
+// streamcommonsFormatter returns the formatter of streamcommons of `format` for `channels` of `exchange`,
+// ticker channels it could not be made for are passed as they are by tickerFormatter.
+func streamcommonsFormatter(exchange string, channels []string, format string) (formatter.Formatter, error) {
+	tickers, others := splitTickerChannels(exchange, channels)
+	if len(tickers) == 0 {
+		return formatter.GetFormatter(exchange, channels, format)
+	}
+	form := &tickerFormatter{exchange: exchange}
+	var err error
+	if form.Formatter, err = formatter.GetFormatter(exchange, channels, format); err != nil {
+		form.Formatter = nil
+		if len(others) > 0 {
+			if form.Formatter, err = formatter.GetFormatter(exchange, others, format); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return form, nil
+}
+
+func (f *tickerFormatter) FormatMessage(channel string, line []byte) ([]formatter.Result, error) {
+	if f.Formatter != nil && f.Formatter.IsSupported(channel) {
+		return f.Formatter.FormatMessage(channel, line)
+	}
+	if isTickerChannel(f.exchange, channel) {
+		return []formatter.Result{{Channel: channel, Message: line}}, nil
+	}
+	if f.Formatter == nil {
+		return nil, nil
+	}
+	return f.Formatter.FormatMessage(channel, line)
+}
+
+func (f *tickerFormatter) IsSupported(channel string) bool {
+	if isTickerChannel(f.exchange, channel) {
+		return true
+	}
+	return f.Formatter != nil && f.Formatter.IsSupported(channel)
+}
@@ -0,0 +1,84 @@
+package snapshot
+
+import (
+	"testing"
+)
+
+func TestIsTickerChannel(t *testing.T) {
+	for _, c := range []struct {
+		exchange string
+		channel  string
+		expected bool
+	}{
+		{"bitflyer", "lightning_ticker_BTC_JPY", true},
+		{"bitflyer", "lightning_board_BTC_JPY", false},
+		{"binance", "btcusdt@bookTicker", true},
+		{"binance", "btcusdt@ticker", true},
+		{"binance", "btcusdt@depth@100ms", false},
+		{"bitmex", "quote_XBTUSD", true},
+		{"bitmex", "quote", true},
+		{"bitmex", "orderBookL2_XBTUSD", false},
+		{binanceFutures, "btcusdt@markPrice@1s", false},
+	} {
+		if actual := isTickerChannel(c.exchange, c.channel); actual != c.expected {
+			t.Errorf("%s %s: expected %v, got %v", c.exchange, c.channel, c.expected, actual)
+		}
+	}
+}
+
+func TestTickerSimulator(t *testing.T) {
+	others := &countingSimulator{channels: []string{"orderBookL2"}, counts: make(map[string]int)}
+	sim := newTickerSimulator([]string{"quote"}, others)
+	line := []byte(`{"table":"quote","action":"insert","data":[{"symbol":"XBTUSD","bidPrice":11794}]}`)
+	if err := sim.ProcessMessageChannelKnown("quote_XBTUSD", line); err != nil {
+		t.Fatal(err)
+	}
+	// the buffer of the line is reused
+	copy(line, `{"table":"broken"`)
+	for _, l := range []struct {
+		channel string
+		message string
+	}{
+		{"quote_ETHUSD", `{"table":"quote","action":"insert","data":[{"symbol":"ETHUSD","bidPrice":380}]}`},
+		{"orderBookL2_XBTUSD", `{}`},
+		{"quote_XBTUSD", `{"table":"quote","action":"insert","data":[{"symbol":"XBTUSD","bidPrice":11795}]}`},
+	} {
+		if err := sim.ProcessMessageChannelKnown(l.channel, []byte(l.message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshots, err := sim.TakeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 || snapshots[0].Channel != "orderBookL2" || string(snapshots[0].Snapshot) != "1" {
+		t.Fatalf("expected snapshot of the other simulator first, got %v", snapshots)
+	}
+	// channels are in the order they first appeared
+	if snapshots[1].Channel != "quote_XBTUSD" || string(snapshots[1].Snapshot) != `{"table":"quote","action":"insert","data":[{"symbol":"XBTUSD","bidPrice":11795}]}` {
+		t.Errorf("expected the latest quote of XBTUSD, got %s: %s", snapshots[1].Channel, snapshots[1].Snapshot)
+	}
+	if snapshots[2].Channel != "quote_ETHUSD" || string(snapshots[2].Snapshot) != `{"table":"quote","action":"insert","data":[{"symbol":"ETHUSD","bidPrice":380}]}` {
+		t.Errorf("expected the latest quote of ETHUSD, got %s: %s", snapshots[2].Channel, snapshots[2].Snapshot)
+	}
+	if err := sim.ProcessStart([]byte("wss://www.bitmex.com/realtime")); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ := sim.TakeSnapshot(); len(snapshots) != 1 {
+		t.Errorf("expected tickers to be forgotten in the new connection, got %v", snapshots)
+	}
+}
+
+func TestTickerFormatter(t *testing.T) {
+	form := &tickerFormatter{exchange: "bitflyer"}
+	results, err := form.FormatMessage("lightning_ticker_BTC_JPY", []byte(`{"best_bid":1000}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Channel != "lightning_ticker_BTC_JPY" || string(results[0].Message) != `{"best_bid":1000}` {
+		t.Errorf("expected the ticker to be passed as it is, got %v", results)
+	}
+	if form.IsSupported("lightning_board_BTC_JPY") {
+		t.Error("expected channels other than tickers not to be supported without the formatter")
+	}
+}
@@ -0,0 +1,51 @@
+package snapshot
+
+import (
+	"strconv"
+	"time"
+)
+
+// units of timestamps in tsv and csv output
+const (
+	// TimestampNanosecond writes nanoseconds from the epoch, which is the default
+	TimestampNanosecond = "ns"
+	// TimestampMillisecond writes milliseconds from the epoch, truncating nanoseconds
+	TimestampMillisecond = "ms"
+	// TimestampRFC3339 writes RFC3339 time in UTC with fractional seconds
+	TimestampRFC3339 = "rfc3339"
+)
+
+// formatTimestamp returns `nanosec` in `unit`, nanoseconds if `unit` is empty.
+func formatTimestamp(nanosec int64, unit string) string {
+	switch unit {
+	case TimestampMillisecond:
+		return strconv.FormatInt(nanosec/int64(time.Millisecond), 10)
+	case TimestampRFC3339:
+		return time.Unix(0, nanosec).UTC().Format(time.RFC3339Nano)
+	default:
+		return strconv.FormatInt(nanosec, 10)
+	}
+}
+
+// lineLayout is how columns before messages are written in tsv and csv output.
+type lineLayout struct {
+	// unit is the unit of timestamps, one of `Timestamp*`
+	unit string
+	// omitTimestamp is true if the column of the target is not written, as it is the same in every line of a snapshot
+	omitTimestamp bool
+	// asOf is true if the column of the timestamp of the last line updated the channel is written
+	asOf bool
+}
+
+// layoutOf returns the layout of lines of snapshots for `param`.
+func layoutOf(param SnapshotParameter) lineLayout {
+	return lineLayout{unit: param.TimestampUnit, omitTimestamp: param.OmitTimestamp, asOf: param.AsOf}
+}
+
+// prefix returns the column of the target `nanosec` including the separator, empty if it is omitted.
+func (l lineLayout) prefix(nanosec int64, separator string) string {
+	if l.omitTimestamp {
+		return ""
+	}
+	return formatTimestamp(nanosec, l.unit) + separator
+}
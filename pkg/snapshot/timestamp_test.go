@@ -0,0 +1,81 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestFormatTimestamp(t *testing.T) {
+	nanosec := int64(1598941025555123456)
+	for unit, expected := range map[string]string{
+		"":                   "1598941025555123456",
+		TimestampNanosecond:  "1598941025555123456",
+		TimestampMillisecond: "1598941025555",
+		TimestampRFC3339:     "2020-09-01T06:17:05.555123456Z",
+	} {
+		if formatted := formatTimestamp(nanosec, unit); formatted != expected {
+			t.Errorf("%s: expected %s, got %s", unit, expected, formatted)
+		}
+	}
+}
+
+func TestWriteEntriesLayout(t *testing.T) {
+	entries := []entry{{channel: "book", message: []byte(`{"price":1}`), asOf: 1598941025000000000}}
+	for _, c := range []struct {
+		layout   lineLayout
+		expected string
+	}{
+		{layout: lineLayout{}, expected: "1598941025555000000\tbook\t{\"price\":1}\n"},
+		{layout: lineLayout{unit: TimestampMillisecond, asOf: true}, expected: "1598941025555\tbook\t1598941025000\t{\"price\":1}\n"},
+		{layout: lineLayout{omitTimestamp: true}, expected: "book\t{\"price\":1}\n"},
+	} {
+		buf := new(bytes.Buffer)
+		writer := bufio.NewWriter(buf)
+		if err := writeEntries(writer, 1598941025555000000, entries, c.layout); err != nil {
+			t.Fatal(err)
+		}
+		writer.Flush()
+		if buf.String() != c.expected {
+			t.Errorf("%+v: expected %q, got %q", c.layout, c.expected, buf.String())
+		}
+	}
+}
+
+func TestWriteCSVRowsLayout(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writer := csv.NewWriter(buf)
+	layout := lineLayout{omitTimestamp: true}
+	writer.Write(layout.csvHeader())
+	if err := writeCSVRows(writer, 100, []entry{{channel: "book", message: []byte(`{"side":"buy","price":1.5,"size":2}`)}}, layout); err != nil {
+		t.Fatal(err)
+	}
+	writer.Flush()
+	expected := "channel,side,price,size\nbook,buy,1.5,2\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestMakeParameterTimestamp(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	event.QueryStringParameters["timestampUnit"] = TimestampRFC3339
+	event.QueryStringParameters["timestampColumn"] = "false"
+	param, err := ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.TimestampUnit != TimestampRFC3339 || !param.OmitTimestamp {
+		t.Errorf("unexpected layout %s %t", param.TimestampUnit, param.OmitTimestamp)
+	}
+	event.MultiValueQueryStringParameters["nanosecs"] = []string{"1598941026000000000"}
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected error omitting the timestamp of multiple targets")
+	}
+	delete(event.MultiValueQueryStringParameters, "nanosecs")
+	event.QueryStringParameters["output"] = OutputJSON
+	if _, err := ParseParameter(event); err == nil {
+		t.Error("expected error for json output")
+	}
+}
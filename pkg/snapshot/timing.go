@@ -0,0 +1,81 @@
+package snapshot
+
+import "time"
+
+// ChannelTiming is the time the simulator took to process lines of a channel in a scan, measured in debug mode.
+type ChannelTiming struct {
+	Channel string `json:"channel"`
+	Lines   int    `json:"lines"`
+	// Bytes is the bytes of messages of the channel
+	Bytes int64 `json:"bytes"`
+	// SimulatorTime is the time in milliseconds the simulator took to process them
+	SimulatorTime float64 `json:"simulatorTime"`
+}
+
+// scanTiming is the breakdown of time spent in a scan into parsing of each file and simulators of each channel.
+// It costs reading the clock for every line, so it is only measured in debug mode.
+type scanTiming struct {
+	// parse is the time lines of the file being fed took to be read and parsed
+	parse    time.Duration
+	channels map[string]*channelTiming
+	// order is channels in the order of appearance
+	order []string
+}
+
+type channelTiming struct {
+	lines     int
+	bytes     int64
+	simulator time.Duration
+}
+
+func newScanTiming() *scanTiming {
+	return &scanTiming{channels: make(map[string]*channelTiming)}
+}
+
+// add records a line of `channel` with `message` the simulator took `elapsed` to process.
+func (t *scanTiming) add(channel string, message []byte, elapsed time.Duration) {
+	c, ok := t.channels[channel]
+	if !ok {
+		c = new(channelTiming)
+		t.channels[channel] = c
+		t.order = append(t.order, channel)
+	}
+	c.lines++
+	c.bytes += int64(len(message))
+	c.simulator += elapsed
+}
+
+// channelTimings returns the breakdown of channels in the order of appearance.
+func (t *scanTiming) channelTimings() []ChannelTiming {
+	timings := make([]ChannelTiming, len(t.order))
+	for i, channel := range t.order {
+		c := t.channels[channel]
+		timings[i] = ChannelTiming{Channel: channel, Lines: c.lines, Bytes: c.bytes, SimulatorTime: milliseconds(c.simulator)}
+	}
+	return timings
+}
+
+// mergeChannelTimes returns `timings` with `more` of scans of the same channels added to them.
+func mergeChannelTimes(timings []ChannelTiming, more []ChannelTiming) []ChannelTiming {
+	for _, m := range more {
+		found := false
+		for i := range timings {
+			if timings[i].Channel == m.Channel {
+				timings[i].Lines += m.Lines
+				timings[i].Bytes += m.Bytes
+				timings[i].SimulatorTime += m.SimulatorTime
+				found = true
+				break
+			}
+		}
+		if !found {
+			timings = append(timings, m)
+		}
+	}
+	return timings
+}
+
+// milliseconds returns `d` in milliseconds as reports tell durations.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,55 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDebugTiming(t *testing.T) {
+	defer registerFixture()()
+	param := SnapshotParameter{
+		Exchange: fixtureExchange,
+		Nanosecs: []int64{fixtureAt(80 * time.Second)},
+		Channels: []string{"book", "ticker"},
+		Format:   "raw",
+		Output:   OutputTSV,
+	}
+	_, report, err := Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ChannelTimes != nil || report.Files[0].ParseTime != 0 {
+		t.Fatalf("expected time not to be measured without debug, got %+v", report)
+	}
+	param.Debug = true
+	_, report, err = Snapshot(context.Background(), param, NewDirSource(goldenDir, fixtureKeys()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lines until the target are of the first file and the first two lines of the second one
+	var lines []string
+	for _, c := range report.ChannelTimes {
+		lines = append(lines, fmt.Sprintf("%s:%d", c.Channel, c.Lines))
+	}
+	if fmt.Sprint(lines) != "[book:4 ticker:3]" {
+		t.Errorf("unexpected lines of channels %v", lines)
+	}
+	for _, file := range report.Files {
+		if file.ParseTime <= 0 {
+			t.Errorf("expected parse time of %s to be measured", file.Name)
+		}
+	}
+}
+
+func TestMergeChannelTimes(t *testing.T) {
+	merged := mergeChannelTimes(
+		[]ChannelTiming{{Channel: "book", Lines: 2, Bytes: 20, SimulatorTime: 1}},
+		[]ChannelTiming{{Channel: "ticker", Lines: 1, Bytes: 5, SimulatorTime: 0.5}, {Channel: "book", Lines: 3, Bytes: 30, SimulatorTime: 2}},
+	)
+	expected := "[{book 5 50 3} {ticker 1 5 0.5}]"
+	if fmt.Sprint(merged) != expected {
+		t.Errorf("expected %s, got %v", expected, merged)
+	}
+}
@@ -0,0 +1,58 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer makes spans of snapshots, they are not recorded unless tracing is set up.
+var tracer = otel.Tracer("github.com/exchangedataset/stream-snapshot")
+
+// tracerProvider is the provider exporting spans, nil if tracing is disabled.
+var tracerProvider *sdktrace.TracerProvider
+
+// setupTracing exports spans with OTLP over HTTP if OTEL_EXPORTER_OTLP_ENDPOINT is set.
+// The exporter is configured by the standard OTEL_EXPORTER_OTLP_* environment variables.
+func setupTracing(ctx context.Context) error {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return err
+	}
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tracerProvider)
+	return nil
+}
+
+// FlushTraces exports spans ended so far, the instance could be frozen after a request in Lambda.
+func FlushTraces(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.ForceFlush(ctx); err != nil {
+		Logger.Warn("could not export spans", "error", err)
+	}
+}
+
+// StartSpan starts the span named `name` as a child of the span in `ctx`.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends `span` marking it as failed if `err` is not nil.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
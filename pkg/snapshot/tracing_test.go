@@ -0,0 +1,44 @@
+package snapshot
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPipelineSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	dir, err := ioutil.TempDir("", "stream-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx, span := StartSpan(context.Background(), "snapshot")
+	done := make(chan struct{})
+	for file := range pipeline(ctx, NewDirSource(dir, []string{"missing.gz"}), done, 0, 0) {
+		if file.reader != nil {
+			t.Fatal("expected missing file")
+		}
+	}
+	close(done)
+	EndSpan(span, nil)
+	spans := recorder.Ended()
+	names := make(map[string]bool)
+	for _, s := range spans {
+		names[s.Name()] = true
+		if s.Name() == "fetch" && s.Parent().SpanID() != span.SpanContext().SpanID() {
+			t.Errorf("fetch span is not a child of snapshot span")
+		}
+	}
+	if !names["fetch"] || !names["snapshot"] {
+		t.Fatalf("expected fetch and snapshot spans, got %v", names)
+	}
+}
@@ -0,0 +1,113 @@
+package snapshot
+
+import (
+	"strings"
+
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// tradeHistory keeps recent messages of trade channels as they are applied, to be written along with snapshots.
+// Trades are kept across start lines as they still happened before the connection was made again.
+type tradeHistory struct {
+	exchange string
+	// count is the maximum number of messages kept per channel, unlimited if 0
+	count int
+	// window is the nanoseconds before the target messages are kept within, unlimited if 0
+	window int64
+	// isTrade caches whether channels are of trades
+	isTrade map[string]bool
+	// channels is the list of trade channels in the order of appearance
+	channels []string
+	trades   map[string][]tradeMessage
+}
+
+type tradeMessage struct {
+	timestamp int64
+	message   []byte
+}
+
+// newTradeHistory returns the history of trades for `param`, nil if recent trades are not requested.
+func newTradeHistory(param SnapshotParameter) *tradeHistory {
+	if param.RecentTrades == 0 && param.RecentTradesNanosec == 0 {
+		return nil
+	}
+	return &tradeHistory{
+		exchange: param.Exchange,
+		count:    param.RecentTrades,
+		window:   param.RecentTradesNanosec,
+		isTrade:  make(map[string]bool),
+		trades:   make(map[string][]tradeMessage),
+	}
+}
+
+// isTradeChannel returns true if `channel` of `exchange` is of trades.
+// Channels of exchanges without normalized naming are told by their names, such as `trade_XBTUSD`.
+func isTradeChannel(exchange string, channel string) bool {
+	if normalized, ok := normalizedChannel(exchange, channel); ok {
+		return strings.HasPrefix(normalized, kindTrades+":")
+	}
+	return strings.Contains(strings.ToLower(channel), "trade")
+}
+
+// add records `message` of `channel` applied at `timestamp`, messages of channels not of trades are ignored.
+// `message` is copied as it is only valid during the call.
+func (h *tradeHistory) add(timestamp int64, channel string, message []byte) {
+	isTrade, ok := h.isTrade[channel]
+	if !ok {
+		isTrade = isTradeChannel(h.exchange, channel)
+		h.isTrade[channel] = isTrade
+	}
+	if !isTrade {
+		return
+	}
+	trades, ok := h.trades[channel]
+	if !ok {
+		h.channels = append(h.channels, channel)
+	}
+	if h.window > 0 {
+		// targets are not before lines applied, so older messages are never written
+		expired := 0
+		for expired < len(trades) && trades[expired].timestamp < timestamp-h.window {
+			expired++
+		}
+		trades = trades[:copy(trades, trades[expired:])]
+	}
+	if h.count > 0 && len(trades) >= h.count {
+		trades = trades[:copy(trades, trades[len(trades)-h.count+1:])]
+	}
+	h.trades[channel] = append(trades, tradeMessage{timestamp: timestamp, message: append([]byte(nil), message...)})
+}
+
+// snapshots returns messages of trades within the window before `nanosec` as snapshots of their channels, the oldest first.
+func (h *tradeHistory) snapshots(nanosec int64) []simulator.Snapshot {
+	var snapshots []simulator.Snapshot
+	for _, channel := range h.channels {
+		for _, trade := range h.trades[channel] {
+			if h.window > 0 && trade.timestamp < nanosec-h.window {
+				continue
+			}
+			snapshots = append(snapshots, simulator.Snapshot{Channel: channel, Snapshot: trade.message})
+		}
+	}
+	return snapshots
+}
+
+// tradeHistorySnapshots is simulator whose snapshots are followed by recent trades at `nanosec`.
+type tradeHistorySnapshots struct {
+	simulator.Simulator
+	history *tradeHistory
+	nanosec int64
+}
+
+func (s *tradeHistorySnapshots) TakeSnapshot() ([]simulator.Snapshot, error) {
+	snapshots, err := s.Simulator.TakeSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	trades := s.history.snapshots(s.nanosec)
+	if len(trades) == 0 {
+		return snapshots, nil
+	}
+	// the simulator could reuse the slice of snapshots
+	return append(snapshots[:len(snapshots):len(snapshots)], trades...), nil
+}
@@ -0,0 +1,69 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTradeHistory(t *testing.T) {
+	history := newTradeHistory(SnapshotParameter{Exchange: "bitmex", RecentTrades: 2, RecentTradesNanosec: 100})
+	for i, channel := range []string{"trade_XBTUSD", "orderBookL2_XBTUSD", "trade_XBTUSD", "trade_ETHUSD", "trade_XBTUSD"} {
+		history.add(int64(i*40), channel, []byte(fmt.Sprintf(`{"i":%d}`, i)))
+	}
+	var actual []string
+	for _, snapshot := range history.snapshots(180) {
+		actual = append(actual, snapshot.Channel+string(snapshot.Snapshot))
+	}
+	// the first trade is neither of the last 2 nor within 100ns
+	expected := []string{`trade_XBTUSD{"i":2}`, `trade_XBTUSD{"i":4}`, `trade_ETHUSD{"i":3}`}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if snapshots := history.snapshots(250); len(snapshots) != 1 {
+		t.Errorf("expected trades before the window to be excluded, got %d", len(snapshots))
+	}
+	if newTradeHistory(SnapshotParameter{Exchange: "bitmex"}) != nil {
+		t.Error("expected no history without recent trades")
+	}
+	if !isTradeChannel("binance", "btcusdt@trade") || isTradeChannel("binance", "btcusdt@depth@100ms") || !isTradeChannel("fixture", "trade") {
+		t.Error("unexpected trade channels")
+	}
+}
+
+func TestRecentTradesOnFixture(t *testing.T) {
+	defer registerFixture()()
+	dir, err := ioutil.TempDir("", "stream-snapshot-trades")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lines := []string{
+		fmt.Sprintf("start\t%d\twss://fixture.example.com/ws", fixtureAt(time.Second)),
+		fmt.Sprintf(`msg	%d	book	{"type":"snapshot","bids":[[100,1]],"asks":[[101,1]]}`, fixtureAt(2*time.Second)),
+		fmt.Sprintf(`msg	%d	trade	{"price":100}`, fixtureAt(3*time.Second)),
+		// trades are kept across connections
+		fmt.Sprintf("start\t%d\twss://fixture.example.com/ws", fixtureAt(4*time.Second)),
+		fmt.Sprintf(`msg	%d	book	{"type":"snapshot","bids":[[100,2]],"asks":[[101,1]]}`, fixtureAt(5*time.Second)),
+		fmt.Sprintf(`msg	%d	trade	{"price":101}`, fixtureAt(6*time.Second)),
+		fmt.Sprintf(`msg	%d	trade	{"price":102}`, fixtureAt(20*time.Second)),
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, fixtureKeys()[0]), multistreamGzip(t, strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nanosec := fixtureAt(10 * time.Second)
+	param := SnapshotParameter{Exchange: fixtureExchange, Nanosecs: []int64{nanosec}, Channels: []string{"book", "trade"}, Format: "raw", Output: OutputTSV, RecentTrades: 5}
+	ret, _, err := Snapshot(context.Background(), param, NewDirSource(dir, fixtureKeys()[:1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("%[1]d\tbook\t{\"type\":\"snapshot\",\"bids\":[[100,2]],\"asks\":[[101,1]]}\n%[1]d\ttrade\t{\"price\":100}\n%[1]d\ttrade\t{\"price\":101}\n", nanosec)
+	if string(ret) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, ret)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !safestring
+// +build !safestring
+
+package snapshot
+
+import "unsafe"
+
+// bytesToString converts byte slice into string without copying.
+// Returned string shares memory with `b`, so it must not be retained after `b` is modified or reused.
+// Build with `-tags safestring` to use allocating conversion instead.
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
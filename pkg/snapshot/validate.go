@@ -0,0 +1,190 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DatasetSince is the map of exchanges to the time their dataset starts in unix nanoseconds, targets before it are rejected.
+// It is given as `exchange=RFC3339 time` separated by comma, exchanges not in it are not checked.
+var DatasetSince = parseDatasetSince(os.Getenv("DATASET_SINCE"))
+
+// validationClock returns the current time, targets after which are rejected, replaced in tests.
+var validationClock = time.Now
+
+// streamcommonsExchanges are exchanges which streamcommons has simulators of.
+var streamcommonsExchanges = map[string]bool{
+	"bitmex":       true,
+	"bitfinex":     true,
+	"bitflyer":     true,
+	"liquid":       true,
+	binanceSpot:    true,
+	binanceFutures: true,
+}
+
+// parseDatasetSince parses pairs of exchanges and times in `str`, malformed pairs are ignored.
+func parseDatasetSince(str string) map[string]int64 {
+	since := make(map[string]int64)
+	for _, pair := range strings.Split(str, ",") {
+		eq := strings.IndexByte(pair, '=')
+		var t time.Time
+		var err error
+		if eq > 0 {
+			t, err = time.Parse(time.RFC3339, strings.TrimSpace(pair[eq+1:]))
+		}
+		if eq <= 0 || err != nil {
+			if pair != "" {
+				Logger.Warn("ignoring invalid dataset start", "value", pair)
+			}
+			continue
+		}
+		since[strings.TrimSpace(pair[:eq])] = t.UnixNano()
+	}
+	return since
+}
+
+// FieldError tells which parameter of the request is invalid and why.
+type FieldError struct {
+	// Field is the name of the parameter, such as `channels`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("'%s': %s", e.Field, e.Message)
+}
+
+// ValidationError is the error of invalid parameters found before dataset is read, every one of them is reported at once.
+type ValidationError struct {
+	Fields []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) add(field string, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Error()
+	}
+	return "invalid parameters: " + strings.Join(messages, "; ")
+}
+
+// exchangeKnown returns true if snapshots of `exchange` can be taken by streamcommons or a registered simulator.
+func exchangeKnown(exchange string) bool {
+	if streamcommonsExchanges[exchange] {
+		return true
+	}
+	simulatorsMu.RLock()
+	defer simulatorsMu.RUnlock()
+	_, ok := simulators[exchange]
+	return ok
+}
+
+// checkFormat returns the error making the formatter of `format` for `channels` of `exchange`, the same as the snapshot would.
+// Channels with patterns are not checked as channels matching them are only known from dataset.
+func checkFormat(exchange string, channels []string, format string) error {
+	if format == "raw" || hasPattern(channels) {
+		return nil
+	}
+	_, err := newFormatter(exchange, channels, format)
+	return err
+}
+
+// validateChannelSyntax returns the reason `channel` is malformed, or empty string if it is not.
+func validateChannelSyntax(channel string) string {
+	if channel == "" {
+		return "channel must not be empty"
+	}
+	if strings.IndexFunc(channel, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return fmt.Sprintf("channel %q must not have spaces or control characters", channel)
+	}
+	if _, err := newChannelMatcher([]string{channel}); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// validatePostFilter adds errors of `param.PostFilter` to `v`. With raw format, channels are not renamed by the formatter,
+// so post filters of names must be one of requested channels or channels of their instruments such as `orderBookL2_XBTUSD`.
+func validatePostFilter(param SnapshotParameter, v *ValidationError) {
+	raw := param.Format == "raw" && len(param.Formats) < 2 && param.Naming == NamingNative && len(param.Exchanges) == 0 && !hasPattern(param.Channels)
+	for _, filter := range param.PostFilter {
+		if reason := validateChannelSyntax(filter); reason != "" {
+			v.add("postFilter", "%s", reason)
+			continue
+		}
+		if !raw || isPattern(filter) || strings.HasPrefix(filter, metricsChannelPrefix) {
+			continue
+		}
+		requested := false
+		for _, channel := range param.Channels {
+			if filter == channel || strings.HasPrefix(filter, channel+"_") {
+				requested = true
+				break
+			}
+			for _, companion := range companionChannels(param.Exchange, channel) {
+				if filter == companion {
+					requested = true
+				}
+			}
+		}
+		if !requested {
+			v.add("postFilter", "'%s' is not any of 'channels', it would match nothing in raw format", filter)
+		}
+	}
+}
+
+// validateParameter checks the exchange, targets, channels, formats and post filters of `param` made from the request,
+// so that malformed requests fail before dataset is read from the storage. It returns *ValidationError if any is invalid.
+func validateParameter(param SnapshotParameter) error {
+	v := new(ValidationError)
+	exchanges := param.Exchanges
+	if len(exchanges) == 0 {
+		exchanges = []ExchangeChannels{{Exchange: param.Exchange, Channels: param.Channels}}
+	}
+	formats := param.Formats
+	if len(formats) < 2 {
+		formats = []string{param.Format}
+	}
+	for i, exchange := range exchanges {
+		exchangeField, channelsField := "exchange", "channels"
+		if i > 0 {
+			exchangeField, channelsField = "exchanges", "exchanges"
+		}
+		if !exchangeKnown(exchange.Exchange) {
+			v.add(exchangeField, "unknown exchange '%s'", exchange.Exchange)
+			continue
+		}
+		if since, ok := DatasetSince[exchange.Exchange]; ok && !param.Latest && param.Nanosecs[0] < since {
+			v.add("nanosec", "dataset of %s is available since %s", exchange.Exchange, time.Unix(0, since).UTC().Format(time.RFC3339))
+		}
+		malformed := false
+		for _, channel := range exchange.Channels {
+			if reason := validateChannelSyntax(channel); reason != "" {
+				v.add(channelsField, "%s", reason)
+				malformed = true
+			}
+		}
+		if malformed {
+			continue
+		}
+		for _, format := range formats {
+			if err := checkFormat(exchange.Exchange, exchange.Channels, format); err != nil {
+				v.add("format", "format '%s' can not be used for channels of %s: %v", format, exchange.Exchange, err)
+			}
+		}
+	}
+	if !param.Latest && param.Nanosecs[len(param.Nanosecs)-1] > validationClock().UnixNano() {
+		v.add("nanosec", "targets must not be in the future")
+	}
+	validatePostFilter(param, v)
+	if len(v.Fields) > 0 {
+		return v
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseDatasetSince(t *testing.T) {
+	since := parseDatasetSince("bitmex=2019-10-01T00:00:00Z, binance =2020-01-01T09:00:00+09:00,broken,liquid=yesterday,")
+	expected := fmt.Sprint(map[string]int64{"bitmex": 1569888000000000000, "binance": 1577836800000000000})
+	if fmt.Sprint(since) != expected {
+		t.Errorf("expected %s, got %v", expected, since)
+	}
+}
+
+// fieldsOf returns fields of the validation error of `err`, failing if it is not.
+func fieldsOf(t *testing.T, err error) []string {
+	t.Helper()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected the validation error, got %v", err)
+	}
+	fields := make([]string, len(verr.Fields))
+	for i, field := range verr.Fields {
+		fields[i] = field.Field
+	}
+	return fields
+}
+
+func TestValidateParameter(t *testing.T) {
+	defer func(since map[string]int64) { DatasetSince = since }(DatasetSince)
+	DatasetSince = map[string]int64{"bitmex": 1569888000000000000}
+	defer func(clock func() time.Time) { validationClock = clock }(validationClock)
+	validationClock = func() time.Time { return time.Unix(0, 1598941085000000000) }
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "json")
+	if _, err := ParseParameter(event); err != nil {
+		t.Fatal(err)
+	}
+	event.PathParameters["exchange"] = "bitmexx"
+	_, err := ParseParameter(event)
+	if fields := fieldsOf(t, err); fmt.Sprint(fields) != "[exchange]" {
+		t.Errorf("expected the unknown exchange, got %v", err)
+	}
+	event.PathParameters["exchange"] = "bitmex"
+	// every invalid parameter is reported at once
+	event.PathParameters["nanosec"] = "1500000000000000000"
+	event.MultiValueQueryStringParameters["nanosecs"] = []string{"1598941145000000000"}
+	event.MultiValueQueryStringParameters["channels"] = []string{"orderBookL2", "trade XBTUSD"}
+	_, err = ParseParameter(event)
+	if fields := fieldsOf(t, err); fmt.Sprint(fields) != "[nanosec channels nanosec]" {
+		t.Errorf("expected the targets and the channel, got %v", err)
+	}
+	event.MultiValueQueryStringParameters["channels"] = []string{"orderBookL2"}
+	_, err = ParseParameter(event)
+	if fields := fieldsOf(t, err); fmt.Sprint(fields) != "[nanosec nanosec]" {
+		t.Errorf("expected targets before dataset and in the future, got %v", err)
+	}
+}
+
+func TestValidatePostFilter(t *testing.T) {
+	event := makeLambdaEvent("bitmex", []string{"orderBookL2"}, "1598941025000000000", "raw")
+	event.MultiValueQueryStringParameters["postFilter"] = []string{"orderBookL2_XBTUSD", "metrics_*", "trade"}
+	_, err := ParseParameter(event)
+	if fields := fieldsOf(t, err); fmt.Sprint(fields) != "[postFilter]" {
+		t.Errorf("expected the post filter of a channel not requested, got %v", err)
+	}
+	event.MultiValueQueryStringParameters["postFilter"] = []string{"orderBookL2_XBTUSD", "re:["}
+	_, err = ParseParameter(event)
+	if fields := fieldsOf(t, err); fmt.Sprint(fields) != "[postFilter]" {
+		t.Errorf("expected the invalid pattern, got %v", err)
+	}
+	// channels can be renamed by formatters
+	event.QueryStringParameters["format"] = "json"
+	event.MultiValueQueryStringParameters["postFilter"] = []string{"trade"}
+	if _, err := ParseParameter(event); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,257 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// WarmChannels is the list of `exchange:channel` whose simulators are kept warm in memory in server mode, separated by comma.
+// Requests of recent targets for any of these channels of an exchange start from the state in memory
+// instead of reading dataset files before it.
+var WarmChannels = os.Getenv("WARM_CHANNELS")
+
+// WarmQueueURL is the URL of SQS queue S3 event notifications of new dataset files are delivered to,
+// simulators of `WarmChannels` are advanced as files land.
+var WarmQueueURL = os.Getenv("WARM_QUEUE_URL")
+
+// warmRetryInterval is how long receiving notifications waits after it failed.
+const warmRetryInterval = 10 * time.Second
+
+// warm is the store of simulators kept warm, nil if `WarmChannels` is not configured.
+var warm *warmStore
+
+// warmStore is checkpointStore keeping the latest checkpoints of warm simulators of exchanges in memory,
+// falling back to `rest` for other channels and older checkpoints.
+type warmStore struct {
+	rest       checkpointStore
+	simulators map[string]*warmSimulator
+}
+
+// warmSimulator is the state of the simulator of `channels` of an exchange at the beginning of `minute`,
+// kept as a checkpoint.
+type warmSimulator struct {
+	channels []string
+	// advancing is held while the simulator is advanced, so that files are applied one at a time
+	advancing sync.Mutex
+	mu        sync.Mutex
+	// minute is the minute the checkpoint is taken at, 0 if the simulator is not warm yet
+	minute int64
+	data   []byte
+}
+
+// parseWarmChannels returns the map of exchanges to channels in `str` of `exchange:channel` separated by comma.
+func parseWarmChannels(str string) (map[string][]string, error) {
+	channels := make(map[string][]string)
+	for _, pair := range strings.Split(str, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		exchange, channel, ok := strings.Cut(pair, ":")
+		if !ok || exchange == "" || channel == "" {
+			return nil, fmt.Errorf("'%s' is not of exchange:channel", pair)
+		}
+		if isPattern(channel) {
+			return nil, fmt.Errorf("'%s' is a pattern, channels kept warm must be known", channel)
+		}
+		channels[exchange] = append(channels[exchange], channel)
+	}
+	return channels, nil
+}
+
+func newWarmStore(channels map[string][]string, rest checkpointStore) *warmStore {
+	s := &warmStore{rest: rest, simulators: make(map[string]*warmSimulator)}
+	for exchange, chs := range channels {
+		s.simulators[exchange] = &warmSimulator{channels: chs}
+	}
+	return s
+}
+
+// covers returns true if the simulator has the state of all of `channels`.
+func (w *warmSimulator) covers(channels []string) bool {
+	for _, channel := range channels {
+		found := false
+		for _, warmChannel := range w.channels {
+			if channel == warmChannel {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Nearest returns the checkpoint in memory if it is of all of `channels` and in the range,
+// lines of channels not requested in it are skipped as are other lines of them.
+func (s *warmStore) Nearest(exchange string, channels []string, after int64, until int64) (int64, io.ReadCloser, error) {
+	if w, ok := s.simulators[exchange]; ok && w.covers(channels) {
+		w.mu.Lock()
+		minute, data := w.minute, w.data
+		w.mu.Unlock()
+		if after < minute && minute <= until {
+			return minute, ioutil.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+	if s.rest == nil {
+		return 0, nil, nil
+	}
+	return s.rest.Nearest(exchange, channels, after, until)
+}
+
+// Save keeps the checkpoint in memory if it is the newest one of the warm simulator.
+func (s *warmStore) Save(exchange string, channels []string, minute int64, data []byte) error {
+	if w, ok := s.simulators[exchange]; ok && channelsKey(channels) == channelsKey(w.channels) {
+		w.mu.Lock()
+		if minute > w.minute {
+			w.minute, w.data = minute, data
+		}
+		w.mu.Unlock()
+	}
+	if s.rest == nil {
+		return nil
+	}
+	return s.rest.Save(exchange, channels, minute, data)
+}
+
+// advance applies the dataset file of `minute` of `exchange` which has just landed to its warm simulator.
+// The simulator is advanced by taking a snapshot at the beginning of the next minute from the checkpoint,
+// which saves the checkpoint at it to the store.
+func (s *warmStore) advance(ctx context.Context, exchange string, minute int64) error {
+	w, ok := s.simulators[exchange]
+	if !ok {
+		return nil
+	}
+	w.advancing.Lock()
+	defer w.advancing.Unlock()
+	w.mu.Lock()
+	current := w.minute
+	w.mu.Unlock()
+	if minute < current {
+		// lines of the file are already applied, or the file was late and is not
+		LoggerFrom(ctx).Debug("ignoring dataset file before warm state", "exchange", exchange, "minute", minute, "warm_minute", current)
+		return nil
+	}
+	param := SnapshotParameter{
+		Exchange: exchange,
+		Channels: w.channels,
+		Nanosecs: []int64{(minute + 1) * 60 * 1000000000},
+		Format:   "raw",
+		Output:   OutputTSV,
+	}
+	source, err := OpenSource(ctx, &param)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	report, err := SnapshotTo(ctx, param, source, ioutil.Discard)
+	if err != nil {
+		return err
+	}
+	LoggerFrom(ctx).Debug("advanced warm simulator", "exchange", exchange, "minute", minute+1, "scanned", report.Scanned)
+	return nil
+}
+
+// datasetKeyMinute returns the exchange and the minute of the minute file `key`, false if it is not of a minute file.
+func datasetKeyMinute(key string) (exchange string, minute int64, ok bool) {
+	for _, extension := range extensions {
+		if !strings.HasSuffix(key, extension) {
+			continue
+		}
+		name := strings.TrimSuffix(key, extension)
+		i := strings.LastIndexByte(name, '_')
+		if i <= 0 {
+			return "", 0, false
+		}
+		minute, err := strconv.ParseInt(name[i+1:], 10, 64)
+		if err != nil {
+			// files of hours have `h` before the hour
+			return "", 0, false
+		}
+		return name[:i], minute, true
+	}
+	return "", 0, false
+}
+
+// notify advances warm simulators by dataset files in the S3 event notification `body`.
+// Notifications without records, such as the test event sent when notifications are configured, are ignored.
+func (s *warmStore) notify(ctx context.Context, body []byte) error {
+	var event events.S3Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return err
+	}
+	for _, record := range event.Records {
+		exchange, minute, ok := datasetKeyMinute(record.S3.Object.URLDecodedKey)
+		if !ok {
+			continue
+		}
+		if err := s.advance(ctx, exchange, minute); err != nil {
+			return fmt.Errorf("%s: %v", record.S3.Object.URLDecodedKey, err)
+		}
+	}
+	return nil
+}
+
+// FollowDataset advances simulators of `WarmChannels` as notifications of new dataset files are received
+// from `WarmQueueURL` until `ctx` is done. Notifications failed to be processed are received again after
+// the visibility timeout of the queue. It does nothing if either of them is not configured.
+func FollowDataset(ctx context.Context) {
+	if warm == nil || WarmQueueURL == "" {
+		return
+	}
+	log := Logger.With("queue", WarmQueueURL)
+	sess, err := session.NewSession()
+	if err != nil {
+		log.Error("could not follow dataset", "error", err)
+		return
+	}
+	client := sqs.New(sess)
+	log.Info("following dataset")
+	for ctx.Err() == nil {
+		out, err := client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(WarmQueueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Warn("could not receive notifications", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(warmRetryInterval):
+			}
+			continue
+		}
+		for _, message := range out.Messages {
+			if err := warm.notify(ctx, []byte(aws.StringValue(message.Body))); err != nil {
+				log.Warn("could not advance warm simulator", "error", err)
+				continue
+			}
+			if _, err := client.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(WarmQueueURL),
+				ReceiptHandle: message.ReceiptHandle,
+			}); err != nil {
+				log.Warn("could not delete notification", "error", err)
+			}
+		}
+	}
+	log.Info("stopped following dataset")
+}
@@ -0,0 +1,80 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseWarmChannels(t *testing.T) {
+	channels, err := parseWarmChannels("bitmex:orderBookL2_XBTUSD, bitmex:trade_XBTUSD,binance:btcusdt@depth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(channels["bitmex"]) != "[orderBookL2_XBTUSD trade_XBTUSD]" || fmt.Sprint(channels["binance"]) != "[btcusdt@depth]" {
+		t.Errorf("unexpected channels %v", channels)
+	}
+	for _, invalid := range []string{"bitmex", "bitmex:", "bitmex:orderBookL2_*"} {
+		if _, err := parseWarmChannels(invalid); err == nil {
+			t.Errorf("expected '%s' to be rejected", invalid)
+		}
+	}
+}
+
+func TestDatasetKeyMinute(t *testing.T) {
+	for key, expected := range map[string]string{
+		"bitmex_26636817.gz":   "bitmex 26636817 true",
+		"bitflyer_fx_1234.zst": "bitflyer_fx 1234 true",
+		"bitmex_h443946.gz":    " 0 false",
+		"bitmex_26636817.json": " 0 false",
+	} {
+		exchange, minute, ok := datasetKeyMinute(key)
+		if actual := fmt.Sprint(exchange, " ", minute, " ", ok); actual != expected {
+			t.Errorf("%s: expected %s, got %s", key, expected, actual)
+		}
+	}
+}
+
+func TestWarmSimulator(t *testing.T) {
+	defer registerFixture()()
+	defer func(dataset string, store checkpointStore) { DatasetDirectory, checkpoints = dataset, store }(DatasetDirectory, checkpoints)
+	DatasetDirectory, checkpoints = goldenDir, nil
+	take := func() ([]byte, Report) {
+		param := SnapshotParameter{Exchange: fixtureExchange, Nanosecs: []int64{fixtureAt(190 * time.Second)}, Channels: []string{"book"}, Format: "raw", Output: OutputTSV, Compression: "gzip"}
+		source, err := OpenSource(context.Background(), &param)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer source.Close()
+		ret, report, err := Snapshot(context.Background(), param, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret, report
+	}
+	cold, coldReport := take()
+	store := newWarmStore(map[string][]string{fixtureExchange: {"book", "ticker"}}, nil)
+	checkpoints = store
+	for _, key := range fixtureKeys() {
+		event := fmt.Sprintf(`{"Records":[{"s3":{"bucket":{"name":"dataset"},"object":{"key":%q}}}]}`, key)
+		if err := store.notify(context.Background(), []byte(event)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if minute := store.simulators[fixtureExchange].minute; minute != fixtureMinute+int64(len(fixtureFiles)) {
+		t.Fatalf("expected the simulator to be at the end of the last file, got minute %d", minute)
+	}
+	// the test event has no records
+	if err := store.notify(context.Background(), []byte(`{"Event":"s3:TestEvent"}`)); err != nil {
+		t.Error(err)
+	}
+	ret, report := take()
+	if !bytes.Equal(ret, cold) {
+		t.Errorf("expected the same snapshot as from dataset:\n%s\nexpected:\n%s", ret, cold)
+	}
+	if report.Scanned >= coldReport.Scanned {
+		t.Errorf("expected less to be read from the warm state, got %d of %d", report.Scanned, coldReport.Scanned)
+	}
+}
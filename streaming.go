@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/exchangedataset/stream-snapshot/pkg/snapshot"
+	sc "github.com/exchangedataset/streamcommons"
+)
+
+// streamBufferSize is the size of chunks in which snapshots are flushed to clients.
+const streamBufferSize = 64 * 1024
+
+// functionURLEvent makes the request of API Gateway equivalent to `request` of function URL at `/{exchange}/{nanosec}`.
+func functionURLEvent(request events.LambdaFunctionURLRequest) (event events.APIGatewayProxyRequest, err error) {
+	segments := strings.Split(strings.Trim(request.RawPath, "/"), "/")
+	if len(segments) < 2 {
+		return event, fmt.Errorf("path must end with /{exchange}/{nanosec}: %s", request.RawPath)
+	}
+	query, err := url.ParseQuery(request.RawQueryString)
+	if err != nil {
+		return
+	}
+	event.PathParameters = map[string]string{
+		"exchange": segments[len(segments)-2],
+		"nanosec":  segments[len(segments)-1],
+	}
+	event.QueryStringParameters = make(map[string]string)
+	event.MultiValueQueryStringParameters = query
+	for name, values := range query {
+		event.QueryStringParameters[name] = values[len(values)-1]
+	}
+	event.Headers = request.Headers
+	event.Body = request.Body
+	event.IsBase64Encoded = request.IsBase64Encoded
+	event.RequestContext.RequestID = request.RequestContext.RequestID
+	return
+}
+
+// streamingResponse makes the streaming response of the buffered `response`.
+func streamingResponse(response *events.APIGatewayProxyResponse) (*events.LambdaFunctionURLStreamingResponse, error) {
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: response.StatusCode,
+		Headers:    response.Headers,
+		Body:       strings.NewReader(string(body)),
+	}, nil
+}
+
+// finishReader calls `finish` once when reading from it reached the end, error of `finish` is returned from `Read`.
+type finishReader struct {
+	io.Reader
+	// closer is closed to stop writing when it is closed before the end
+	closer   io.Closer
+	finish   func(err error) error
+	finished bool
+	// read is the bytes read from it
+	read int64
+}
+
+func (r *finishReader) Close() error {
+	r.closer.Close()
+	if r.finished {
+		return nil
+	}
+	r.finished = true
+	return r.finish(io.ErrClosedPipe)
+}
+
+func (r *finishReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	r.read += int64(n)
+	if err != nil && !r.finished {
+		r.finished = true
+		if err == io.EOF {
+			if serr := r.finish(nil); serr != nil {
+				err = serr
+			}
+		} else {
+			r.finish(err)
+		}
+	}
+	return
+}
+
+// snapshotResult is the result of a snapshot taken in background.
+type snapshotResult struct {
+	report snapshot.Report
+	err    error
+}
+
+// handleStreamingRequest handles requests of function URL in `RESPONSE_STREAM` mode, snapshots are flushed to clients while they are made.
+// Dry-run, parquet, destination and multi-exchange requests are made at once as `handleRequest` does.
+// Headers known only after snapshots are made such as `X-Snapshot-Timestamp` are not sent.
+func handleStreamingRequest(ctx context.Context, request events.LambdaFunctionURLRequest) (response *events.LambdaFunctionURLStreamingResponse, err error) {
+	if Production {
+		sc.AWSEnableProduction()
+	}
+	event, serr := functionURLEvent(request)
+	if serr != nil {
+		return streamingResponse(sc.MakeResponse(400, serr.Error()))
+	}
+	id := requestID(event)
+	log := snapshot.Logger.With("request_id", id)
+	ctx = snapshot.WithLogger(ctx, log)
+	record := snapshot.NewRequestRecord(id, event)
+	ctx = snapshot.WithRequestRecord(ctx, record)
+	ctx, span := snapshot.StartSpan(ctx, "request")
+	db, serr := sc.ConnectDatabase()
+	if serr != nil {
+		err = serr
+		snapshot.EndSpan(span, err)
+		snapshot.FlushTraces(ctx)
+		finishRecord(record, 500, err)
+		return
+	}
+	// called once the response is made or streamed to the end
+	finish := func(err error) error {
+		serr := db.Close()
+		if serr != nil {
+			if err != nil {
+				err = fmt.Errorf("%v, original error was: %v", serr, err)
+			} else {
+				err = serr
+			}
+		}
+		snapshot.EndSpan(span, err)
+		snapshot.FlushTraces(ctx)
+		return err
+	}
+	streaming := false
+	defer func() {
+		if !streaming {
+			err = finish(err)
+			status := 500
+			if response != nil && err == nil {
+				status = response.StatusCode
+				response.Headers = withRequestID(response.Headers, id)
+			}
+			finishRecord(record, status, err)
+		}
+	}()
+	st := time.Now()
+	param, bill, buffered, serr := prepareRequest(ctx, db, event)
+	if serr != nil {
+		err = serr
+		return
+	}
+	if buffered != nil {
+		return streamingResponse(buffered)
+	}
+	log.Debug("setup end", "elapsed", time.Now().Sub(st))
+	ctx = snapshot.WithLogger(ctx, logFor(log, param))
+	if param.DryRun || param.Nearest || param.MadeAtOnce() {
+		buffered, err = respond(ctx, st, param, bill)
+		if err != nil {
+			return
+		}
+		return streamingResponse(buffered)
+	}
+	source, serr := snapshot.OpenSource(ctx, &param)
+	if serr != nil {
+		err = fmt.Errorf("snapshot: %v", serr)
+		return
+	}
+	reader, writer := io.Pipe()
+	done := make(chan snapshotResult, 1)
+	go func() {
+		buffer := bufio.NewWriterSize(writer, streamBufferSize)
+		// snapshots are compressed before they are buffered
+		encoded := snapshot.NewEncodingWriter(buffer, param.Encoding)
+		report, serr := snapshot.SnapshotTo(ctx, param, source, encoded)
+		if serr == nil {
+			serr = encoded.Close()
+		}
+		if serr == nil {
+			serr = buffer.Flush()
+		}
+		source.Close()
+		done <- snapshotResult{report, serr}
+		writer.CloseWithError(serr)
+	}()
+	body := bufio.NewReaderSize(reader, streamBufferSize)
+	if _, serr := body.Peek(1); serr != nil {
+		// nothing was written, the status can still tell how it went
+		result := <-done
+		buffered, err = makeSnapshotResponse(ctx, st, nil, param, result.report, result.err, bill)
+		if err != nil {
+			return
+		}
+		return streamingResponse(buffered)
+	}
+	headers := map[string]string{"Content-Type": snapshot.ContentTypes[param.Output], snapshot.SchemaVersionHeader: snapshot.ResponseSchemaVersion(param)}
+	if param.Encoding != "" {
+		headers["Content-Encoding"] = param.Encoding
+		headers["Vary"] = "Accept-Encoding"
+	}
+	if param.Exclusive {
+		headers["X-Snapshot-Boundary"] = "exclusive"
+	} else {
+		headers["X-Snapshot-Boundary"] = "inclusive"
+	}
+	streaming = true
+	headers[snapshot.RequestIDHeader] = id
+	var sent *finishReader
+	sent = &finishReader{Reader: body, closer: reader, finish: func(err error) error {
+		result := <-done
+		record.Observe(result.report, result.err)
+		record.Size = sent.read
+		if err == nil && result.err == nil {
+			// bytes are billed once the snapshot is sent to the end
+			snapshot.LoggerFrom(ctx).Info("snapshot end", "scanned", result.report.Scanned, "elapsed", time.Now().Sub(st))
+			_, err = bill(result.report.Scanned)
+		} else if err == nil {
+			err = result.err
+		}
+		err = finish(err)
+		// the status is already sent, errors are only in the record
+		finishRecord(record, 200, err)
+		return err
+	}}
+	response = &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: 200,
+		Headers:    headers,
+		Body:       sent,
+	}
+	return
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/exchangedataset/stream-snapshot/pkg/snapshot"
+)
+
+func TestFunctionURLEvent(t *testing.T) {
+	event, err := functionURLEvent(events.LambdaFunctionURLRequest{
+		RawPath:        "/snapshot/bitmex/1598941025000000000",
+		RawQueryString: "channels=orderBookL2&channels=trade&format=json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	param, err := snapshot.ParseParameter(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.Exchange != "bitmex" || param.Nanosecs[0] != 1598941025000000000 || len(param.Channels) != 2 || param.Format != "json" {
+		t.Errorf("unexpected parameter: %+v", param)
+	}
+	if _, err := functionURLEvent(events.LambdaFunctionURLRequest{RawPath: "/bitmex"}); err == nil {
+		t.Error("short path: expected error")
+	}
+}
+
+func TestFinishReader(t *testing.T) {
+	calls := 0
+	failed := errors.New("billing failed")
+	reader := &finishReader{Reader: strings.NewReader("snapshot"), closer: ioutil.NopCloser(nil), finish: func(err error) error {
+		calls++
+		return failed
+	}}
+	b, err := ioutil.ReadAll(reader)
+	if string(b) != "snapshot" {
+		t.Errorf("expected snapshot, got %s", b)
+	}
+	if err != failed {
+		t.Errorf("expected error of finish, got %v", err)
+	}
+	reader.Close()
+	if calls != 1 {
+		t.Errorf("expected finish to be called once, got %d", calls)
+	}
+	if reader.read != int64(len("snapshot")) {
+		t.Errorf("expected bytes read to be counted, got %d", reader.read)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	event := events.APIGatewayProxyRequest{Headers: map[string]string{"x-request-id": "client-id"}}
+	event.RequestContext.RequestID = "gateway-id"
+	if id := requestID(event); id != "client-id" {
+		t.Errorf("expected the ID of the client, got %s", id)
+	}
+	event.Headers = nil
+	if id := requestID(event); id != "gateway-id" {
+		t.Errorf("expected the ID of API Gateway, got %s", id)
+	}
+	headers := withRequestID(nil, "gateway-id")
+	if headers[snapshot.RequestIDHeader] != "gateway-id" {
+		t.Errorf("expected the ID in headers, got %v", headers)
+	}
+}